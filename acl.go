@@ -0,0 +1,88 @@
+package shm
+
+import (
+	"errors"
+	"os"
+	"unsafe"
+)
+
+// uids of each list of an ACL at most
+const aclUIDs = 16
+
+var (
+	// ErrNoACL on ACL of a map created without one
+	ErrNoACL = errors.New("map without acl")
+	// ErrACLSize on create a map of an ACL of more than 16 uids a list
+	ErrACLSize = errors.New("too many uids in the acl")
+	// ErrAccess on attach to a map by a uid not in its ACL
+	ErrAccess = errors.New("access denied by the map acl")
+)
+
+// acl record after the fifo record, 128 bytes, the uids plus one, 0
+// for none
+type acl struct {
+	writers [aclUIDs]uint32
+	readers [aclUIDs]uint32
+}
+
+// the acl record of a map of header h
+func aclOf(h *header) *acl {
+	off := unsafe.Sizeof(header{})
+	if h.features&featOrigin != 0 {
+		off += unsafe.Sizeof(origin{})
+	}
+	if h.features&featFIFO != 0 {
+		off += unsafe.Sizeof(fifo{})
+	}
+	return (*acl)(unsafe.Pointer(uintptr(unsafe.Pointer(h)) + off))
+}
+
+// record the uids of a new map
+func (a *acl) init(writers, readers []int) {
+	for i, uid := range writers {
+		a.writers[i] = uint32(uid) + 1
+	}
+	for i, uid := range readers {
+		a.readers[i] = uint32(uid) + 1
+	}
+}
+
+// the uids of a list
+func aclList(l []uint32) (uids []int) {
+	for _, u := range l {
+		if u != 0 {
+			uids = append(uids, int(u-1))
+		}
+	}
+	return
+}
+
+// check the process may attach, read only if a reader; there are no
+// uids on windows, nor checks
+func (a *acl) check() (readOnly bool, err error) {
+	uid := os.Getuid()
+	if uid < 0 {
+		return false, nil
+	}
+	for _, u := range a.writers {
+		if u == uint32(uid)+1 {
+			return false, nil
+		}
+	}
+	for _, u := range a.readers {
+		if u == uint32(uid)+1 {
+			return true, nil
+		}
+	}
+	return false, ErrAccess
+}
+
+// ACL return the uids allowed to write and those to read the map, as
+// recorded at Create with the ACL option
+func (m *Map) ACL() (writers, readers []int, err error) {
+	if m.head.features&featACL == 0 {
+		return nil, nil, ErrNoACL
+	}
+	a := aclOf(m.head)
+	return aclList(a.writers[:]), aclList(a.readers[:]), nil
+}
@@ -0,0 +1,54 @@
+package shm
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMap_ACL(t *testing.T) {
+	name := "testacl.db"
+	defer os.Remove(name)
+	uid := os.Getuid()
+	if uid < 0 {
+		t.Skip("no uids")
+	}
+	m := newTestFile(t, name, 64, 16, 8, ACL([]int{uid}, []int{uid + 1}))
+	var err error
+	if err = m.Set("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	_ = m.Close()
+	if m, err = Open(name, testMaxTry, initWait); err != nil {
+		t.Fatal(err)
+	}
+	writers, readers, err := m.ACL()
+	if err != nil || len(writers) != 1 || writers[0] != uid || len(readers) != 1 || readers[0] != uid+1 {
+		t.Errorf("expect the acl kept, got %v, %v, %v", writers, readers, err)
+	}
+	if err = m.Set("b", []byte("2")); err != nil {
+		t.Error(err)
+	}
+	_ = m.Close()
+	_ = os.Remove(name)
+
+	// a reader opens read only
+	if m, err = Create(name, 64, 16, 8, testMaxTry, initWait, ACL([]int{uid + 1}, []int{uid})); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Set("a", []byte("1")); err != ErrReadOnly {
+		t.Errorf("expect %v, got %v", ErrReadOnly, err)
+	}
+	_ = m.Close()
+	_ = os.Remove(name)
+
+	// neither
+	if _, err = Create(name, 64, 16, 8, testMaxTry, initWait, ACL([]int{uid + 1}, nil)); err != ErrAccess {
+		t.Errorf("expect %v, got %v", ErrAccess, err)
+	}
+	if _, err = Open(name, testMaxTry, initWait); err != ErrAccess {
+		t.Errorf("expect %v, got %v", ErrAccess, err)
+	}
+	if _, err = Create("", 64, 16, 8, testMaxTry, initWait, InMemory(), ACL(make([]int, 17), nil)); err != ErrACLSize {
+		t.Errorf("expect %v, got %v", ErrACLSize, err)
+	}
+}
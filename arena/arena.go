@@ -0,0 +1,213 @@
+// Package arena allocates variable length blocks in memory shared by
+// processes, addressed by offsets since the memory may be mapped at
+// different addresses, to build shared structures on a mapping, and
+// chains values longer than a block over a Pool of fixed size blocks,
+// as a map spans its long values over continuation buckets
+package arena
+
+import (
+	"errors"
+	"sync/atomic"
+	"unsafe"
+)
+
+// size classes, blocks of 16 << class bytes including a block header
+const (
+	minBlock   = 16
+	numClasses = 16
+	// MaxAlloc is the largest allocation
+	MaxAlloc = minBlock<<(numClasses-1) - blockHeader
+)
+
+// bytes before the first block and in front of each block
+const (
+	HeaderSize  = 256
+	blockHeader = 8
+)
+
+// the largest arena, offsets are 32-bit
+const maxSize = 1<<32 - 1
+
+var (
+	// ErrSize on a memory too small or too large for an arena
+	ErrSize = errors.New("memory size out of range")
+	// ErrAlign on a memory not aligned to 16 bytes
+	ErrAlign = errors.New("memory not aligned")
+	// ErrFull on alloc with no more space
+	ErrFull = errors.New("arena full")
+	// ErrTooLarge on alloc more than MaxAlloc
+	ErrTooLarge = errors.New("allocation too large")
+	// ErrDamaged on a block header or free list out of the arena
+	ErrDamaged = errors.New("arena damaged")
+)
+
+// arena header in the memory
+type header struct {
+	// end of the allocated blocks, 0 for HeaderSize
+	bump uint64
+	// free list of each class, a tag in the high 32 bits against ABA,
+	// the offset of the first block in the low ones, 0 if empty
+	free [numClasses]uint64
+}
+
+// block header
+type block struct {
+	class uint32
+	// requested length
+	len uint32
+	// next free block, in the payload while free
+	next uint32
+}
+
+// Arena of blocks in a memory
+type Arena struct {
+	mem  []byte
+	head *header
+}
+
+// At return the arena in b, zeroed memory is an empty arena
+// all processes must pass memory of the same length
+func At(b []byte) (*Arena, error) {
+	if len(b) < HeaderSize+minBlock || uint64(len(b)) > maxSize {
+		return nil, ErrSize
+	}
+	p := unsafe.Pointer(&b[0])
+	if uintptr(p)&(minBlock-1) != 0 {
+		return nil, ErrAlign
+	}
+	return &Arena{mem: b, head: (*header)(p)}, nil
+}
+
+// class of an allocation of n bytes
+func class(n int) int {
+	c := 0
+	for minBlock<<uint(c) < n+blockHeader {
+		c++
+	}
+	return c
+}
+
+// Alloc n bytes, return the offset of the block
+func (a *Arena) Alloc(n int) (off uint32, err error) {
+	if n < 0 || n > MaxAlloc {
+		return 0, ErrTooLarge
+	}
+	c := class(n)
+	if off, err = a.pop(c); err != nil {
+		return
+	}
+	if off == 0 {
+		if off, err = a.bump(minBlock << uint(c)); err != nil {
+			return
+		}
+	}
+	b := a.block(off)
+	b.class = uint32(c)
+	b.len = uint32(n)
+	return
+}
+
+// Free the block at off, from Alloc, ErrDamaged if off or the class
+// in its header is out of the arena
+func (a *Arena) Free(off uint32) error {
+	if !a.valid(off) {
+		return ErrDamaged
+	}
+	b := a.block(off)
+	c := atomic.LoadUint32(&b.class)
+	if c >= numClasses || uint64(off)+minBlock<<c > a.end() {
+		return ErrDamaged
+	}
+	h := &a.head.free[c]
+	for {
+		old := atomic.LoadUint64(h)
+		atomic.StoreUint32(&b.next, uint32(old))
+		if atomic.CompareAndSwapUint64(h, old, (old>>32+1)<<32|uint64(off)) {
+			return nil
+		}
+	}
+}
+
+// Bytes return the allocated bytes of the block at off,
+// capacity to the end of the block
+func (a *Arena) Bytes(off uint32) []byte {
+	b := a.block(off)
+	start := int(off) + blockHeader
+	end := int(off) + minBlock<<b.class
+	return a.mem[start : start+int(b.len) : end]
+}
+
+// Used return the bytes of blocks taken from the free space,
+// including those freed since
+func (a *Arena) Used() int {
+	bump := atomic.LoadUint64(&a.head.bump)
+	if bump == 0 {
+		return 0
+	}
+	return int(bump) - HeaderSize
+}
+
+// pop a free block of class c, 0 if none, ErrDamaged if the list
+// leaves the arena
+func (a *Arena) pop(c int) (uint32, error) {
+	h := &a.head.free[c]
+	for {
+		old := atomic.LoadUint64(h)
+		off := uint32(old)
+		if off == 0 {
+			return 0, nil
+		}
+		if !a.valid(off) {
+			return 0, ErrDamaged
+		}
+		// may be stale if popped meanwhile, the tag fails the CAS then
+		next := atomic.LoadUint32(&a.block(off).next)
+		if next != 0 && !a.valid(next) {
+			// the list cut, off and the blocks after it lost
+			if atomic.CompareAndSwapUint64(h, old, (old>>32+1)<<32) {
+				return 0, ErrDamaged
+			}
+			continue
+		}
+		if atomic.CompareAndSwapUint64(h, old, (old>>32+1)<<32|uint64(next)) {
+			return off, nil
+		}
+	}
+}
+
+// end of the blocks taken from the free space
+func (a *Arena) end() uint64 {
+	bump := atomic.LoadUint64(&a.head.bump)
+	if bump == 0 {
+		return HeaderSize
+	}
+	return bump
+}
+
+// off is the start of a block taken from the free space
+func (a *Arena) valid(off uint32) bool {
+	return off >= HeaderSize && off&(minBlock-1) == 0 && uint64(off)+minBlock <= a.end()
+}
+
+// take size bytes from the free space
+func (a *Arena) bump(size int) (uint32, error) {
+	for {
+		old := atomic.LoadUint64(&a.head.bump)
+		start := old
+		if start == 0 {
+			start = HeaderSize
+		}
+		end := start + uint64(size)
+		if end > uint64(len(a.mem)) {
+			return 0, ErrFull
+		}
+		if atomic.CompareAndSwapUint64(&a.head.bump, old, end) {
+			return uint32(start), nil
+		}
+	}
+}
+
+// block header at off
+func (a *Arena) block(off uint32) *block {
+	return (*block)(unsafe.Pointer(&a.mem[off]))
+}
@@ -0,0 +1,147 @@
+package arena
+
+import (
+	"testing"
+	"unsafe"
+)
+
+// 16 byte aligned memory
+func memory(n int) []byte {
+	b := make([]byte, n+minBlock)
+	off := int(-uintptr(unsafe.Pointer(&b[0])) & (minBlock - 1))
+	return b[off : off+n]
+}
+
+func TestArena(t *testing.T) {
+	a, err := At(memory(HeaderSize + 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := a.Alloc(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(a.Bytes(x), "0123456789")
+	y, err := a.Alloc(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Bytes(y)) != 100 || cap(a.Bytes(y)) != 120 {
+		t.Errorf("block of len %d, cap %d", len(a.Bytes(y)), cap(a.Bytes(y)))
+	}
+	if string(a.Bytes(x)) != "0123456789" {
+		t.Errorf("overwritten block %q", a.Bytes(x))
+	}
+	a.Free(x)
+	z, err := a.Alloc(12)
+	if err != nil || z != x {
+		t.Errorf("expect freed block %d, got %d, %v", x, z, err)
+	}
+	if _, err = a.Alloc(1024); err != ErrFull {
+		t.Errorf("expect ErrFull, got %v", err)
+	}
+	if _, err = a.Alloc(MaxAlloc + 1); err != ErrTooLarge {
+		t.Errorf("expect ErrTooLarge, got %v", err)
+	}
+}
+
+func TestArenaDamaged(t *testing.T) {
+	a, err := At(memory(HeaderSize + 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := a.Alloc(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Free(x + 1024); err != ErrDamaged {
+		t.Errorf("expect ErrDamaged on an offset out of the arena, got %v", err)
+	}
+	c := a.block(x).class
+	a.block(x).class = numClasses
+	if err = a.Free(x); err != ErrDamaged {
+		t.Errorf("expect ErrDamaged on a class out of range, got %v", err)
+	}
+	a.block(x).class = c
+	if err = a.Free(x); err != nil {
+		t.Fatal(err)
+	}
+	a.block(x).next = 1 << 20
+	if _, err = a.Alloc(10); err != ErrDamaged {
+		t.Errorf("expect ErrDamaged on a free list out of the arena, got %v", err)
+	}
+	if y, err := a.Alloc(10); err != nil || y == x {
+		t.Errorf("expect a new block past the cut list, got %d, %v", y, err)
+	}
+}
+
+// blocks of 4 bytes in a slice
+type pool struct {
+	blocks [][4]byte
+	next   []int32
+	free   []int32
+}
+
+func (p *pool) Len() int32 {
+	return int32(len(p.blocks))
+}
+
+func (p *pool) Get() int32 {
+	if len(p.free) == 0 {
+		return -1
+	}
+	i := p.free[len(p.free)-1]
+	p.free = p.free[:len(p.free)-1]
+	return i
+}
+
+func (p *pool) Put(first, last int32) {
+	for i := first; ; i = p.next[i] {
+		p.free = append(p.free, i)
+		if i == last {
+			return
+		}
+	}
+}
+
+func (p *pool) Block(i int32) []byte {
+	return p.blocks[i][:]
+}
+
+func (p *pool) Fill(i int32, b []byte) int {
+	return copy(p.blocks[i][:], b)
+}
+
+func (p *pool) Next(i int32) int32 {
+	return p.next[i]
+}
+
+func (p *pool) Link(i, next int32) {
+	p.next[i] = next
+}
+
+func TestChain(t *testing.T) {
+	p := &pool{blocks: make([][4]byte, 4), next: make([]int32, 4), free: []int32{3, 2, 1, 0}}
+	first, err := WriteChain(p, []byte("0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 10)
+	if err = ReadChain(p, first, b); err != nil || string(b) != "0123456789" {
+		t.Errorf("expect the chain read back, got %q, %v", b, err)
+	}
+	last := ChainEnd(p, first, 10)
+	if last != 2 {
+		t.Errorf("expect the chain to end at 2, got %d", last)
+	}
+	if _, err = WriteChain(p, []byte("0123456789")); err != ErrFull || len(p.free) != 1 {
+		t.Errorf("expect ErrFull with the free block put back, got %v, %d free", err, len(p.free))
+	}
+	p.next[1] = 8
+	if err = ReadChain(p, first, b); err != ErrDamaged {
+		t.Errorf("expect ErrDamaged, got %v", err)
+	}
+	if last = ChainEnd(p, first, 10); last != 1 {
+		t.Errorf("expect the chain cut at 1, got %d", last)
+	}
+}
@@ -0,0 +1,75 @@
+package arena
+
+// Pool of blocks of one size addressed by index, holding a value
+// longer than a block in a chain of blocks linked by index, as the
+// continuation buckets of a map hold the spans of its long values
+type Pool interface {
+	// Len return the number of blocks, indices are below it
+	Len() int32
+	// Get a free block, -1 if none
+	Get() int32
+	// Put the chain from first to last back to the free blocks
+	Put(first, last int32)
+	// Block return the bytes of block i
+	Block(i int32) []byte
+	// Fill block i with the head of b, return the bytes copied
+	Fill(i int32, b []byte) int
+	// Next return the block after i in its chain
+	Next(i int32) int32
+	// Link block i to next
+	Link(i, next int32)
+}
+
+// WriteChain b to a chain of blocks of p, return the first, -1 if b
+// is empty, ErrFull if the free blocks are too few, those taken put
+// back then
+func WriteChain(p Pool, b []byte) (first int32, err error) {
+	first, last := int32(-1), int32(-1)
+	for off := 0; off < len(b); {
+		i := p.Get()
+		if i < 0 {
+			if first >= 0 {
+				p.Put(first, last)
+			}
+			return -1, ErrFull
+		}
+		off += p.Fill(i, b[off:])
+		if last < 0 {
+			first = i
+		} else {
+			p.Link(last, i)
+		}
+		last = i
+	}
+	return first, nil
+}
+
+// ReadChain fill b from the chain at first, ErrDamaged if the chain
+// leaves p before b is full
+func ReadChain(p Pool, first int32, b []byte) error {
+	for i, off := first, 0; off < len(b); i = p.Next(i) {
+		if i < 0 || i >= p.Len() {
+			return ErrDamaged
+		}
+		off += copy(b[off:], p.Block(i))
+	}
+	return nil
+}
+
+// ChainEnd return the last block of the chain of n bytes at first,
+// cut where it leaves p, -1 if first is out of p
+func ChainEnd(p Pool, first int32, n int) (last int32) {
+	if first < 0 || first >= p.Len() {
+		return -1
+	}
+	last = first
+	size := len(p.Block(first))
+	for k := n - size; k > 0; k -= size {
+		next := p.Next(last)
+		if next < 0 || next >= p.Len() {
+			break
+		}
+		last = next
+	}
+	return
+}
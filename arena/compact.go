@@ -0,0 +1,89 @@
+package arena
+
+import "sync/atomic"
+
+// Compactor takes free blocks below a limit for the blocks moved down
+// by a defragmenter, and gives the space above the blocks left in use
+// back to the free space
+type Compactor struct {
+	a     *Arena
+	limit uint32
+	// free blocks at or above limit taken off the free lists
+	aside []uint32
+}
+
+// Compactor of the blocks moved below limit
+func (a *Arena) Compactor(limit uint32) *Compactor {
+	return &Compactor{a: a, limit: limit}
+}
+
+// Size return the bytes of the block at off, its header included
+func (a *Arena) Size(off uint32) int {
+	return minBlock << a.block(off).class
+}
+
+// Alloc n bytes in a free block below the limit, false if none, the
+// free blocks above it popped meanwhile are set aside
+func (c *Compactor) Alloc(n int) (off uint32, ok bool) {
+	if n < 0 || n > MaxAlloc {
+		return 0, false
+	}
+	cl := class(n)
+	for {
+		if off, _ = c.a.pop(cl); off == 0 {
+			return 0, false
+		}
+		if off < c.limit {
+			break
+		}
+		c.aside = append(c.aside, off)
+	}
+	b := c.a.block(off)
+	b.class = uint32(cl)
+	b.len = uint32(n)
+	return off, true
+}
+
+// Release the blocks set aside to the free lists, ErrDamaged if any
+// was overwritten meanwhile, then it is dropped
+func (c *Compactor) Release() (err error) {
+	for _, off := range c.aside {
+		if e := c.a.Free(off); e != nil {
+			err = e
+		}
+	}
+	c.aside = nil
+	return
+}
+
+// Trim the free space to start at end, past every block in use, the
+// free blocks at or above it dropped, return the bytes given back
+// no other may use the arena meanwhile
+func (c *Compactor) Trim(end uint32) int {
+	a := c.a
+	bump := atomic.LoadUint64(&a.head.bump)
+	if end < HeaderSize {
+		end = HeaderSize
+	}
+	if bump <= uint64(end) {
+		c.Release()
+		return 0
+	}
+	keep := c.aside[:0]
+	for _, off := range c.aside {
+		if off < end {
+			keep = append(keep, off)
+		}
+	}
+	for cl := 0; cl < numClasses; cl++ {
+		for off, _ := a.pop(cl); off != 0; off, _ = a.pop(cl) {
+			if off < end {
+				keep = append(keep, off)
+			}
+		}
+	}
+	c.aside = keep
+	c.Release()
+	atomic.StoreUint64(&a.head.bump, uint64(end))
+	return int(bump) - int(end)
+}
@@ -0,0 +1,36 @@
+package shm
+
+import (
+	"github.com/fengyoulin/shm/database"
+	"github.com/fengyoulin/shm/mapping"
+	"os"
+)
+
+// filer is a Mapping of a file
+type filer interface {
+	File() *os.File
+}
+
+// Exclusive make sure no other process is attached to the map until
+// release, return ErrAttached otherwise, for maintenance as Repair
+// processes opening the map meanwhile wait for release, which may
+// time them out, as another open of the map in this process does
+// it always succeeds without a backing file, or where the platform
+// has no flock
+func (m *Map) Exclusive() (release func() error, err error) {
+	fm, ok := m.mp.(filer)
+	if !ok || fm.File() == nil {
+		return func() error { return nil }, nil
+	}
+	return database.Exclusive(fm.File())
+}
+
+// Attached return the count of the maps open on the file at path in
+// all processes, 0 if none, -1 if some but the count is not known,
+// only linux counts them
+func Attached(path string) (int, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		path = mapping.SegmentName(path, 0)
+	}
+	return database.Attached(path)
+}
@@ -0,0 +1,35 @@
+package shm
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestMap_Exclusive(t *testing.T) {
+	name := "testexclusive.db"
+	m := newTestFile(t, name, 64, 16, 8)
+	defer os.Remove(name)
+	defer m.Close()
+	var err error
+	o := newTestFile(t, name, 64, 16, 8)
+	if runtime.GOOS == "linux" {
+		if n, err := Attached(name); err != nil || n != 2 {
+			t.Errorf("expect 2 attached, got %d, %v", n, err)
+		}
+	}
+	if runtime.GOOS != "windows" {
+		if err = m.Repair(); err != ErrAttached {
+			t.Errorf("expect ErrAttached, got %v", err)
+		}
+	}
+	if err = o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Repair(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := Attached(name); err != nil || n == 0 {
+		t.Errorf("expect attached, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,180 @@
+package shm
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// entries of the audit ring of AuditLog by default, and at most
+const (
+	defaultAuditSize = 1024
+	maxAuditSize     = 1 << 20
+)
+
+// bytes of a key kept in an audit entry, the rest cut
+const auditKeyLen = 40
+
+// ErrNoAudit on Audit of a map created without AuditLog
+var ErrNoAudit = errors.New("map without audit log")
+
+// AuditOp of an entry of the audit log
+type AuditOp uint8
+
+// ops audited
+const (
+	// AuditSet of a write of a value, or a key added by Get
+	AuditSet AuditOp = 1 + iota
+	// AuditDelete of a Delete
+	AuditDelete
+	// AuditExpire of an entry deleted expired
+	AuditExpire
+	// AuditEvict of an entry evicted
+	AuditEvict
+)
+
+// String of the op, as shmtool prints it
+func (op AuditOp) String() string {
+	switch op {
+	case AuditSet:
+		return "set"
+	case AuditDelete:
+		return "delete"
+	case AuditExpire:
+		return "expire"
+	case AuditEvict:
+		return "evict"
+	}
+	return "unknown"
+}
+
+// AuditEntry of the audit log, an op on a key by a process
+type AuditEntry struct {
+	// Seq of the entry, counting from 0 over the life of the map
+	Seq uint64
+	// Time of the op
+	Time time.Time
+	// PID of the process doing it
+	PID int
+	Op  AuditOp
+	// Key of the op, its first 40 bytes
+	Key string
+	// KeyLen of the key in full
+	KeyLen int
+}
+
+// audit record after the version counter, the entries follow it
+type audit struct {
+	// entries recorded, the next one at next % size of the ring
+	next uint64
+	_    uint64
+}
+
+// an entry of the ring, 64 bytes, seq is that of the entry plus one,
+// 0 while written, stored last
+type auditEntry struct {
+	seq  uint64
+	time int64
+	pid  int32
+	op   uint8
+	klen uint8
+	_    [2]byte
+	key  [auditKeyLen]byte
+}
+
+// the offset of the audit record of a map of features f from its
+// header, the records of the features before it skipped
+func auditOff(f uint32) uintptr {
+	off := unsafe.Sizeof(header{})
+	if f&featOrigin != 0 {
+		off += unsafe.Sizeof(origin{})
+	}
+	if f&featFIFO != 0 {
+		off += unsafe.Sizeof(fifo{})
+	}
+	if f&featACL != 0 {
+		off += unsafe.Sizeof(acl{})
+	}
+	if f&featVersion != 0 {
+		off += unsafe.Sizeof(verSeq{})
+	}
+	return off
+}
+
+// the audit record of a map of header h
+func auditOf(h *header) *audit {
+	return (*audit)(unsafe.Pointer(uintptr(unsafe.Pointer(h)) + auditOff(h.features)))
+}
+
+// the size of the audit ring of h, the space left before the hash area,
+// of offsets only, h may be a copy of the header
+func (h *header) auditSize() int {
+	if h.features&featAudit == 0 {
+		return 0
+	}
+	off := auditOff(h.features)
+	return int((uintptr(h.hashOff) - off - unsafe.Sizeof(audit{})) / unsafe.Sizeof(auditEntry{}))
+}
+
+// the entry i of the ring
+func (a *audit) entry(i int) *auditEntry {
+	off := unsafe.Sizeof(audit{}) + uintptr(i)*unsafe.Sizeof(auditEntry{})
+	return (*auditEntry)(unsafe.Pointer(uintptr(unsafe.Pointer(a)) + off))
+}
+
+// record op on key by the process, if the map has an audit log
+func (m *Map) auditOp(op AuditOp, key string) {
+	a := m.auditLog
+	if a == nil {
+		return
+	}
+	n := atomic.AddUint64(&a.next, 1) - 1
+	e := a.entry(int(n % uint64(m.auditSize)))
+	atomic.StoreUint64(&e.seq, 0)
+	e.time = time.Now().UnixNano()
+	e.pid = int32(m.pid)
+	e.op = uint8(op)
+	e.klen = uint8(len(key))
+	copy(e.key[:], key)
+	atomic.StoreUint64(&e.seq, n+1)
+}
+
+// Audit return the entries of the audit log of AuditLog, oldest first,
+// those being written skipped
+func (m *Map) Audit() ([]AuditEntry, error) {
+	if m.auditLog == nil {
+		return nil, ErrNoAudit
+	}
+	a, size := m.auditLog, uint64(m.auditSize)
+	next := atomic.LoadUint64(&a.next)
+	first := uint64(0)
+	if next > size {
+		first = next - size
+	}
+	entries := make([]AuditEntry, 0, next-first)
+	for n := first; n < next; n++ {
+		e := a.entry(int(n % size))
+		if atomic.LoadUint64(&e.seq) != n+1 {
+			continue
+		}
+		r := AuditEntry{
+			Seq:    n,
+			Time:   time.Unix(0, e.time),
+			PID:    int(e.pid),
+			Op:     AuditOp(e.op),
+			KeyLen: int(e.klen),
+		}
+		if r.KeyLen > auditKeyLen {
+			r.Key = string(e.key[:])
+		} else {
+			r.Key = string(e.key[:r.KeyLen])
+		}
+		// overwritten meanwhile
+		if atomic.LoadUint64(&e.seq) != n+1 {
+			continue
+		}
+		entries = append(entries, r)
+	}
+	return entries, nil
+}
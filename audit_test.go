@@ -0,0 +1,105 @@
+package shm
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMap_Audit(t *testing.T) {
+	name := "testaudit.db"
+	defer os.Remove(name)
+	m := newTestFile(t, name, 64, 64, 8, RecordOrigin(), FreeFIFO(0), ACL([]int{os.Getuid()}, nil), AuditLog(8))
+	var err error
+	long := strings.Repeat("k", 50)
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", long} {
+		if err = m.Set(k, []byte(k[:1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !m.Delete("a") || !m.Delete(long) {
+		t.Fatal("expect deleted")
+	}
+	_ = m.Close()
+	if m, err = Open(name, testMaxTry, initWait); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err = m.Set("h", nil); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := m.Audit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 8 || entries[0].Seq != 3 {
+		t.Fatalf("expect the last 8 entries, got %v", entries)
+	}
+	last := entries[len(entries)-3:]
+	if last[0].Op != AuditDelete || last[0].Key != "a" || last[2].Op != AuditSet || last[2].Key != "h" {
+		t.Errorf("unexpected entries %v", last)
+	}
+	if e := last[1]; e.Key != long[:auditKeyLen] || e.KeyLen != len(long) {
+		t.Errorf("expect the key cut to %d bytes, got %q of %d", auditKeyLen, e.Key, e.KeyLen)
+	}
+	for _, e := range entries {
+		if e.PID != os.Getpid() || time.Since(e.Time) > time.Minute {
+			t.Errorf("unexpected entry %v", e)
+		}
+	}
+	if o, err := m.Origin(); err != nil || o.PID != os.Getpid() {
+		t.Errorf("expect the origin kept, got %v, %v", o, err)
+	}
+	plain := newTestMap(t, 8, 8, 8)
+	defer plain.Close()
+	if _, err = plain.Audit(); err != ErrNoAudit {
+		t.Errorf("expect %v, got %v", ErrNoAudit, err)
+	}
+}
+
+func TestMap_AuditWrites(t *testing.T) {
+	m := newTestMap(t, 64, 8, 8, Expiration(), Versions(), KeyLocks(), AuditLog(16))
+	defer m.Close()
+	// added once, the second a read
+	for i := 0; i < 2; i++ {
+		if _, err := m.Get("get", true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Update("update", true, func(value []byte) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetIfVersion("version", []byte("v"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetMissing("missing", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	tx := m.Txn()
+	if err := tx.Lock(context.Background(), "txn", "get"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Set("txn", []byte("t")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Delete("get"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := m.Audit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Op.String()+" "+e.Key)
+	}
+	expect := []string{"set get", "set update", "set version", "set missing", "set txn", "delete get"}
+	if strings.Join(got, ",") != strings.Join(expect, ",") {
+		t.Errorf("expect %v, got %v", expect, got)
+	}
+}
@@ -0,0 +1,168 @@
+// Package backup streams the dumps of a map to a sink, a directory,
+// an object store or any io.Writer, for the disaster recovery of
+// persistent maps; a dump is that of Map.DumpZstd, restored by
+// Map.LoadZstd
+package backup
+
+import (
+	"context"
+	"fmt"
+	"github.com/fengyoulin/shm"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Writer of a backup, Close commits it, Abort drops what was written
+type Writer interface {
+	io.Writer
+	Close() error
+	Abort() error
+}
+
+// Sink of backups by name
+type Sink interface {
+	Create(ctx context.Context, name string) (Writer, error)
+}
+
+// Option of a backup
+type Option func(*options)
+
+type options struct {
+	consistent bool
+	blockSize  int
+}
+
+// Consistent dump a frozen copy of the map, see Map.FencedSnapshot,
+// rather than the map as writers go on with it, the writers of all
+// processes paused while it is copied
+func Consistent() Option {
+	return func(o *options) {
+		o.consistent = true
+	}
+}
+
+// BlockSize of the compressed blocks of the dump, see Map.DumpZstd
+func BlockSize(n int) Option {
+	return func(o *options) {
+		o.blockSize = n
+	}
+}
+
+// Write the dump of m to w, ctx bounding the wait for the fence of
+// Consistent
+func Write(ctx context.Context, m *shm.Map, w io.Writer, opts ...Option) (err error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.consistent {
+		if m, err = m.FencedSnapshot(ctx); err != nil {
+			return
+		}
+		defer func() {
+			if e := m.Close(); err == nil {
+				err = e
+			}
+		}()
+	}
+	return m.DumpZstd(w, o.blockSize)
+}
+
+// Run write the dump of m to the backup name of s, return the bytes
+// written; the backup is dropped on error
+func Run(ctx context.Context, m *shm.Map, s Sink, name string, opts ...Option) (n int64, err error) {
+	w, err := s.Create(ctx, name)
+	if err != nil {
+		return
+	}
+	cw := &counter{ctx: ctx, w: w}
+	if err = Write(ctx, m, cw, opts...); err == nil {
+		err = w.Close()
+	} else {
+		_ = w.Abort()
+	}
+	return cw.n, err
+}
+
+// Name of a backup taken at t, prefix then the UTC time, which sort
+// by time
+func Name(prefix string, t time.Time) string {
+	return prefix + t.UTC().Format("20060102T150405Z") + ".shmdump"
+}
+
+// Job return a backup to s of a map named by prefix and the time, for
+// Janitor.Backup
+func Job(ctx context.Context, s Sink, prefix string, opts ...Option) func(m *shm.Map) error {
+	return func(m *shm.Map) error {
+		_, err := Run(ctx, m, s, Name(prefix, time.Now()), opts...)
+		return err
+	}
+}
+
+// counter of the bytes written, stop at ctx done
+type counter struct {
+	ctx context.Context
+	w   io.Writer
+	n   int64
+}
+
+func (c *counter) Write(b []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// Dir is a Sink of files in a directory, a backup written to a
+// temporary file renamed to its name on Close
+type Dir string
+
+// Create the file of the backup name
+func (d Dir) Create(ctx context.Context, name string) (Writer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if filepath.Base(name) != name {
+		return nil, fmt.Errorf("backup name %q not a file name", name)
+	}
+	f, err := ioutil.TempFile(string(d), "."+name+".*")
+	if err != nil {
+		return nil, err
+	}
+	return &file{f: f, name: filepath.Join(string(d), name)}, nil
+}
+
+type file struct {
+	f    *os.File
+	name string
+}
+
+func (f *file) Write(b []byte) (int, error) {
+	return f.f.Write(b)
+}
+
+// Close sync the file and rename it to the backup
+func (f *file) Close() error {
+	err := f.f.Sync()
+	if e := f.f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(f.f.Name(), f.name)
+	}
+	if err != nil {
+		_ = os.Remove(f.f.Name())
+	}
+	return err
+}
+
+// Abort remove the temporary file
+func (f *file) Abort() error {
+	_ = f.f.Close()
+	return os.Remove(f.f.Name())
+}
@@ -0,0 +1,158 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/fengyoulin/shm"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 keep the objects put and uploaded by parts, failing the first
+// part once
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	parts   map[int][]byte
+	failed  bool
+	aborted bool
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=ak/") {
+		http.Error(w, "unsigned", http.StatusForbidden)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	q := r.URL.Query()
+	_, uploads := q["uploads"]
+	switch {
+	case r.Method == "POST" && uploads:
+		f.parts = make(map[int][]byte)
+		fmt.Fprint(w, "<InitiateMultipartUploadResult><UploadId>u1</UploadId></InitiateMultipartUploadResult>")
+	case r.Method == "PUT" && q.Get("uploadId") == "u1":
+		if !f.failed {
+			f.failed = true
+			http.Error(w, "slow down", http.StatusServiceUnavailable)
+			return
+		}
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		f.parts[n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, n))
+	case r.Method == "POST" && q.Get("uploadId") == "u1":
+		var b []byte
+		for i := 1; i <= len(f.parts); i++ {
+			b = append(b, f.parts[i]...)
+		}
+		f.objects[r.URL.Path] = b
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == "DELETE":
+		f.aborted = true
+	case r.Method == "PUT":
+		f.objects[r.URL.Path] = body
+	default:
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	name := "testbackup.db"
+	defer os.Remove(name)
+	m, err := shm.Create(name, 1024, 16, 64, 10, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	for i := 0; i < 1000; i++ {
+		if err = m.Set(strconv.Itoa(i), []byte(strings.Repeat(strconv.Itoa(i), 8))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var want bytes.Buffer
+	if err = Write(context.Background(), m, &want, BlockSize(4096)); err != nil {
+		t.Fatal(err)
+	}
+	check := func(b []byte) {
+		t.Helper()
+		if err := shm.VerifyDump(bytes.NewReader(b), int64(len(b))); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, want.Bytes()) {
+			t.Errorf("expect the dump of %d bytes, got %d", want.Len(), len(b))
+		}
+	}
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	n, err := Run(ctx, m, Dir(dir), "a.shmdump", Consistent(), BlockSize(4096))
+	if err != nil || n != int64(want.Len()) {
+		t.Fatalf("expect %d bytes written, got %d, %v", want.Len(), n, err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "a.shmdump"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(b)
+
+	f := &fakeS3{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	s3 := &S3{Endpoint: srv.URL, Region: "us-east-1", Bucket: "b", Prefix: "maps/", AccessKey: "ak", SecretKey: "sk", PartSize: 1024}
+	if _, err = Run(ctx, m, s3, "multi", BlockSize(4096)); err != nil {
+		t.Fatal(err)
+	}
+	if !f.failed || len(f.parts) < 2 {
+		t.Errorf("expect parts retried, got failed %v, %d parts", f.failed, len(f.parts))
+	}
+	check(f.objects["/b/maps/multi"])
+	s3.PartSize = 0
+	if _, err = Run(ctx, m, s3, "single", BlockSize(4096)); err != nil {
+		t.Fatal(err)
+	}
+	check(f.objects["/b/maps/single"])
+
+	// a backup failing half way is aborted
+	s3.PartSize, s3.Tries, f.failed = 1024, 1, false
+	if _, err = Run(ctx, m, s3, "failed", BlockSize(4096)); err == nil || !f.aborted {
+		t.Errorf("expect the upload aborted, got %v", err)
+	}
+	if _, ok := f.objects["/b/maps/failed"]; ok {
+		t.Error("expect no object of a failed upload")
+	}
+
+	// scheduled by the janitor
+	j, err := m.Janitor(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Backup(time.Hour, Job(ctx, Dir(dir), "map-", BlockSize(4096)))
+	if !j.Run() || !j.Run() {
+		t.Fatal("expect the janitor to run")
+	}
+	if st := j.Stats(); st.Backups != 1 || st.BackupErr != nil {
+		t.Errorf("expect one backup, got %d, %v", st.Backups, st.BackupErr)
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, "map-*.shmdump"))
+	if len(names) != 1 {
+		t.Fatalf("expect one backup file, got %v", names)
+	}
+	if b, err = ioutil.ReadFile(names[0]); err != nil {
+		t.Fatal(err)
+	}
+	check(b)
+}
@@ -0,0 +1,355 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// default bytes of a part of a multipart upload
+	defaultPart = 8 << 20
+	// default tries of a request
+	defaultTries = 4
+)
+
+var (
+	// ErrAborted on write a backup after its upload failed or was
+	// aborted
+	ErrAborted = errors.New("upload aborted")
+	// ErrClosed on write a backup after Close
+	ErrClosed = errors.New("upload closed")
+)
+
+// S3 is a Sink of objects of an S3 compatible store, a backup sent
+// in one request if it fits in a part, or else uploaded by parts, each
+// request retried on a network error or a 5xx or 429 response
+// requests are signed by AWS signature version 4, path style
+type S3 struct {
+	// Endpoint of the store, as https://s3.us-east-1.amazonaws.com
+	Endpoint string
+	// Region signed for
+	Region string
+	// Bucket of the objects
+	Bucket string
+	// Prefix of the object keys
+	Prefix string
+	// AccessKey, SecretKey and SessionToken of the credentials, the
+	// token "" if none
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// PartSize in bytes, 8 MiB if 0; S3 takes no part but the last
+	// below 5 MiB
+	PartSize int
+	// Tries of a request, 4 if 0
+	Tries int
+	// Client of the requests, http.DefaultClient if nil
+	Client *http.Client
+}
+
+// Create an object of key Prefix plus name
+func (s *S3) Create(ctx context.Context, name string) (Writer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	size := s.PartSize
+	if size <= 0 {
+		size = defaultPart
+	}
+	return &upload{s: s, ctx: ctx, key: s.Prefix + name, size: size}, nil
+}
+
+// an upload of an object
+type upload struct {
+	s    *S3
+	ctx  context.Context
+	key  string
+	size int
+	buf  []byte
+	// of the multipart upload, "" before the first part is sent
+	id    string
+	etags []string
+	err   error
+}
+
+func (u *upload) Write(b []byte) (n int, err error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+	for len(b) > 0 {
+		k := copy(u.buf[len(u.buf):cap(u.buf)], b)
+		if k == 0 {
+			if u.buf == nil {
+				u.buf = make([]byte, 0, u.size)
+				continue
+			}
+			if err = u.part(); err != nil {
+				return
+			}
+			continue
+		}
+		u.buf = u.buf[:len(u.buf)+k]
+		b = b[k:]
+		n += k
+	}
+	return
+}
+
+// send the buffer as the next part
+func (u *upload) part() (err error) {
+	defer u.fail(&err)
+	if u.id == "" {
+		var res struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err = u.s.do(u.ctx, "POST", u.key, url.Values{"uploads": {""}}, nil, &res, nil); err != nil {
+			return
+		}
+		if res.UploadID == "" {
+			return errors.New("s3: no upload id")
+		}
+		u.id = res.UploadID
+	}
+	q := url.Values{"partNumber": {strconv.Itoa(len(u.etags) + 1)}, "uploadId": {u.id}}
+	var h http.Header
+	if err = u.s.do(u.ctx, "PUT", u.key, q, u.buf, nil, &h); err != nil {
+		return
+	}
+	u.etags = append(u.etags, h.Get("ETag"))
+	u.buf = u.buf[:0]
+	return
+}
+
+// abort the multipart upload on err
+func (u *upload) fail(err *error) {
+	if *err == nil {
+		return
+	}
+	u.err = fmt.Errorf("%w: %v", ErrAborted, *err)
+	_ = u.Abort()
+}
+
+// Close send the last part and complete the upload, or put the object
+// if no part was sent
+func (u *upload) Close() (err error) {
+	if u.err != nil {
+		return u.err
+	}
+	if u.id == "" {
+		if err = u.s.do(u.ctx, "PUT", u.key, nil, u.buf, nil, nil); err != nil {
+			u.err = fmt.Errorf("%w: %v", ErrAborted, err)
+			return
+		}
+		u.err = ErrClosed
+		return
+	}
+	if len(u.buf) > 0 {
+		if err = u.part(); err != nil {
+			return
+		}
+	}
+	defer u.fail(&err)
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	var req struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}
+	for i, etag := range u.etags {
+		req.Parts = append(req.Parts, part{i + 1, etag})
+	}
+	body, err := xml.Marshal(&req)
+	if err != nil {
+		return
+	}
+	// a complete may fail with a 200 response
+	var res struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if err = u.s.do(u.ctx, "POST", u.key, url.Values{"uploadId": {u.id}}, body, &res, nil); err != nil {
+		return
+	}
+	if res.XMLName.Local == "Error" {
+		return fmt.Errorf("s3: complete: %s: %s", res.Code, res.Message)
+	}
+	u.err, u.id = ErrClosed, ""
+	return
+}
+
+// Abort the multipart upload, the parts sent are dropped
+func (u *upload) Abort() error {
+	if u.err == nil {
+		u.err = ErrAborted
+	}
+	if u.id == "" {
+		return nil
+	}
+	id := u.id
+	u.id = ""
+	return u.s.do(context.Background(), "DELETE", u.key, url.Values{"uploadId": {id}}, nil, nil, nil)
+}
+
+// do a request of the object key, retried, decode the xml response
+// to res, and copy the headers to h, if not nil
+func (s *S3) do(ctx context.Context, method, key string, q url.Values, body []byte, res interface{}, h *http.Header) (err error) {
+	tries := s.Tries
+	if tries <= 0 {
+		tries = defaultTries
+	}
+	wait := 100 * time.Millisecond
+	for i := 0; i < tries; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+		var retry bool
+		if retry, err = s.try(ctx, method, key, q, body, res, h); err == nil || !retry {
+			return
+		}
+	}
+	return
+}
+
+// try a request once, report whether it may be retried on error
+func (s *S3) try(ctx context.Context, method, key string, q url.Values, body []byte, res interface{}, h *http.Header) (bool, error) {
+	u := strings.TrimRight(s.Endpoint, "/") + "/" + escape(s.Bucket+"/"+key, false)
+	if len(q) > 0 {
+		u += "?" + query(q)
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = int64(len(body))
+	s.sign(req, body, time.Now())
+	c := s.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return true, err
+	}
+	if resp.StatusCode/100 != 2 {
+		retry := resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("s3: %s %s: %s: %s", method, key, resp.Status, bytes.TrimSpace(b))
+	}
+	if h != nil {
+		*h = resp.Header
+	}
+	if res != nil {
+		if err = xml.Unmarshal(b, res); err != nil {
+			return false, fmt.Errorf("s3: %s %s: %v", method, key, err)
+		}
+	}
+	return false, nil
+}
+
+// sign req of body at t by AWS signature version 4
+func (s *S3) sign(req *http.Request, body []byte, t time.Time) {
+	t = t.UTC()
+	date, stamp := t.Format("20060102"), t.Format("20060102T150405Z")
+	sum := sha256.Sum256(body)
+	payload := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	names := []string{"host"}
+	for k := range req.Header {
+		names = append(names, strings.ToLower(k))
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, k := range names {
+		v := req.Host
+		if k != "host" {
+			v = strings.TrimSpace(req.Header.Get(k))
+		}
+		headers.WriteString(k + ":" + v + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{
+		req.Method,
+		escape(req.URL.Path, false),
+		req.URL.RawQuery,
+		headers.String(),
+		signed,
+		payload,
+	}, "\n")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	sum = sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := []byte("AWS4" + s.SecretKey)
+	for _, v := range []string{date, s.Region, "s3", "aws4_request"} {
+		key = hmacSum(key, v)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signed, hex.EncodeToString(hmacSum(key, toSign))))
+}
+
+func hmacSum(key []byte, v string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(v))
+	return h.Sum(nil)
+}
+
+// the canonical query of q, sorted by name
+func query(q url.Values) string {
+	names := make([]string, 0, len(q))
+	for k := range q {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, k := range names {
+		for _, v := range q[k] {
+			parts = append(parts, escape(k, true)+"="+escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// escape all but the unreserved bytes, and slashes unless all
+func escape(s string, all bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !all {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
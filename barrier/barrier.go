@@ -0,0 +1,97 @@
+// Package barrier is a reusable barrier for a fixed number of
+// parties, processes or goroutines, in shared memory
+package barrier
+
+import (
+	"context"
+	"errors"
+	"github.com/fengyoulin/shm/internal/futex"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Size of a barrier in bytes
+const Size = 4
+
+// MaxParties of a barrier
+const MaxParties = 1<<16 - 1
+
+var (
+	// ErrSize on a memory too small for a barrier
+	ErrSize = errors.New("memory too small")
+	// ErrAlign on a memory not aligned to 4 bytes
+	ErrAlign = errors.New("memory not aligned")
+	// ErrParties on parties out of range
+	ErrParties = errors.New("parties out of range")
+)
+
+// how long a waiter sleeps before checking ctx
+const checkInterval = 100 * time.Millisecond
+
+// Barrier block its parties until all of them arrive, then release
+// them together and start over
+// the word packs the generation in the high 16 bits and the parties
+// arrived in the low 16 bits
+type Barrier struct {
+	word    *uint32
+	parties uint32
+}
+
+// At return the barrier of parties in the first Size bytes of b,
+// zeroed memory is a barrier no party arrived at
+// all parties must agree on the number of parties
+func At(b []byte, parties int) (*Barrier, error) {
+	if parties <= 0 || parties > MaxParties {
+		return nil, ErrParties
+	}
+	if len(b) < Size {
+		return nil, ErrSize
+	}
+	p := unsafe.Pointer(&b[0])
+	if uintptr(p)&3 != 0 {
+		return nil, ErrAlign
+	}
+	return &Barrier{word: (*uint32)(p), parties: uint32(parties)}, nil
+}
+
+// Wait until all parties arrived, or ctx is done, when the arrival
+// is withdrawn and ctx.Err returned
+// last report whether this party arrived last and released the others
+func (b *Barrier) Wait(ctx context.Context) (last bool, err error) {
+	var gen uint32
+	for {
+		old := atomic.LoadUint32(b.word)
+		gen = old >> 16
+		next := old + 1
+		if old&0xffff+1 == b.parties {
+			next = (gen + 1) << 16
+		}
+		if atomic.CompareAndSwapUint32(b.word, old, next) {
+			if next&0xffff == 0 {
+				futex.Wake(b.word, 1<<30)
+				return true, nil
+			}
+			break
+		}
+	}
+	for {
+		cur := atomic.LoadUint32(b.word)
+		if cur>>16 != gen {
+			return false, nil
+		}
+		if err = ctx.Err(); err != nil {
+			// withdraw, unless the barrier opened meanwhile
+			if atomic.CompareAndSwapUint32(b.word, cur, cur-1) {
+				return false, err
+			}
+			continue
+		}
+		futex.Wait(b.word, cur, checkInterval)
+	}
+}
+
+// Arrived return the parties waiting at the barrier
+func (b *Barrier) Arrived() int {
+	return int(atomic.LoadUint32(b.word) & 0xffff)
+}
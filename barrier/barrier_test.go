@@ -0,0 +1,65 @@
+package barrier
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBarrier(t *testing.T) {
+	mem := make([]byte, Size)
+	const parties = 4
+	var phase, lasts int32
+	done := make(chan struct{})
+	for g := 0; g < parties; g++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			b, err := At(mem, parties)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for round := int32(0); round < 3; round++ {
+				if p := atomic.LoadInt32(&phase); p != round {
+					t.Errorf("round %d in phase %d", round, p)
+				}
+				last, err := b.Wait(context.Background())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if last {
+					atomic.AddInt32(&lasts, 1)
+					atomic.AddInt32(&phase, 1)
+				}
+				// all see the phase of the last one before the next round
+				if _, err = b.Wait(context.Background()); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	for g := 0; g < parties; g++ {
+		<-done
+	}
+	if lasts != 3 {
+		t.Errorf("expect 3 last arrivals, got %d", lasts)
+	}
+}
+
+func TestBarrier_Timeout(t *testing.T) {
+	b, err := At(make([]byte, Size), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = b.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expect deadline exceeded, got %v", err)
+	}
+	if b.Arrived() != 0 {
+		t.Errorf("arrival not withdrawn, %d arrived", b.Arrived())
+	}
+}
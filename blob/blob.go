@@ -0,0 +1,515 @@
+// Package blob keeps values too large for the buckets of a map in a
+// companion file, the map holding their offsets and lengths, so that
+// the index shared by processes stays compact, the values read from
+// the file with a system call; a value may be shared by keys, counted
+// by references, and the space of those no key refers to is reclaimed
+// by Vacuum
+package blob
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/fengyoulin/shm"
+	"github.com/fengyoulin/shm/database"
+	"github.com/fengyoulin/shm/mapping"
+	shmsync "github.com/fengyoulin/shm/sync"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// bytes of a reference in a map value, the offset of the record, the
+// length of the value, and the generation of the file
+const refSize = 16
+
+// bytes of a record before its value, the reference count and the
+// length
+const recordHeader = 16
+
+// bytes of a blob file before the records
+const fileHeader = 16
+
+// magic of a blob file
+var magic = [fileHeader]byte{'s', 'h', 'm', ' ', 'b', 'l', 'o', 'b', 1}
+
+var (
+	// ErrValueSize on a map of values shorter than a reference
+	ErrValueSize = errors.New("map values too small")
+	// ErrCorrupt on a blob file of a bad header, or a record of a bad
+	// length
+	ErrCorrupt = errors.New("blob record corrupt")
+	// ErrValueLen on put a value of 4 GiB or more
+	ErrValueLen = errors.New("value too long")
+)
+
+// header of the control file
+type header struct {
+	// lock of the writers
+	mu uint32
+	// generation of the file appended to
+	gen uint32
+	// end of the records in it, 0 for fileHeader
+	end uint64
+	// bytes of the records referred to by no key
+	garbage uint64
+}
+
+// Store of values in a blob file, by the keys of a map
+type Store struct {
+	m    *shm.Map
+	head *header
+	mu   *shmsync.Mutex
+	mp   *mapping.Mapping
+	path string
+	// open blob files by generation
+	files   map[uint32]*os.File
+	filesMu sync.Mutex
+}
+
+// a reference to a record
+type ref struct {
+	off uint64
+	len uint32
+	gen uint32
+}
+
+// Open the store of the map at path for at most keys keys of at most
+// keyLen bytes, with the control file at path.ctl and the blob files
+// at path.blob.N, waiting for at most wait for their locks
+func Open(path string, keys, keyLen int, wait time.Duration, opts ...shm.Option) (s *Store, err error) {
+	m, err := shm.Create(path, keys, keyLen, refSize, 0, wait, opts...)
+	if err != nil {
+		return
+	}
+	mp, unlock, err := database.Open(path+".ctl", int(unsafe.Sizeof(header{})), wait)
+	if err != nil {
+		_ = m.Close()
+		return
+	}
+	if err = unlock(); err == nil {
+		s, err = New(m, path, mp.Bytes())
+	}
+	if err != nil {
+		_ = mp.Close()
+		_ = m.Close()
+		return nil, err
+	}
+	s.mp = mp
+	return
+}
+
+// New use m of values of at least 16 bytes for the references, the
+// control header in ctl, and the blob files at path.blob.N
+func New(m *shm.Map, path string, ctl []byte) (*Store, error) {
+	if m.ValueCap() < refSize {
+		return nil, ErrValueSize
+	}
+	if len(ctl) < int(unsafe.Sizeof(header{})) {
+		return nil, ErrCorrupt
+	}
+	mu, err := shmsync.MutexAt(ctl)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		m:     m,
+		head:  (*header)(unsafe.Pointer(&ctl[0])),
+		mu:    mu,
+		path:  path,
+		files: make(map[uint32]*os.File),
+	}, nil
+}
+
+// lock the writers, a dead holder may have reserved a record it did
+// not refer to, or not counted a reference it dropped, both left for
+// Vacuum to count again
+func (s *Store) lock() error {
+	if err := s.mu.Lock(); err != nil && err != shmsync.ErrOwnerDead {
+		return err
+	}
+	return nil
+}
+
+// the blob file of generation gen, created if not exist
+func (s *Store) file(gen uint32) (f *os.File, err error) {
+	s.filesMu.Lock()
+	defer s.filesMu.Unlock()
+	if f = s.files[gen]; f != nil {
+		return
+	}
+	if f, err = os.OpenFile(fmt.Sprintf("%s.blob.%d", s.path, gen), os.O_RDWR|os.O_CREATE, 0664); err != nil {
+		return
+	}
+	var b [fileHeader]byte
+	n, err := f.ReadAt(b[:], 0)
+	switch {
+	case n == 0:
+		_, err = f.WriteAt(magic[:], 0)
+	case n < fileHeader || b != magic:
+		err = ErrCorrupt
+	default:
+		err = nil
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	s.files[gen] = f
+	return
+}
+
+// bytes of a record of a value of n bytes
+func recordSize(n uint32) uint64 {
+	return uint64(recordHeader) + uint64(n)
+}
+
+// Put the value of key to the blob file, dropping a reference to the
+// value it had
+func (s *Store) Put(key string, value []byte) (err error) {
+	if uint64(len(value)) > uint64(^uint32(0)) {
+		return ErrValueLen
+	}
+	n := uint32(len(value))
+	// reserve the record, write it unlocked
+	if err = s.lock(); err != nil {
+		return
+	}
+	r := ref{off: s.head.end, len: n, gen: s.head.gen}
+	if r.off == 0 {
+		r.off = fileHeader
+	}
+	s.head.end = r.off + recordSize(n)
+	if err = s.mu.Unlock(); err != nil {
+		return
+	}
+	f, err := s.file(r.gen)
+	if err != nil {
+		return
+	}
+	b := make([]byte, recordSize(n))
+	binary.LittleEndian.PutUint32(b, 1)
+	binary.LittleEndian.PutUint32(b[4:], n)
+	copy(b[recordHeader:], value)
+	if _, err = f.WriteAt(b, int64(r.off)); err != nil {
+		return
+	}
+	return s.refer(key, r, false)
+}
+
+// refer key to r, counted already unless count
+func (s *Store) refer(key string, r ref, count bool) (err error) {
+	if err = s.lock(); err != nil {
+		return
+	}
+	defer func() {
+		if e := s.mu.Unlock(); err == nil {
+			err = e
+		}
+	}()
+	old, ok, err := s.ref(key)
+	if err != nil && err != shm.ErrKeyNot {
+		return
+	}
+	if count {
+		if err = s.count(r, 1); err != nil {
+			return
+		}
+	}
+	if err = s.m.Set(key, r.bytes()); err != nil {
+		// a record written for key is garbage
+		_ = s.count(r, -1)
+		return
+	}
+	if ok {
+		return s.count(old, -1)
+	}
+	return nil
+}
+
+// add d to the reference count of the record of r, with the lock held
+func (s *Store) count(r ref, d int32) error {
+	f, err := s.file(r.gen)
+	if err != nil {
+		return err
+	}
+	var b [4]byte
+	if _, err = f.ReadAt(b[:], int64(r.off)); err != nil {
+		return err
+	}
+	c := binary.LittleEndian.Uint32(b[:]) + uint32(d)
+	binary.LittleEndian.PutUint32(b[:], c)
+	if _, err = f.WriteAt(b[:], int64(r.off)); err != nil {
+		return err
+	}
+	if c == 0 {
+		atomic.AddUint64(&s.head.garbage, recordSize(r.len))
+	}
+	return nil
+}
+
+// the reference of key, false if it has none
+func (s *Store) ref(key string) (r ref, ok bool, err error) {
+	v, err := s.m.Get(key, false)
+	if err != nil {
+		return
+	}
+	if len(v) < refSize {
+		return r, false, ErrValueSize
+	}
+	r, ok = decode(v)
+	return
+}
+
+// the reference in a map value, false if none
+func decode(v []byte) (r ref, ok bool) {
+	if len(v) < refSize {
+		return
+	}
+	r = ref{
+		off: binary.LittleEndian.Uint64(v),
+		len: binary.LittleEndian.Uint32(v[8:]),
+		gen: binary.LittleEndian.Uint32(v[12:]),
+	}
+	return r, r.off != 0
+}
+
+// the map value of a reference
+func (r ref) bytes() []byte {
+	b := make([]byte, refSize)
+	binary.LittleEndian.PutUint64(b, r.off)
+	binary.LittleEndian.PutUint32(b[8:], r.len)
+	binary.LittleEndian.PutUint32(b[12:], r.gen)
+	return b
+}
+
+// Get the value of key, read from the blob file, shm.ErrKeyNot if
+// none was put
+func (s *Store) Get(key string) ([]byte, error) {
+	r, ok, err := s.ref(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, shm.ErrKeyNot
+	}
+	return s.read(r)
+}
+
+// the value of the record of r
+func (s *Store) read(r ref) ([]byte, error) {
+	f, err := s.file(r.gen)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, recordSize(r.len))
+	if _, err = f.ReadAt(b, int64(r.off)); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(b[4:]) != r.len {
+		return nil, ErrCorrupt
+	}
+	return b[recordHeader:], nil
+}
+
+// Link key to the value of from, shared, shm.ErrKeyNot if from has
+// none
+func (s *Store) Link(key, from string) error {
+	r, ok, err := s.ref(from)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return shm.ErrKeyNot
+	}
+	return s.refer(key, r, true)
+}
+
+// Delete key, dropping its reference, false if it was not there
+func (s *Store) Delete(key string) (deleted bool, err error) {
+	if err = s.lock(); err != nil {
+		return
+	}
+	defer func() {
+		if e := s.mu.Unlock(); err == nil {
+			err = e
+		}
+	}()
+	r, ok, err := s.ref(key)
+	if err == shm.ErrKeyNot {
+		return false, nil
+	}
+	if err != nil || !s.m.Delete(key) {
+		return
+	}
+	if ok {
+		err = s.count(r, -1)
+	}
+	return true, err
+}
+
+// Garbage return the bytes of the records no key refers to, reclaimed
+// by Vacuum
+func (s *Store) Garbage() int64 {
+	return int64(atomic.LoadUint64(&s.head.garbage))
+}
+
+// Vacuum copy the records the keys refer to into a blob file of the
+// next generation, counting their references again, and remove the
+// files of the older ones, return the bytes reclaimed
+// it holds the map Exclusive, shm.ErrAttached if other processes are
+// attached, and the store must not be used meanwhile
+// a Vacuum interrupted leaves keys referring to either generation,
+// which the next one takes over
+func (s *Store) Vacuum() (reclaimed int64, err error) {
+	release, err := s.m.Exclusive()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if e := release(); err == nil {
+			err = e
+		}
+	}()
+	if err = s.lock(); err != nil {
+		return
+	}
+	defer func() {
+		if e := s.mu.Unlock(); err == nil {
+			err = e
+		}
+	}()
+	var keys []string
+	refs := make(map[ref]uint32)
+	gen := s.head.gen
+	s.m.Foreach(func(key string, value []byte) bool {
+		if r, ok := decode(value); ok {
+			keys = append(keys, key)
+			refs[r]++
+			if r.gen > gen {
+				gen = r.gen
+			}
+		}
+		return true
+	})
+	gen++
+	old, err := s.sizes()
+	if err != nil {
+		return
+	}
+	// copy in the order of the records, of the older generations first
+	live := make([]ref, 0, len(refs))
+	for r := range refs {
+		live = append(live, r)
+	}
+	sort.Slice(live, func(i, j int) bool {
+		if live[i].gen != live[j].gen {
+			return live[i].gen < live[j].gen
+		}
+		return live[i].off < live[j].off
+	})
+	f, err := s.file(gen)
+	if err != nil {
+		return
+	}
+	moved := make(map[ref]ref, len(live))
+	end := uint64(fileHeader)
+	for _, r := range live {
+		var v []byte
+		if v, err = s.read(r); err != nil {
+			return
+		}
+		b := make([]byte, recordSize(r.len))
+		binary.LittleEndian.PutUint32(b, refs[r])
+		binary.LittleEndian.PutUint32(b[4:], r.len)
+		copy(b[recordHeader:], v)
+		if _, err = f.WriteAt(b, int64(end)); err != nil {
+			return
+		}
+		moved[r] = ref{off: end, len: r.len, gen: gen}
+		end += uint64(len(b))
+	}
+	if err = f.Sync(); err != nil {
+		return
+	}
+	for _, key := range keys {
+		r, ok, e := s.ref(key)
+		if e != nil || !ok {
+			continue
+		}
+		if err = s.m.Set(key, moved[r].bytes()); err != nil {
+			return
+		}
+	}
+	s.head.gen, s.head.end = gen, end
+	atomic.StoreUint64(&s.head.garbage, 0)
+	if err = s.removeOlder(gen); err != nil {
+		return
+	}
+	return old - int64(end), nil
+}
+
+// bytes of the blob files
+func (s *Store) sizes() (n int64, err error) {
+	names, err := filepath.Glob(s.path + ".blob.*")
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		var info os.FileInfo
+		if info, err = os.Stat(name); err != nil {
+			return
+		}
+		n += info.Size()
+	}
+	return
+}
+
+// remove the blob files of the generations before gen
+func (s *Store) removeOlder(gen uint32) error {
+	names, err := filepath.Glob(s.path + ".blob.*")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		var g uint32
+		if _, err = fmt.Sscanf(name[len(s.path):], ".blob.%d", &g); err != nil || g >= gen {
+			continue
+		}
+		s.filesMu.Lock()
+		if f := s.files[g]; f != nil {
+			_ = f.Close()
+			delete(s.files, g)
+		}
+		s.filesMu.Unlock()
+		if err = os.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close the blob files, the control file and the map
+func (s *Store) Close() error {
+	var err error
+	for g, f := range s.files {
+		if e := f.Close(); err == nil {
+			err = e
+		}
+		delete(s.files, g)
+	}
+	if s.mp != nil {
+		if e := s.mp.Close(); err == nil {
+			err = e
+		}
+		s.mp = nil
+	}
+	if e := s.m.Close(); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package blob
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	name := "testblob.db"
+	defer func() {
+		names, _ := filepath.Glob(name + "*")
+		for _, n := range names {
+			os.Remove(n)
+		}
+	}()
+	s, err := Open(name, 64, 16, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	large := bytes.Repeat([]byte("0123456789"), 1000)
+	if err = s.Put("a", large); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Link("b", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get("b"); err != nil || !bytes.Equal(v, large) {
+		t.Errorf("expect the value linked, got %d bytes, %v", len(v), err)
+	}
+	// still referred to by b
+	if err = s.Put("a", []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	if g := s.Garbage(); g != 0 {
+		t.Errorf("expect no garbage, got %d", g)
+	}
+	if deleted, err := s.Delete("b"); err != nil || !deleted {
+		t.Errorf("delete: %v, %v", deleted, err)
+	}
+	if g := s.Garbage(); g != int64(recordSize(uint32(len(large)))) {
+		t.Errorf("expect the large value garbage, got %d", g)
+	}
+	reclaimed, err := s.Vacuum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed != int64(recordSize(uint32(len(large)))) {
+		t.Errorf("expect the large value reclaimed, got %d", reclaimed)
+	}
+	if v, err := s.Get("a"); err != nil || string(v) != "small" {
+		t.Errorf("expect small after vacuum, got %q, %v", v, err)
+	}
+	if _, err = os.Stat(name + ".blob.0"); !os.IsNotExist(err) {
+		t.Errorf("expect the old blob file removed, %v", err)
+	}
+	if _, err = s.Get("b"); err == nil {
+		t.Error("expect b deleted")
+	}
+}
@@ -0,0 +1,654 @@
+// Package btree provides an ordered map of byte string keys to fixed
+// size values in memory shared by processes, a B+tree with nodes in an
+// arena, for ordered iteration that a hash map cannot provide
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/fengyoulin/shm/arena"
+	"github.com/fengyoulin/shm/database"
+	"github.com/fengyoulin/shm/mapping"
+	"github.com/fengyoulin/shm/sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// HeaderSize is the bytes before the arena
+const HeaderSize = 64
+
+// bytes of a node before the keys
+const nodeHeader = 16
+
+// MaxKeyLen is the longest key
+const MaxKeyLen = 255
+
+var (
+	// ErrSize on a memory too small
+	ErrSize = errors.New("memory too small")
+	// ErrParam on an order, key or value length out of range
+	ErrParam = errors.New("parameter out of range")
+	// ErrLayout on open a tree of other parameters
+	ErrLayout = errors.New("tree of other parameters")
+	// ErrKeyLen on a key too long
+	ErrKeyLen = errors.New("key too long")
+	// ErrValLen on a value too long
+	ErrValLen = errors.New("value too long")
+)
+
+// header in the memory
+type header struct {
+	mu uint32
+	// offset of the root node, 0 before the first put
+	root     uint32
+	height   uint32
+	count    uint32
+	order    uint32
+	keyLen   uint32
+	valueLen uint32
+	// undo log of the change in progress, 0 if none
+	log uint32
+}
+
+// undo log of a change in a block: the header fields before it, the
+// nodes saved so far, the reserved copies and spares, then the node
+// saved in each copy, a change of a dead holder is rolled back by the
+// next lock, the copies and spares freed
+type undo struct {
+	root   uint32
+	height uint32
+	count  uint32
+	saved  uint32
+	copies uint32
+	spares uint32
+}
+
+// Tree of keys in order
+// a node holds at most order keys, plus one while splitting, a leaf
+// holds a value of each key and links to the next leaf, an inner node
+// holds a child more than keys, keys equal to one go to its right
+// node layout: count, leaf, next leaf, then the keys as a length byte
+// and keyLen bytes, then the values or the child offsets
+// a change saves the nodes it changes to an undo log first, the change
+// of a process dead holding the lock is rolled back by the next one
+type Tree struct {
+	head  *header
+	mu    *sync.Mutex
+	nodes *arena.Arena
+	// bytes of a key slot and a node
+	keySize  int
+	nodeSize int
+	// nodes reserved by a change for its splits, and for the copies of
+	// the nodes it changes
+	spare  []uint32
+	copies []uint32
+	// undo log of the change in progress
+	log uint32
+	// mapping of Open, nil with At
+	mp *mapping.Mapping
+}
+
+// At return the tree in b, of nodes of at most order keys of at most
+// keyLen bytes, with values of valueLen bytes, zeroed memory is an
+// empty tree, all processes must pass the same parameters
+func At(b []byte, order, keyLen, valueLen int) (*Tree, error) {
+	if order < 3 || keyLen <= 0 || keyLen > MaxKeyLen || valueLen < 0 {
+		return nil, ErrParam
+	}
+	if len(b) < HeaderSize {
+		return nil, ErrSize
+	}
+	a, err := arena.At(b[HeaderSize:])
+	if err != nil {
+		return nil, err
+	}
+	mu, err := sync.MutexAt(b)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tree{
+		head:    (*header)(unsafe.Pointer(&b[0])),
+		mu:      mu,
+		nodes:   a,
+		keySize: 1 + keyLen,
+	}
+	// room for a key and a child over the order while splitting
+	slots := (order + 1) * valueLen
+	if kids := (order + 2) * 4; kids > slots {
+		slots = kids
+	}
+	t.nodeSize = nodeHeader + (order+1)*t.keySize + slots
+	if t.nodeSize > arena.MaxAlloc {
+		return nil, ErrParam
+	}
+	if err = t.lock(); err != nil {
+		return nil, err
+	}
+	h := t.head
+	if h.order == 0 {
+		h.order, h.keyLen, h.valueLen = uint32(order), uint32(keyLen), uint32(valueLen)
+	} else if h.order != uint32(order) || h.keyLen != uint32(keyLen) || h.valueLen != uint32(valueLen) {
+		err = ErrLayout
+	}
+	t.unlock(&err)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Open the tree in the file at path of size bytes, created if not
+// exist, waiting for at most wait for its initialization lock
+func Open(path string, size, order, keyLen, valueLen int, wait time.Duration) (t *Tree, err error) {
+	mp, unlock, err := database.Open(path, size, wait)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if e := unlock(); err == nil {
+			err = e
+		}
+		if err != nil {
+			_ = mp.Close()
+			t = nil
+		}
+	}()
+	if t, err = At(mp.Bytes(), order, keyLen, valueLen); err != nil {
+		return
+	}
+	t.mp = mp
+	return
+}
+
+// lock the tree, rolling back the change a dead holder left
+func (t *Tree) lock() error {
+	err := t.mu.Lock()
+	if err == sync.ErrOwnerDead {
+		if err = t.rollback(); err != nil {
+			_ = t.mu.Unlock()
+		}
+	}
+	return err
+}
+
+// unlock the tree, keep the first error
+func (t *Tree) unlock(err *error) {
+	if e := t.mu.Unlock(); *err == nil {
+		*err = e
+	}
+}
+
+// node view of the bytes of a block
+type node []byte
+
+func (t *Tree) node(off uint32) node {
+	return node(t.nodes.Bytes(off))
+}
+
+func (n node) count() int {
+	return int(binary.LittleEndian.Uint32(n))
+}
+
+func (n node) setCount(c int) {
+	binary.LittleEndian.PutUint32(n, uint32(c))
+}
+
+func (n node) leaf() bool {
+	return n[4] != 0
+}
+
+func (n node) next() uint32 {
+	return binary.LittleEndian.Uint32(n[8:])
+}
+
+func (n node) setNext(off uint32) {
+	binary.LittleEndian.PutUint32(n[8:], off)
+}
+
+// key slots from i
+func (t *Tree) keys(n node, i int) []byte {
+	return n[nodeHeader+i*t.keySize:]
+}
+
+func (t *Tree) key(n node, i int) []byte {
+	k := t.keys(n, i)
+	return k[1 : 1+k[0]]
+}
+
+func (t *Tree) setKey(n node, i int, key []byte) {
+	k := t.keys(n, i)
+	k[0] = byte(len(key))
+	copy(k[1:t.keySize], key)
+}
+
+// value or child slots from i
+func (t *Tree) slots(n node, i, size int) []byte {
+	return n[nodeHeader+(int(t.head.order)+1)*t.keySize+i*size:]
+}
+
+func (t *Tree) value(n node, i int) []byte {
+	return t.slots(n, i, int(t.head.valueLen))[:t.head.valueLen]
+}
+
+func (t *Tree) child(n node, i int) uint32 {
+	return binary.LittleEndian.Uint32(t.slots(n, i, 4))
+}
+
+func (t *Tree) setChild(n node, i int, off uint32) {
+	binary.LittleEndian.PutUint32(t.slots(n, i, 4), off)
+}
+
+// shift the slots from i one to the right, over count used ones
+func shift(s []byte, i, count, size int) {
+	copy(s[(i+1)*size:(count+1)*size], s[i*size:count*size])
+}
+
+// search the first key of n not less than key, and whether equal
+func (t *Tree) search(n node, key []byte) (i int, eq bool) {
+	lo, hi := 0, n.count()
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if bytes.Compare(t.key(n, mid), key) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, lo < n.count() && bytes.Equal(t.key(n, lo), key)
+}
+
+// child index of key in an inner node
+func (t *Tree) childIndex(n node, key []byte) int {
+	i, eq := t.search(n, key)
+	if eq {
+		i++
+	}
+	return i
+}
+
+// reserve the nodes a put of key may split into, and the copies of
+// the nodes on its path, so that it either fails before a change or
+// completes
+func (t *Tree) reserve(key []byte) error {
+	need, path := 1, 0
+	if h := t.head; h.root != 0 {
+		full := make([]bool, 0, h.height)
+		n := t.node(h.root)
+		for {
+			full = append(full, n.count() >= int(h.order))
+			if n.leaf() {
+				break
+			}
+			n = t.node(t.child(n, t.childIndex(n, key)))
+		}
+		// a split of each full node from the leaf up, and a new root
+		need = 0
+		for i := len(full) - 1; i >= 0 && full[i]; i-- {
+			need++
+		}
+		if need == len(full) {
+			need++
+		}
+		path = len(full)
+	}
+	return t.reserveNodes(need, path)
+}
+
+// reserve spare nodes and copies, and the undo log of a change
+func (t *Tree) reserveNodes(spares, copies int) error {
+	for len(t.spare) < spares {
+		off, err := t.nodes.Alloc(t.nodeSize)
+		if err != nil {
+			t.release()
+			return err
+		}
+		t.spare = append(t.spare, off)
+	}
+	for len(t.copies) < copies {
+		off, err := t.nodes.Alloc(t.nodeSize)
+		if err != nil {
+			t.release()
+			return err
+		}
+		t.copies = append(t.copies, off)
+	}
+	n := int(unsafe.Sizeof(undo{})) + 4*(2*len(t.copies)+len(t.spare))
+	off, err := t.nodes.Alloc(n)
+	if err != nil {
+		t.release()
+		return err
+	}
+	t.log = off
+	return nil
+}
+
+// the undo log at off and its copies, spares and saved nodes
+func (t *Tree) undoAt(off uint32) (u *undo, copies, spares, saved []uint32, err error) {
+	b := t.nodes.Bytes(off)
+	hs := int(unsafe.Sizeof(undo{}))
+	if len(b) < hs {
+		return nil, nil, nil, nil, arena.ErrDamaged
+	}
+	u = (*undo)(unsafe.Pointer(&b[0]))
+	if hs+4*(2*int(u.copies)+int(u.spares)) > len(b) || u.saved > u.copies {
+		return nil, nil, nil, nil, arena.ErrDamaged
+	}
+	words := (*[1 << 20]uint32)(unsafe.Pointer(&b[hs]))[: 2*u.copies+u.spares : 2*u.copies+u.spares]
+	return u, words[:u.copies], words[u.copies : u.copies+u.spares], words[u.copies+u.spares:], nil
+}
+
+// begin a change, logging the header and the reserved nodes
+func (t *Tree) begin() {
+	b := t.nodes.Bytes(t.log)
+	u := (*undo)(unsafe.Pointer(&b[0]))
+	h := t.head
+	*u = undo{root: h.root, height: h.height, count: h.count, copies: uint32(len(t.copies)), spares: uint32(len(t.spare))}
+	_, copies, spares, _, _ := t.undoAt(t.log)
+	copy(copies, t.copies)
+	copy(spares, t.spare)
+	atomic.StoreUint32(&h.log, t.log)
+}
+
+// save the node at off to the undo log before its first change,
+// unless a spare new in this change
+func (t *Tree) save(off uint32) {
+	u, copies, spares, saved, _ := t.undoAt(t.log)
+	for _, s := range saved[:u.saved] {
+		if s == off {
+			return
+		}
+	}
+	for _, s := range spares {
+		if s == off {
+			return
+		}
+	}
+	copy(t.node(copies[u.saved]), t.node(off))
+	saved[u.saved] = off
+	atomic.StoreUint32(&u.saved, u.saved+1)
+}
+
+// commit the change, the spares not used are released after
+func (t *Tree) commit() {
+	atomic.StoreUint32(&t.head.log, 0)
+	for _, off := range t.copies {
+		_ = t.nodes.Free(off)
+	}
+	_ = t.nodes.Free(t.log)
+	t.copies, t.log = t.copies[:0], 0
+}
+
+// rollback the change of the undo log in the header, if any
+func (t *Tree) rollback() error {
+	h := t.head
+	off := atomic.LoadUint32(&h.log)
+	if off == 0 {
+		return nil
+	}
+	u, copies, spares, saved, err := t.undoAt(off)
+	if err != nil {
+		return err
+	}
+	for i, s := range saved[:u.saved] {
+		copy(t.node(s), t.node(copies[i]))
+	}
+	h.root, h.height, h.count = u.root, u.height, u.count
+	atomic.StoreUint32(&h.log, 0)
+	for _, c := range copies {
+		_ = t.nodes.Free(c)
+	}
+	for _, s := range spares {
+		_ = t.nodes.Free(s)
+	}
+	return t.nodes.Free(off)
+}
+
+// release the reserved nodes not used, ErrDamaged from the arena
+// if any was overwritten
+func (t *Tree) release() (err error) {
+	for _, off := range t.spare {
+		if e := t.nodes.Free(off); e != nil {
+			err = e
+		}
+	}
+	for _, off := range t.copies {
+		if e := t.nodes.Free(off); e != nil {
+			err = e
+		}
+	}
+	if t.log != 0 {
+		if e := t.nodes.Free(t.log); e != nil {
+			err = e
+		}
+	}
+	t.spare, t.copies, t.log = t.spare[:0], t.copies[:0], 0
+	return
+}
+
+// alloc a reserved node
+func (t *Tree) alloc(leaf bool) (off uint32, n node) {
+	off = t.spare[len(t.spare)-1]
+	t.spare = t.spare[:len(t.spare)-1]
+	n = t.node(off)
+	for i := range n {
+		n[i] = 0
+	}
+	if leaf {
+		n[4] = 1
+	}
+	return
+}
+
+// Put value of key, shorter than the value length is padded with 0
+func (t *Tree) Put(key string, value []byte) (err error) {
+	if len(key) > int(t.head.keyLen) {
+		return ErrKeyLen
+	}
+	if len(value) > int(t.head.valueLen) {
+		return ErrValLen
+	}
+	if err = t.lock(); err != nil {
+		return
+	}
+	defer t.unlock(&err)
+	k := []byte(key)
+	if err = t.reserve(k); err != nil {
+		return
+	}
+	defer func() {
+		if e := t.release(); err == nil {
+			err = e
+		}
+	}()
+	t.begin()
+	defer t.commit()
+	h := t.head
+	if h.root == 0 {
+		h.root, _ = t.alloc(true)
+		h.height = 1
+	}
+	sep, right := t.put(h.root, k, value)
+	if right == 0 {
+		return
+	}
+	off, root := t.alloc(false)
+	root.setCount(1)
+	t.setKey(root, 0, sep)
+	t.setChild(root, 0, h.root)
+	t.setChild(root, 1, right)
+	h.root = off
+	h.height++
+	return
+}
+
+// put into the subtree at off, return the separator and the right
+// node if it split
+func (t *Tree) put(off uint32, key, value []byte) (sep []byte, right uint32) {
+	n := t.node(off)
+	c := n.count()
+	if n.leaf() {
+		t.save(off)
+		i, eq := t.search(n, key)
+		if !eq {
+			shift(t.keys(n, 0), i, c, t.keySize)
+			vs := int(t.head.valueLen)
+			shift(t.slots(n, 0, vs), i, c, vs)
+			t.setKey(n, i, key)
+			c++
+			n.setCount(c)
+			t.head.count++
+		}
+		v := t.value(n, i)
+		for j := copy(v, value); j < len(v); j++ {
+			v[j] = 0
+		}
+	} else {
+		i := t.childIndex(n, key)
+		var s []byte
+		var r uint32
+		if s, r = t.put(t.child(n, i), key, value); r == 0 {
+			return
+		}
+		t.save(off)
+		shift(t.keys(n, 0), i, c, t.keySize)
+		shift(t.slots(n, 0, 4), i+1, c+1, 4)
+		t.setKey(n, i, s)
+		t.setChild(n, i+1, r)
+		c++
+		n.setCount(c)
+	}
+	if c <= int(t.head.order) {
+		return
+	}
+	return t.split(n)
+}
+
+// split the overflowed node n
+func (t *Tree) split(n node) (sep []byte, right uint32) {
+	c := n.count()
+	right, r := t.alloc(n.leaf())
+	mid := c / 2
+	if n.leaf() {
+		vs := int(t.head.valueLen)
+		copy(t.keys(r, 0), t.keys(n, mid)[:(c-mid)*t.keySize])
+		copy(t.slots(r, 0, vs), t.slots(n, mid, vs)[:(c-mid)*vs])
+		r.setCount(c - mid)
+		n.setCount(mid)
+		r.setNext(n.next())
+		n.setNext(right)
+		sep = append(sep, t.key(r, 0)...)
+		return
+	}
+	sep = append(sep, t.key(n, mid)...)
+	copy(t.keys(r, 0), t.keys(n, mid+1)[:(c-mid-1)*t.keySize])
+	copy(t.slots(r, 0, 4), t.slots(n, mid+1, 4)[:(c-mid)*4])
+	r.setCount(c - mid - 1)
+	n.setCount(mid)
+	return
+}
+
+// leaf of key at off and the index of the first key not less than it
+func (t *Tree) find(key []byte) (off uint32, n node, i int, eq bool) {
+	if off = t.head.root; off == 0 {
+		return 0, nil, 0, false
+	}
+	n = t.node(off)
+	for !n.leaf() {
+		off = t.child(n, t.childIndex(n, key))
+		n = t.node(off)
+	}
+	i, eq = t.search(n, key)
+	return
+}
+
+// Get the value of key appending it to dst, false if none
+func (t *Tree) Get(key string, dst []byte) (b []byte, ok bool, err error) {
+	if err = t.lock(); err != nil {
+		return dst, false, err
+	}
+	defer t.unlock(&err)
+	_, n, i, eq := t.find([]byte(key))
+	if !eq {
+		return dst, false, nil
+	}
+	return append(dst, t.value(n, i)...), true, nil
+}
+
+// Delete key, true if it was there, leaves are not merged
+func (t *Tree) Delete(key string) (deleted bool, err error) {
+	if err = t.lock(); err != nil {
+		return
+	}
+	defer t.unlock(&err)
+	off, n, i, eq := t.find([]byte(key))
+	if !eq {
+		return
+	}
+	if err = t.reserveNodes(0, 1); err != nil {
+		return
+	}
+	defer func() {
+		if e := t.release(); err == nil {
+			err = e
+		}
+	}()
+	t.begin()
+	defer t.commit()
+	t.save(off)
+	c := n.count()
+	vs := int(t.head.valueLen)
+	copy(t.keys(n, i), t.keys(n, i+1)[:(c-i-1)*t.keySize])
+	copy(t.slots(n, i, vs), t.slots(n, i+1, vs)[:(c-i-1)*vs])
+	n.setCount(c - 1)
+	t.head.count--
+	return true, nil
+}
+
+// RangeAscending call fn with the keys from from, inclusive, to to,
+// exclusive, in ascending order, until fn return false
+// an empty to has no upper bound
+// the tree is locked meanwhile, fn must not change it
+func (t *Tree) RangeAscending(from, to string, fn func(key string, value []byte) bool) (err error) {
+	if err = t.lock(); err != nil {
+		return
+	}
+	defer t.unlock(&err)
+	_, n, i, _ := t.find([]byte(from))
+	for n != nil {
+		for ; i < n.count(); i++ {
+			k := t.key(n, i)
+			if to != "" && string(k) >= to {
+				return
+			}
+			if !fn(string(k), t.value(n, i)) {
+				return
+			}
+		}
+		if next := n.next(); next != 0 {
+			n, i = t.node(next), 0
+		} else {
+			n = nil
+		}
+	}
+	return
+}
+
+// Len return the number of keys
+func (t *Tree) Len() (n int, err error) {
+	if err = t.lock(); err != nil {
+		return
+	}
+	defer t.unlock(&err)
+	return int(t.head.count), nil
+}
+
+// Close the mapping of Open
+func (t *Tree) Close() (err error) {
+	if t.mp != nil {
+		err = t.mp.Close()
+		t.mp = nil
+	}
+	return
+}
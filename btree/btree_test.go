@@ -0,0 +1,110 @@
+package btree
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestTree(t *testing.T) {
+	name := "testbtree.db"
+	defer os.Remove(name)
+	tr, err := Open(name, 1<<20, 4, 8, 4, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	expect := make(map[string]string)
+	for _, i := range rand.Perm(500) {
+		k, v := fmt.Sprintf("k%04d", i), fmt.Sprintf("%d", i%1000)
+		if err = tr.Put(k, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+		expect[k] = v
+	}
+	for i := 0; i < 500; i += 3 {
+		k := fmt.Sprintf("k%04d", i)
+		if deleted, err := tr.Delete(k); err != nil || !deleted {
+			t.Fatalf("delete %s: %v, %v", k, deleted, err)
+		}
+		delete(expect, k)
+	}
+	if err = tr.Put("k0001", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	expect["k0001"] = "x"
+	if n, err := tr.Len(); err != nil || n != len(expect) {
+		t.Errorf("expect len %d, got %d, %v", len(expect), n, err)
+	}
+	for k, v := range expect {
+		b, ok, err := tr.Get(k, nil)
+		if err != nil || !ok || string(b[:len(v)]) != v {
+			t.Errorf("get %s: %q, %v, %v", k, b, ok, err)
+		}
+	}
+	var keys []string
+	for k := range expect {
+		if k >= "k0100" && k < "k0200" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var got []string
+	if err = tr.RangeAscending("k0100", "k0200", func(k string, v []byte) bool {
+		got = append(got, k)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(keys) {
+		t.Errorf("range: expect %v, got %v", keys, got)
+	}
+	if _, err = Open(name, 1<<20, 8, 8, 4, time.Second); err != ErrLayout {
+		t.Errorf("expect ErrLayout, got %v", err)
+	}
+}
+
+func TestTree_Rollback(t *testing.T) {
+	tr, err := At(make([]byte, 1<<20), 4, 8, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for i := 0; i < 200; i += 2 {
+		k := fmt.Sprintf("k%04d", i)
+		if err = tr.Put(k, []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+	for i := 1; i < 200; i += 20 {
+		// a holder dies after the changes of a put, before its commit
+		k := []byte(fmt.Sprintf("k%04d", i))
+		if err = tr.mu.Lock(); err != nil {
+			t.Fatal(err)
+		}
+		if err = tr.reserve(k); err != nil {
+			t.Fatal(err)
+		}
+		tr.begin()
+		tr.put(tr.head.root, k, []byte("x"))
+		tr.head.mu = 1<<31 - 1
+		tr.spare, tr.copies, tr.log = nil, nil, 0
+		if _, ok, err := tr.Get(string(k), nil); err != nil || ok {
+			t.Fatalf("expect %s rolled back, got %v, %v", k, ok, err)
+		}
+	}
+	var got []string
+	if err = tr.RangeAscending("", "", func(k string, v []byte) bool {
+		got = append(got, k)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := tr.Len(); err != nil || n != len(keys) || fmt.Sprint(got) != fmt.Sprint(keys) {
+		t.Errorf("expect %v, got %v, len %d, %v", keys, got, n, err)
+	}
+}
@@ -0,0 +1,114 @@
+package shm
+
+import (
+	"context"
+	"github.com/fengyoulin/shm/database"
+	"github.com/fengyoulin/shm/mapping"
+	"os"
+	"runtime"
+	"time"
+)
+
+// CloseOption changes the behaviour of CloseWith
+type CloseOption func(*closeOptions)
+
+// options collected from CloseWith
+type closeOptions struct {
+	sync   bool
+	unlink bool
+	wait   time.Duration
+}
+
+// FinalSync flush the pages of the map to the file before closing it,
+// only the dirty ones with SyncDirty
+func FinalSync() CloseOption {
+	return func(o *closeOptions) {
+		o.sync = true
+	}
+}
+
+// UnlinkLast remove the file of the map if no other map is attached
+// to it, as a temporary file removed by the last process leaving
+// wait for at most wait for the database lock, keeping processes from
+// opening the file meanwhile, on windows the file is kept
+func UnlinkLast(wait time.Duration) CloseOption {
+	return func(o *closeOptions) {
+		o.unlink = true
+		o.wait = wait
+	}
+}
+
+// CloseWith close the map as Close does, after the steps of opts
+// the Attach lock of the map is released by closing its file, the
+// map is no longer counted by Attached then
+// the map is closed even if a step fails, the first error returned
+func (m *Map) CloseWith(opts ...CloseOption) (err error) {
+	var o closeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if m.temp && !o.unlink {
+		o.unlink, o.wait = true, m.wait
+	}
+	if o.sync {
+		// no background flush racing the final one
+		m.stopSync()
+		err = m.Sync()
+	}
+	if o.unlink && m.path != "" {
+		unlock, e := m.unlinkLast(o.wait)
+		if err == nil {
+			err = e
+		}
+		if unlock != nil {
+			defer func() {
+				if e := unlock(); err == nil {
+					err = e
+				}
+			}()
+		}
+	}
+	if e := m.close(); err == nil {
+		err = e
+	}
+	return
+}
+
+// remove the file of the map if it is the last attached, return the
+// database lock to hold until closed
+func (m *Map) unlinkLast(wait time.Duration) (unlock func() error, err error) {
+	// no flock to tell the last, nor removing a mapped file
+	if runtime.GOOS == "windows" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+	if unlock, err = database.Lock(ctx, m.path); err != nil {
+		return
+	}
+	// the exclusive lock goes with the file on close
+	switch _, err = m.Exclusive(); err {
+	case nil:
+		err = removeMap(m.path)
+	case ErrAttached:
+		err = nil
+	}
+	return
+}
+
+// remove the file of a map, and its segments
+func removeMap(path string) error {
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	for i := 0; err == nil; i++ {
+		if e := os.Remove(mapping.SegmentName(path, i)); e != nil {
+			if !os.IsNotExist(e) {
+				err = e
+			}
+			break
+		}
+	}
+	return err
+}
@@ -0,0 +1,40 @@
+package shm
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMap_CloseWith(t *testing.T) {
+	name := "testclosewith.db"
+	defer os.Remove(name)
+	m := newTestFile(t, name, 64, 16, 8, SyncDirty())
+	o := newTestFile(t, name, 64, 16, 8)
+	if err := m.Set("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CloseWith(FinalSync(), UnlinkLast(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); err != nil {
+		t.Fatalf("expect the file kept for o, got %v", err)
+	}
+	if v, err := o.Load("a", nil); err != nil || v[0] != '1' {
+		t.Errorf("unexpected %q, %v", v, err)
+	}
+	if runtime.GOOS == "windows" {
+		_ = o.Close()
+		return
+	}
+	if err := o.CloseWith(UnlinkLast(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("expect the file removed, got %v", err)
+	}
+	if _, err := os.Stat(name + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("expect no lock file, got %v", err)
+	}
+}
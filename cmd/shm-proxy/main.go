@@ -0,0 +1,154 @@
+// Command shm-proxy serves shm maps over a unix socket for processes
+// not linking the library, such as php, python or ruby workers on the
+// same host, with the protocol of package wire
+//
+//	shm-proxy -socket /run/shm.sock -map sessions=/dev/shm/sessions.db,65536,40,256
+//
+// a request is a 32-bit big endian length of the rest, an op byte,
+// then the map name, the key and the value, each a 32-bit big endian
+// length and the bytes, ops are 1 get, 2 set, 3 delete, 4 len, 5 scan
+// a response is a 32-bit big endian length of the rest, a status byte,
+// 0 ok, 1 not found, 2 error, then the value or the error text
+// the maps are opened as they are, unlike shm-server nothing expires
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/fengyoulin/shm"
+	"github.com/fengyoulin/shm/wire"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// mapFlags collect the maps of -map name=path,cap,key,value
+type mapFlags struct {
+	maps wire.Maps
+	wait *time.Duration
+}
+
+// String implements flag.Value
+func (f *mapFlags) String() string {
+	names := make([]string, 0, len(f.maps))
+	for name := range f.maps {
+		names = append(names, name)
+	}
+	return strings.Join(names, ",")
+}
+
+// errMapFlag on a malformed -map
+var errMapFlag = errors.New("want name=path,cap,key,value")
+
+// Set implements flag.Value
+func (f *mapFlags) Set(s string) error {
+	eq := strings.IndexByte(s, '=')
+	if eq <= 0 {
+		return errMapFlag
+	}
+	name := s[:eq]
+	parts := strings.Split(s[eq+1:], ",")
+	if len(parts) != 4 {
+		return errMapFlag
+	}
+	var params [3]int
+	for i := range params {
+		n, err := strconv.Atoi(parts[i+1])
+		if err != nil {
+			return errMapFlag
+		}
+		params[i] = n
+	}
+	if _, ok := f.maps[name]; ok {
+		return fmt.Errorf("map %s twice", name)
+	}
+	m, err := shm.Create(parts[0], params[0], params[1], params[2], 0, *f.wait)
+	if err != nil {
+		return err
+	}
+	f.maps[name] = m
+	return nil
+}
+
+func main() {
+	socket := flag.String("socket", "shm.sock", "unix socket to listen on")
+	wait := flag.Duration("wait", time.Second, "wait for the database lock, before -map")
+	maps := &mapFlags{maps: make(wire.Maps), wait: wait}
+	flag.Var(maps, "map", "serve a map, name=path,cap,key,value, repeated")
+	flag.Parse()
+	if len(maps.maps) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	_ = os.Remove(*socket)
+	l, err := net.Listen("unix", *socket)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the connections open, closed on a signal, and waited for before
+	// the maps are closed
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		conns  = make(map[net.Conn]struct{})
+		closed bool
+	)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		mu.Lock()
+		closed = true
+		_ = l.Close()
+		for c := range conns {
+			_ = c.Close()
+		}
+		mu.Unlock()
+	}()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			break
+		}
+		mu.Lock()
+		if closed {
+			mu.Unlock()
+			_ = c.Close()
+			break
+		}
+		conns[c] = struct{}{}
+		wg.Add(1)
+		mu.Unlock()
+		go func() {
+			defer wg.Done()
+			defer func() {
+				mu.Lock()
+				delete(conns, c)
+				mu.Unlock()
+				_ = c.Close()
+			}()
+			if err := wire.ServeConn(c, maps.maps); err != nil {
+				mu.Lock()
+				quiet := closed
+				mu.Unlock()
+				if !quiet {
+					log.Printf("%v: %v", c.RemoteAddr(), err)
+				}
+			}
+		}()
+	}
+	// the requests in flight end before the maps close
+	wg.Wait()
+	for name, m := range maps.maps {
+		if err := m.Close(); err != nil {
+			log.Printf("close %s: %v", name, err)
+		}
+	}
+}
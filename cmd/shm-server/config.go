@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// config of the server, a json file
+type config struct {
+	// Socket path of the unix socket to listen on
+	Socket string `json:"socket"`
+	// Metrics address of the http listener of /metrics, none if empty
+	Metrics string `json:"metrics"`
+	// Sweep interval of deleting expired keys
+	Sweep duration `json:"sweep"`
+	// Compact interval of compacting the maps, never if 0
+	Compact duration `json:"compact"`
+	// Wait for the lock of a database
+	Wait duration `json:"wait"`
+	// Maps served
+	Maps []mapConfig `json:"maps"`
+}
+
+// config of a map
+type mapConfig struct {
+	// Name of the map in requests
+	Name string `json:"name"`
+	// Path of the database
+	Path     string `json:"path"`
+	Cap      int    `json:"cap"`
+	KeyLen   int    `json:"key"`
+	ValueLen int    `json:"value"`
+	MaxTry   int    `json:"try"`
+	// TTL of keys since their last set, none if 0
+	TTL duration `json:"ttl"`
+}
+
+// duration in json as a string of time.ParseDuration
+type duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	*d = duration(v)
+	return err
+}
+
+// errConfig on an invalid config
+var errConfig = errors.New("invalid config")
+
+// loadConfig read the config at path, with the defaults filled in
+func loadConfig(path string) (c *config, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	c = &config{Sweep: duration(time.Second), Wait: duration(time.Second)}
+	if err = json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if c.Socket == "" {
+		return nil, fmt.Errorf("%w: no socket", errConfig)
+	}
+	names := make(map[string]bool)
+	for i := range c.Maps {
+		mc := &c.Maps[i]
+		if mc.Name == "" || mc.Path == "" {
+			return nil, fmt.Errorf("%w: map %d without a name or a path", errConfig, i)
+		}
+		if names[mc.Name] {
+			return nil, fmt.Errorf("%w: map %q twice", errConfig, mc.Name)
+		}
+		names[mc.Name] = true
+	}
+	return
+}
@@ -0,0 +1,73 @@
+// Command shm-server owns the shm maps of a config file and serves them
+// over a unix socket with the protocol of package wire, deleting keys
+// past their ttl, compacting the maps and exporting metrics
+//
+//	shm-server -config shm.json
+//
+// the config is a json object:
+//
+//	{
+//		"socket": "/run/shm.sock",
+//		"metrics": "127.0.0.1:9180",
+//		"sweep": "1s",
+//		"compact": "24h",
+//		"wait": "1s",
+//		"maps": [
+//			{"name": "sessions", "path": "/dev/shm/sessions.db",
+//			 "cap": 65536, "key": 40, "value": 256, "ttl": "30m"}
+//		]
+//	}
+//
+// SIGINT and SIGTERM shut the server down gracefully
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	path := flag.String("config", "shm.json", "config file")
+	flag.Parse()
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s, err := newServer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// a socket left by a crashed server
+	_ = os.Remove(cfg.Socket)
+	l, err := net.Listen("unix", cfg.Socket)
+	if err != nil {
+		s.close()
+		log.Fatal(err)
+	}
+	if cfg.Metrics != "" {
+		go func() {
+			log.Print(http.ListenAndServe(cfg.Metrics, s))
+		}()
+	}
+	stop := make(chan struct{})
+	s.wg.Add(1)
+	go s.background(stop)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.serve(l)
+	}()
+	select {
+	case <-sig:
+	case err = <-done:
+		log.Print(err)
+	}
+	close(stop)
+	s.shutdown(l)
+}
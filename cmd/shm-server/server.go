@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fengyoulin/shm"
+	"github.com/fengyoulin/shm/hist"
+	"github.com/fengyoulin/shm/wire"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// a map served
+type namedMap struct {
+	// keys deleted by sweeps, and compactions, first for the 64-bit
+	// alignment of their atomics on 32-bit platforms
+	swept     uint64
+	compacted uint64
+	cfg       mapConfig
+	// held for reading by ops, for writing by compaction
+	mu sync.RWMutex
+	// nil while unavailable, after a compaction failed to reopen it
+	m *shm.Map
+}
+
+// open the map of the config
+func (nm *namedMap) open(wait time.Duration) (err error) {
+	var opts []shm.Option
+	if nm.cfg.TTL > 0 {
+		opts = append(opts, shm.Timestamps())
+	}
+	c := &nm.cfg
+	m, err := shm.Create(c.Path, c.Cap, c.KeyLen, c.ValueLen, c.MaxTry, wait, opts...)
+	if err != nil {
+		return
+	}
+	nm.m = m
+	return
+}
+
+// server of the maps of a config
+type server struct {
+	cfg  *config
+	maps map[string]*namedMap
+	// requests by op, index 0 for unknown ones, and failed ones
+	requests [wire.OpScan + 1]uint64
+	errors   uint64
+	latency  *hist.Histogram
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+// names of the ops in metrics
+var opNames = [...]string{"unknown", "get", "set", "delete", "len", "scan"}
+
+// newServer open the maps of cfg
+func newServer(cfg *config) (s *server, err error) {
+	s = &server{
+		cfg:     cfg,
+		maps:    make(map[string]*namedMap, len(cfg.Maps)),
+		latency: hist.New(),
+		conns:   make(map[net.Conn]struct{}),
+	}
+	for _, mc := range cfg.Maps {
+		nm := &namedMap{cfg: mc}
+		if err = nm.open(time.Duration(cfg.Wait)); err != nil {
+			s.close()
+			return nil, fmt.Errorf("map %s: %w", mc.Name, err)
+		}
+		s.maps[mc.Name] = nm
+	}
+	return
+}
+
+// serve connections of l until it is closed
+func (s *server) serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = c.Close()
+			return nil
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(c)
+	}
+}
+
+// serve the requests of a connection
+func (s *server) serveConn(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		_ = c.Close()
+	}()
+	if err := wire.ServeConn(c, s); err != nil {
+		s.mu.Lock()
+		closed := s.closed
+		s.mu.Unlock()
+		if !closed {
+			log.Printf("%v: %v", c.RemoteAddr(), err)
+		}
+	}
+}
+
+// Handle implements wire.Handler
+func (s *server) Handle(req *wire.Request, dst []byte) (resp wire.Response, b []byte) {
+	start := time.Now()
+	op := req.Op
+	if int(op) >= len(s.requests) {
+		op = 0
+	}
+	atomic.AddUint64(&s.requests[op], 1)
+	nm := s.maps[req.Map]
+	if nm == nil {
+		return wire.Response{Status: wire.StatusNotFound, Payload: []byte("no map " + req.Map)}, dst
+	}
+	nm.mu.RLock()
+	if nm.m == nil {
+		resp, b = wire.Response{Status: wire.StatusError, Payload: []byte("map " + req.Map + " unavailable")}, dst
+	} else {
+		resp, b = wire.Apply(nm.m, req, dst)
+	}
+	nm.mu.RUnlock()
+	if resp.Status == wire.StatusError {
+		atomic.AddUint64(&s.errors, 1)
+	}
+	s.latency.Record(time.Since(start))
+	return
+}
+
+// sweep delete the keys not set for longer than the ttl of their map
+func (s *server) sweep() {
+	now := time.Now()
+	for _, nm := range s.maps {
+		ttl := time.Duration(nm.cfg.TTL)
+		if ttl <= 0 {
+			continue
+		}
+		nm.mu.RLock()
+		var n int
+		if nm.m != nil {
+			n = nm.m.ExpireBefore(now.Add(-ttl))
+		}
+		nm.mu.RUnlock()
+		atomic.AddUint64(&nm.swept, uint64(n))
+	}
+}
+
+// compact the maps, each is unavailable meanwhile, one left
+// unavailable by the last compaction is reopened instead
+func (s *server) compact() {
+	wait := time.Duration(s.cfg.Wait)
+	for _, nm := range s.maps {
+		nm.mu.Lock()
+		if nm.m == nil {
+			if err := nm.open(wait); err != nil {
+				log.Printf("reopen %s: %v", nm.cfg.Name, err)
+			}
+		} else if err := s.compactMap(nm, wait); err != nil {
+			log.Printf("compact %s: %v", nm.cfg.Name, err)
+		} else {
+			atomic.AddUint64(&nm.compacted, 1)
+		}
+		nm.mu.Unlock()
+	}
+}
+
+// compact a map into a new file swapped in for the old one
+func (s *server) compactMap(nm *namedMap, wait time.Duration) (err error) {
+	newPath := nm.cfg.Path + ".compact"
+	if err = nm.m.CompactTo(newPath, wait); err != nil {
+		return
+	}
+	err = nm.m.Close()
+	nm.m = nil
+	if err != nil {
+		return
+	}
+	err = shm.SwapIn(nm.cfg.Path, newPath, wait)
+	// reopen either way, the map unavailable until the next compaction
+	// if it fails
+	if e := nm.open(wait); err == nil {
+		err = e
+	}
+	return
+}
+
+// run the sweeps and compactions until stop is closed
+func (s *server) background(stop <-chan struct{}) {
+	defer s.wg.Done()
+	sweep := time.NewTicker(time.Duration(s.cfg.Sweep))
+	defer sweep.Stop()
+	var compact <-chan time.Time
+	if s.cfg.Compact > 0 {
+		t := time.NewTicker(time.Duration(s.cfg.Compact))
+		defer t.Stop()
+		compact = t.C
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-sweep.C:
+			s.sweep()
+		case <-compact:
+			s.compact()
+		}
+	}
+}
+
+// ServeHTTP write the metrics in the prometheus text format
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	names := make([]string, 0, len(s.maps))
+	for name := range s.maps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "# TYPE shm_map_keys gauge")
+	for _, name := range names {
+		nm := s.maps[name]
+		nm.mu.RLock()
+		if nm.m != nil {
+			fmt.Fprintf(w, "shm_map_keys{map=%q} %d\n", name, nm.m.Len())
+			fmt.Fprintf(w, "shm_map_capacity{map=%q} %d\n", name, nm.m.Cap())
+		}
+		nm.mu.RUnlock()
+	}
+	fmt.Fprintln(w, "# TYPE shm_map_swept_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "shm_map_swept_total{map=%q} %d\n", name, atomic.LoadUint64(&s.maps[name].swept))
+	}
+	fmt.Fprintln(w, "# TYPE shm_map_compactions_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "shm_map_compactions_total{map=%q} %d\n", name, atomic.LoadUint64(&s.maps[name].compacted))
+	}
+	fmt.Fprintln(w, "# TYPE shm_requests_total counter")
+	for op, name := range opNames {
+		fmt.Fprintf(w, "shm_requests_total{op=%q} %d\n", name, atomic.LoadUint64(&s.requests[op]))
+	}
+	fmt.Fprintln(w, "# TYPE shm_request_errors_total counter")
+	fmt.Fprintf(w, "shm_request_errors_total %d\n", atomic.LoadUint64(&s.errors))
+	_ = s.latency.WritePrometheus(w, "shm_request_duration_seconds", nil)
+}
+
+// shutdown close l and the connections, wait for their requests in
+// flight and the background work, then close the maps
+func (s *server) shutdown(l net.Listener) {
+	s.mu.Lock()
+	s.closed = true
+	_ = l.Close()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	s.close()
+}
+
+// close the maps
+func (s *server) close() {
+	for name, nm := range s.maps {
+		if nm.m == nil {
+			continue
+		}
+		if err := nm.m.Close(); err != nil {
+			log.Printf("close %s: %v", name, err)
+		}
+		nm.m = nil
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/fengyoulin/shm/wire"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shm-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := filepath.Join(dir, "shm.json")
+	if err = ioutil.WriteFile(conf, []byte(`{
+		"socket": "`+filepath.Join(dir, "shm.sock")+`",
+		"maps": [
+			{"name": "a", "path": "`+filepath.Join(dir, "a.db")+`", "cap": 64, "key": 16, "value": 8, "ttl": "1h"}
+		]
+	}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", cfg.Socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.serve(l)
+	defer s.shutdown(l)
+	c, err := net.Dial("unix", cfg.Socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r := bufio.NewReader(c)
+	call := func(req wire.Request) wire.Response {
+		if err := wire.WriteRequest(c, &req); err != nil {
+			t.Fatal(err)
+		}
+		resp, _, err := wire.ReadResponse(r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	if resp := call(wire.Request{Op: wire.OpSet, Map: "a", Key: "k", Value: []byte("v")}); resp.Status != wire.StatusOK {
+		t.Errorf("set: %d %s", resp.Status, resp.Payload)
+	}
+	if resp := call(wire.Request{Op: wire.OpGet, Map: "a", Key: "k"}); resp.Status != wire.StatusOK || string(resp.Payload[:1]) != "v" {
+		t.Errorf("get: %d %q", resp.Status, resp.Payload)
+	}
+	if resp := call(wire.Request{Op: wire.OpGet, Map: "b", Key: "k"}); resp.Status != wire.StatusNotFound {
+		t.Errorf("get from no map: %d", resp.Status)
+	}
+	if resp := call(wire.Request{Op: wire.OpSet, Map: "a", Key: "k", Value: []byte("123456789")}); resp.Status != wire.StatusError {
+		t.Errorf("set too long: %d", resp.Status)
+	}
+	// compaction keeps the keys, the sweep keeps those within the ttl
+	call(wire.Request{Op: wire.OpSet, Map: "a", Key: "x", Value: []byte("x")})
+	call(wire.Request{Op: wire.OpDelete, Map: "a", Key: "x"})
+	s.compact()
+	s.sweep()
+	if resp := call(wire.Request{Op: wire.OpLen, Map: "a"}); resp.Status != wire.StatusOK || binary.BigEndian.Uint64(resp.Payload) != 1 {
+		t.Errorf("len: %d %v", resp.Status, resp.Payload)
+	}
+	s.maps["a"].cfg.TTL = duration(time.Nanosecond)
+	s.sweep()
+	if resp := call(wire.Request{Op: wire.OpGet, Map: "a", Key: "k"}); resp.Status != wire.StatusNotFound {
+		t.Errorf("get expired: %d", resp.Status)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{`shm_map_swept_total{map="a"} 1`, `shm_map_compactions_total{map="a"} 1`, `shm_requests_total{op="get"} 3`} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Errorf("metrics without %s:\n%s", line, w.Body)
+		}
+	}
+	// a reopen failing leaves the map unavailable, not nil for the ops
+	nm := s.maps["a"]
+	nm.cfg.ValueLen = 16
+	s.compact()
+	if nm.m != nil {
+		t.Fatal("expect the reopen failed")
+	}
+	if resp := call(wire.Request{Op: wire.OpGet, Map: "a", Key: "k"}); resp.Status != wire.StatusError {
+		t.Errorf("get unavailable: %d", resp.Status)
+	}
+	s.sweep()
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics", nil))
+	nm.cfg.ValueLen = 8
+	s.compact()
+	if resp := call(wire.Request{Op: wire.OpLen, Map: "a"}); resp.Status != wire.StatusOK {
+		t.Errorf("len after reopen: %d %s", resp.Status, resp.Payload)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/fengyoulin/shm"
+	"os"
+	"strings"
+	"time"
+)
+
+// audit: print the audit log of a map, to tell which process set or
+// deleted a key
+func audit(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	maxTry := fs.Int("try", 20, "max tries of an operation")
+	wait := fs.Duration("wait", time.Second, "wait for the database lock")
+	key := fs.String("k", "", "print the entries of this key only")
+	pid := fs.Int("pid", 0, "print the entries of this process only")
+	op := fs.String("op", "", "print the entries of this op only: set, delete, expire or evict")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: shmtool audit [flags] a.db")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	m, err := shm.Open(fs.Arg(0), *maxTry, *wait)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	entries, err := m.Audit()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		// the keys longer than kept match by their prefix
+		if *key != "" && e.Key != *key && (e.KeyLen == len(e.Key) || !strings.HasPrefix(*key, e.Key)) {
+			continue
+		}
+		if *pid != 0 && e.PID != *pid || *op != "" && e.Op.String() != *op {
+			continue
+		}
+		k := e.Key
+		if e.KeyLen > len(e.Key) {
+			k += "..."
+		}
+		fmt.Printf("%d\t%s\t%d\t%s\t%q\n", e.Seq, e.Time.Format(time.RFC3339Nano), e.PID, e.Op, k)
+	}
+	return nil
+}
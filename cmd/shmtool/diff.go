@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/fengyoulin/shm"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errDiffer on diff of entries not the same
+var errDiffer = errors.New("maps differ")
+
+// diff: compare the entries of two maps, or a map and a json dump
+func diff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	maxTry := fs.Int("try", 20, "max tries of an operation")
+	wait := fs.Duration("wait", time.Second, "wait for the database lock")
+	quiet := fs.Bool("q", false, "report the counts only")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: shmtool diff [flags] a.db b.db")
+		fmt.Fprintln(fs.Output(), "either may be a .json dump, an object of keys to base64 values")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	var sides [2]map[string][]byte
+	for i := range sides {
+		var err error
+		if sides[i], err = loadEntries(fs.Arg(i), *maxTry, *wait); err != nil {
+			return err
+		}
+	}
+	out := io.Writer(os.Stdout)
+	if *quiet {
+		out = ioutil.Discard
+	}
+	added, removed, changed := diffEntries(out, sides[0], sides[1])
+	fmt.Fprintf(os.Stderr, "%d added, %d removed, %d changed\n", added, removed, changed)
+	if added+removed+changed > 0 {
+		return errDiffer
+	}
+	return nil
+}
+
+// the entries of a map file or a json dump, values compared exactly
+func loadEntries(path string, maxTry int, wait time.Duration) (entries map[string][]byte, err error) {
+	if strings.HasSuffix(path, ".json") {
+		var b []byte
+		if b, err = ioutil.ReadFile(path); err != nil {
+			return
+		}
+		if err = json.Unmarshal(b, &entries); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return
+	}
+	m, err := shm.Open(path, maxTry, wait)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	defer func() {
+		if e := m.Close(); err == nil {
+			err = e
+		}
+	}()
+	entries = make(map[string][]byte, m.Len())
+	m.Foreach(func(key string, value []byte) bool {
+		// key and value are in the mapping, unmapped on Close
+		entries[string([]byte(key))] = append([]byte(nil), value...)
+		return true
+	})
+	return
+}
+
+// write the keys added to, removed from and changed in b of a, in key
+// order, prefixed with +, - and ~, return their counts
+func diffEntries(w io.Writer, a, b map[string][]byte) (added, removed, changed int) {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		va, inA := a[k]
+		vb, inB := b[k]
+		switch {
+		case !inA:
+			added++
+			fmt.Fprintf(w, "+ %q\n", k)
+		case !inB:
+			removed++
+			fmt.Fprintf(w, "- %q\n", k)
+		case !bytes.Equal(va, vb):
+			changed++
+			fmt.Fprintf(w, "~ %q\n", k)
+		}
+	}
+	return
+}
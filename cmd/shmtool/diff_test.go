@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiffEntries(t *testing.T) {
+	a := map[string][]byte{"same": []byte("1"), "gone": []byte("2"), "edit": []byte("3")}
+	b := map[string][]byte{"same": []byte("1"), "edit": []byte("4"), "new": []byte("5")}
+	var out bytes.Buffer
+	added, removed, changed := diffEntries(&out, a, b)
+	if added != 1 || removed != 1 || changed != 1 {
+		t.Errorf("expect 1, 1, 1, got %d, %d, %d", added, removed, changed)
+	}
+	if expect := "~ \"edit\"\n- \"gone\"\n+ \"new\"\n"; out.String() != expect {
+		t.Errorf("expect %q, got %q", expect, out.String())
+	}
+}
+
+func TestLoadEntriesExact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shmtool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.json")
+	// "dgA=" is v and a zero byte
+	if err = ioutil.WriteFile(path, []byte(`{"k": "dgA="}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := loadEntries(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := entries["k"]; !bytes.Equal(v, []byte{'v', 0}) {
+		t.Errorf("expect the trailing zero kept, got %q", v)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/fengyoulin/shm"
+	"io"
+	"os"
+	"time"
+)
+
+// export-parquet: write the entries of a map to a parquet file
+func exportParquet(args []string) (err error) {
+	fs := flag.NewFlagSet("export-parquet", flag.ExitOnError)
+	maxTry := fs.Int("try", 20, "max tries of an operation")
+	wait := fs.Duration("wait", time.Second, "wait for the database lock")
+	out := fs.String("o", "map.parquet", "parquet file")
+	rows := fs.Int("rows", 65536, "rows of a row group")
+	compress := fs.Bool("snappy", true, "snappy compress the pages")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: shmtool export-parquet [flags] a.db")
+		fmt.Fprintln(fs.Output(), "columns: key, value, length, flags, and created and updated with Timestamps")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 || *rows <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	m, err := shm.Open(fs.Arg(0), *maxTry, *wait)
+	if err != nil {
+		return
+	}
+	defer m.Close()
+	f, err := os.Create(*out)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if e := f.Close(); err == nil {
+			err = e
+		}
+	}()
+	w := bufio.NewWriter(f)
+	n, err := writeParquet(w, m, *rows, *compress)
+	if err != nil {
+		return
+	}
+	if err = w.Flush(); err != nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%d entries exported\n", n)
+	return
+}
+
+// write the entries of m to w in row groups of rows, values as Get
+// return them
+func writeParquet(w io.Writer, m *shm.Map, rows int, compress bool) (n int, err error) {
+	key := &pqColumn{name: "key", typ: pqByteArray, conv: pqUTF8}
+	value := &pqColumn{name: "value", typ: pqByteArray, conv: pqNone}
+	length := &pqColumn{name: "length", typ: pqInt32, conv: pqNone}
+	flags := &pqColumn{name: "flags", typ: pqInt32, conv: pqNone}
+	created := &pqColumn{name: "created", typ: pqInt64, conv: pqTimestampMicros}
+	updated := &pqColumn{name: "updated", typ: pqInt64, conv: pqTimestampMicros}
+	cols := []*pqColumn{key, value, length, flags}
+	times := false
+	for _, f := range m.Params().Features {
+		if f == "Timestamps" {
+			times = true
+			cols = append(cols, created, updated)
+		}
+	}
+	pw, err := newParquetWriter(w, cols, compress)
+	if err != nil {
+		return
+	}
+	m.Foreach(func(k string, _ []byte) bool {
+		v, meta, e := m.GetWithMeta(k)
+		if e != nil {
+			// deleted or missing meanwhile
+			return true
+		}
+		key.bytes([]byte(k))
+		value.bytes(v)
+		length.int32(int32(len(v)))
+		flags.int32(int32(meta.Flags))
+		if times {
+			created.int64(meta.Created.UnixNano() / int64(time.Microsecond))
+			updated.int64(meta.Updated.UnixNano() / int64(time.Microsecond))
+		}
+		pw.row()
+		if n++; n%rows == 0 {
+			err = pw.flush()
+		}
+		return err == nil
+	})
+	if err != nil {
+		return
+	}
+	err = pw.Close()
+	return
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/fengyoulin/shm"
+	"io"
+	"os"
+	"time"
+)
+
+// import-rdb: load the string keys of a redis rdb dump into a map
+func importRDB(args []string) (err error) {
+	fs := flag.NewFlagSet("import-rdb", flag.ExitOnError)
+	var p mapParams
+	p.register(fs)
+	db := fs.Int("redis-db", -1, "import only this redis database, -1 for all")
+	quiet := fs.Bool("q", false, "do not report skipped entries")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: shmtool import-rdb [flags] dump.rdb")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	d, err := newRDBReader(f)
+	if err != nil {
+		return
+	}
+	m, err := p.create()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if e := m.Close(); err == nil {
+			err = e
+		}
+	}()
+	skip := func(e *rdbEntry, format string, args ...interface{}) {
+		if !*quiet {
+			fmt.Fprintf(os.Stderr, "skip %q: %s\n", e.key, fmt.Sprintf(format, args...))
+		}
+	}
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	var imported, skipped int
+	for {
+		var e rdbEntry
+		if e, err = d.next(); err != nil {
+			break
+		}
+		if *db >= 0 && e.db != *db {
+			continue
+		}
+		switch {
+		case e.typ != rdbTypeString:
+			skip(&e, "not a string, type %d", e.typ)
+		case e.expire != 0 && e.expire <= now:
+			skip(&e, "expired")
+		case len(e.key) > p.keyLen:
+			skip(&e, "key of %d bytes", len(e.key))
+		default:
+			err = m.Set(e.key, e.value)
+			if errors.Is(err, shm.ErrValLen) {
+				skip(&e, "value of %d bytes", len(e.value))
+				err = nil
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("set %q: %w", e.key, err)
+			}
+			imported++
+			continue
+		}
+		skipped++
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	fmt.Fprintf(os.Stderr, "imported %d, skipped %d\n", imported, skipped)
+	return
+}
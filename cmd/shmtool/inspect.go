@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/fengyoulin/shm"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// inspect: print the parameters, origin and memory of a map
+func inspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	maxTry := fs.Int("try", 20, "max tries of an operation")
+	wait := fs.Duration("wait", time.Second, "wait for the database lock")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: shmtool inspect [flags] a.db")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	m, err := shm.Open(fs.Arg(0), *maxTry, *wait)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	if err = printMap(os.Stdout, m); err != nil {
+		return err
+	}
+	// this one included
+	n, err := shm.Attached(fs.Arg(0))
+	switch {
+	case err != nil:
+		return err
+	case n < 0:
+		fmt.Println("attached:    unknown")
+	default:
+		fmt.Printf("attached:    %d other\n", n-1)
+	}
+	return nil
+}
+
+// print the report of m to w
+func printMap(w io.Writer, m *shm.Map) error {
+	p := m.Params()
+	fmt.Fprintf(w, "entries:     %d of %d\n", m.Len(), p.Cap)
+	fmt.Fprintf(w, "key cap:     %d\n", p.KeyCap)
+	fmt.Fprintf(w, "value cap:   %d\n", p.ValueCap)
+	fmt.Fprintf(w, "bucket size: %d, align %d\n", p.BucketSize, p.Align)
+	fmt.Fprintf(w, "hash:        %s\n", strings.Join(p.Hash, ", "))
+	fmt.Fprintf(w, "features:    %s\n", strings.Join(p.Features, ", "))
+	o, err := m.Origin()
+	switch {
+	case errors.Is(err, shm.ErrNoOrigin):
+		fmt.Fprintln(w, "origin:      not recorded")
+	case err != nil:
+		return err
+	default:
+		fmt.Fprintf(w, "origin:      pid %d on %s, version %s\n", o.PID, o.Host, o.Version)
+		fmt.Fprintf(w, "created:     %s\n", o.Created.Format(time.RFC3339))
+	}
+	writers, readers, err := m.ACL()
+	switch {
+	case errors.Is(err, shm.ErrNoACL):
+	case err != nil:
+		return err
+	default:
+		fmt.Fprintf(w, "acl:         writers %v, readers %v\n", writers, readers)
+	}
+	s, err := m.MemStats()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "virtual:     %d\n", s.Virtual)
+	if s.Resident >= 0 {
+		fmt.Fprintf(w, "resident:    %d\n", s.Resident)
+	}
+	fmt.Fprintf(w, "live:        %d, free %d, unused %d\n", s.Live, s.Free, s.Unused)
+	return nil
+}
@@ -0,0 +1,80 @@
+// Command shmtool works on shm map databases
+//
+//	shmtool import-rdb [flags] dump.rdb
+//	shmtool diff [flags] a.db b.db
+//	shmtool inspect [flags] a.db
+//	shmtool export-parquet [flags] a.db
+//	shmtool audit [flags] a.db
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/fengyoulin/shm"
+	"os"
+	"sort"
+	"time"
+)
+
+// the subcommands
+var commands = map[string]func(args []string) error{
+	"import-rdb":     importRDB,
+	"diff":           diff,
+	"inspect":        inspect,
+	"export-parquet": exportParquet,
+	"audit":          audit,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "shmtool:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "usage: shmtool <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  "+name)
+	}
+}
+
+// params of the map a command works on, as given to shm.Create
+type mapParams struct {
+	path     string
+	mapCap   int
+	keyLen   int
+	valueLen int
+	maxTry   int
+	wait     time.Duration
+}
+
+// register the flags of the params on fs
+func (p *mapParams) register(fs *flag.FlagSet) {
+	fs.StringVar(&p.path, "db", "map.db", "database file")
+	fs.IntVar(&p.mapCap, "cap", 4096, "map capacity")
+	fs.IntVar(&p.keyLen, "key", 40, "max key length")
+	fs.IntVar(&p.valueLen, "value", 32, "value length")
+	fs.IntVar(&p.maxTry, "try", 20, "max tries of an operation")
+	fs.DurationVar(&p.wait, "wait", time.Second, "wait for the database lock")
+}
+
+// create or open the map
+func (p *mapParams) create(opts ...shm.Option) (*shm.Map, error) {
+	return shm.Create(p.path, p.mapCap, p.keyLen, p.valueLen, p.maxTry, p.wait, opts...)
+}
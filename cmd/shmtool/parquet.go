@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/binary"
+	"github.com/klauspost/compress/snappy"
+	"io"
+)
+
+// parquet physical types
+const (
+	pqInt32     = 1
+	pqInt64     = 2
+	pqByteArray = 6
+)
+
+// parquet converted types, -1 for none
+const (
+	pqNone            = -1
+	pqUTF8            = 0
+	pqTimestampMicros = 10
+)
+
+// thrift compact protocol types
+const (
+	tI32    = 5
+	tI64    = 6
+	tBinary = 8
+	tList   = 9
+	tStruct = 12
+)
+
+// thrift compact protocol encoder of the structs of the parquet
+// metadata
+type thrift struct {
+	b []byte
+	// last field id of the structs open
+	last []int16
+}
+
+func (t *thrift) uvarint(x uint64) {
+	var n [binary.MaxVarintLen64]byte
+	t.b = append(t.b, n[:binary.PutUvarint(n[:], x)]...)
+}
+
+func (t *thrift) varint(x int64) {
+	t.uvarint(uint64(x<<1) ^ uint64(x>>63))
+}
+
+func (t *thrift) field(id int16, typ byte) {
+	last := &t.last[len(t.last)-1]
+	if d := id - *last; d > 0 && d <= 15 {
+		t.b = append(t.b, byte(d)<<4|typ)
+	} else {
+		t.b = append(t.b, typ)
+		t.varint(int64(id))
+	}
+	*last = id
+}
+
+func (t *thrift) i32(id int16, v int32) {
+	t.field(id, tI32)
+	t.varint(int64(v))
+}
+
+func (t *thrift) i64(id int16, v int64) {
+	t.field(id, tI64)
+	t.varint(v)
+}
+
+func (t *thrift) binary(s string) {
+	t.uvarint(uint64(len(s)))
+	t.b = append(t.b, s...)
+}
+
+func (t *thrift) str(id int16, s string) {
+	t.field(id, tBinary)
+	t.binary(s)
+}
+
+// begin a list field of n elements of typ
+func (t *thrift) list(id int16, typ byte, n int) {
+	t.field(id, tList)
+	if n < 15 {
+		t.b = append(t.b, byte(n)<<4|typ)
+		return
+	}
+	t.b = append(t.b, 0xf0|typ)
+	t.uvarint(uint64(n))
+}
+
+// begin a struct, the field id 0 for a list element
+func (t *thrift) begin(id int16) {
+	if id != 0 {
+		t.field(id, tStruct)
+	}
+	t.last = append(t.last, 0)
+}
+
+func (t *thrift) end() {
+	t.b = append(t.b, 0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+// pqColumn of a parquet file, its values of the row group being
+// written plain encoded
+type pqColumn struct {
+	name string
+	typ  int32
+	conv int32
+	data []byte
+}
+
+// a column chunk written
+type pqChunk struct {
+	off          int64
+	values       int
+	uncompressed int
+	compressed   int
+}
+
+// parquetWriter write rows of required columns to w, in row groups of
+// one data page per column
+type parquetWriter struct {
+	w      io.Writer
+	off    int64
+	cols   []*pqColumn
+	snappy bool
+	rows   int
+	total  int64
+	groups [][]pqChunk
+}
+
+// newParquetWriter write the magic to w
+func newParquetWriter(w io.Writer, cols []*pqColumn, snappy bool) (*parquetWriter, error) {
+	p := &parquetWriter{w: w, cols: cols, snappy: snappy}
+	return p, p.write([]byte("PAR1"))
+}
+
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.off += int64(n)
+	return err
+}
+
+// add a value to the row being written, by column
+func (c *pqColumn) bytes(v []byte) {
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(v)))
+	c.data = append(append(c.data, n[:]...), v...)
+}
+
+func (c *pqColumn) int32(v int32) {
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(v))
+	c.data = append(c.data, n[:]...)
+}
+
+func (c *pqColumn) int64(v int64) {
+	var n [8]byte
+	binary.LittleEndian.PutUint64(n[:], uint64(v))
+	c.data = append(c.data, n[:]...)
+}
+
+// row end the row whose values were added
+func (p *parquetWriter) row() {
+	p.rows++
+}
+
+// flush the rows as a row group
+func (p *parquetWriter) flush() error {
+	if p.rows == 0 {
+		return nil
+	}
+	chunks := make([]pqChunk, len(p.cols))
+	for i, c := range p.cols {
+		page := c.data
+		if p.snappy {
+			page = snappy.Encode(nil, c.data)
+		}
+		var t thrift
+		t.begin(0)
+		// data page
+		t.i32(1, 0)
+		t.i32(2, int32(len(c.data)))
+		t.i32(3, int32(len(page)))
+		t.begin(5)
+		t.i32(1, int32(p.rows))
+		// plain, levels rle
+		t.i32(2, 0)
+		t.i32(3, 3)
+		t.i32(4, 3)
+		t.end()
+		t.end()
+		chunks[i] = pqChunk{
+			off:          p.off,
+			values:       p.rows,
+			uncompressed: len(t.b) + len(c.data),
+			compressed:   len(t.b) + len(page),
+		}
+		if err := p.write(t.b); err != nil {
+			return err
+		}
+		if err := p.write(page); err != nil {
+			return err
+		}
+		c.data = c.data[:0]
+	}
+	p.groups = append(p.groups, chunks)
+	p.total += int64(p.rows)
+	p.rows = 0
+	return nil
+}
+
+// Close flush the rows and write the metadata
+func (p *parquetWriter) Close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	var t thrift
+	t.begin(0)
+	t.i32(1, 1)
+	t.list(2, tStruct, len(p.cols)+1)
+	t.begin(0)
+	t.str(4, "schema")
+	t.i32(5, int32(len(p.cols)))
+	t.end()
+	for _, c := range p.cols {
+		t.begin(0)
+		t.i32(1, c.typ)
+		// required
+		t.i32(3, 0)
+		t.str(4, c.name)
+		if c.conv != pqNone {
+			t.i32(6, c.conv)
+		}
+		t.end()
+	}
+	t.i64(3, p.total)
+	t.list(4, tStruct, len(p.groups))
+	for _, chunks := range p.groups {
+		t.begin(0)
+		t.list(1, tStruct, len(chunks))
+		size := int64(0)
+		for i, ch := range chunks {
+			c := p.cols[i]
+			t.begin(0)
+			t.i64(2, ch.off)
+			t.begin(3)
+			t.i32(1, c.typ)
+			t.list(2, tI32, 1)
+			t.varint(0)
+			t.list(3, tBinary, 1)
+			t.binary(c.name)
+			codec := int32(0)
+			if p.snappy {
+				codec = 1
+			}
+			t.i32(4, codec)
+			t.i64(5, int64(ch.values))
+			t.i64(6, int64(ch.uncompressed))
+			t.i64(7, int64(ch.compressed))
+			t.i64(9, ch.off)
+			t.end()
+			t.end()
+			size += int64(ch.uncompressed)
+		}
+		t.i64(2, size)
+		t.i64(3, int64(chunks[0].values))
+		t.end()
+	}
+	t.str(6, "shmtool")
+	t.end()
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(t.b)))
+	if err := p.write(t.b); err != nil {
+		return err
+	}
+	if err := p.write(n[:]); err != nil {
+		return err
+	}
+	return p.write([]byte("PAR1"))
+}
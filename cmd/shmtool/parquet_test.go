@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/fengyoulin/shm"
+	"github.com/klauspost/compress/snappy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// the files are read back by the decoder below, written from the
+// parquet and thrift compact protocol specs, no parquet reader being
+// among the dependencies
+
+// a thrift struct read, its fields by id
+type tStructVal map[int16]interface{}
+
+// tReader of the thrift compact protocol, integers read as int64,
+// binaries as []byte, lists as []interface{}
+type tReader struct {
+	b   []byte
+	err error
+}
+
+var errThrift = errors.New("bad thrift")
+
+func (r *tReader) byte() byte {
+	if len(r.b) == 0 {
+		r.err = errThrift
+		return 0
+	}
+	c := r.b[0]
+	r.b = r.b[1:]
+	return c
+}
+
+func (r *tReader) uvarint() uint64 {
+	x, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = errThrift
+		return 0
+	}
+	r.b = r.b[n:]
+	return x
+}
+
+func (r *tReader) varint() int64 {
+	x := r.uvarint()
+	return int64(x>>1) ^ -int64(x&1)
+}
+
+func (r *tReader) value(typ byte) interface{} {
+	switch typ {
+	case 1, 2:
+		return typ == 1
+	case 3:
+		return int64(int8(r.byte()))
+	case 4, tI32, tI64:
+		return r.varint()
+	case tBinary:
+		n := r.uvarint()
+		if uint64(len(r.b)) < n {
+			r.err = errThrift
+			return nil
+		}
+		v := r.b[:n]
+		r.b = r.b[n:]
+		return v
+	case tList:
+		h := r.byte()
+		n := uint64(h >> 4)
+		if n == 15 {
+			n = r.uvarint()
+		}
+		var l []interface{}
+		for ; n > 0 && r.err == nil; n-- {
+			l = append(l, r.value(h&0x0f))
+		}
+		return l
+	case tStruct:
+		return r.structVal()
+	}
+	r.err = fmt.Errorf("%w: type %d", errThrift, typ)
+	return nil
+}
+
+func (r *tReader) structVal() tStructVal {
+	s := tStructVal{}
+	var last int16
+	for r.err == nil {
+		h := r.byte()
+		if h == 0 {
+			break
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(r.varint())
+		}
+		last = id
+		s[id] = r.value(h & 0x0f)
+	}
+	return s
+}
+
+// a column of a parquet file read, its rows by type
+type pqRead struct {
+	name   string
+	typ    int64
+	conv   int64
+	values []interface{}
+}
+
+// read a parquet file of required columns written plain
+func readParquet(b []byte) (cols []*pqRead, rows int64, err error) {
+	n := len(b)
+	if n < 12 || string(b[:4]) != "PAR1" || string(b[n-4:]) != "PAR1" {
+		return nil, 0, errors.New("bad magic")
+	}
+	size := int(binary.LittleEndian.Uint32(b[n-8:]))
+	if size > n-12 {
+		return nil, 0, errors.New("bad footer")
+	}
+	r := &tReader{b: b[n-8-size : n-8]}
+	meta := r.structVal()
+	if r.err != nil || len(r.b) != 0 {
+		return nil, 0, fmt.Errorf("footer: %v, %d bytes left", r.err, len(r.b))
+	}
+	schema := meta[2].([]interface{})
+	if root := schema[0].(tStructVal); root[5].(int64) != int64(len(schema)-1) {
+		return nil, 0, errors.New("bad schema root")
+	}
+	for _, e := range schema[1:] {
+		s := e.(tStructVal)
+		if s[3].(int64) != 0 {
+			return nil, 0, errors.New("column not required")
+		}
+		c := &pqRead{name: string(s[4].([]byte)), typ: s[1].(int64), conv: pqNone}
+		if conv, ok := s[6]; ok {
+			c.conv = conv.(int64)
+		}
+		cols = append(cols, c)
+	}
+	for _, g := range meta[4].([]interface{}) {
+		group := g.(tStructVal)
+		chunks := group[1].([]interface{})
+		if len(chunks) != len(cols) {
+			return nil, 0, errors.New("bad row group")
+		}
+		for i, ch := range chunks {
+			cm := ch.(tStructVal)[3].(tStructVal)
+			if string(cm[3].([]interface{})[0].([]byte)) != cols[i].name || cm[1].(int64) != cols[i].typ {
+				return nil, 0, fmt.Errorf("column %d mismatch", i)
+			}
+			off := cm[9].(int64)
+			page := &tReader{b: b[off : off+cm[7].(int64)]}
+			h := page.structVal()
+			if page.err != nil || h[1].(int64) != 0 {
+				return nil, 0, errors.New("bad page header")
+			}
+			if int64(len(page.b)) != h[3].(int64) {
+				return nil, 0, errors.New("bad compressed page size")
+			}
+			data := page.b
+			if cm[4].(int64) == 1 {
+				if data, err = snappy.Decode(nil, data); err != nil {
+					return nil, 0, err
+				}
+			}
+			if int64(len(data)) != h[2].(int64) {
+				return nil, 0, errors.New("bad uncompressed page size")
+			}
+			dh := h[5].(tStructVal)
+			values := dh[1].(int64)
+			if dh[2].(int64) != 0 || values != cm[5].(int64) || values != group[3].(int64) {
+				return nil, 0, errors.New("bad data page header")
+			}
+			for k := int64(0); k < values; k++ {
+				var v interface{}
+				switch cols[i].typ {
+				case pqInt32:
+					v, data = int32(binary.LittleEndian.Uint32(data)), data[4:]
+				case pqInt64:
+					v, data = int64(binary.LittleEndian.Uint64(data)), data[8:]
+				case pqByteArray:
+					l := binary.LittleEndian.Uint32(data)
+					v, data = data[4:4+l], data[4+l:]
+				}
+				cols[i].values = append(cols[i].values, v)
+			}
+			if len(data) != 0 {
+				return nil, 0, errors.New("page data left")
+			}
+		}
+		rows += group[3].(int64)
+	}
+	if rows != meta[3].(int64) {
+		return nil, 0, errors.New("bad row count")
+	}
+	return
+}
+
+func TestWriteParquet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shmtool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m, err := shm.Create(filepath.Join(dir, "a.db"), 64, 8, 8, 20, time.Second, shm.Timestamps())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	const n = 10
+	for i := 0; i < n; i++ {
+		// trailing zero bytes are part of the value
+		if err = m.Set(fmt.Sprint("k", i), []byte{'v', byte(i), 0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = m.SetFlags("k3", 5); err != nil {
+		t.Fatal(err)
+	}
+	for _, compress := range []bool{false, true} {
+		var out bytes.Buffer
+		written, err := writeParquet(&out, m, 4, compress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cols, rows, err := readParquet(out.Bytes())
+		if err != nil {
+			t.Fatalf("snappy %v: %v", compress, err)
+		}
+		if written != n || rows != n || len(cols) != 6 {
+			t.Fatalf("expect %d rows of 6 columns, got %d, %d of %d", n, written, rows, len(cols))
+		}
+		expect := []pqRead{
+			{name: "key", typ: pqByteArray, conv: pqUTF8},
+			{name: "value", typ: pqByteArray, conv: pqNone},
+			{name: "length", typ: pqInt32, conv: pqNone},
+			{name: "flags", typ: pqInt32, conv: pqNone},
+			{name: "created", typ: pqInt64, conv: pqTimestampMicros},
+			{name: "updated", typ: pqInt64, conv: pqTimestampMicros},
+		}
+		for i, c := range cols {
+			if c.name != expect[i].name || c.typ != expect[i].typ || c.conv != expect[i].conv {
+				t.Errorf("expect column %+v, got %+v", expect[i], *c)
+			}
+		}
+		for r := 0; r < n; r++ {
+			key := string(cols[0].values[r].([]byte))
+			v, meta, err := m.GetWithMeta(key)
+			if err != nil {
+				t.Fatalf("key %q: %v", key, err)
+			}
+			value := cols[1].values[r].([]byte)
+			if !bytes.Equal(value, v) || cols[2].values[r].(int32) != int32(len(v)) {
+				t.Errorf("expect %q of %q, got %q of %d", v, key, value, cols[2].values[r])
+			}
+			if cols[3].values[r].(int32) != int32(meta.Flags) {
+				t.Errorf("expect flags %d of %q, got %d", meta.Flags, key, cols[3].values[r])
+			}
+			if us := meta.Updated.UnixNano() / int64(time.Microsecond); cols[5].values[r].(int64) != us {
+				t.Errorf("expect updated %d of %q, got %d", us, key, cols[5].values[r])
+			}
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// opcodes of a redis rdb dump
+const (
+	rdbOpFunction2     = 0xf5
+	rdbOpFunctionPreGA = 0xf6
+	rdbOpModuleAux     = 0xf7
+	rdbOpIdle          = 0xf8
+	rdbOpFreq          = 0xf9
+	rdbOpAux           = 0xfa
+	rdbOpResizeDB      = 0xfb
+	rdbOpExpireMS      = 0xfc
+	rdbOpExpire        = 0xfd
+	rdbOpSelectDB      = 0xfe
+	rdbOpEOF           = 0xff
+)
+
+// value types of a redis rdb dump
+const (
+	rdbTypeString         = 0
+	rdbTypeList           = 1
+	rdbTypeSet            = 2
+	rdbTypeZset           = 3
+	rdbTypeHash           = 4
+	rdbTypeZset2          = 5
+	rdbTypeHashZipmap     = 9
+	rdbTypeListZiplist    = 10
+	rdbTypeSetIntset      = 11
+	rdbTypeZsetZiplist    = 12
+	rdbTypeHashZiplist    = 13
+	rdbTypeListQuicklist  = 14
+	rdbTypeStream         = 15
+	rdbTypeHashListpack   = 16
+	rdbTypeZsetListpack   = 17
+	rdbTypeListQuicklist2 = 18
+	rdbTypeStream2        = 19
+	rdbTypeSetListpack    = 20
+	rdbTypeStream3        = 21
+)
+
+// newest rdb version understood
+const rdbMaxVersion = 12
+
+// longest string read, redis bounds bulk strings to 512MB
+const rdbMaxString = 512 << 20
+
+// ErrRDB on a damaged or unsupported dump
+var ErrRDB = errors.New("invalid rdb dump")
+
+// an entry of a dump
+type rdbEntry struct {
+	db  int
+	typ byte
+	key string
+	// only for strings
+	value []byte
+	// unix milliseconds, 0 if none
+	expire int64
+}
+
+// rdbReader read the entries of a redis rdb dump
+type rdbReader struct {
+	r       *bufio.Reader
+	version int
+	db      int
+	expire  int64
+}
+
+// newRDBReader check the magic of the dump in r
+func newRDBReader(r io.Reader) (d *rdbReader, err error) {
+	d = &rdbReader{r: bufio.NewReader(r)}
+	var magic [9]byte
+	if _, err = io.ReadFull(d.r, magic[:]); err != nil {
+		return nil, err
+	}
+	if string(magic[:5]) != "REDIS" {
+		return nil, ErrRDB
+	}
+	d.version, err = strconv.Atoi(string(magic[5:]))
+	if err != nil || d.version < 1 || d.version > rdbMaxVersion {
+		return nil, fmt.Errorf("%w: version %q", ErrRDB, magic[5:])
+	}
+	return d, nil
+}
+
+// next entry of the dump, io.EOF at the end
+// values of types other than string are skipped, leaving value nil
+func (d *rdbReader) next() (e rdbEntry, err error) {
+	for {
+		var op byte
+		if op, err = d.r.ReadByte(); err != nil {
+			return e, unexpected(err)
+		}
+		switch op {
+		case rdbOpEOF:
+			// the checksum is not verified
+			return e, io.EOF
+		case rdbOpSelectDB:
+			var n uint64
+			if n, err = d.plainLength(); err != nil {
+				return
+			}
+			d.db = int(n)
+		case rdbOpResizeDB:
+			if _, err = d.plainLength(); err != nil {
+				return
+			}
+			if _, err = d.plainLength(); err != nil {
+				return
+			}
+		case rdbOpAux:
+			if err = d.skipStrings(2); err != nil {
+				return
+			}
+		case rdbOpExpireMS:
+			var b [8]byte
+			if _, err = io.ReadFull(d.r, b[:]); err != nil {
+				return e, unexpected(err)
+			}
+			d.expire = int64(binary.LittleEndian.Uint64(b[:]))
+		case rdbOpExpire:
+			var b [4]byte
+			if _, err = io.ReadFull(d.r, b[:]); err != nil {
+				return e, unexpected(err)
+			}
+			d.expire = int64(binary.LittleEndian.Uint32(b[:])) * 1000
+		case rdbOpIdle:
+			if _, err = d.plainLength(); err != nil {
+				return
+			}
+		case rdbOpFreq:
+			if _, err = d.r.ReadByte(); err != nil {
+				return e, unexpected(err)
+			}
+		case rdbOpFunction2:
+			if err = d.skipStrings(1); err != nil {
+				return
+			}
+		case rdbOpFunctionPreGA, rdbOpModuleAux:
+			return e, fmt.Errorf("%w: opcode %#x unsupported", ErrRDB, op)
+		default:
+			e.db, e.typ, e.expire = d.db, op, d.expire
+			d.expire = 0
+			var key []byte
+			if key, err = d.str(); err != nil {
+				return
+			}
+			e.key = string(key)
+			if op == rdbTypeString {
+				e.value, err = d.str()
+			} else {
+				err = d.skipValue(op)
+			}
+			return
+		}
+	}
+}
+
+// skip a value of type typ
+func (d *rdbReader) skipValue(typ byte) (err error) {
+	switch typ {
+	case rdbTypeHashZipmap, rdbTypeListZiplist, rdbTypeSetIntset, rdbTypeZsetZiplist,
+		rdbTypeHashZiplist, rdbTypeHashListpack, rdbTypeZsetListpack, rdbTypeSetListpack:
+		return d.skipStrings(1)
+	case rdbTypeStream, rdbTypeStream2, rdbTypeStream3:
+		return d.skipStream(typ)
+	}
+	n, err := d.plainLength()
+	if err != nil {
+		return
+	}
+	switch typ {
+	case rdbTypeList, rdbTypeSet, rdbTypeListQuicklist:
+		return d.skipStrings(n)
+	case rdbTypeHash:
+		return d.skipStrings(2 * n)
+	case rdbTypeZset:
+		for ; n > 0; n-- {
+			if err = d.skipStrings(1); err != nil {
+				return
+			}
+			// a double as a string with a length byte
+			var l byte
+			if l, err = d.r.ReadByte(); err != nil {
+				return unexpected(err)
+			}
+			// 253 to 255 are nan and infinities, without bytes
+			if l < 253 {
+				if _, err = d.r.Discard(int(l)); err != nil {
+					return unexpected(err)
+				}
+			}
+		}
+		return
+	case rdbTypeZset2:
+		for ; n > 0; n-- {
+			if err = d.skipStrings(1); err != nil {
+				return
+			}
+			if _, err = d.r.Discard(8); err != nil {
+				return unexpected(err)
+			}
+		}
+		return
+	case rdbTypeListQuicklist2:
+		for ; n > 0; n-- {
+			// the container kind, then the node
+			if _, err = d.plainLength(); err != nil {
+				return
+			}
+			if err = d.skipStrings(1); err != nil {
+				return
+			}
+		}
+		return
+	}
+	return fmt.Errorf("%w: value type %d unsupported", ErrRDB, typ)
+}
+
+// skip a stream of type typ: its listpacks, each a node key and the
+// pack, its length and ids, then its consumer groups
+func (d *rdbReader) skipStream(typ byte) (err error) {
+	n, err := d.plainLength()
+	if err != nil {
+		return
+	}
+	if err = d.skipStrings(2 * n); err != nil {
+		return
+	}
+	// length and last id, then the first id, the max deleted id and
+	// the entries added of the later types
+	lengths := 3
+	if typ >= rdbTypeStream2 {
+		lengths += 5
+	}
+	if err = d.skipLengths(lengths); err != nil {
+		return
+	}
+	groups, err := d.plainLength()
+	if err != nil {
+		return
+	}
+	for ; groups > 0; groups-- {
+		if err = d.skipStrings(1); err != nil {
+			return
+		}
+		// last id, and the entries read of the later types
+		lengths = 2
+		if typ >= rdbTypeStream2 {
+			lengths++
+		}
+		if err = d.skipLengths(lengths); err != nil {
+			return
+		}
+		// pending entries: an id, the delivery time and count
+		if n, err = d.plainLength(); err != nil {
+			return
+		}
+		for ; n > 0; n-- {
+			if _, err = d.r.Discard(16 + 8); err != nil {
+				return unexpected(err)
+			}
+			if _, err = d.plainLength(); err != nil {
+				return
+			}
+		}
+		var consumers uint64
+		if consumers, err = d.plainLength(); err != nil {
+			return
+		}
+		for ; consumers > 0; consumers-- {
+			if err = d.skipStrings(1); err != nil {
+				return
+			}
+			// seen time, and active time of the latest type
+			times := 8
+			if typ >= rdbTypeStream3 {
+				times += 8
+			}
+			if _, err = d.r.Discard(times); err != nil {
+				return unexpected(err)
+			}
+			// ids of the pending entries of the consumer
+			if n, err = d.plainLength(); err != nil {
+				return
+			}
+			for ; n > 0; n-- {
+				if _, err = d.r.Discard(16); err != nil {
+					return unexpected(err)
+				}
+			}
+		}
+	}
+	return
+}
+
+// skip n lengths
+func (d *rdbReader) skipLengths(n int) error {
+	for ; n > 0; n-- {
+		if _, err := d.plainLength(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skip n strings
+func (d *rdbReader) skipStrings(n uint64) error {
+	for ; n > 0; n-- {
+		if _, err := d.str(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read a length, or the kind of a specially encoded string
+func (d *rdbReader) length() (n uint64, encoded bool, err error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, false, unexpected(err)
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		var b2 byte
+		if b2, err = d.r.ReadByte(); err != nil {
+			return 0, false, unexpected(err)
+		}
+		return uint64(b&0x3f)<<8 | uint64(b2), false, nil
+	case 2:
+		var buf [8]byte
+		switch b {
+		case 0x80:
+			if _, err = io.ReadFull(d.r, buf[:4]); err != nil {
+				return 0, false, unexpected(err)
+			}
+			return uint64(binary.BigEndian.Uint32(buf[:4])), false, nil
+		case 0x81:
+			if _, err = io.ReadFull(d.r, buf[:]); err != nil {
+				return 0, false, unexpected(err)
+			}
+			return binary.BigEndian.Uint64(buf[:]), false, nil
+		}
+		return 0, false, fmt.Errorf("%w: length prefix %#x", ErrRDB, b)
+	}
+	return uint64(b & 0x3f), true, nil
+}
+
+// read a length, not a string encoding
+func (d *rdbReader) plainLength() (uint64, error) {
+	n, encoded, err := d.length()
+	if err == nil && encoded {
+		err = fmt.Errorf("%w: encoded string for a length", ErrRDB)
+	}
+	return n, err
+}
+
+// read a string
+func (d *rdbReader) str() (b []byte, err error) {
+	n, encoded, err := d.length()
+	if err != nil {
+		return
+	}
+	if !encoded {
+		return d.bytes(n)
+	}
+	var buf [4]byte
+	switch n {
+	case 0:
+		if _, err = io.ReadFull(d.r, buf[:1]); err != nil {
+			return nil, unexpected(err)
+		}
+		return strconv.AppendInt(nil, int64(int8(buf[0])), 10), nil
+	case 1:
+		if _, err = io.ReadFull(d.r, buf[:2]); err != nil {
+			return nil, unexpected(err)
+		}
+		return strconv.AppendInt(nil, int64(int16(binary.LittleEndian.Uint16(buf[:2]))), 10), nil
+	case 2:
+		if _, err = io.ReadFull(d.r, buf[:4]); err != nil {
+			return nil, unexpected(err)
+		}
+		return strconv.AppendInt(nil, int64(int32(binary.LittleEndian.Uint32(buf[:4]))), 10), nil
+	case 3:
+		var clen, ulen uint64
+		if clen, err = d.plainLength(); err != nil {
+			return
+		}
+		if ulen, err = d.plainLength(); err != nil {
+			return
+		}
+		if ulen > rdbMaxString {
+			return nil, fmt.Errorf("%w: string of %d bytes", ErrRDB, ulen)
+		}
+		var c []byte
+		if c, err = d.bytes(clen); err != nil {
+			return
+		}
+		return lzfDecompress(c, int(ulen))
+	}
+	return nil, fmt.Errorf("%w: string encoding %d", ErrRDB, n)
+}
+
+// read n bytes
+func (d *rdbReader) bytes(n uint64) (b []byte, err error) {
+	if n > rdbMaxString {
+		return nil, fmt.Errorf("%w: string of %d bytes", ErrRDB, n)
+	}
+	b = make([]byte, n)
+	if _, err = io.ReadFull(d.r, b); err != nil {
+		return nil, unexpected(err)
+	}
+	return
+}
+
+// decompress the lzf data in into n bytes
+func lzfDecompress(in []byte, n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			// a literal run of ctrl+1 bytes
+			l := ctrl + 1
+			if i+l > len(in) {
+				return nil, fmt.Errorf("%w: lzf literal overrun", ErrRDB)
+			}
+			out = append(out, in[i:i+l]...)
+			i += l
+			continue
+		}
+		// a back reference
+		l := ctrl >> 5
+		if l == 7 {
+			if i >= len(in) {
+				return nil, fmt.Errorf("%w: lzf truncated", ErrRDB)
+			}
+			l += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, fmt.Errorf("%w: lzf truncated", ErrRDB)
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, fmt.Errorf("%w: lzf reference out of range", ErrRDB)
+		}
+		// may overlap the bytes being written
+		for j := 0; j < l+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != n {
+		return nil, fmt.Errorf("%w: lzf length %d, expect %d", ErrRDB, len(out), n)
+	}
+	return out, nil
+}
+
+// a dump ending early is damaged
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestRDBReader(t *testing.T) {
+	dump := []byte("REDIS0009")
+	dump = append(dump, rdbOpAux, 9)
+	dump = append(dump, "redis-ver"...)
+	dump = append(dump, 5)
+	dump = append(dump, "6.0.0"...)
+	dump = append(dump, rdbOpSelectDB, 0, rdbOpResizeDB, 4, 1)
+	// a plain string
+	dump = append(dump, rdbTypeString, 1, 'a', 5)
+	dump = append(dump, "hello"...)
+	// an int8 encoded string with an expire
+	dump = append(dump, rdbOpExpireMS, 1, 0, 0, 0, 0, 0, 0, 0)
+	dump = append(dump, rdbTypeString, 1, 'b', 0xc0, 0x85)
+	// a list, skipped
+	dump = append(dump, rdbTypeList, 1, 'l', 2, 1, 'x', 1, 'y')
+	// lzf: literal "ab", then a back reference copying 4 bytes at distance 2
+	dump = append(dump, rdbTypeString, 1, 'c', 0xc3, 5, 6, 1, 'a', 'b', 2<<5, 1)
+	dump = append(dump, rdbOpEOF, 0, 0, 0, 0, 0, 0, 0, 0)
+	d, err := newRDBReader(bytes.NewReader(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []rdbEntry{
+		{typ: rdbTypeString, key: "a", value: []byte("hello")},
+		{typ: rdbTypeString, key: "b", value: []byte("-123"), expire: 1},
+		{typ: rdbTypeList, key: "l"},
+		{typ: rdbTypeString, key: "c", value: []byte("ababab")},
+	}
+	for _, x := range expect {
+		e, err := d.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.typ != x.typ || e.key != x.key || string(e.value) != string(x.value) || e.expire != x.expire {
+			t.Errorf("expect %+v, got %+v", x, e)
+		}
+	}
+	if _, err = d.next(); err != io.EOF {
+		t.Errorf("expect io.EOF, got %v", err)
+	}
+}
+
+func TestRDBReaderStream(t *testing.T) {
+	id := make([]byte, 16)
+	dump := []byte("REDIS0011")
+	dump = append(dump, rdbTypeStream3, 1, 's')
+	// a listpack, its node key and the pack
+	dump = append(dump, 1, 16)
+	dump = append(dump, id...)
+	dump = append(dump, 3, 'a', 'b', 'c')
+	// length, last id, first id, max deleted id, entries added
+	dump = append(dump, 2, 5, 0, 1, 0, 0, 0, 2)
+	// a group of a pending entry and a consumer
+	dump = append(dump, 1, 1, 'g', 5, 0, 2, 1)
+	dump = append(dump, id...)
+	dump = append(dump, make([]byte, 8)...)
+	dump = append(dump, 1, 1, 1, 'c')
+	dump = append(dump, make([]byte, 16)...)
+	dump = append(dump, 1)
+	dump = append(dump, id...)
+	dump = append(dump, rdbTypeString, 1, 'a', 1, 'x')
+	dump = append(dump, rdbOpEOF, 0, 0, 0, 0, 0, 0, 0, 0)
+	d, err := newRDBReader(bytes.NewReader(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []rdbEntry{{typ: rdbTypeStream3, key: "s"}, {typ: rdbTypeString, key: "a", value: []byte("x")}} {
+		e, err := d.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.typ != x.typ || e.key != x.key || string(e.value) != string(x.value) {
+			t.Errorf("expect %+v, got %+v", x, e)
+		}
+	}
+	if _, err = d.next(); err != io.EOF {
+		t.Errorf("expect io.EOF, got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package shm
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// layoutOptions return the options creating a map of the same layout
+func (m *Map) layoutOptions() []Option {
+	opts := headerOptions(m.head)
+	if m.comp != nil {
+		opts = append(opts, Compress(m.comp.codec, m.comp.threshold))
+	}
+	if m.fifo != nil {
+		opts = append(opts, FreeFIFO(time.Duration(m.fifo.delay)))
+	}
+	if writers, readers, err := m.ACL(); err == nil {
+		opts = append(opts, ACL(writers, readers))
+	}
+	return opts
+}
+
+// headerOptions return the options creating a map of the layout of h,
+// compressing values of any length with its codec
+func headerOptions(h *header) []Option {
+	f := h.features
+	opts := []Option{}
+	if h.align != 0 {
+		opts = append(opts, BucketAlign(int(h.align)))
+	}
+	if f&featTimes != 0 {
+		opts = append(opts, Timestamps())
+	}
+	if f&featHits != 0 {
+		opts = append(opts, AccessCounters())
+	}
+	if f&featExpiry != 0 {
+		opts = append(opts, Expiration())
+	}
+	if f&featVersion != 0 {
+		opts = append(opts, Versions())
+	}
+	if f&featFlight != 0 {
+		opts = append(opts, InFlight())
+	}
+	if f&featSlotOps != 0 {
+		opts = append(opts, SlotCounters())
+	}
+	if f&featOrigin != 0 {
+		opts = append(opts, RecordOrigin())
+	}
+	if f&featTickets != 0 {
+		opts = append(opts, FairLocks())
+	}
+	if f&featFIFO != 0 {
+		opts = append(opts, FreeFIFO(0))
+	}
+	if f&featSpan != 0 {
+		opts = append(opts, SpanValues())
+	}
+	if f&featKeyLocks != 0 {
+		opts = append(opts, KeyLocks())
+	}
+	if f&featKeyWaits != 0 {
+		opts = append(opts, KeyWaits())
+	}
+	if f&featACL != 0 {
+		opts = append(opts, ACL(nil, nil))
+	}
+	if f&featAudit != 0 {
+		opts = append(opts, AuditLog(h.auditSize()))
+	}
+	if f&featTwoChoice != 0 {
+		opts = append(opts, TwoChoice())
+	}
+	if f&featCuckoo != 0 {
+		opts = append(opts, Cuckoo())
+	}
+	if f&featSingleWriter != 0 {
+		opts = append(opts, SingleWriter())
+	}
+	switch {
+	case f&featSnappy != 0:
+		opts = append(opts, Compress(Snappy, 0))
+	case f&featZstd != 0:
+		opts = append(opts, Compress(Zstd, 0))
+	}
+	return opts
+}
+
+// CompactTo write the entries with their flags, timestamps and access
+// counters to a new map at newPath of the same layout, buckets packed
+// and chains rebuilt, replacing any file there, for SwapIn
+// the operations on m wait meanwhile behind a Fence, raised within
+// wait, as the database lock of newPath
+// return ErrPinned if any entry is pinned, whose bucket would be
+// swapped out under its holder
+func (m *Map) CompactTo(newPath string, wait time.Duration, opts ...Option) (err error) {
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	lift, err := m.Fence(ctx)
+	cancel()
+	if err != nil {
+		return
+	}
+	defer lift()
+	if m.anyPinned() {
+		return ErrPinned
+	}
+	if err = os.Remove(newPath); err != nil && !os.IsNotExist(err) {
+		return
+	}
+	opts = append(m.layoutOptions(), opts...)
+	n, err := Create(newPath, int(m.head.cap), int(m.head.keySize)-1, int(m.head.valueSize), m.try, wait, opts...)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if e := n.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			_ = os.Remove(newPath)
+		}
+	}()
+	for i := int32(0); i < m.head.cap; i++ {
+		bkt := m.bucket(i)
+		if bkt.used == 0 || missing(bkt) || m.expired(bkt) {
+			continue
+		}
+		var v []byte
+		if v, err = m.valueOf(bkt); err != nil {
+			return
+		}
+		key := bkt.key(m)
+		if err = n.Set(key, v); err != nil {
+			return
+		}
+		var nb *bucket
+		if nb, err = n.lookup(key, false); err != nil {
+			return
+		}
+		atomic.StoreUint32(&nb.flags, atomic.LoadUint32(&bkt.flags)&FlagMask|atomic.LoadUint32(&nb.flags)&^FlagMask)
+		if m.meta.times != 0 {
+			ts, nts := bkt.times(m), nb.times(n)
+			atomic.StoreInt64(&nts[0], atomic.LoadInt64(&ts[0]))
+			atomic.StoreInt64(&nts[1], atomic.LoadInt64(&ts[1]))
+		}
+		if m.meta.hits != 0 {
+			atomic.StoreUint64(nb.hits(n), atomic.LoadUint64(bkt.hits(m)))
+		}
+		if m.meta.expiry != 0 {
+			atomic.StoreInt64(nb.expiry(n), atomic.LoadInt64(bkt.expiry(m)))
+		}
+		if m.meta.version != 0 {
+			atomic.StoreUint64(nb.version(n), atomic.LoadUint64(bkt.version(m)))
+		}
+	}
+	return n.Sync()
+}
@@ -0,0 +1,55 @@
+package shm
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestMap_CompactTo(t *testing.T) {
+	name, newName := "testcompact.db", "testcompact.new.db"
+	defer os.Remove(name)
+	defer os.Remove(newName)
+	m := newTestFile(t, name, 64, 16, 8, Timestamps())
+	for i := 0; i < 32; i++ {
+		if err := m.Set(strconv.Itoa(i), []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 32; i += 2 {
+		m.Delete(strconv.Itoa(i))
+	}
+	if err := m.SetFlags("1", 7); err != nil {
+		t.Fatal(err)
+	}
+	_, meta, err := m.GetWithMeta("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.CompactTo(newName, initWait); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = SwapIn(name, newName, initWait); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestFile(t, name, 64, 16, 8, Timestamps())
+	defer c.Close()
+	if c.Len() != 16 {
+		t.Errorf("expect 16 keys, got %d", c.Len())
+	}
+	for i := 1; i < 32; i += 2 {
+		v, err := c.Get(strconv.Itoa(i), false)
+		if err != nil || v[0] != byte(i) {
+			t.Errorf("key %d: %v, %v", i, v, err)
+		}
+	}
+	if f, err := c.GetFlags("1"); err != nil || f != 7 {
+		t.Errorf("expect flags 7, got %d, %v", f, err)
+	}
+	if _, cm, err := c.GetWithMeta("1"); err != nil || !cm.Created.Equal(meta.Created) {
+		t.Errorf("expect created %v, got %v, %v", meta.Created, cm.Created, err)
+	}
+}
@@ -0,0 +1,172 @@
+package shm
+
+import (
+	"errors"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Codec compress values, see Compress
+type Codec int
+
+const (
+	// Snappy is fast with a modest ratio
+	Snappy Codec = iota + 1
+	// Zstd is slower with a better ratio
+	Zstd
+)
+
+// flagCompressed marks a value stored compressed, a reserved flag bit
+const flagCompressed uint32 = 1 << 24
+
+// a value compressed is at most maxRatio times the value capacity,
+// longer ones are not stored, a damaged length is not allocated
+const maxRatio = 64
+
+var (
+	// ErrCompressed on decompress a damaged value
+	ErrCompressed = errors.New("compressed value damaged")
+	// ErrCodec on param validate
+	ErrCodec = errors.New("unknown compression codec")
+)
+
+// compressor of a map, shared by goroutines
+type compressor struct {
+	codec     Codec
+	threshold int
+	enc       *zstd.Encoder
+	dec       *zstd.Decoder
+}
+
+// the feature bit of a codec
+func (c Codec) feature() uint32 {
+	switch c {
+	case Snappy:
+		return featSnappy
+	case Zstd:
+		return featZstd
+	}
+	return 0
+}
+
+// compressor for the codec recorded in features, nil if none
+func newCompressor(features uint32, threshold int) (c *compressor, err error) {
+	switch {
+	case features&featSnappy != 0:
+		c = &compressor{codec: Snappy}
+	case features&featZstd != 0:
+		c = &compressor{codec: Zstd}
+		if c.enc, err = zstd.NewWriter(nil); err != nil {
+			return nil, err
+		}
+		if c.dec, err = zstd.NewReader(nil); err != nil {
+			return nil, err
+		}
+	default:
+		return
+	}
+	c.threshold = threshold
+	return
+}
+
+// compress src into a new slice
+func (c *compressor) compress(src []byte) []byte {
+	if c.codec == Snappy {
+		return snappy.Encode(nil, src)
+	}
+	return c.enc.EncodeAll(src, nil)
+}
+
+// decompress src of n bytes decompressed into a new slice
+func (c *compressor) decompress(src []byte, n int) (b []byte, err error) {
+	if c.codec == Snappy {
+		b, err = snappy.Decode(make([]byte, n), src)
+	} else {
+		b, err = c.dec.DecodeAll(src, make([]byte, 0, n))
+	}
+	if err != nil || len(b) != n {
+		return nil, ErrCompressed
+	}
+	return
+}
+
+// the bytes to store for value, compressed if it is longer than
+// the threshold and compressing shrinks it
+func (m *Map) encode(value []byte) (b []byte, compressed bool, err error) {
+	c := m.comp
+	n := len(value)
+	if c != nil && len(value) > c.threshold {
+		z := c.compress(value)
+		if len(z) < len(value) && len(z) <= m.vcap && len(value) <= m.vcap*maxRatio {
+			return z, true, nil
+		}
+		if len(z) < n {
+			n = len(z)
+		}
+	}
+	// spanning continuation buckets, not compressed
+	if len(value) > m.vcap && (m.meta.span == 0 || len(value)-m.vcap > m.spanCap()*int(m.head.cap)) {
+		return nil, false, &ValueLenError{Len: n, Cap: m.vcap}
+	}
+	return value, false, nil
+}
+
+// store the encoded value b of n bytes in a locked bucket, spanning
+// continuation buckets if longer than the value capacity
+func (m *Map) store(bkt *bucket, b []byte, compressed bool, n int) error {
+	first := int32(-1)
+	if len(b) > m.vcap {
+		var err error
+		if first, err = m.allocSpan(b[m.vcap:]); err != nil {
+			return err
+		}
+	}
+	v := bkt.value(m)
+	l := copy(v, b)
+	for i := l; i < len(v); i++ {
+		v[i] = 0
+	}
+	m.setSpan(bkt, first, len(b))
+	if m.comp == nil {
+		return nil
+	}
+	vl := bkt.vlen(m)
+	atomic.StoreUint32(&vl[0], uint32(len(b)))
+	atomic.StoreUint32(&vl[1], uint32(n))
+	for {
+		old := atomic.LoadUint32(&bkt.flags)
+		f := old &^ flagCompressed
+		if compressed {
+			f |= flagCompressed
+		}
+		if atomic.CompareAndSwapUint32(&bkt.flags, old, f) {
+			return nil
+		}
+	}
+}
+
+// the value of a bucket, a decompressed copy if stored compressed, an
+// assembled one if spanning continuation buckets
+func (m *Map) valueOf(bkt *bucket) ([]byte, error) {
+	if m.meta.span != 0 && atomic.LoadUint32(&bkt.span(m)[0]) != 0 {
+		return m.spanValue(bkt)
+	}
+	if m.comp == nil || atomic.LoadUint32(&bkt.flags)&flagCompressed == 0 {
+		return bkt.value(m), nil
+	}
+	vl := bkt.vlen(m)
+	// compared unsigned, a damaged length converted to int may be
+	// negative on 32-bit platforms
+	stored, n := atomic.LoadUint32(&vl[0]), atomic.LoadUint32(&vl[1])
+	if stored > uint32(m.vcap) || n > uint32(m.vcap*maxRatio) {
+		return nil, ErrCompressed
+	}
+	return m.comp.decompress(bkt.value(m)[:stored], int(n))
+}
+
+// stored and decompressed length of a bucket value
+func (b *bucket) vlen(m *Map) *[2]uint32 {
+	return (*[2]uint32)(unsafe.Pointer(uintptr(unsafe.Pointer(b)) + m.meta.vlen))
+}
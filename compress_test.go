@@ -0,0 +1,56 @@
+package shm
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestMap_Compress(t *testing.T) {
+	value := []byte(strings.Repeat("compressible ", 40))
+	for _, codec := range []Codec{Snappy, Zstd} {
+		m := newTestMap(t, 64, 16, 128, Compress(codec, 32))
+		if err := m.Set("big", value); err != nil {
+			t.Fatalf("codec %d: %v", codec, err)
+		}
+		if err := m.Set("small", []byte("raw")); err != nil {
+			t.Fatal(err)
+		}
+		b, err := m.Get("big", false)
+		if err != nil || string(b) != string(value) {
+			t.Errorf("codec %d: got %q, %v", codec, b, err)
+		}
+		b, err = m.Get("small", false)
+		if err != nil || string(b[:3]) != "raw" || len(b) != 128 {
+			t.Errorf("codec %d: got %q, %v", codec, b, err)
+		}
+		random := make([]byte, 200)
+		rand.Read(random)
+		if err = m.Set("random", random); !errors.Is(err, ErrValLen) {
+			t.Errorf("codec %d: expect ErrValLen, got %v", codec, err)
+		}
+		_ = m.Close()
+	}
+}
+
+func TestMap_CompressBound(t *testing.T) {
+	m := newTestMap(t, 64, 16, 32, Compress(Zstd, 16))
+	defer m.Close()
+	// compressible, but beyond the ratio
+	if err := m.Set("long", make([]byte, 32*maxRatio+1)); !errors.Is(err, ErrValLen) {
+		t.Errorf("expect ErrValLen, got %v", err)
+	}
+	if err := m.Set("k", make([]byte, 32*maxRatio)); err != nil {
+		t.Fatal(err)
+	}
+	bkt, err := m.lookup("k", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a damaged length is not allocated
+	bkt.vlen(m)[1] = 1 << 31
+	if _, err = m.Get("k", false); err != ErrCompressed {
+		t.Errorf("expect ErrCompressed, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package shm
+
+import (
+	"container/heap"
+	"sort"
+	"sync/atomic"
+)
+
+// keys sampled for each slot in a contention report
+const slotSampleKeys = 4
+
+// SlotStat report the load of a hash slot
+type SlotStat struct {
+	// Slot index in the hash area
+	Slot int
+	// Ops counted on the slot
+	Ops uint32
+	// Chain length of the slot
+	Chain int
+	// Keys sampled from the chain
+	Keys []string
+}
+
+// Contention return the n busiest hash slots, busiest first
+// a few hot slots with long chains mean the keys defeat the hash
+// empty without SlotCounters
+func (m *Map) Contention(n int) []SlotStat {
+	if m.ops == nil || n <= 0 {
+		return nil
+	}
+	if n > int(m.head.cap) {
+		n = int(m.head.cap)
+	}
+	// the counters read once each, the n busiest kept
+	h := make(slotHeap, 0, n)
+	for i := 0; i < int(m.head.cap); i++ {
+		ops := atomic.LoadUint32(&m.ops[i])
+		if len(h) < n {
+			heap.Push(&h, SlotStat{Slot: i, Ops: ops})
+		} else if ops > h[0].Ops {
+			h[0] = SlotStat{Slot: i, Ops: ops}
+			heap.Fix(&h, 0)
+		}
+	}
+	sort.Slice(h, func(i, j int) bool {
+		return h[i].Ops > h[j].Ops
+	})
+	for i := range h {
+		st := &h[i]
+		ptr := &(*m.hash)[st.Slot]
+		st.Chain = ptr.length()
+		for idx := ptr.index(); idx >= 0 && len(st.Keys) < slotSampleKeys; {
+			bkt := m.bucket(idx)
+			st.Keys = append(st.Keys, bkt.key(m))
+			idx = bkt.next
+		}
+	}
+	return h
+}
+
+// count an operation on the slot of hash h
+func (m *Map) countOp(h int32) {
+	if m.ops == nil {
+		return
+	}
+	atomic.AddUint32(&m.ops[int(uint(h)%uint(m.head.cap))], 1)
+}
+
+// min heap of slot stats by ops
+type slotHeap []SlotStat
+
+func (h slotHeap) Len() int            { return len(h) }
+func (h slotHeap) Less(i, j int) bool  { return h[i].Ops < h[j].Ops }
+func (h slotHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *slotHeap) Push(x interface{}) { *h = append(*h, x.(SlotStat)) }
+func (h *slotHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,20 @@
+package shm
+
+import "testing"
+
+func TestMap_Contention(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8, SlotCounters())
+	defer m.Close()
+	for i := 0; i < 10; i++ {
+		if _, err := m.Get("hot", true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Get("cold", true); err != nil {
+		t.Fatal(err)
+	}
+	st := m.Contention(1)
+	if len(st) != 1 || st[0].Ops != 10 || st[0].Chain < 1 {
+		t.Errorf("unexpected contention %+v", st)
+	}
+}
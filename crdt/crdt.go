@@ -0,0 +1,145 @@
+// Package crdt keeps conflict free counters in the values of a map,
+// for maps written on several hosts and replicated between them with
+// no primary: a counter is a PN-Counter, the increments and the
+// decrements of each replica counted apart, so merging the states of
+// two replicas, by taking the larger count of each, is commutative,
+// associative and idempotent, and the replicas converge whatever the
+// order of the merges; a counter only added to is a G-Counter
+package crdt
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/fengyoulin/shm"
+)
+
+// bytes of the counts of a replica in a value: the id of the replica,
+// 4 bytes of padding, the increments and the decrements
+const entrySize = 24
+
+var (
+	// ErrReplica on a replica id of 0
+	ErrReplica = errors.New("replica id 0")
+	// ErrValueSize on a map of values too small for a replica
+	ErrValueSize = errors.New("map values too small")
+	// ErrReplicas on a counter of more replicas than its value holds
+	ErrReplicas = errors.New("too many replicas of a counter")
+)
+
+// Counters of a map, as updated by one replica
+type Counters struct {
+	m  *shm.Map
+	id uint32
+}
+
+// New counters in the values of m, updated as replica id, not 0 and
+// unique among the replicas; a value of n bytes holds the counts of
+// n/24 replicas
+func New(m *shm.Map, id uint32) (*Counters, error) {
+	if id == 0 {
+		return nil, ErrReplica
+	}
+	if m.ValueCap() < entrySize {
+		return nil, ErrValueSize
+	}
+	return &Counters{m: m, id: id}, nil
+}
+
+// Add delta to the counter of key, added as 0 if not exist, return the
+// value after it
+func (c *Counters) Add(key string, delta int64) (v int64, err error) {
+	err = c.m.Update(key, true, func(b []byte) error {
+		e := entry(b, c.id)
+		if e == nil {
+			return ErrReplicas
+		}
+		binary.LittleEndian.PutUint32(e, c.id)
+		if delta >= 0 {
+			binary.LittleEndian.PutUint64(e[8:], binary.LittleEndian.Uint64(e[8:])+uint64(delta))
+		} else {
+			binary.LittleEndian.PutUint64(e[16:], binary.LittleEndian.Uint64(e[16:])+uint64(-delta))
+		}
+		v = Value(b)
+		return nil
+	})
+	return
+}
+
+// Get the value of the counter of key
+func (c *Counters) Get(key string) (int64, error) {
+	b, err := c.m.Load(key, nil)
+	if err != nil {
+		return 0, err
+	}
+	return Value(b), nil
+}
+
+// State of the counter of key, to send to the other replicas for Merge
+func (c *Counters) State(key string) ([]byte, error) {
+	b, err := c.m.Load(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return b[:len(b)/entrySize*entrySize], nil
+}
+
+// Merge the state of the counter of key of another replica, added if
+// not exist
+func (c *Counters) Merge(key string, state []byte) error {
+	return c.m.Update(key, true, func(b []byte) error {
+		return merge(b, state)
+	})
+}
+
+// Resolve the conflict of the counter of key in two maps, for
+// Map.Merge of the map of another replica, nil keeping dst if the
+// replicas are too many
+func Resolve(key string, dst, src []byte) []byte {
+	b := append([]byte(nil), dst...)
+	if merge(b, src) != nil {
+		return nil
+	}
+	return b
+}
+
+// Value of a counter in b
+func Value(b []byte) (v int64) {
+	for ; len(b) >= entrySize; b = b[entrySize:] {
+		if binary.LittleEndian.Uint32(b) == 0 {
+			break
+		}
+		v += int64(binary.LittleEndian.Uint64(b[8:]) - binary.LittleEndian.Uint64(b[16:]))
+	}
+	return
+}
+
+// the counts of replica id in b, or the first free ones, nil if full
+func entry(b []byte, id uint32) []byte {
+	for ; len(b) >= entrySize; b = b[entrySize:] {
+		if i := binary.LittleEndian.Uint32(b); i == id || i == 0 {
+			return b[:entrySize]
+		}
+	}
+	return nil
+}
+
+// merge state into b, the larger of each count
+func merge(b, state []byte) error {
+	for ; len(state) >= entrySize; state = state[entrySize:] {
+		id := binary.LittleEndian.Uint32(state)
+		if id == 0 {
+			break
+		}
+		e := entry(b, id)
+		if e == nil {
+			return ErrReplicas
+		}
+		binary.LittleEndian.PutUint32(e, id)
+		for _, off := range []int{8, 16} {
+			if n := binary.LittleEndian.Uint64(state[off:]); n > binary.LittleEndian.Uint64(e[off:]) {
+				binary.LittleEndian.PutUint64(e[off:], n)
+			}
+		}
+	}
+	return nil
+}
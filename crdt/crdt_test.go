@@ -0,0 +1,84 @@
+package crdt
+
+import (
+	"github.com/fengyoulin/shm"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCounters(t *testing.T) {
+	names := []string{"testcrdt1.db", "testcrdt2.db"}
+	var cs []*Counters
+	for i, name := range names {
+		defer os.Remove(name)
+		m, err := shm.Create(name, 64, 16, 3*entrySize, 10, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer m.Close()
+		c, err := New(m, uint32(i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs = append(cs, c)
+	}
+	a, b := cs[0], cs[1]
+	if _, err := a.Add("x", 5); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := a.Add("x", -2); err != nil || v != 3 {
+		t.Errorf("expect 3, got %d, %v", v, err)
+	}
+	if _, err := b.Add("x", 10); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := a.State("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb, err := b.State("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// merged in any order, and again, the replicas converge
+	for _, s := range [][]byte{sb, sa, sb} {
+		if err = a.Merge("x", s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = b.Merge("x", sa); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cs {
+		if v, err := c.Get("x"); err != nil || v != 13 {
+			t.Errorf("expect 13, got %d, %v", v, err)
+		}
+	}
+
+	// by Map.Merge
+	if _, err = b.Add("y", 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.Add("x", -1); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.m.Merge(b.m, Resolve); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := a.Get("x"); err != nil || v != 12 {
+		t.Errorf("expect 12, got %d, %v", v, err)
+	}
+	if v, err := a.Get("y"); err != nil || v != 4 {
+		t.Errorf("expect 4, got %d, %v", v, err)
+	}
+
+	// a value holds the counts of 3 replicas
+	full := make([]byte, 4*entrySize)
+	for i := 0; i < 4; i++ {
+		full[i*entrySize] = byte(10 + i)
+	}
+	if err = a.Merge("z", full); err != ErrReplicas {
+		t.Errorf("expect ErrReplicas, got %v", err)
+	}
+}
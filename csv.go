@@ -0,0 +1,100 @@
+package shm
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// columns of DumpCSV
+var csvHeader = []string{"key", "value", "length", "flags", "ttl"}
+
+// ErrCSV on load a malformed csv record
+var ErrCSV = errors.New("malformed csv record")
+
+// DumpCSV write the entries as csv records with a header row:
+// key, base64 value, value length, application flags, ttl seconds
+// values are written whole, as Get return them, trailing zero bytes
+// included
+// ttl is 0 for keys without one, rounded up to whole seconds
+func (m *Map) DumpCSV(w io.Writer) (err error) {
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	rec := make([]string, len(csvHeader))
+	for i := int32(0); i < m.head.cap; i++ {
+		bkt := m.bucket(i)
+		if bkt.used == 0 || missing(bkt) || m.expired(bkt) {
+			continue
+		}
+		v, err := m.valueOf(bkt)
+		if err != nil {
+			return err
+		}
+		rec[0] = bkt.key(m)
+		rec[1] = base64.StdEncoding.EncodeToString(v)
+		rec[2] = strconv.Itoa(len(v))
+		rec[3] = strconv.FormatUint(uint64(atomic.LoadUint32(&bkt.flags)&FlagMask), 10)
+		rec[4] = strconv.FormatInt(int64((m.ttlOf(bkt)+time.Second-1)/time.Second), 10)
+		if err = cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// LoadCSV set the entries of csv records as written by DumpCSV,
+// the header row is optional, the ttl column is ignored by a map
+// without Expiration
+func (m *Map) LoadCSV(r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(csvHeader)
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if line == 1 && rec[0] == csvHeader[0] && rec[1] == csvHeader[1] {
+			continue
+		}
+		v, err := base64.StdEncoding.DecodeString(rec[1])
+		if err != nil {
+			return fmt.Errorf("%w: line %d: %v", ErrCSV, line, err)
+		}
+		if n, err := strconv.Atoi(rec[2]); err != nil || n != len(v) {
+			return fmt.Errorf("%w: line %d: length %q, value of %d bytes", ErrCSV, line, rec[2], len(v))
+		}
+		flags, err := strconv.ParseUint(rec[3], 10, 32)
+		if err != nil {
+			return fmt.Errorf("%w: line %d: %v", ErrCSV, line, err)
+		}
+		ttl, err := strconv.ParseUint(rec[4], 10, 32)
+		if err != nil {
+			return fmt.Errorf("%w: line %d: %v", ErrCSV, line, err)
+		}
+		if err = m.Set(rec[0], v); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err = m.SetFlags(rec[0], uint32(flags)); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if ttl > 0 && m.meta.expiry != 0 {
+			if err = m.Touch(rec[0], time.Duration(ttl)*time.Second); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+	}
+}
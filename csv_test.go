@@ -0,0 +1,48 @@
+package shm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMap_CSV(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8)
+	defer m.Close()
+	var err error
+	if err = m.Set("a", []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Set("b", []byte{0, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.SetFlags("b", 5); err != nil {
+		t.Fatal(err)
+	}
+	// trailing zeros are value bytes
+	if err = m.Set("z", []byte{1, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	var buf strings.Builder
+	if err = m.DumpCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	n := newTestMap(t, 64, 16, 8)
+	defer n.Close()
+	if err = n.LoadCSV(strings.NewReader(buf.String())); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "z"} {
+		x, _ := m.Get(key, false)
+		y, err := n.Get(key, false)
+		if err != nil || string(x) != string(y) {
+			t.Errorf("key %s: expect %v, got %v, %v", key, x, y, err)
+		}
+	}
+	if f, _ := n.GetFlags("b"); f != 5 {
+		t.Errorf("expect flags 5, got %d", f)
+	}
+	if err = n.LoadCSV(strings.NewReader("c,AAE=,3,0,0\n")); !errors.Is(err, ErrCSV) {
+		t.Errorf("expect ErrCSV, got %v", err)
+	}
+}
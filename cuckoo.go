@@ -0,0 +1,106 @@
+package shm
+
+import (
+	"math/rand"
+	"sync/atomic"
+)
+
+// longest displacement path of a cuckoo add
+const maxKicks = 32
+
+// add the bucket at idx to one of the slots ss of its key,
+// moving residents along a path to their other slots
+// false if the slots changed and the caller should retry
+func (m *Map) cuckooAdd(ss []slot, target *bucket, idx int32) (bool, error) {
+	// both slots of the key are locked against a concurrent add of it
+	locks := append(make([]slot, 0, len(ss)+maxKicks), ss...)
+	// indices of locks from the slot of target to an empty one
+	path := make([]int, 0, maxKicks+1)
+	for i := range ss {
+		if ss[i].index < 0 {
+			path = append(path, i)
+			break
+		}
+	}
+	if len(path) == 0 {
+		start := rand.Intn(len(ss))
+		path = append(path, start)
+		var ok bool
+		if locks, ok = m.cuckooPath(locks, start); !ok {
+			if changed(ss) {
+				return false, nil
+			}
+			return false, ErrDbFull
+		}
+		for i := len(ss); i < len(locks); i++ {
+			path = append(path, i)
+		}
+	}
+	if !lockAll(locks) {
+		return false, nil
+	}
+	// move the tails backwards along the path, each one is added
+	// to its other slot before removed from the current one,
+	// so a reader always find it in one of its slots
+	for j := len(path) - 1; j > 0; j-- {
+		from, to := &locks[path[j-1]], &locks[path[j]]
+		last, v, vi := m.tail(from.ptr.index())
+		v.hash = to.h
+		v.next = to.ptr.index()
+		to.ptr.setIndex(vi)
+		to.ptr.addLength(1)
+		if last != nil {
+			last.next = -1
+			m.markBucket(last, from.ptr)
+		} else {
+			from.ptr.setIndex(-1)
+		}
+		from.ptr.addLength(-1)
+		m.markBucket(v, to.ptr)
+	}
+	dst := &locks[path[0]]
+	target.hash = dst.h
+	target.next = dst.ptr.index()
+	dst.ptr.setIndex(idx)
+	m.point("get.link")
+	target.used = 1
+	dst.ptr.addLength(1)
+	unlockAll(locks)
+	atomic.AddInt32(&m.head.len, 1)
+	m.markBucket(target, dst.ptr)
+	return true, nil
+}
+
+// walk from locks[start], each resident to its other slot,
+// until an empty slot, return locks with the path appended
+func (m *Map) cuckooPath(locks []slot, start int) ([]slot, bool) {
+	cur := locks[start]
+	for kick := 0; kick < maxKicks; kick++ {
+		_, victim, _ := m.tail(cur.index)
+		if victim == nil {
+			// emptied meanwhile
+			return nil, false
+		}
+		vs, n, err := m.slots(victim.key(m))
+		if err != nil || n < 2 {
+			return nil, false
+		}
+		alt := vs[0]
+		if alt.ptr == cur.ptr {
+			alt = vs[1]
+		}
+		for i := range locks {
+			if locks[i].ptr == alt.ptr {
+				// a cycle
+				return nil, false
+			}
+		}
+		alt.load()
+		locks = append(locks, alt)
+		if alt.index < 0 {
+			return locks, true
+		}
+		cur = alt
+	}
+	return nil, false
+}
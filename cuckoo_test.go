@@ -0,0 +1,38 @@
+package shm
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestMap_Cuckoo(t *testing.T) {
+	m := newTestMap(t, 1024, 16, 8, Cuckoo())
+	defer m.Close()
+	var n int
+	for ; n < 1024; n++ {
+		v, err := m.Get(strconv.Itoa(n), true)
+		if err == ErrDbFull {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		copy(v, strconv.Itoa(n))
+	}
+	if n < 900 {
+		t.Errorf("only %d keys added", n)
+	}
+	if err := m.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		v, err := m.Get(strconv.Itoa(i), false)
+		if err != nil || string(v[:len(strconv.Itoa(i))]) != strconv.Itoa(i) {
+			t.Fatalf("lost key %d after displacement, %v", i, err)
+		}
+	}
+	for i := int32(0); i < m.nslots; i++ {
+		if (*m.hash)[i].length() > 1 {
+			t.Fatalf("slot %d holds %d buckets", i, (*m.hash)[i].length())
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+// ErrAttached when other processes are attached to the database
+var ErrAttached = errors.New("database attached by other processes")
+
+// Attach take the shared lock of f held by every process attached to
+// the database, counted by Attached, until f is closed
+// wait until ctx is done while a process holds it Exclusive
+func Attach(ctx context.Context, f *os.File) error {
+	for {
+		ok, err := flock(f, false)
+		if ok || err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return &TimeoutError{Path: f.Name()}
+			}
+			return ctx.Err()
+		case <-time.After(lockPoll):
+		}
+	}
+}
+
+// Exclusive turn the shared lock of f taken by Attach exclusive,
+// return ErrAttached if other processes hold theirs, release turns it
+// shared again, for maintenance no other process may see meanwhile
+// processes attaching wait for release, the lock is not taken where
+// the platform has no flock
+func Exclusive(f *os.File) (release func() error, err error) {
+	ok, err := flock(f, true)
+	if err != nil {
+		return
+	}
+	// a failed conversion may drop the shared lock too
+	if !ok {
+		if err = relock(f); err == nil {
+			err = ErrAttached
+		}
+		return
+	}
+	return func() error {
+		return relock(f)
+	}, nil
+}
+
+// take the shared lock of f again
+func relock(f *os.File) error {
+	for {
+		ok, err := flock(f, false)
+		if ok || err != nil {
+			return err
+		}
+		time.Sleep(lockPoll)
+	}
+}
+
+// Attached return the count of the Attach locks of the database file
+// at path, 0 if it is not in use, -1 if it is but the count is not
+// known, on linux every attached map is counted
+func Attached(path string) (n int, err error) {
+	return attached(path)
+}
@@ -0,0 +1,8 @@
+// +build darwin dragonfly freebsd netbsd openbsd solaris
+
+package database
+
+// whether path is locked, the count is not known here
+func attached(path string) (int, error) {
+	return probe(path)
+}
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"bufio"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"strings"
+)
+
+// count the flock locks of path in /proc/locks
+func attached(path string) (n int, err error) {
+	var st unix.Stat_t
+	if err = unix.Stat(path, &st); err != nil {
+		return
+	}
+	f, err := os.Open("/proc/locks")
+	if err != nil {
+		return probe(path)
+	}
+	defer f.Close()
+	// major and minor in hex, inode in decimal
+	id := fmt.Sprintf("%02x:%02x:%d", unix.Major(uint64(st.Dev)), unix.Minor(uint64(st.Dev)), st.Ino)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// 1: FLOCK  ADVISORY  READ  1234 08:01:5678 0 EOF
+		// waiters are listed after "->", not holding it
+		fs := strings.Fields(s.Text())
+		if len(fs) < 6 || fs[1] != "FLOCK" {
+			continue
+		}
+		if fs[5] == id {
+			n++
+		}
+	}
+	return n, s.Err()
+}
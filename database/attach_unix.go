@@ -0,0 +1,41 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package database
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// lock f shared or exclusive without blocking, false if another
+// holds a conflicting lock
+func flock(f *os.File, exclusive bool) (ok bool, err error) {
+	how := unix.LOCK_SH
+	if exclusive {
+		how = unix.LOCK_EX
+	}
+	for {
+		err = unix.Flock(int(f.Fd()), how|unix.LOCK_NB)
+		if err != unix.EINTR {
+			break
+		}
+	}
+	if err == unix.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// probe the locks of path, -1 if any
+func probe(path string) (n int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	ok, err := flock(f, true)
+	if err != nil || ok {
+		return
+	}
+	return -1, nil
+}
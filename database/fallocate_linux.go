@@ -0,0 +1,16 @@
+package database
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// reserve blocks for the whole file, nothing on a file system not
+// supporting it, where new files are written with zeros instead
+func fallocate(f *os.File, size int) error {
+	err := unix.Fallocate(int(f.Fd()), 0, 0, int64(size))
+	if err == unix.EOPNOTSUPP {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package database
+
+import (
+	"os"
+)
+
+// reserve blocks for the whole file, new files are
+// already written with zeros so nothing to do here
+func fallocate(f *os.File, size int) error {
+	return nil
+}
@@ -1,9 +1,11 @@
 package database
 
 import (
+	"context"
 	"errors"
 	"github.com/fengyoulin/shm/mapping"
 	"os"
+	"syscall"
 	"time"
 )
 
@@ -11,28 +13,24 @@ import (
 var ErrTimeout = errors.New("timeout when waiting for database init")
 
 // Open a database file, return a mapping
-func Open(path string, size int, wait time.Duration) (m *mapping.Mapping, unlock func() error, err error) {
-	var lock *os.File
-	name := path + ".lock"
-	for i := 0; i < int(wait/time.Millisecond/10); i++ {
-		lock, err = os.OpenFile(name, os.O_CREATE|os.O_EXCL, 0664)
-		if err == nil {
-			break
-		}
-		if !os.IsExist(err) {
-			return
-		}
-		time.Sleep(10 * time.Millisecond)
+// wait for at most wait if another process holds the lock
+func Open(path string, size int, wait time.Duration, opts ...Option) (m *mapping.Mapping, unlock func() error, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+	return OpenContext(ctx, path, size, opts...)
+}
+
+// OpenContext is Open polling the lock until ctx is done, the mapping
+// holds the Attach lock of the file until closed
+// return a *TimeoutError if the deadline of ctx exceeded, a
+// *NoSpaceError if the file system has no room for the file
+func OpenContext(ctx context.Context, path string, size int, opts ...Option) (m *mapping.Mapping, unlock func() error, err error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
 	}
+	uf, err := Lock(ctx, path)
 	if err != nil {
-		err = ErrTimeout
-		return
-	}
-	uf := func() (er error) {
-		er = lock.Close()
-		if e := os.Remove(name); er == nil {
-			er = e
-		}
 		return
 	}
 	defer func() {
@@ -43,19 +41,64 @@ func Open(path string, size int, wait time.Duration) (m *mapping.Mapping, unlock
 			err = e
 		}
 	}()
+	if err = CheckSpace(path, size); err != nil {
+		return
+	}
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0664)
 	if err != nil {
 		return
 	}
 	defer func() {
-		if e := f.Close(); err == nil {
-			err = e
+		// the mapping owns f on success
+		if err == nil {
+			return
 		}
+		_ = f.Close()
 	}()
+	if err = fill(f, size, &o); err != nil {
+		return
+	}
+	if err = Attach(ctx, f); err != nil {
+		return
+	}
+	m, err = mapping.Create(f)
+	if err != nil {
+		return
+	}
+	if o.prefault {
+		m.Prefault()
+	}
+	unlock, uf = uf, nil
+	return
+}
+
+// write zeros to a new file up to size, reserve its blocks to
+// Reserve or Prefault, a *NoSpaceError if the file system is full
+func fill(f *os.File, size int, o *options) (err error) {
 	info, err := f.Stat()
 	if err != nil {
 		return
 	}
+	created := info.Size() == 0
+	defer func() {
+		if !errors.Is(err, syscall.ENOSPC) {
+			return
+		}
+		// a short file would be taken for a database on the next Open
+		if created {
+			_ = f.Truncate(0)
+		}
+		err = noSpace(f.Name(), int64(size))
+	}()
+	if o.reserve || o.prefault {
+		if err = fallocate(f, size); err != nil {
+			return
+		}
+		// a new file extended by the reservation needs no zeros
+		if info, err = f.Stat(); err != nil {
+			return
+		}
+	}
 	// created new file
 	if info.Size() == 0 {
 		var buf [4096]byte
@@ -72,10 +115,5 @@ func Open(path string, size int, wait time.Duration) (m *mapping.Mapping, unlock
 			}
 		}
 	}
-	m, err = mapping.Create(f)
-	if err != nil {
-		return
-	}
-	unlock, uf = uf, nil
 	return
 }
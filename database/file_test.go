@@ -0,0 +1,231 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/fengyoulin/shm/mapping"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+const testFileName = "testlock.db"
+
+func TestOpen_Timeout(t *testing.T) {
+	name := testFileName + ".lock"
+	if err := ioutil.WriteFile(name, []byte("12345\n"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	_, _, err := Open(testFileName, 4096, 30*time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expect timeout, got %v", err)
+	}
+	var te *TimeoutError
+	if !errors.As(err, &te) || te.PID != 12345 {
+		t.Errorf("expect holder pid 12345, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = OpenContext(ctx, testFileName, 4096)
+	if err != context.Canceled {
+		t.Errorf("expect canceled, got %v", err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.db")
+	unlock, err := Lock(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid := lockHolder(path + ".lock"); pid != os.Getpid() {
+		t.Errorf("expect holder pid %d, got %d", os.Getpid(), pid)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var te *TimeoutError
+	if _, err = Lock(ctx, path); !errors.As(err, &te) || te.PID != os.Getpid() {
+		t.Errorf("expect a timeout held by pid %d, got %v", os.Getpid(), err)
+	}
+	if err = unlock(); err != nil {
+		t.Fatal(err)
+	}
+	// the temporary files linked from removed
+	if names, _ := ioutil.ReadDir(dir); len(names) != 0 {
+		t.Errorf("expect no files left, got %d", len(names))
+	}
+}
+
+func TestCheckSpace(t *testing.T) {
+	if err := CheckSpace(testFileName, 4096); err != nil {
+		t.Fatal(err)
+	}
+	size := int(^uint(0) >> 1)
+	if runtime.GOOS != "linux" || uint64(size)>>32 == 0 {
+		t.Skip("free space not known")
+	}
+	err := CheckSpace(testFileName, size)
+	var ne *NoSpaceError
+	if !errors.Is(err, ErrNoSpace) || !errors.As(err, &ne) || ne.Available < 0 {
+		t.Fatalf("expect no space, got %v", err)
+	}
+	if _, _, err = Open(testFileName, size, time.Second); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("expect no space on open, got %v", err)
+	}
+	if _, err = os.Stat(testFileName); !os.IsNotExist(err) {
+		t.Errorf("expect no file created, got %v", err)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	name := "testreserve.db"
+	defer os.Remove(name)
+	m, unlock, err := Open(name, 3*4096+100, time.Second, Reserve())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Bytes()) != 3*4096+100 {
+		t.Errorf("expect %d bytes, got %d", 3*4096+100, len(m.Bytes()))
+	}
+	if err = m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	avail, err := available(".")
+	if runtime.GOOS != "linux" || err != nil || avail < 0 {
+		t.Skip("free space not known")
+	}
+	f, err := os.OpenFile("testnospace.db", os.O_RDWR|os.O_CREATE, 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	// zeros written instead would fill the file system
+	if err = fallocate(f, 4096); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := f.Stat(); err != nil || info.Size() != 4096 {
+		t.Skip("fallocate not supported")
+	}
+	if err = f.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	err = fill(f, int(avail)+1<<30, &options{reserve: true})
+	var ne *NoSpaceError
+	if !errors.Is(err, ErrNoSpace) || !errors.As(err, &ne) || ne.Path != f.Name() {
+		t.Fatalf("expect no space, got %v", err)
+	}
+	if info, err := f.Stat(); err != nil || info.Size() != 0 {
+		t.Errorf("expect file truncated, got %v", err)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	name := "testattach.db"
+	defer os.Remove(name)
+	m1, unlock, err := Open(name, 4096, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = unlock(); err != nil {
+		t.Fatal(err)
+	}
+	defer m1.Close()
+	m2, unlock, err := Open(name, 4096, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS == "linux" {
+		if n, err := Attached(name); err != nil || n != 2 {
+			t.Errorf("expect 2 attached, got %d, %v", n, err)
+		}
+	}
+	if runtime.GOOS != "windows" {
+		if _, err = Exclusive(m1.File()); err != ErrAttached {
+			t.Errorf("expect ErrAttached, got %v", err)
+		}
+	}
+	if err = m2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	release, err := Exclusive(m1.File())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" {
+		// an attach waits for release
+		_, _, err = Open(name, 4096, 30*time.Millisecond)
+		if !errors.Is(err, ErrTimeout) {
+			t.Errorf("expect timeout, got %v", err)
+		}
+	}
+	if err = release(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := Attached(name); err != nil || n == 0 {
+		t.Errorf("expect attached, got %d, %v", n, err)
+	}
+}
+
+func TestOpenSegments(t *testing.T) {
+	name := "testsegments.db"
+	ps := os.Getpagesize()
+	if _, _, err := OpenSegments(context.Background(), name, 3*ps, ps+1); err != mapping.ErrSegmentSize {
+		t.Errorf("expect ErrSegmentSize, got %v", err)
+	}
+	m, unlock, err := OpenSegments(context.Background(), name, 3*ps-1, ps)
+	if err == mapping.ErrSegments {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for i := 0; i < 4; i++ {
+			_ = os.Remove(mapping.SegmentName(name, i))
+		}
+	}()
+	if err = unlock(); err != nil {
+		t.Fatal(err)
+	}
+	b := m.Bytes()
+	if len(b) != 3*ps {
+		t.Fatalf("expect %d bytes, got %d", 3*ps, len(b))
+	}
+	// across the end of a segment
+	copy(b[ps-2:], "abcd")
+	if err = m.Resize(4 * ps); err != nil {
+		t.Fatal(err)
+	}
+	if b = m.Bytes(); len(b) != 4*ps || string(b[ps-2:ps+2]) != "abcd" {
+		t.Errorf("unexpected %d bytes, %q", len(b), b[ps-2:ps+2])
+	}
+	if err = m.Resize(2 * ps); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(mapping.SegmentName(name, 2)); !os.IsNotExist(err) {
+		t.Errorf("expect segment 2 removed, got %v", err)
+	}
+	if err = m.Close(); err != nil {
+		t.Error(err)
+	}
+	seg, err := ioutil.ReadFile(mapping.SegmentName(name, 1))
+	if err != nil || len(seg) != ps || string(seg[:2]) != "cd" {
+		t.Errorf("unexpected segment of %d bytes, %v", len(seg), err)
+	}
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeoutError when waiting for the lock held by another process
+type TimeoutError struct {
+	// Path of the lock file
+	Path string
+	// PID of the lock holder, 0 if unknown
+	PID int
+}
+
+// Error implements error
+func (e *TimeoutError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("%s: %s", ErrTimeout.Error(), e.Path)
+	}
+	return fmt.Sprintf("%s: %s held by pid %d", ErrTimeout.Error(), e.Path, e.PID)
+}
+
+// Is make errors.Is(err, ErrTimeout) true
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// Lock the database at path as Open does while initializing it,
+// wait until ctx is done if another process holds the lock
+func Lock(ctx context.Context, path string) (unlock func() error, err error) {
+	name := path + ".lock"
+	f, err := lock(ctx, name)
+	if err != nil {
+		return
+	}
+	unlock = func() (er error) {
+		er = f.Close()
+		if e := os.Remove(name); er == nil {
+			er = e
+		}
+		return
+	}
+	return
+}
+
+// lock poll interval
+const lockPoll = 10 * time.Millisecond
+
+// create the lock file with our pid in it as the lock header, written
+// to a temporary file linked into place, so a lock file is never seen
+// without its pid
+func lock(ctx context.Context, name string) (f *os.File, err error) {
+	dir, base := filepath.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	if f, err = ioutil.TempFile(dir, base+".tmp"); err != nil {
+		return
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err = f.WriteString(strconv.Itoa(os.Getpid()) + "\n"); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	for {
+		err = os.Link(tmp, name)
+		if err == nil {
+			return
+		}
+		if !os.IsExist(err) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				err = &TimeoutError{Path: name, PID: lockHolder(name)}
+			} else {
+				err = ctx.Err()
+			}
+			_ = f.Close()
+			return nil, err
+		case <-time.After(lockPoll):
+		}
+	}
+	_ = f.Close()
+	return nil, err
+}
+
+// pid recorded in the lock header, 0 if unknown
+func lockHolder(name string) int {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
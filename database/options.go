@@ -0,0 +1,27 @@
+package database
+
+// Option changes the behaviour of Open
+type Option func(*options)
+
+// options collected from Open
+type options struct {
+	prefault bool
+	reserve  bool
+}
+
+// Prefault reserve the blocks of the file and fault in
+// every page of the mapping before Open return
+func Prefault() Option {
+	return func(o *options) {
+		o.prefault = true
+	}
+}
+
+// Reserve allocate the blocks of the whole file at Open, so that a
+// full file system fails Open with a *NoSpaceError instead of a SIGBUS
+// on the first touch of a page deep in the file, Prefault reserves too
+func Reserve() Option {
+	return func(o *options) {
+		o.reserve = true
+	}
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"github.com/fengyoulin/shm/mapping"
+	"os"
+)
+
+// OpenSegments is OpenContext of a database in files of segSize bytes,
+// named by mapping.SegmentName, mapped contiguously, as many as hold
+// size bytes, locked by path
+func OpenSegments(ctx context.Context, path string, size, segSize int, opts ...Option) (m *mapping.Segments, unlock func() error, err error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if segSize <= 0 || segSize%os.Getpagesize() != 0 {
+		return nil, nil, mapping.ErrSegmentSize
+	}
+	uf, err := Lock(ctx, path)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if uf == nil {
+			return
+		}
+		if e := uf(); err == nil {
+			err = e
+		}
+	}()
+	n := (size + segSize - 1) / segSize
+	var need int64
+	for i := 0; i < n; i++ {
+		need += missing(mapping.SegmentName(path, i), int64(segSize))
+	}
+	if err = checkSpace(path, int64(n*segSize), need); err != nil {
+		return
+	}
+	files := make([]*os.File, 0, n)
+	defer func() {
+		// the mapping owns the files on success
+		if err == nil {
+			return
+		}
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	for i := 0; i < n; i++ {
+		var f *os.File
+		if f, err = os.OpenFile(mapping.SegmentName(path, i), os.O_RDWR|os.O_CREATE, 0664); err != nil {
+			return
+		}
+		files = append(files, f)
+		if err = fill(f, segSize, &o); err != nil {
+			return
+		}
+	}
+	// the first segment holds the Attach lock
+	if err = Attach(ctx, files[0]); err != nil {
+		return
+	}
+	if m, err = mapping.CreateSegments(path, segSize, files); err != nil {
+		return
+	}
+	if o.prefault {
+		m.Prefault()
+	}
+	unlock, uf = uf, nil
+	return
+}
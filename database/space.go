@@ -0,0 +1,73 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrNoSpace when the file system has no room for the database
+var ErrNoSpace = errors.New("no space left for the database")
+
+// NoSpaceError when the file system of Path has Available bytes left
+// of the Size of the database
+type NoSpaceError struct {
+	// Path of the database
+	Path string
+	// Size of the database
+	Size int64
+	// Available bytes on its file system, -1 if not known
+	Available int64
+}
+
+// Error implements error
+func (e *NoSpaceError) Error() string {
+	return fmt.Sprintf("%s: %s needs %d bytes, %d available", ErrNoSpace.Error(), e.Path, e.Size, e.Available)
+}
+
+// Is make errors.Is(err, ErrNoSpace) true
+func (e *NoSpaceError) Is(target error) bool {
+	return target == ErrNoSpace
+}
+
+// CheckSpace return a *NoSpaceError if the file system of path has
+// less room than a database of size bytes needs beyond the file at
+// path, nil if it has or it is not known, so that a full tmpfs fails
+// Open instead of faulting on the first touch of a page
+func CheckSpace(path string, size int) error {
+	return checkSpace(path, int64(size), missing(path, int64(size)))
+}
+
+// bytes of a file of size at path not yet written
+func missing(path string, size int64) int64 {
+	if info, err := os.Stat(path); err == nil {
+		size -= info.Size()
+	}
+	if size < 0 {
+		return 0
+	}
+	return size
+}
+
+// a *NoSpaceError if the file system of path has less than need bytes
+// left for a database of size
+func checkSpace(path string, size, need int64) error {
+	if need <= 0 {
+		return nil
+	}
+	avail, err := available(filepath.Dir(path))
+	if err != nil || avail < 0 || avail >= need {
+		return nil
+	}
+	return &NoSpaceError{Path: path, Size: size, Available: avail}
+}
+
+// a *NoSpaceError of a write to path failing with ENOSPC
+func noSpace(path string, size int64) error {
+	avail, err := available(filepath.Dir(path))
+	if err != nil {
+		avail = -1
+	}
+	return &NoSpaceError{Path: path, Size: size, Available: avail}
+}
@@ -0,0 +1,14 @@
+package database
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// bytes available to the user on the file system of dir
+func available(dir string) (int64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return -1, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
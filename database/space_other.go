@@ -0,0 +1,8 @@
+// +build !linux
+
+package database
+
+// bytes available on the file system of dir, -1 as not known here
+func available(dir string) (int64, error) {
+	return -1, nil
+}
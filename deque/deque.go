@@ -0,0 +1,216 @@
+// Package deque provides a bounded double ended queue of fixed size
+// elements in memory shared by processes, usable as a stack or a queue
+package deque
+
+import (
+	"errors"
+	"github.com/fengyoulin/shm/sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// HeaderSize is the bytes before the elements
+const HeaderSize = 16
+
+var (
+	// ErrSize on a memory too small for an element
+	ErrSize = errors.New("memory too small")
+	// ErrElemSize on an element size out of range
+	ErrElemSize = errors.New("element size out of range")
+	// ErrFull on push to a full deque
+	ErrFull = errors.New("deque full")
+	// ErrAlign on a memory not aligned to 8 bytes
+	ErrAlign = errors.New("memory not aligned")
+)
+
+// header in the memory, the index of the front element in the low 32
+// bits of state and the length in the high ones, changed together by
+// one store under the mutex once an element pushed is written, so a
+// dead holder leaves the deque as before or after its change
+type header struct {
+	mu    uint32
+	_     uint32
+	state uint64
+}
+
+// Deque of elements, the head index is the front element, the tail one
+// is past the back element
+type Deque struct {
+	head  *header
+	mu    *sync.Mutex
+	elems []byte
+	cap   uint32
+	size  int
+}
+
+// At return the deque in b, of elements of size bytes
+// zeroed memory is an empty deque
+// all processes must pass memory of the same length
+func At(b []byte, size int) (*Deque, error) {
+	if size <= 0 {
+		return nil, ErrElemSize
+	}
+	if len(b) < HeaderSize+size {
+		return nil, ErrSize
+	}
+	if uintptr(unsafe.Pointer(&b[0]))&7 != 0 {
+		return nil, ErrAlign
+	}
+	mu, err := sync.MutexAt(b)
+	if err != nil {
+		return nil, err
+	}
+	elems := b[HeaderSize:]
+	return &Deque{
+		head:  (*header)(unsafe.Pointer(&b[0])),
+		mu:    mu,
+		elems: elems,
+		cap:   uint32(len(elems) / size),
+		size:  size,
+	}, nil
+}
+
+// lock the deque, a dead holder left it whole
+func (d *Deque) lock() error {
+	if err := d.mu.Lock(); err != nil && err != sync.ErrOwnerDead {
+		return err
+	}
+	return nil
+}
+
+// unlock the deque, keep the first error
+func (d *Deque) unlock(err *error) {
+	if e := d.mu.Unlock(); *err == nil {
+		*err = e
+	}
+}
+
+// element at index i
+func (d *Deque) elem(i uint32) []byte {
+	off := int(i) * d.size
+	return d.elems[off : off+d.size]
+}
+
+// put elem at index i, padded with 0
+func (d *Deque) put(i uint32, elem []byte) {
+	e := d.elem(i)
+	for j := copy(e, elem); j < len(e); j++ {
+		e[j] = 0
+	}
+}
+
+// push elem at the front or the back
+func (d *Deque) push(elem []byte, front bool) (err error) {
+	if len(elem) > d.size {
+		return ErrElemSize
+	}
+	if err = d.lock(); err != nil {
+		return
+	}
+	defer d.unlock(&err)
+	head, n := d.state()
+	if n == d.cap {
+		return ErrFull
+	}
+	if front {
+		head = (head + d.cap - 1) % d.cap
+		d.put(head, elem)
+	} else {
+		d.put((head+n)%d.cap, elem)
+	}
+	d.setState(head, n+1)
+	return
+}
+
+// pop an element from the front or the back once fn took it, left
+// in the deque if fn fails
+func (d *Deque) pop(front bool, fn func(elem []byte) error) (ok bool, err error) {
+	if err = d.lock(); err != nil {
+		return
+	}
+	defer d.unlock(&err)
+	head, n := d.state()
+	if n == 0 {
+		return
+	}
+	i := head
+	if !front {
+		i = (head + n - 1) % d.cap
+	}
+	if err = fn(d.elem(i)); err != nil {
+		return
+	}
+	if front {
+		head = (head + 1) % d.cap
+	}
+	d.setState(head, n-1)
+	return true, nil
+}
+
+// index of the front element and the length, a state out of range,
+// from memory of another length, is an empty deque
+func (d *Deque) state() (head, n uint32) {
+	w := atomic.LoadUint64(&d.head.state)
+	head, n = uint32(w), uint32(w>>32)
+	if head >= d.cap || n > d.cap {
+		return 0, 0
+	}
+	return
+}
+
+func (d *Deque) setState(head, n uint32) {
+	atomic.StoreUint64(&d.head.state, uint64(n)<<32|uint64(head))
+}
+
+// pop appending to dst
+func (d *Deque) popTo(dst []byte, front bool) ([]byte, bool, error) {
+	ok, err := d.pop(front, func(elem []byte) error {
+		dst = append(dst, elem...)
+		return nil
+	})
+	return dst, ok, err
+}
+
+// PushFront elem, shorter than the element size is padded with 0
+func (d *Deque) PushFront(elem []byte) error {
+	return d.push(elem, true)
+}
+
+// PushBack elem, shorter than the element size is padded with 0
+func (d *Deque) PushBack(elem []byte) error {
+	return d.push(elem, false)
+}
+
+// PopFront the front element appending to dst, false if empty
+func (d *Deque) PopFront(dst []byte) ([]byte, bool, error) {
+	return d.popTo(dst, true)
+}
+
+// PopBack the back element appending to dst, false if empty
+func (d *Deque) PopBack(dst []byte) ([]byte, bool, error) {
+	return d.popTo(dst, false)
+}
+
+// PopFrontFunc call fn with the front element under the lock, then pop
+// it unless fn fails, false if empty, so that the element is kept
+// somewhere by fn before it leaves the deque
+// elem is only valid during fn, which must not use the deque
+func (d *Deque) PopFrontFunc(fn func(elem []byte) error) (bool, error) {
+	return d.pop(true, fn)
+}
+
+// PopBackFunc call fn with the back element as PopFrontFunc
+func (d *Deque) PopBackFunc(fn func(elem []byte) error) (bool, error) {
+	return d.pop(false, fn)
+}
+
+// Len return the number of elements, without locking
+func (d *Deque) Len() int {
+	_, n := d.state()
+	return int(n)
+}
+
+// Cap return the most elements
+func (d *Deque) Cap() int {
+	return int(d.cap)
+}
@@ -0,0 +1,122 @@
+package deque
+
+import (
+	"errors"
+	stdsync "sync"
+	"testing"
+)
+
+func TestDeque(t *testing.T) {
+	d, err := At(make([]byte, HeaderSize+3*4), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.PushBack([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.PushFront([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.PushBack([]byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.PushBack([]byte("d")); err != ErrFull {
+		t.Errorf("expect ErrFull, got %v", err)
+	}
+	if d.Len() != 3 {
+		t.Errorf("expect len 3, got %d", d.Len())
+	}
+	var got string
+	for _, front := range []bool{false, true, true} {
+		b, ok, err := d.popTo(nil, front)
+		if err != nil || !ok {
+			t.Fatalf("pop: %v, %v", ok, err)
+		}
+		got += string(b[:1])
+	}
+	if got != "cab" {
+		t.Errorf("expect cab, got %s", got)
+	}
+	if _, ok, err := d.PopFront(nil); ok || err != nil {
+		t.Errorf("pop empty: %v, %v", ok, err)
+	}
+}
+
+func TestDeque_PopFunc(t *testing.T) {
+	d, err := At(make([]byte, HeaderSize+2*8), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.PushBack([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	failed := errors.New("failed")
+	if ok, err := d.PopFrontFunc(func(elem []byte) error {
+		return failed
+	}); ok || err != failed || d.Len() != 1 {
+		t.Errorf("expect the element kept on failure, got %v, %v, len %d", ok, err, d.Len())
+	}
+	var got []byte
+	if ok, err := d.PopBackFunc(func(elem []byte) error {
+		got = append(got, elem[0])
+		return nil
+	}); !ok || err != nil || string(got) != "a" || d.Len() != 0 {
+		t.Errorf("expect a popped, got %q, %v, %v, len %d", got, ok, err, d.Len())
+	}
+}
+
+func TestDeque_Concurrent(t *testing.T) {
+	d, err := At(make([]byte, HeaderSize+64*8), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg stdsync.WaitGroup
+	var mu stdsync.Mutex
+	seen := make(map[string]bool)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				elem := []byte{byte(w), byte(i), byte(i >> 8)}
+				for d.PushBack(elem) == ErrFull {
+				}
+				b, ok, err := d.PopFront(nil)
+				if err != nil || !ok {
+					t.Errorf("pop: %v, %v", ok, err)
+					return
+				}
+				mu.Lock()
+				seen[string(b)] = true
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+	if len(seen) != 4000 || d.Len() != 0 {
+		t.Errorf("expect 4000 elements, got %d, left %d", len(seen), d.Len())
+	}
+}
+
+func TestDeque_OwnerDead(t *testing.T) {
+	d, err := At(make([]byte, HeaderSize+2*8), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.PushBack([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	// a holder dies writing a pushed element, before its commit
+	d.put(1, []byte("b"))
+	d.head.mu = 1<<31 - 1
+	if d.Len() != 1 {
+		t.Errorf("expect len 1, got %d", d.Len())
+	}
+	b, ok, err := d.PopBack(nil)
+	if err != nil || !ok || b[0] != 'a' {
+		t.Errorf("expect a, got %q, %v, %v", b, ok, err)
+	}
+	if _, err = At(make([]byte, HeaderSize+9)[1:], 8); err != ErrAlign {
+		t.Errorf("expect ErrAlign, got %v", err)
+	}
+}
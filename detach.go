@@ -0,0 +1,60 @@
+package shm
+
+import (
+	"errors"
+	"os"
+	"runtime/debug"
+	"unsafe"
+)
+
+// ErrDetached on access a map whose backing file was truncated or replaced
+var ErrDetached = errors.New("map detached from its backing file")
+
+// a Mapping backed by a file
+type fileStater interface {
+	Stat() (os.FileInfo, error)
+}
+
+// Detached return ErrDetached if the backing file was truncated
+// below the map, or the path of the map now names another file
+func (m *Map) Detached() error {
+	st, ok := m.mp.(fileStater)
+	if !ok {
+		return nil
+	}
+	info, err := st.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < int64(len(m.mp.Bytes())) {
+		return ErrDetached
+	}
+	if m.path != "" {
+		if pi, err := os.Stat(m.path); err != nil || !os.SameFile(info, pi) {
+			return ErrDetached
+		}
+	}
+	return nil
+}
+
+// turn a memory fault of the goroutine into ErrDetached in *err,
+// deferred by the operations of a protected map
+func (m *Map) protected(err *error) func() {
+	old := debug.SetPanicOnFault(true)
+	return func() {
+		debug.SetPanicOnFault(old)
+		r := recover()
+		if r == nil {
+			return
+		}
+		// a fault reports its address, only those in the mapping are ours
+		if fe, ok := r.(interface{ Addr() uintptr }); ok {
+			base := uintptr(unsafe.Pointer(m.head))
+			if a := fe.Addr(); a >= base && a-base < uintptr(len(m.mp.Bytes())) {
+				*err = ErrDetached
+				return
+			}
+		}
+		panic(r)
+	}
+}
@@ -0,0 +1,35 @@
+package shm
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMap_Protect(t *testing.T) {
+	name := "testprotect.db"
+	defer os.Remove(name)
+	m := newTestFile(t, name, 1024, 16, 8, Protect())
+	defer m.Close()
+	var err error
+	if err = m.Set("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Detached(); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Truncate(name, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Detached(); err != ErrDetached {
+		t.Errorf("expect ErrDetached, got %v", err)
+	}
+	if _, err = m.Get("a", false); err != ErrDetached {
+		t.Errorf("expect ErrDetached, got %v", err)
+	}
+	if m.Delete("a") {
+		t.Error("delete on a detached map")
+	}
+	if next, err := m.Scan(0, 10, func(key string, value []byte) bool { return true }); err != ErrDetached || next != 0 {
+		t.Errorf("expect ErrDetached at cursor 0, got %d, %v", next, err)
+	}
+}
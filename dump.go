@@ -0,0 +1,305 @@
+package shm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"hash/crc32"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// the dump of DumpZstd:
+// magic, the blocks, the index, the trailer
+// a block is its header, the compressed length, the crc32c of the
+// compressed bytes, the records and the raw length, each 4 bytes,
+// then one zstd frame of its records
+// a record is the key, the value, the application flags and the ttl
+// seconds, lengths and numbers uvarint
+// the index is the offset, the compressed length and the records of
+// each block, 8, 4 and 4 bytes
+// the trailer is the offset of the index, the blocks and the crc32c
+// of the index, 8, 4 and 4 bytes, then the index magic
+const (
+	dumpMagic    = "SHMDUMP1"
+	indexMagic   = "SHMDIDX1"
+	blockHeader  = 16
+	indexEntry   = 16
+	dumpTrailer  = 24
+	defaultBlock = 1 << 20
+	// records of a block read at most, twice the largest blockSize
+	maxBlock = 1 << 26
+)
+
+// ErrDump on read a damaged dump of DumpZstd
+var ErrDump = errors.New("damaged dump")
+
+// DumpBlock of a dump in its index
+type DumpBlock struct {
+	// Offset of the block header in the dump
+	Offset int64
+	// Len of the compressed records
+	Len int
+	// Records in the block
+	Records int
+}
+
+// DumpZstd write the entries as DumpCSV does, in zstd compressed
+// blocks of about blockSize bytes of records, 1 MiB if 0, 32 MiB at
+// most, each with a
+// checksum, followed by an index of the blocks, so that a dump can be
+// verified and loaded by block, see VerifyDump and LoadZstd
+func (m *Map) DumpZstd(w io.Writer, blockSize int) (err error) {
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	if blockSize <= 0 {
+		blockSize = defaultBlock
+	}
+	if blockSize > maxBlock/2 {
+		blockSize = maxBlock / 2
+	}
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return
+	}
+	defer enc.Close()
+	if _, err = io.WriteString(w, dumpMagic); err != nil {
+		return
+	}
+	off := int64(len(dumpMagic))
+	var index []byte
+	var raw, buf []byte
+	records := 0
+	flush := func() error {
+		if records == 0 {
+			return nil
+		}
+		z := enc.EncodeAll(raw, buf[:0])
+		var h [blockHeader]byte
+		binary.LittleEndian.PutUint32(h[:], uint32(len(z)))
+		binary.LittleEndian.PutUint32(h[4:], crc32.Checksum(z, castagnoli))
+		binary.LittleEndian.PutUint32(h[8:], uint32(records))
+		binary.LittleEndian.PutUint32(h[12:], uint32(len(raw)))
+		if _, err := w.Write(h[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(z); err != nil {
+			return err
+		}
+		var e [indexEntry]byte
+		binary.LittleEndian.PutUint64(e[:], uint64(off))
+		binary.LittleEndian.PutUint32(e[8:], uint32(len(z)))
+		binary.LittleEndian.PutUint32(e[12:], uint32(records))
+		index = append(index, e[:]...)
+		off += int64(blockHeader + len(z))
+		raw, buf, records = raw[:0], z, 0
+		return nil
+	}
+	var n [binary.MaxVarintLen64]byte
+	putUvarint := func(x uint64) {
+		raw = append(raw, n[:binary.PutUvarint(n[:], x)]...)
+	}
+	for i := int32(0); i < m.head.cap; i++ {
+		bkt := m.bucket(i)
+		if bkt.used == 0 || missing(bkt) || m.expired(bkt) {
+			continue
+		}
+		var v []byte
+		if v, err = m.valueOf(bkt); err != nil {
+			return
+		}
+		key := bkt.key(m)
+		putUvarint(uint64(len(key)))
+		raw = append(raw, key...)
+		putUvarint(uint64(len(v)))
+		raw = append(raw, v...)
+		putUvarint(uint64(atomic.LoadUint32(&bkt.flags) & FlagMask))
+		putUvarint(uint64((m.ttlOf(bkt) + time.Second - 1) / time.Second))
+		if records++; len(raw) >= blockSize {
+			if err = flush(); err != nil {
+				return
+			}
+		}
+	}
+	if err = flush(); err != nil {
+		return
+	}
+	if _, err = w.Write(index); err != nil {
+		return
+	}
+	var t [dumpTrailer]byte
+	binary.LittleEndian.PutUint64(t[:], uint64(off))
+	binary.LittleEndian.PutUint32(t[8:], uint32(len(index)/indexEntry))
+	binary.LittleEndian.PutUint32(t[12:], crc32.Checksum(index, castagnoli))
+	copy(t[16:], indexMagic)
+	_, err = w.Write(t[:])
+	return
+}
+
+// ReadDumpIndex return the blocks of the dump of size bytes in r
+func ReadDumpIndex(r io.ReaderAt, size int64) ([]DumpBlock, error) {
+	if size < int64(len(dumpMagic)+dumpTrailer) {
+		return nil, ErrDump
+	}
+	var magic [len(dumpMagic)]byte
+	if _, err := r.ReadAt(magic[:], 0); err != nil {
+		return nil, err
+	}
+	var t [dumpTrailer]byte
+	if _, err := r.ReadAt(t[:], size-dumpTrailer); err != nil {
+		return nil, err
+	}
+	if string(magic[:]) != dumpMagic || string(t[16:]) != indexMagic {
+		return nil, ErrDump
+	}
+	off := int64(binary.LittleEndian.Uint64(t[:]))
+	n := int64(binary.LittleEndian.Uint32(t[8:]))
+	if off < int64(len(dumpMagic)) || off+n*indexEntry != size-dumpTrailer {
+		return nil, ErrDump
+	}
+	index := make([]byte, n*indexEntry)
+	if _, err := r.ReadAt(index, off); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(index, castagnoli) != binary.LittleEndian.Uint32(t[12:]) {
+		return nil, fmt.Errorf("%w: index checksum", ErrDump)
+	}
+	blocks := make([]DumpBlock, n)
+	for i := range blocks {
+		e := index[i*indexEntry:]
+		blocks[i] = DumpBlock{
+			Offset:  int64(binary.LittleEndian.Uint64(e)),
+			Len:     int(binary.LittleEndian.Uint32(e[8:])),
+			Records: int(binary.LittleEndian.Uint32(e[12:])),
+		}
+		if blocks[i].Offset+blockHeader+int64(blocks[i].Len) > off {
+			return nil, fmt.Errorf("%w: block %d beyond the index", ErrDump, i)
+		}
+	}
+	return blocks, nil
+}
+
+// the records of block b of r, checked against the header, the index
+// and the checksum
+func readBlock(r io.ReaderAt, b DumpBlock, dec *zstd.Decoder) ([]byte, error) {
+	buf := make([]byte, blockHeader+b.Len)
+	if _, err := r.ReadAt(buf, b.Offset); err != nil {
+		return nil, err
+	}
+	z := buf[blockHeader:]
+	if int(binary.LittleEndian.Uint32(buf)) != b.Len || int(binary.LittleEndian.Uint32(buf[8:])) != b.Records {
+		return nil, errors.New("header not as indexed")
+	}
+	if crc32.Checksum(z, castagnoli) != binary.LittleEndian.Uint32(buf[4:]) {
+		return nil, errors.New("checksum")
+	}
+	n := binary.LittleEndian.Uint32(buf[12:])
+	if n > maxBlock {
+		return nil, errors.New("length")
+	}
+	raw, err := dec.DecodeAll(z, make([]byte, 0, n))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != int(n) {
+		return nil, errors.New("length")
+	}
+	return raw, nil
+}
+
+// call fn with the records of the listed blocks of the dump of size
+// bytes in r, all if none listed
+func foreachDump(r io.ReaderAt, size int64, blocks []int, fn func(key string, value []byte, flags uint32, ttl time.Duration) error) error {
+	index, err := ReadDumpIndex(r, size)
+	if err != nil {
+		return err
+	}
+	if len(blocks) == 0 {
+		blocks = make([]int, len(index))
+		for i := range blocks {
+			blocks[i] = i
+		}
+	}
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxBlock))
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+	for _, i := range blocks {
+		if i < 0 || i >= len(index) {
+			return fmt.Errorf("%w: no block %d", ErrDump, i)
+		}
+		raw, err := readBlock(r, index[i], dec)
+		if err != nil {
+			return fmt.Errorf("%w: block %d: %v", ErrDump, i, err)
+		}
+		for k := 0; k < index[i].Records; k++ {
+			var key, value []byte
+			var flags, ttl uint64
+			if key, raw, err = dumpBytes(raw); err == nil {
+				if value, raw, err = dumpBytes(raw); err == nil {
+					if flags, raw, err = dumpUvarint(raw); err == nil {
+						ttl, raw, err = dumpUvarint(raw)
+					}
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("%w: block %d record %d", ErrDump, i, k)
+			}
+			if err = fn(string(key), value, uint32(flags), time.Duration(ttl)*time.Second); err != nil {
+				return err
+			}
+		}
+		if len(raw) != 0 {
+			return fmt.Errorf("%w: block %d: bytes after the records", ErrDump, i)
+		}
+	}
+	return nil
+}
+
+// a uvarint of a record
+func dumpUvarint(b []byte) (uint64, []byte, error) {
+	x, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, nil, ErrDump
+	}
+	return x, b[n:], nil
+}
+
+// bytes of a record after their length
+func dumpBytes(b []byte) ([]byte, []byte, error) {
+	n, b, err := dumpUvarint(b)
+	if err != nil || n > uint64(len(b)) {
+		return nil, nil, ErrDump
+	}
+	return b[:n], b[n:], nil
+}
+
+// VerifyDump check the index, and the checksums and records of the
+// listed blocks of the dump of size bytes in r, all if none listed
+func VerifyDump(r io.ReaderAt, size int64, blocks ...int) error {
+	return foreachDump(r, size, blocks, func(string, []byte, uint32, time.Duration) error {
+		return nil
+	})
+}
+
+// LoadZstd set the entries of the listed blocks of the dump of size
+// bytes in r, all if none listed, as LoadCSV does; a damaged block
+// return an error wrapping ErrDump, after the blocks before it are set
+func (m *Map) LoadZstd(r io.ReaderAt, size int64, blocks ...int) error {
+	return foreachDump(r, size, blocks, func(key string, value []byte, flags uint32, ttl time.Duration) error {
+		if err := m.Set(key, value); err != nil {
+			return err
+		}
+		if err := m.SetFlags(key, flags); err != nil {
+			return err
+		}
+		if ttl > 0 && m.meta.expiry != 0 {
+			return m.Touch(key, ttl)
+		}
+		return nil
+	})
+}
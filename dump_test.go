@@ -0,0 +1,82 @@
+package shm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMap_DumpZstd(t *testing.T) {
+	name := "testdumpzstd.db"
+	defer os.Remove(name)
+	m := newTestFile(t, name, 1024, 16, 32)
+	defer m.Close()
+	for i := 0; i < 500; i++ {
+		if err := m.Set(strconv.Itoa(i), []byte(strings.Repeat("v", i%32))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.SetFlags("7", 3); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := m.DumpZstd(&buf, 1024); err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(buf.Bytes())
+	size := int64(buf.Len())
+	index, err := ReadDumpIndex(r, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := 0
+	for _, b := range index {
+		records += b.Records
+	}
+	if len(index) < 2 || records != 500 {
+		t.Errorf("expect 500 records in blocks, got %d in %d", records, len(index))
+	}
+	if err = VerifyDump(r, size); err != nil {
+		t.Error(err)
+	}
+	name2 := "testdumpzstd2.db"
+	defer os.Remove(name2)
+	n, err := Create(name2, 1024, 16, 32, testMaxTry, initWait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	if err = n.LoadZstd(r, size); err != nil {
+		t.Fatal(err)
+	}
+	if n.Len() != 500 {
+		t.Errorf("expect 500 loaded, got %d", n.Len())
+	}
+	if f, err := n.GetFlags("7"); err != nil || f != 3 {
+		t.Errorf("expect flags 3, got %d, %v", f, err)
+	}
+	want, _ := m.Get("7", false)
+	if v, err := n.Load("7", nil); err != nil || !bytes.Equal(v, want) {
+		t.Errorf("expect %q, got %q, %v", want, v, err)
+	}
+	// a damaged block fails alone
+	b := append([]byte(nil), buf.Bytes()...)
+	b[index[1].Offset+blockHeader] ^= 0xff
+	r = bytes.NewReader(b)
+	if err = VerifyDump(r, size, 1); !errors.Is(err, ErrDump) {
+		t.Errorf("expect ErrDump, got %v", err)
+	}
+	if err = VerifyDump(r, size, 0); err != nil {
+		t.Errorf("expect block 0 intact, %v", err)
+	}
+	// a raw length beyond any block is not allocated
+	b = append(b[:0], buf.Bytes()...)
+	binary.LittleEndian.PutUint32(b[index[0].Offset+12:], 1<<31)
+	if err = VerifyDump(bytes.NewReader(b), size, 0); !errors.Is(err, ErrDump) {
+		t.Errorf("expect ErrDump, got %v", err)
+	}
+}
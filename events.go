@@ -0,0 +1,131 @@
+package shm
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/fengyoulin/shm/log"
+	"sync"
+	"sync/atomic"
+)
+
+// EventKind of an event of a map, or a mask of kinds for Events
+type EventKind uint8
+
+// kinds of events
+const (
+	// EventSet of a Set
+	EventSet EventKind = 1 << iota
+	// EventDelete of a Delete
+	EventDelete
+	// EventExpire of an entry deleted expired, see OnExpire
+	EventExpire
+	// EventEvict of an entry evicted, see OnEvict
+	EventEvict
+)
+
+// ErrEvent on read a malformed event
+var ErrEvent = errors.New("malformed event")
+
+// Event of a map, an entry set or removed
+type Event struct {
+	Kind EventKind
+	Key  string
+	// Value set or removed, in the memory of the log, valid while it
+	// is open
+	Value []byte
+}
+
+// the log of Events, a record is the kind, the key as a uvarint length
+// and the bytes, and the value
+type eventLog struct {
+	l       *log.Log
+	mu      sync.Mutex
+	buf     []byte
+	dropped uint64
+}
+
+// append an event, dropped on error
+func (e *eventLog) append(kind EventKind, key string, value []byte) {
+	var n [binary.MaxVarintLen64]byte
+	e.mu.Lock()
+	b := append(e.buf[:0], byte(kind))
+	b = append(b, n[:binary.PutUvarint(n[:], uint64(len(key)))]...)
+	b = append(append(b, key...), value...)
+	_, err := e.l.Append(b)
+	e.buf = b
+	e.mu.Unlock()
+	if err != nil {
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+// feed the events of kinds to l, after the callbacks of the process
+func (m *Map) feedEvents(l *log.Log, kinds EventKind) {
+	e := &eventLog{l: l}
+	m.events = e
+	feed := func(kind EventKind, fn func(key string, value []byte)) func(key string, value []byte) {
+		if kinds&kind == 0 {
+			return fn
+		}
+		return func(key string, value []byte) {
+			if fn != nil {
+				fn(key, value)
+			}
+			e.append(kind, key, value)
+		}
+	}
+	m.onSet = feed(EventSet, nil)
+	m.onDelete = feed(EventDelete, nil)
+	m.onExpire = feed(EventExpire, m.onExpire)
+	m.onEvict = feed(EventEvict, m.onEvict)
+}
+
+// DroppedEvents return the events of this process the log of Events
+// failed to take, as full
+func (m *Map) DroppedEvents() uint64 {
+	if m.events == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&m.events.dropped)
+}
+
+// ReadEvent decode an event of a record of the log of Events
+func ReadEvent(b []byte) (e Event, err error) {
+	if len(b) == 0 {
+		return e, ErrEvent
+	}
+	n, k := binary.Uvarint(b[1:])
+	if k <= 0 || n > uint64(len(b)-1-k) {
+		return e, ErrEvent
+	}
+	e.Kind = EventKind(b[0])
+	b = b[1+k:]
+	e.Key, e.Value = string(b[:n]), b[n:]
+	return
+}
+
+// Subscriber of the events of a log of Events
+type Subscriber struct {
+	r *log.Reader
+}
+
+// Subscribe to the events of l from offset off, 0 for the first one,
+// l.Committed() for those from now on
+func Subscribe(l *log.Log, off uint64) *Subscriber {
+	return &Subscriber{r: l.Tail(off)}
+}
+
+// Next event, waiting for one until ctx is done
+func (s *Subscriber) Next(ctx context.Context) (Event, error) {
+	b, err := s.r.Next(ctx)
+	if err != nil {
+		return Event{}, err
+	}
+	return ReadEvent(b)
+}
+
+// Offset of the next event, to subscribe from again
+func (s *Subscriber) Offset() uint64 {
+	return s.r.Offset()
+}
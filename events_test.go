@@ -0,0 +1,63 @@
+package shm
+
+import (
+	"context"
+	"github.com/fengyoulin/shm/log"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestMap_Events(t *testing.T) {
+	name := "testevents.log"
+	defer os.Remove(name)
+	l, err := log.Open(name, 1<<16, initWait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var expired []string
+	m, err := Create("", 64, 16, 8, testMaxTry, initWait, InMemory(), MaxChain(1), EvictChainTail(), Expiration(),
+		OnExpire(func(key string, value []byte) {
+			expired = append(expired, key)
+		}), Events(l, EventDelete|EventExpire|EventEvict))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	for i := 0; i < 64; i++ {
+		k := strconv.Itoa(i)
+		if err = m.Set(k, []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	evicted := 64 - m.Len()
+	keys := m.Sample(2)
+	if !m.Delete(keys[0]) {
+		t.Fatal("expect deleted")
+	}
+	if err = m.Touch(keys[1], -1); err != nil {
+		t.Fatal(err)
+	}
+	if m.ExpireDue() != 1 || len(expired) != 1 {
+		t.Fatalf("expect %s expired, got %v", keys[1], expired)
+	}
+	s := Subscribe(l, 0)
+	counts := map[EventKind]int{}
+	for s.Offset() != l.Committed() {
+		e, err := s.Next(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[e.Kind]++
+		if e.Kind != EventEvict && (e.Key != keys[0] && e.Key != keys[1] || string(e.Value[:len(e.Key)]) != e.Key) {
+			t.Errorf("unexpected event %v", e)
+		}
+	}
+	if counts[EventEvict] != evicted || counts[EventDelete] != 1 || counts[EventExpire] != 1 || counts[EventSet] != 0 {
+		t.Errorf("expect %d evicted, 1 deleted, 1 expired, got %v", evicted, counts)
+	}
+	if m.DroppedEvents() != 0 {
+		t.Errorf("expect none dropped, got %d", m.DroppedEvents())
+	}
+}
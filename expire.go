@@ -0,0 +1,62 @@
+package shm
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ExpireBefore delete the entries last updated before t, in one scan
+// of the buckets, return the number deleted
+// an entry updated during the scan is kept, a map without Timestamps
+// deletes nothing
+func (m *Map) ExpireBefore(t time.Time) (removed int) {
+	if m.meta.times == 0 {
+		return 0
+	}
+	before := t.UnixNano()
+	return m.deleteAll(func(bkt *bucket) bool {
+		return atomic.LoadInt64(&bkt.times(m)[1]) < before
+	}, AuditExpire, m.onExpire)
+}
+
+// ExpireDue delete the entries past their expiry, in one scan of the
+// buckets, return the number deleted
+// a map without Expiration deletes nothing
+func (m *Map) ExpireDue() (removed int) {
+	if m.meta.expiry == 0 {
+		return 0
+	}
+	return m.deleteAll(m.expired, AuditExpire, m.onExpire)
+}
+
+// delete the entries cond is true of, scanning the buckets then
+// deleting each if cond is still true under its chain lock, notify
+// fn of each
+func (m *Map) deleteAll(cond func(bkt *bucket) bool, op AuditOp, fn func(key string, value []byte)) (removed int) {
+	if m.protect {
+		var err error
+		defer m.protected(&err)()
+	}
+	// pinned entries do not expire
+	pinnedCond := cond
+	cond = func(bkt *bucket) bool {
+		return !pinned(bkt) && pinnedCond(bkt)
+	}
+	var keys []string
+	for i := int32(0); i < m.head.cap; i++ {
+		if j := i + prefetchAhead; j < m.head.cap {
+			m.touch(j)
+		}
+		bkt := m.bucket(i)
+		if bkt.used != 0 && cond(bkt) {
+			// a copy, the bucket may be reused before the delete
+			keys = append(keys, string([]byte(bkt.key(m))))
+		}
+	}
+	for _, key := range keys {
+		if m.deleteNotify(key, cond, op, fn) {
+			removed++
+		}
+	}
+	return
+}
@@ -0,0 +1,63 @@
+package shm
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMap_ExpireBefore(t *testing.T) {
+	for _, opts := range [][]Option{{InMemory(), Timestamps()}, {InMemory(), Timestamps(), SingleProcess()}} {
+		m, err := Create("", 64, 16, 8, testMaxTry, initWait, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []string{"a", "b", "c"} {
+			if err = m.Set(k, []byte(k)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		epoch := time.Now()
+		time.Sleep(time.Millisecond)
+		if err = m.Set("b", []byte("B")); err != nil {
+			t.Fatal(err)
+		}
+		if n := m.ExpireBefore(epoch); n != 2 {
+			t.Errorf("expect 2 expired, got %d", n)
+		}
+		if m.Exists("a") || !m.Exists("b") || m.Exists("c") || m.Len() != 1 {
+			t.Errorf("expect only b left, len %d", m.Len())
+		}
+		_ = m.Close()
+	}
+}
+
+func TestMap_OnExpire(t *testing.T) {
+	var expired []string
+	m, err := Create("", 64, 16, 8, testMaxTry, initWait, InMemory(), Expiration(), OnExpire(func(key string, value []byte) {
+		expired = append(expired, key+"="+string(value[:1]))
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	for _, k := range []string{"a", "b", "c"} {
+		if err = m.Set(k, []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+		if err = m.Touch(k, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.Exists("a") {
+		t.Error("expect a expired")
+	}
+	if n := m.ExpireDue(); n != 2 {
+		t.Errorf("expect 2 expired, got %d", n)
+	}
+	sort.Strings(expired)
+	if strings.Join(expired, ",") != "a=a,b=b,c=c" {
+		t.Errorf("unexpected expired %v", expired)
+	}
+}
@@ -0,0 +1,159 @@
+package shm
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/fengyoulin/shm/internal/proc"
+)
+
+// fenceBit in the features of the header while a Fence is raised, a
+// state of the map, not a feature of the layout
+const fenceBit uint32 = 1 << 31
+
+// the lock word of a chain held by a Fence of process pid, negative
+// apart from the pids of the operations holding one
+func fenceWord(pid int32) int32 {
+	return -pid
+}
+
+const (
+	// fence poll interval of the operations waiting
+	fencePoll = time.Millisecond
+	// maxFence bound the wait of an operation for a Fence to lift,
+	// LockTimeout instead if set
+	maxFence = 10 * time.Second
+)
+
+// ErrFenced on an operation waiting too long for a Fence to lift
+var ErrFenced = errors.New("map fenced for maintenance")
+
+// Fence quiesce the operations on the map in all processes for a
+// maintenance working on the map directly, until lift
+// it takes the lock of the first chain, raises a flag in the header,
+// then takes the lock of every other chain as the acknowledgment of
+// its writers, waiting for the operations holding one, and for the
+// writes of SingleWriter begun; operations starting meanwhile wait
+// for lift, failing with ErrFenced after LockTimeout, or 10s without
+// wait until ctx is done for another Fence or a chain lock; the lock
+// of the first chain records the fencing process, the Janitor or
+// Repair lift the fence of a crashed one with the locks it left
+// the operations of m wait too, writes through the slices of Get are
+// not fenced
+func (m *Map) Fence(ctx context.Context) (lift func(), err error) {
+	self := fenceWord(selfPID)
+	first := &(*m.hash)[0]
+	for !atomic.CompareAndSwapInt32(&first[2], 0, self) {
+		if err = fenceWait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	for !m.raiseFence() {
+		if err = fenceWait(ctx); err != nil {
+			atomic.CompareAndSwapInt32(&first[2], self, 0)
+			return nil, err
+		}
+	}
+	for i := range m.shards {
+		m.shards[i].Lock()
+	}
+	for i := int32(1); i < m.nslots; i++ {
+		ptr := &(*m.hash)[i]
+		for !atomic.CompareAndSwapInt32(&(*ptr)[2], 0, self) {
+			if err = ctx.Err(); err != nil {
+				m.liftFence(i)
+				return
+			}
+			runtime.Gosched()
+		}
+	}
+	// the single writer takes no chain locks, a write begun before
+	// it saw the flag ends with an even serial
+	for i := int32(0); m.writer && i < m.nslots; i++ {
+		ptr := &(*m.hash)[i]
+		for atomic.LoadInt32(&(*ptr)[1])&1 != 0 {
+			if err = ctx.Err(); err != nil {
+				m.liftFence(m.nslots)
+				return
+			}
+			runtime.Gosched()
+		}
+	}
+	return func() { m.liftFence(m.nslots) }, nil
+}
+
+// wait a poll for a Fence or the first chain, the error of ctx if done
+func fenceWait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(fencePoll):
+	}
+	return nil
+}
+
+// set the fence flag, false if raised by another
+func (m *Map) raiseFence() bool {
+	f := atomic.LoadUint32(&m.head.features)
+	return f&fenceBit == 0 && atomic.CompareAndSwapUint32(&m.head.features, f, f|fenceBit)
+}
+
+// release the first n chains held by the fence, and clear its flag
+func (m *Map) liftFence(n int32) {
+	for i := range m.shards {
+		m.shards[i].Unlock()
+	}
+	m.releaseFence(fenceWord(selfPID), n)
+}
+
+// lift the Fence of a crashed process, report whether there was one
+func (m *Map) liftDeadFence() bool {
+	w := atomic.LoadInt32(&(*m.hash)[0][2])
+	if w >= 0 || proc.Alive(int(-w)) {
+		return false
+	}
+	m.releaseFence(w, m.nslots)
+	return true
+}
+
+// release the first n chains held with word, clear the fence flag,
+// then the first chain, the record of the fencer
+func (m *Map) releaseFence(word, n int32) {
+	for i := n - 1; i >= 0; i-- {
+		ptr := &(*m.hash)[i]
+		if i == 0 {
+			for {
+				f := atomic.LoadUint32(&m.head.features)
+				if atomic.CompareAndSwapUint32(&m.head.features, f, f&^fenceBit) {
+					break
+				}
+			}
+		}
+		// the maintenance may have changed the chain
+		m.bumpSerial(ptr)
+		atomic.CompareAndSwapInt32(&(*ptr)[2], word, 0)
+	}
+}
+
+// wait for a Fence on the map to lift, ErrFenced after LockTimeout,
+// or maxFence without
+func (m *Map) waitFence() error {
+	if atomic.LoadUint32(&m.head.features)&fenceBit == 0 {
+		return nil
+	}
+	wait := maxFence
+	if m.lockTimeout > 0 {
+		wait = m.lockTimeout
+	}
+	deadline := time.Now().Add(wait)
+	for atomic.LoadUint32(&m.head.features)&fenceBit != 0 {
+		if !time.Now().Before(deadline) {
+			return ErrFenced
+		}
+		time.Sleep(fencePoll)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package shm
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMap_Fence(t *testing.T) {
+	name := "testfence.db"
+	defer os.Remove(name)
+	m := newTestFile(t, name, 64, 16, 8)
+	defer m.Close()
+	o := newTestFile(t, name, 64, 16, 8, LockTimeout(30*time.Millisecond))
+	defer o.Close()
+	if err := o.Set("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	lift, err := m.Fence(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = o.Set("a", []byte("2")); err != ErrFenced {
+		t.Errorf("expect ErrFenced, got %v", err)
+	}
+	if _, err = o.Load("a", nil); err != ErrFenced {
+		t.Errorf("expect ErrFenced on load, got %v", err)
+	}
+	if err = m.Verify(); err != nil {
+		t.Errorf("verify under the fence: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	if _, err = o.Fence(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expect deadline exceeded, got %v", err)
+	}
+	cancel()
+	done := make(chan error)
+	go func() {
+		done <- m.Set("a", []byte("3"))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	lift()
+	if err = <-done; err != nil {
+		t.Fatalf("expect the set after lift, got %v", err)
+	}
+	if v, err := o.Load("a", nil); err != nil || v[0] != '3' {
+		t.Errorf("unexpected %q, %v", v, err)
+	}
+	if err = m.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMap_FenceDead(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8, LockTimeout(10*time.Millisecond))
+	defer m.Close()
+	// a fencer crashed half way through the chains
+	dead := fenceWord(deadPID)
+	for i := int32(0); i < m.nslots/2; i++ {
+		atomic.StoreInt32(&(*m.hash)[i][2], dead)
+	}
+	if !m.raiseFence() {
+		t.Fatal("expect the fence raised")
+	}
+	if err := m.Set("a", []byte("1")); err != ErrFenced {
+		t.Errorf("expect ErrFenced, got %v", err)
+	}
+	if err := m.Verify(); err == nil {
+		t.Error("expect the dead fence reported")
+	}
+	j, err := m.Janitor(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !j.Run() {
+		t.Fatal("expect the lease taken")
+	}
+	if s := j.Stats(); s.Fences != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	if err = m.Set("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Verify(); err != nil {
+		t.Error(err)
+	}
+	// a live fence kept by Repair, a dead one lifted
+	lift, err := m.Fence(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Repair(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadUint32(&m.head.features)&fenceBit == 0 {
+		t.Error("expect the live fence kept")
+	}
+	lift()
+	atomic.StoreInt32(&(*m.hash)[0][2], dead)
+	if !m.raiseFence() {
+		t.Fatal("expect the fence raised")
+	}
+	if err = m.Repair(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadUint32(&m.head.features)&fenceBit != 0 || atomic.LoadInt32(&(*m.hash)[0][2]) != 0 {
+		t.Error("expect the dead fence lifted")
+	}
+}
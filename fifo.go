@@ -0,0 +1,110 @@
+package shm
+
+import (
+	"github.com/fengyoulin/shm/internal/proc"
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// fifo record of the free list of FreeFIFO, after the header and the
+// origin record, the list runs from the deleted link of the header,
+// freed first, to tail
+type fifo struct {
+	// pid of the process holding the list, 0 if none
+	owner uint32
+	// bucket freed last, -1 if none
+	tail int32
+	// the times of the list in milliseconds since base, in unix nano
+	base int64
+	// quarantine of a freed bucket in nanoseconds
+	delay int64
+	_     int64
+}
+
+// the fifo record of a map of header h
+func fifoOf(h *header) *fifo {
+	off := unsafe.Sizeof(header{})
+	if h.features&featOrigin != 0 {
+		off += unsafe.Sizeof(origin{})
+	}
+	return (*fifo)(unsafe.Pointer(uintptr(unsafe.Pointer(h)) + off))
+}
+
+// init the record of a new map
+func (f *fifo) init(quarantine time.Duration) {
+	f.tail = -1
+	f.base = time.Now().UnixNano()
+	f.delay = int64(quarantine)
+}
+
+// take the list, from a holder crashed too
+func (f *fifo) lock(pid uint32) {
+	for spins := 1; !atomic.CompareAndSwapUint32(&f.owner, 0, pid); spins++ {
+		if spins%1024 == 0 {
+			if o := atomic.LoadUint32(&f.owner); o != 0 && !proc.Alive(int(o)) {
+				atomic.CompareAndSwapUint32(&f.owner, o, 0)
+			}
+		}
+		runtime.Gosched()
+	}
+}
+
+// release the list
+func (f *fifo) unlock() {
+	atomic.StoreUint32(&f.owner, 0)
+}
+
+// milliseconds since base
+func (f *fifo) now() uint32 {
+	return uint32((time.Now().UnixNano() - f.base) / int64(time.Millisecond))
+}
+
+// the time a bucket freed now may be taken again
+func (f *fifo) ready() uint32 {
+	return f.now() + uint32(f.delay/int64(time.Millisecond))
+}
+
+// the bucket freed first, -1 if none, or if in quarantine unless young
+func (m *Map) allocFIFO(young bool) int32 {
+	f := m.fifo
+	f.lock(m.pid)
+	defer f.unlock()
+	i := m.head.deleteLink
+	if i < 0 {
+		return -1
+	}
+	bkt := m.bucket(i)
+	// a free bucket keeps the time it is ready in its hash
+	if !young && int32(f.now()-uint32(bkt.hash)) < 0 {
+		return -1
+	}
+	atomic.StoreInt32(&m.head.deleteLink, bkt.next)
+	if bkt.next < 0 {
+		f.tail = -1
+	}
+	bkt.next = -1
+	return i
+}
+
+// append the buckets linked from first to last to the list
+func (m *Map) freeFIFO(first, last int32) {
+	f := m.fifo
+	ready := int32(f.ready())
+	for i := first; ; i = m.bucket(i).next {
+		m.bucket(i).hash = ready
+		if i == last {
+			break
+		}
+	}
+	m.bucket(last).next = -1
+	f.lock(m.pid)
+	defer f.unlock()
+	if f.tail < 0 {
+		atomic.StoreInt32(&m.head.deleteLink, first)
+	} else {
+		atomic.StoreInt32(&m.bucket(f.tail).next, first)
+	}
+	f.tail = last
+}
@@ -0,0 +1,69 @@
+package shm
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMap_FreeFIFO(t *testing.T) {
+	name := "testfreefifo.db"
+	defer os.Remove(name)
+	m := newTestFile(t, name, 8, 16, 8, FreeFIFO(time.Hour))
+	defer m.Close()
+	for i := 0; i < 4; i++ {
+		if err := m.Set(strconv.Itoa(i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Delete("0")
+	m.Delete("1")
+	// in quarantine, new buckets first
+	if err := m.Set("a", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if bkt, _ := m.lookup("a", false); bkt != m.bucket(4) {
+		t.Error("expect a new bucket in the quarantine")
+	}
+	for i := 4; i < 7; i++ {
+		if err := m.Set(strconv.Itoa(i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// none left else, the one freed first
+	if err := m.Set("b", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if bkt, _ := m.lookup("b", false); bkt != m.bucket(0) {
+		t.Error("expect the bucket freed first")
+	}
+	if err := m.Verify(); err != nil {
+		t.Error(err)
+	}
+	if err := m.Repair(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Verify(); err != nil {
+		t.Error(err)
+	}
+	if p := m.Params(); p.Features[len(p.Features)-1] != "FreeFIFO" {
+		t.Errorf("expect FreeFIFO in %v", p.Features)
+	}
+	n := newTestFile(t, "testfreefifo0.db", 8, 16, 8, FreeFIFO(0))
+	defer os.Remove("testfreefifo0.db")
+	defer n.Close()
+	for _, k := range []string{"a", "b", "c"} {
+		if err := n.Set(k, []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n.Delete("b")
+	n.Delete("a")
+	if err := n.Set("d", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if bkt, _ := n.lookup("d", false); bkt != n.bucket(1) {
+		t.Error("expect the bucket freed first, out of quarantine")
+	}
+}
@@ -0,0 +1,77 @@
+package shm
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// FlagMask is the flag bits available to applications,
+// the higher bits are reserved for the library
+const FlagMask uint32 = 1<<24 - 1
+
+// ErrFlags on set flags outside FlagMask
+var ErrFlags = errors.New("flags outside of FlagMask")
+
+// GetFlags return the application flags of key
+func (m *Map) GetFlags(key string) (f uint32, err error) {
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	bkt, err := m.lookup(key, false)
+	if err != nil {
+		return 0, err
+	}
+	return atomic.LoadUint32(&bkt.flags) & FlagMask, nil
+}
+
+// SetFlags replace the application flags of key atomically, with its
+// chain locked against a concurrent delete and reuse of the bucket
+func (m *Map) SetFlags(key string, flags uint32) (err error) {
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	if flags&^FlagMask != 0 {
+		return ErrFlags
+	}
+	return m.locked(key, false, func(bkt *bucket) error {
+		// the library bits may change meanwhile
+		for {
+			old := atomic.LoadUint32(&bkt.flags)
+			if atomic.CompareAndSwapUint32(&bkt.flags, old, old&^FlagMask|flags) {
+				return nil
+			}
+		}
+	})
+}
+
+// CompareAndSwapFlags set the application flags of key to new if they
+// are old, atomically, report whether swapped
+// add the key first if add, the flags of a key added are 0, the swap
+// is done with the chain of the key locked
+func (m *Map) CompareAndSwapFlags(key string, old, new uint32, add bool) (swapped bool, err error) {
+	if m.readOnly {
+		return false, ErrReadOnly
+	}
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	if (old|new)&^FlagMask != 0 {
+		return false, ErrFlags
+	}
+	err = m.locked(key, add, func(bkt *bucket) error {
+		for {
+			f := atomic.LoadUint32(&bkt.flags)
+			if f&FlagMask != old {
+				return nil
+			}
+			// the library bits may change meanwhile
+			if swapped = atomic.CompareAndSwapUint32(&bkt.flags, f, f&^FlagMask|new); swapped {
+				return nil
+			}
+		}
+	})
+	return
+}
@@ -0,0 +1,36 @@
+package shm
+
+import "testing"
+
+func TestMap_Flags(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8)
+	defer m.Close()
+	var err error
+	if _, err = m.Get("flags", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.SetFlags("flags", 1<<24); err != ErrFlags {
+		t.Errorf("expect ErrFlags, got %v", err)
+	}
+	if err = m.SetFlags("flags", 5); err != nil {
+		t.Error(err)
+	}
+	if f, err := m.GetFlags("flags"); err != nil || f != 5 {
+		t.Errorf("unexpected flags %d, %v", f, err)
+	}
+	if _, err = m.GetFlags("none"); err != ErrKeyNot {
+		t.Errorf("expect ErrKeyNot, got %v", err)
+	}
+	if ok, err := m.CompareAndSwapFlags("flags", 4, 6, false); err != nil || ok {
+		t.Errorf("expect not swapped, got %v, %v", ok, err)
+	}
+	if ok, err := m.CompareAndSwapFlags("flags", 5, 6, false); err != nil || !ok {
+		t.Errorf("expect swapped, got %v, %v", ok, err)
+	}
+	if ok, err := m.CompareAndSwapFlags("new", 0, 1, true); err != nil || !ok {
+		t.Errorf("expect added and swapped, got %v, %v", ok, err)
+	}
+	if _, err = m.CompareAndSwapFlags("none", 0, 1, false); err != ErrKeyNot {
+		t.Errorf("expect ErrKeyNot, got %v", err)
+	}
+}
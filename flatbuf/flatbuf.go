@@ -0,0 +1,89 @@
+// Package flatbuf stores flatbuffers in the values of a map, read in
+// place over the bucket memory and built straight into it, so that
+// structured values need no copy nor unmarshaling
+package flatbuf
+
+import (
+	"errors"
+	"github.com/fengyoulin/shm"
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+var (
+	// ErrInvalid on a value not holding a flatbuffer
+	ErrInvalid = errors.New("invalid flatbuffer")
+	// ErrTooLarge on a flatbuffer larger than the value capacity
+	ErrTooLarge = errors.New("flatbuffer too large for value")
+)
+
+// Validate check the root table of the flatbuffer in b and its vtable
+// lie in b, a shallow check, nested tables, vectors and strings are
+// not followed
+func Validate(b []byte) error {
+	n := flatbuffers.UOffsetT(len(b))
+	if n < flatbuffers.SizeUOffsetT {
+		return ErrInvalid
+	}
+	pos := flatbuffers.GetUOffsetT(b)
+	if pos < flatbuffers.SizeUOffsetT || pos > n-flatbuffers.SizeSOffsetT {
+		return ErrInvalid
+	}
+	vt := int64(pos) - int64(flatbuffers.GetSOffsetT(b[pos:]))
+	if vt < 0 || vt > int64(n)-2*flatbuffers.SizeVOffsetT {
+		return ErrInvalid
+	}
+	vsize := int64(flatbuffers.GetVOffsetT(b[vt:]))
+	tsize := int64(flatbuffers.GetVOffsetT(b[vt+flatbuffers.SizeVOffsetT:]))
+	if vsize < 2*flatbuffers.SizeVOffsetT || vsize&1 != 0 || vt+vsize > int64(n) ||
+		tsize < flatbuffers.SizeSOffsetT || int64(pos)+tsize > int64(n) {
+		return ErrInvalid
+	}
+	for off := int64(2 * flatbuffers.SizeVOffsetT); off < vsize; off += flatbuffers.SizeVOffsetT {
+		if int64(flatbuffers.GetVOffsetT(b[vt+off:])) >= tsize {
+			return ErrInvalid
+		}
+	}
+	return nil
+}
+
+// Get the flatbuffer in the value of key, validated, for the GetRootAs
+// functions of generated code at offset 0
+// the bytes are the bucket memory, not a copy, unless the map is
+// compressed, writers change them under the reader
+func Get(m *shm.Map, key string) (b []byte, err error) {
+	if b, err = m.Get(key, false); err != nil {
+		return
+	}
+	if err = Validate(b); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Set the value of key to the flatbuffer of build, the root it return
+// is finished, add the key if not exist, the builder writes straight
+// into the bucket under Update, a flatbuffer too large leaves the
+// value zeroed
+func Set(m *shm.Map, key string, build func(b *flatbuffers.Builder) flatbuffers.UOffsetT) error {
+	return m.Update(key, true, func(v []byte) error {
+		b := flatbuffers.NewBuilder(0)
+		// full slice, the builder grows in place up to the capacity
+		b.Bytes = v[:len(v):len(v)]
+		b.Reset()
+		b.Finish(build(b))
+		if len(b.Bytes) != len(v) {
+			zero(v)
+			return ErrTooLarge
+		}
+		// the builder writes from the end, move the data to the front
+		n := copy(v, b.FinishedBytes())
+		zero(v[n:])
+		return nil
+	})
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,48 @@
+package flatbuf
+
+import (
+	"github.com/fengyoulin/shm"
+	flatbuffers "github.com/google/flatbuffers/go"
+	"testing"
+	"time"
+)
+
+// a table of an int32 and a string, as generated code would build it
+func build(id int32, name string) func(b *flatbuffers.Builder) flatbuffers.UOffsetT {
+	return func(b *flatbuffers.Builder) flatbuffers.UOffsetT {
+		s := b.CreateString(name)
+		b.StartObject(2)
+		b.PrependInt32Slot(0, id, 0)
+		b.PrependUOffsetTSlot(1, s, 0)
+		return b.EndObject()
+	}
+}
+
+func TestSet(t *testing.T) {
+	m, err := shm.Create("", 64, 16, 64, 0, time.Second, shm.InMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err = Set(m, "k", build(42, "hello")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := Get(m, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tab flatbuffers.Table
+	tab.Bytes, tab.Pos = b, flatbuffers.GetUOffsetT(b)
+	if id := tab.GetInt32Slot(4, 0); id != 42 {
+		t.Errorf("expect 42, got %d", id)
+	}
+	if o := flatbuffers.UOffsetT(tab.Offset(6)); o == 0 || string(tab.ByteVector(o+tab.Pos)) != "hello" {
+		t.Errorf("expect hello at %d", o)
+	}
+	if err = Set(m, "k", build(1, string(make([]byte, 100)))); err != ErrTooLarge {
+		t.Errorf("expect ErrTooLarge, got %v", err)
+	}
+	if _, err = Get(m, "k"); err != ErrInvalid {
+		t.Errorf("expect ErrInvalid, got %v", err)
+	}
+}
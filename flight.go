@@ -0,0 +1,165 @@
+package shm
+
+import (
+	"context"
+	"errors"
+	"github.com/fengyoulin/shm/internal/proc"
+	"os"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// poll interval of WaitOrCompute waiting on another process
+const flightPoll = 5 * time.Millisecond
+
+// ErrNoFlight on WaitOrCompute of a map without InFlight
+var ErrNoFlight = errors.New("map without in-flight markers")
+
+// WaitOrCompute append the value of key to dst, computing it if the
+// key is missing: one process at a time adds the key with its
+// in-flight marker, computes the value with compute and sets it, the
+// others poll for the value until ctx is done
+// a marker held for longer than timeout, if timeout > 0, or by a dead
+// process, is taken over by the next caller, the claim time is kept to
+// the second
+// an entry without a marker is set, added by Get or another API too,
+// its value returned as is, ErrMissing if stored by SetMissing
+// the key reads as a zero value meanwhile, a failed compute deletes
+// it for the next caller
+func (m *Map) WaitOrCompute(ctx context.Context, key string, dst []byte, timeout time.Duration, compute func(ctx context.Context) ([]byte, error)) (b []byte, err error) {
+	if m.meta.flight == 0 {
+		return dst, ErrNoFlight
+	}
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	self := uint64(os.Getpid()) << 32
+	for {
+		var done, claimed bool
+		c := &claim{mark: self | uint64(uint32(time.Now().Unix()))}
+		err = m.lockedKey(key, true, c, func(bkt *bucket) error {
+			w := atomic.LoadUint64(bkt.flight(m))
+			// set since added or claimed, the writes count in the
+			// low bits
+			if w == 0 || uint32(atomic.LoadUint64(bkt.version(m))) != 0 {
+				done = true
+				if missing(bkt) {
+					return ErrMissing
+				}
+				v, err := m.valueOf(bkt)
+				b = append(dst, v...)
+				return err
+			}
+			if !c.added || w != c.mark {
+				if !m.flightIdle(w, timeout) {
+					return nil
+				}
+				atomic.StoreUint64(bkt.flight(m), c.mark)
+			}
+			claimed = true
+			v := bkt.value(m)
+			for i := range v {
+				v[i] = 0
+			}
+			return nil
+		})
+		if err != nil {
+			return dst, err
+		}
+		if done {
+			return
+		}
+		if claimed {
+			return m.compute(ctx, key, dst, c.mark, compute)
+		}
+		t := time.NewTimer(flightPoll)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return dst, ctx.Err()
+		}
+	}
+}
+
+// compute and set the value of key claimed with mark
+func (m *Map) compute(ctx context.Context, key string, dst []byte, mark uint64, compute func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	v, err := compute(ctx)
+	if err == nil {
+		var b []byte
+		var compressed bool
+		if b, compressed, err = m.encode(v); err == nil {
+			err = m.locked(key, true, func(bkt *bucket) error {
+				if err := m.store(bkt, b, compressed, len(v)); err != nil {
+					return err
+				}
+				m.setUpdated(bkt)
+				m.bumpVersion(bkt)
+				atomic.CompareAndSwapUint64(bkt.flight(m), mark, 0)
+				return nil
+			})
+		}
+	}
+	if err != nil {
+		// unless taken over or set meanwhile
+		m.deleteIf(key, func(bkt *bucket) bool {
+			return atomic.LoadUint64(bkt.flight(m)) == mark && uint32(atomic.LoadUint64(bkt.version(m))) == 0
+		})
+		return dst, err
+	}
+	return append(dst, v...), nil
+}
+
+// report whether the marker w is free for a claim: none, held for
+// longer than timeout, or by a dead process
+func (m *Map) flightIdle(w uint64, timeout time.Duration) bool {
+	if w == 0 {
+		return true
+	}
+	if timeout > 0 && time.Since(time.Unix(int64(uint32(w)), 0)) > timeout {
+		return true
+	}
+	return !proc.Alive(int(w >> 32))
+}
+
+// pid computing the value of a bucket, 0 if none or no InFlight
+func (m *Map) ownerOf(b *bucket) int {
+	if m.meta.flight == 0 {
+		return 0
+	}
+	return int(atomic.LoadUint64(b.flight(m)) >> 32)
+}
+
+// the claim of WaitOrCompute on a key it adds, the bucket added with
+// the marker, before other processes find it, a mark of 0 to learn
+// only whether the key was added
+type claim struct {
+	mark  uint64
+	added bool
+}
+
+// record the bucket of the claim linked, nil ignored
+func (c *claim) link() {
+	if c != nil {
+		c.added = true
+	}
+}
+
+// set the marker of a new bucket, that of c, none if c is nil
+func (m *Map) resetFlight(b *bucket, c *claim) {
+	if m.meta.flight == 0 {
+		return
+	}
+	var w uint64
+	if c != nil {
+		w = c.mark
+	}
+	atomic.StoreUint64(b.flight(m), w)
+}
+
+// in-flight marker of a bucket, the pid in the high 32 bits and the
+// claim in unix seconds in the low 32 bits, 0 if none
+func (b *bucket) flight(m *Map) *uint64 {
+	return (*uint64)(unsafe.Pointer(uintptr(unsafe.Pointer(b)) + m.meta.flight))
+}
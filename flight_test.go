@@ -0,0 +1,144 @@
+package shm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMap_WaitOrCompute(t *testing.T) {
+	name := "testflight.db"
+	defer os.Remove(name)
+	m := newTestFile(t, name, 64, 16, 8, InFlight())
+	defer m.Close()
+	ctx := context.Background()
+	var computes int32
+	started, release := make(chan struct{}), make(chan struct{})
+	compute := func(ctx context.Context) ([]byte, error) {
+		if atomic.AddInt32(&computes, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return []byte("v"), nil
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if v, err := m.WaitOrCompute(ctx, "k", nil, 0, compute); err != nil || string(v[:1]) != "v" {
+			t.Errorf("unexpected %q, %v", v, err)
+		}
+	}()
+	<-started
+	if _, meta, err := m.GetWithMeta("k"); err != nil || meta.Owner != os.Getpid() {
+		t.Errorf("expect owner %d, got %d, %v", os.Getpid(), meta.Owner, err)
+	}
+	// a waiter in a process of its own, the chains it reads are
+	// written by this one
+	var out bytes.Buffer
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMap_WaitOrComputeProcess$")
+	cmd.Env = append(os.Environ(), "SHM_TEST_FLIGHT="+name)
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	close(release)
+	<-done
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("waiter: %v\n%s", err, out.Bytes())
+	}
+	if n := atomic.LoadInt32(&computes); n != 1 {
+		t.Errorf("expect 1 compute, got %d", n)
+	}
+	fail := errors.New("compute failed")
+	if _, err := m.WaitOrCompute(ctx, "bad", nil, 0, func(ctx context.Context) ([]byte, error) { return nil, fail }); err != fail {
+		t.Errorf("expect the compute error, got %v", err)
+	}
+	if m.Exists("bad") {
+		t.Error("expect the key of a failed compute deleted")
+	}
+	// claimed by a process which died
+	bkt, err := m.lookup("dead", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreUint64(bkt.flight(m), 1<<62|uint64(time.Now().Unix()))
+	if v, err := m.WaitOrCompute(ctx, "dead", nil, 0, func(ctx context.Context) ([]byte, error) { return []byte("d"), nil }); err != nil || string(v) != "d" {
+		t.Errorf("unexpected %q, %v", v, err)
+	}
+	if _, meta, _ := m.GetWithMeta("dead"); meta.Owner != 0 {
+		t.Errorf("expect no owner, got %d", meta.Owner)
+	}
+	// claimed by this process, waiting until ctx is done
+	if _, err = m.lookup("busy", true); err != nil {
+		t.Fatal(err)
+	}
+	bkt, _ = m.lookup("busy", false)
+	atomic.StoreUint64(bkt.flight(m), uint64(os.Getpid())<<32|uint64(time.Now().Unix()))
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err = m.WaitOrCompute(tctx, "busy", nil, 0, compute); err != context.DeadlineExceeded {
+		t.Errorf("expect DeadlineExceeded, got %v", err)
+	}
+	n := newTestMap(t, 64, 16, 8)
+	defer n.Close()
+	if _, err = n.WaitOrCompute(ctx, "k", nil, 0, compute); err != ErrNoFlight {
+		t.Errorf("expect ErrNoFlight, got %v", err)
+	}
+}
+
+// the waiter of TestMap_WaitOrCompute, run by it in a new process
+func TestMap_WaitOrComputeProcess(t *testing.T) {
+	name := os.Getenv("SHM_TEST_FLIGHT")
+	if name == "" {
+		t.Skip("run by TestMap_WaitOrCompute")
+	}
+	m, err := Open(name, testMaxTry, initWait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	v, err := m.WaitOrCompute(context.Background(), "k", nil, 0, func(ctx context.Context) ([]byte, error) {
+		t.Error("unexpected compute")
+		return []byte("c"), nil
+	})
+	if err != nil || string(v[:1]) != "v" {
+		t.Errorf("unexpected %q, %v", v, err)
+	}
+}
+
+func TestMap_WaitOrComputeExisting(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8, InFlight(), Expiration())
+	defer m.Close()
+	ctx := context.Background()
+	compute := func(ctx context.Context) ([]byte, error) {
+		t.Error("unexpected compute")
+		return []byte("c"), nil
+	}
+	// added by Get and Ref, never written
+	v, err := m.Get("g", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(v, "g")
+	if _, err = m.Ref("r", true); err != nil {
+		t.Fatal(err)
+	}
+	if v, err = m.WaitOrCompute(ctx, "g", nil, 0, compute); err != nil || string(v[:1]) != "g" {
+		t.Errorf("unexpected %q, %v", v, err)
+	}
+	if _, err = m.WaitOrCompute(ctx, "r", nil, 0, compute); err != nil {
+		t.Error(err)
+	}
+	if err = m.SetMissing("m", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.WaitOrCompute(ctx, "m", nil, 0, compute); err != ErrMissing {
+		t.Errorf("expect ErrMissing, got %v", err)
+	}
+}
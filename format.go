@@ -0,0 +1,42 @@
+package shm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// formatVersion of the files this library writes and the newest it
+// attaches to, bumped on a change of the format older libraries would
+// misread
+const formatVersion uint32 = 1
+
+// ErrFormat on attaching to a map of a format newer than the library
+var ErrFormat = errors.New("map format newer than the library")
+
+// FormatError on attaching to a map requiring format Need of the
+// library, which supports up to Have
+type FormatError struct {
+	// Need is the format recorded in the header
+	Need uint32
+	// Have is the newest format of this library
+	Have uint32
+}
+
+// Error implements error
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("%s: needs format %d, library has %d", ErrFormat.Error(), e.Need, e.Have)
+}
+
+// Is make errors.Is(err, ErrFormat) true
+func (e *FormatError) Is(target error) bool {
+	return target == ErrFormat
+}
+
+// check the library can attach to a map of header h, files of before
+// the format was recorded have 0
+func checkFormat(h *header) error {
+	if h.format > formatVersion {
+		return &FormatError{Need: h.format, Have: formatVersion}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package shm
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestMap_Format(t *testing.T) {
+	name := "testformat.db"
+	m := newTestFile(t, name, 64, 16, 8)
+	defer os.Remove(name)
+	if m.head.format != formatVersion {
+		t.Errorf("expect format %d, got %d", formatVersion, m.head.format)
+	}
+	m.head.format = formatVersion + 1
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var fe *FormatError
+	_, err := Create(name, 64, 16, 8, testMaxTry, initWait)
+	if !errors.Is(err, ErrFormat) || !errors.As(err, &fe) || fe.Need != formatVersion+1 {
+		t.Errorf("expect format error, got %v", err)
+	}
+	if _, err = Open(name, testMaxTry, initWait); !errors.Is(err, ErrFormat) {
+		t.Errorf("expect format error on open, got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package shm
+
+import (
+	"sync"
+	"time"
+)
+
+// freeBatch of the buckets freed by a map not yet on the free list of
+// the header, pushed there in one CAS, and taken back by alloc first
+type freeBatch struct {
+	mu     sync.Mutex
+	idx    []int32
+	size   int
+	age    time.Duration
+	timer  *time.Timer
+	closed bool
+}
+
+// the next link of a bucket in the batch of the process pid, below the
+// -1 of a bucket allocated
+func batchNext(pid int32) int32 {
+	return ^pid
+}
+
+// free the bucket of index i to the batch, push the batch if full
+func (b *freeBatch) free(m *Map, i int32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		m.freeChain(i, i)
+		return
+	}
+	// owned by the process, not an orphan for the Janitor
+	m.bucket(i).next = batchNext(selfPID)
+	b.idx = append(b.idx, i)
+	if len(b.idx) >= b.size {
+		b.flush(m)
+		return
+	}
+	if len(b.idx) == 1 {
+		b.timer = time.AfterFunc(b.age, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if !b.closed {
+				b.flush(m)
+			}
+		})
+	}
+}
+
+// the bucket freed last to the batch, -1 if empty
+func (b *freeBatch) alloc(m *Map) int32 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(b.idx)
+	if n == 0 {
+		return -1
+	}
+	i := b.idx[n-1]
+	b.idx = b.idx[:n-1]
+	if n == 1 {
+		b.timer.Stop()
+	}
+	m.bucket(i).next = -1
+	return i
+}
+
+// push the batch to the free list, with b.mu held
+func (b *freeBatch) flush(m *Map) {
+	n := len(b.idx)
+	if n == 0 {
+		return
+	}
+	b.timer.Stop()
+	for k := 0; k < n-1; k++ {
+		m.bucket(b.idx[k]).next = b.idx[k+1]
+	}
+	m.freeChain(b.idx[0], b.idx[n-1])
+	b.idx = b.idx[:0]
+}
+
+// flush the batch and free to the free list from now on
+func (b *freeBatch) close(m *Map) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flush(m)
+	b.closed = true
+}
+
+// drop the batch, its buckets put on the free list rebuilt by Repair
+func (b *freeBatch) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.idx) > 0 {
+		b.timer.Stop()
+	}
+	b.idx = b.idx[:0]
+}
+
+// push the buckets freed to the batch of the map to the free list
+func (m *Map) flushFree() {
+	if m.batch == nil {
+		return
+	}
+	m.batch.mu.Lock()
+	m.batch.flush(m)
+	m.batch.mu.Unlock()
+}
@@ -0,0 +1,52 @@
+package shm
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMap_FreeBatch(t *testing.T) {
+	name := "testfreebatch.db"
+	defer os.Remove(name)
+	m := newTestFile(t, name, 64, 16, 8, FreeBatch(8, time.Hour))
+	o := newTestFile(t, name, 64, 16, 8)
+	defer o.Close()
+	for i := 0; i < 20; i++ {
+		if err := m.Set(strconv.Itoa(i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 12; i++ {
+		m.Delete(strconv.Itoa(i))
+	}
+	// one batch pushed, 4 buckets kept
+	free := 0
+	for i := o.head.deleteLink; i >= 0; i = o.bucket(i).next {
+		free++
+	}
+	if free != 8 || len(m.batch.idx) != 4 {
+		t.Errorf("expect 8 free and 4 kept, got %d and %d", free, len(m.batch.idx))
+	}
+	// taken back first
+	if err := m.Set("a", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.batch.idx) != 3 {
+		t.Errorf("expect 3 kept, got %d", len(m.batch.idx))
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Verify(); err != nil {
+		t.Error(err)
+	}
+	p := newTestFile(t, name, 64, 16, 8, FreeBatch(8, 10*time.Millisecond))
+	defer p.Close()
+	p.Delete("a")
+	time.Sleep(50 * time.Millisecond)
+	if err := o.Verify(); err != nil {
+		t.Errorf("expect the batch pushed after its age, %v", err)
+	}
+}
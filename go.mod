@@ -2,4 +2,8 @@ module github.com/fengyoulin/shm
 
 go 1.13
 
-require golang.org/x/sys v0.0.0-20200302150141-5c8b2ff67527
+require (
+	github.com/google/flatbuffers v1.11.0
+	github.com/klauspost/compress v1.10.0
+	golang.org/x/sys v0.0.0-20200302150141-5c8b2ff67527
+)
@@ -0,0 +1,184 @@
+// Package hist is a latency histogram in shared memory, recorded
+// with atomic increments by any number of processes, mergeable and
+// exportable in the prometheus text format
+package hist
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// sub-buckets per power of two, 1<<subBits, bounding the relative
+// error of a bucket to 1/8
+const subBits = 3
+
+// buckets of a histogram, covering all uint64 values
+const numBuckets = (64 - subBits + 1) << subBits
+
+// Size of a histogram in bytes
+const Size = (numBuckets + 2) * 8
+
+var (
+	// ErrSize on a memory too small for a histogram
+	ErrSize = errors.New("memory too small")
+	// ErrAlign on a memory not aligned to 8 bytes
+	ErrAlign = errors.New("memory not aligned")
+)
+
+// DefaultBounds of WritePrometheus in seconds, as the prometheus clients
+var DefaultBounds = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// layout in memory
+type layout struct {
+	count   uint64
+	sum     uint64
+	buckets [numBuckets]uint64
+}
+
+// Histogram of durations, log linear buckets of nanoseconds
+type Histogram struct {
+	l *layout
+}
+
+// At return the histogram in the first Size bytes of b,
+// zeroed memory is an empty histogram
+func At(b []byte) (*Histogram, error) {
+	if len(b) < Size {
+		return nil, ErrSize
+	}
+	p := unsafe.Pointer(&b[0])
+	if uintptr(p)&7 != 0 {
+		return nil, ErrAlign
+	}
+	return &Histogram{l: (*layout)(p)}, nil
+}
+
+// New return a histogram in process memory, to merge into
+func New() *Histogram {
+	return &Histogram{l: new(layout)}
+}
+
+// bucket of value v
+func index(v uint64) int {
+	if v < 1<<subBits {
+		return int(v)
+	}
+	e := bits.Len64(v) - 1
+	sub := v >> uint(e-subBits) & (1<<subBits - 1)
+	return (e-subBits+1)<<subBits + int(sub)
+}
+
+// the highest value of bucket i
+func upper(i int) uint64 {
+	if i < 1<<subBits {
+		return uint64(i)
+	}
+	e := uint(i>>subBits + subBits - 1)
+	sub := uint64(i & (1<<subBits - 1))
+	lower := (1<<subBits + sub) << (e - subBits)
+	return lower + 1<<(e-subBits) - 1
+}
+
+// Record a duration, negative ones as 0
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.RecordValue(uint64(d))
+}
+
+// RecordValue record a value in nanoseconds
+func (h *Histogram) RecordValue(v uint64) {
+	atomic.AddUint64(&h.l.buckets[index(v)], 1)
+	atomic.AddUint64(&h.l.sum, v)
+	atomic.AddUint64(&h.l.count, 1)
+}
+
+// Count return the recorded values
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.l.count)
+}
+
+// Sum return the sum of the recorded values
+func (h *Histogram) Sum() time.Duration {
+	return time.Duration(atomic.LoadUint64(&h.l.sum))
+}
+
+// Quantile return an upper bound of the q quantile, 0 <= q <= 1,
+// within 1/8 of the true value, 0 if empty
+func (h *Histogram) Quantile(q float64) time.Duration {
+	total := h.Count()
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(total) + 0.5)
+	if rank == 0 {
+		rank = 1
+	}
+	var n uint64
+	for i := range h.l.buckets {
+		n += atomic.LoadUint64(&h.l.buckets[i])
+		if n >= rank {
+			return time.Duration(upper(i))
+		}
+	}
+	return time.Duration(upper(numBuckets - 1))
+}
+
+// Merge add the values recorded in src, while both may be recorded
+func (h *Histogram) Merge(src *Histogram) {
+	for i := range src.l.buckets {
+		if n := atomic.LoadUint64(&src.l.buckets[i]); n != 0 {
+			atomic.AddUint64(&h.l.buckets[i], n)
+		}
+	}
+	atomic.AddUint64(&h.l.sum, atomic.LoadUint64(&src.l.sum))
+	atomic.AddUint64(&h.l.count, atomic.LoadUint64(&src.l.count))
+}
+
+// Reset to empty, values recorded meanwhile may be partly lost
+func (h *Histogram) Reset() {
+	for i := range h.l.buckets {
+		atomic.StoreUint64(&h.l.buckets[i], 0)
+	}
+	atomic.StoreUint64(&h.l.sum, 0)
+	atomic.StoreUint64(&h.l.count, 0)
+}
+
+// WritePrometheus write the histogram as the prometheus metric name,
+// with cumulative buckets at bounds in seconds, DefaultBounds if nil
+// a value is counted at a bound its bucket ends below, so the counts
+// are within the bucket error of the exact ones
+func (h *Histogram) WritePrometheus(w io.Writer, name string, bounds []float64) error {
+	if bounds == nil {
+		bounds = DefaultBounds
+	}
+	if _, err := fmt.Fprintf(w, "# TYPE %s histogram\n", name); err != nil {
+		return err
+	}
+	var n uint64
+	i := 0
+	for _, le := range bounds {
+		limit := uint64(le * float64(time.Second))
+		for ; i < numBuckets && upper(i) <= limit; i++ {
+			n += atomic.LoadUint64(&h.l.buckets[i])
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, le, n); err != nil {
+			return err
+		}
+	}
+	count := h.Count()
+	if _, err := fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, count); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%s_sum %g\n", name, h.Sum().Seconds()); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s_count %d\n", name, count)
+	return err
+}
@@ -0,0 +1,52 @@
+package hist
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIndex(t *testing.T) {
+	for _, v := range []uint64{0, 1, 7, 8, 9, 15, 16, 17, 1000, 123456789, 1<<64 - 1} {
+		i := index(v)
+		if v > upper(i) || i > 0 && v <= upper(i-1) {
+			t.Errorf("value %d in bucket %d, (%d, %d]", v, i, upper(i-1), upper(i))
+		}
+	}
+	if index(1<<64-1) != numBuckets-1 {
+		t.Errorf("max value in bucket %d", index(1<<64-1))
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h, err := At(make([]byte, Size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 100; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	if q := h.Quantile(0.5); q < 50*time.Millisecond || q > 57*time.Millisecond {
+		t.Errorf("median %v", q)
+	}
+	total := New()
+	total.Merge(h)
+	total.Merge(h)
+	if total.Count() != 200 || total.Sum() != 2*h.Sum() {
+		t.Errorf("merged %d values, sum %v", total.Count(), total.Sum())
+	}
+	var buf strings.Builder
+	if err = h.WritePrometheus(&buf, "latency_seconds", []float64{0.01, 1}); err != nil {
+		t.Fatal(err)
+	}
+	// the bucket of 10ms ends above it
+	for _, line := range []string{
+		`latency_seconds_bucket{le="0.01"} 9`,
+		`latency_seconds_bucket{le="1"} 100`,
+		`latency_seconds_count 100`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %s in\n%s", line, buf.String())
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package shm
+
+import (
+	"container/heap"
+	"sort"
+	"sync/atomic"
+	"unsafe"
+)
+
+// HotKey is a key with its access count
+type HotKey struct {
+	Key  string
+	Hits uint64
+}
+
+// HotKeys return the n most accessed keys, most first
+// empty without AccessCounters
+func (m *Map) HotKeys(n int) []HotKey {
+	if m.meta.hits == 0 || n <= 0 {
+		return nil
+	}
+	h := make(hotHeap, 0, n)
+	for i := int32(0); i < m.head.cap; i++ {
+		bkt := m.bucket(i)
+		if bkt.used == 0 {
+			continue
+		}
+		hits := atomic.LoadUint64(bkt.hits(m))
+		if len(h) < n {
+			heap.Push(&h, HotKey{Key: bkt.key(m), Hits: hits})
+		} else if hits > h[0].Hits {
+			h[0] = HotKey{Key: bkt.key(m), Hits: hits}
+			heap.Fix(&h, 0)
+		}
+	}
+	sort.Slice(h, func(i, j int) bool {
+		return h[i].Hits > h[j].Hits
+	})
+	return h
+}
+
+// count an access
+func (m *Map) addHit(b *bucket) {
+	if m.meta.hits == 0 {
+		return
+	}
+	atomic.AddUint64(b.hits(m), 1)
+}
+
+// zero the counter of a new bucket
+func (m *Map) resetHits(b *bucket) {
+	if m.meta.hits == 0 {
+		return
+	}
+	atomic.StoreUint64(b.hits(m), 0)
+}
+
+// access counter of a bucket
+func (b *bucket) hits(m *Map) *uint64 {
+	return (*uint64)(unsafe.Pointer(uintptr(unsafe.Pointer(b)) + m.meta.hits))
+}
+
+// min heap of hot keys
+type hotHeap []HotKey
+
+func (h hotHeap) Len() int            { return len(h) }
+func (h hotHeap) Less(i, j int) bool  { return h[i].Hits < h[j].Hits }
+func (h hotHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *hotHeap) Push(x interface{}) { *h = append(*h, x.(HotKey)) }
+func (h *hotHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
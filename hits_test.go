@@ -0,0 +1,19 @@
+package shm
+
+import "testing"
+
+func TestMap_HotKeys(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8, AccessCounters())
+	defer m.Close()
+	for i, key := range []string{"a", "b", "c", "d"} {
+		for j := 0; j <= i; j++ {
+			if _, err := m.Get(key, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	hot := m.HotKeys(2)
+	if len(hot) != 2 || hot[0].Key != "d" || hot[0].Hits != 4 || hot[1].Key != "c" {
+		t.Errorf("unexpected hot keys %v", hot)
+	}
+}
@@ -0,0 +1,55 @@
+// Package intern maps strings to stable int32 ids and back, in a map
+// shared by processes, which may then exchange the compact ids
+package intern
+
+import (
+	"github.com/fengyoulin/shm"
+	"time"
+)
+
+// Table of interned strings, ids are never reused
+type Table struct {
+	m *shm.Map
+}
+
+// Open the table in the file at path for at most capacity strings
+// of at most maxLen bytes, waiting for at most wait for its lock
+func Open(path string, capacity, maxLen int, wait time.Duration, opts ...shm.Option) (*Table, error) {
+	m, err := shm.Create(path, capacity, maxLen, 0, 0, wait, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return New(m), nil
+}
+
+// New use m as a table, its keys are the strings, the values unused,
+// keys must not be deleted while their ids are in use
+func New(m *shm.Map) *Table {
+	return &Table{m: m}
+}
+
+// ID return the id of s, interning it if new
+func (t *Table) ID(s string) (int32, error) {
+	return t.m.Ref(s, true)
+}
+
+// Lookup return the id of s if interned
+func (t *Table) Lookup(s string) (int32, bool) {
+	id, err := t.m.Ref(s, false)
+	return id, err == nil
+}
+
+// String return the string of id, shm.ErrKeyNot if unknown
+func (t *Table) String(id int32) (string, error) {
+	return t.m.KeyOf(id)
+}
+
+// Len return the strings interned
+func (t *Table) Len() int {
+	return t.m.Len()
+}
+
+// Close the table
+func (t *Table) Close() error {
+	return t.m.Close()
+}
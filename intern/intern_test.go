@@ -0,0 +1,40 @@
+package intern
+
+import (
+	"github.com/fengyoulin/shm"
+	"testing"
+	"time"
+)
+
+func TestTable(t *testing.T) {
+	tab, err := Open("", 64, 32, time.Second, shm.InMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tab.Close()
+	ids := make(map[string]int32)
+	for _, s := range []string{"cpu", "mem", "disk", "cpu"} {
+		id, err := tab.ID(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prev, ok := ids[s]; ok && prev != id {
+			t.Errorf("%s interned as %d and %d", s, prev, id)
+		}
+		ids[s] = id
+	}
+	for s, id := range ids {
+		if got, err := tab.String(id); err != nil || got != s {
+			t.Errorf("id %d: expect %s, got %s, %v", id, s, got, err)
+		}
+	}
+	if _, ok := tab.Lookup("net"); ok {
+		t.Error("lookup interned a string")
+	}
+	if _, err = tab.String(63); err != shm.ErrKeyNot {
+		t.Errorf("expect ErrKeyNot, got %v", err)
+	}
+	if tab.Len() != 3 {
+		t.Errorf("expect 3 strings, got %d", tab.Len())
+	}
+}
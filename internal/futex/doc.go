@@ -0,0 +1,3 @@
+// Package futex wait on and wake a 32-bit word in memory shared by
+// processes, with the futex syscall on linux and by polling elsewhere
+package futex
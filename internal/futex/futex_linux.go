@@ -0,0 +1,30 @@
+package futex
+
+import (
+	"golang.org/x/sys/unix"
+	"time"
+	"unsafe"
+)
+
+// futex operations, shared between processes, no private flag
+const (
+	futexWait = 0
+	futexWake = 1
+)
+
+// Wait until the word at addr is woken, if it still holds val,
+// or timeout passed, forever if timeout <= 0
+// may return early, the caller must check the word again
+func Wait(addr *uint32, val uint32, timeout time.Duration) {
+	var ts *unix.Timespec
+	if timeout > 0 {
+		t := unix.NsecToTimespec(int64(timeout))
+		ts = &t
+	}
+	_, _, _ = unix.Syscall6(unix.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWait, uintptr(val), uintptr(unsafe.Pointer(ts)), 0, 0)
+}
+
+// Wake up to n waiters of the word at addr
+func Wake(addr *uint32, n int) {
+	_, _, _ = unix.Syscall6(unix.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWake, uintptr(n), 0, 0, 0)
+}
@@ -0,0 +1,28 @@
+// +build !linux
+
+package futex
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// poll interval without a futex
+const poll = time.Millisecond
+
+// Wait until the word at addr no longer holds val, or timeout
+// passed, forever if timeout <= 0, polling the word
+// may return early, the caller must check the word again
+func Wait(addr *uint32, val uint32, timeout time.Duration) {
+	d := poll
+	if timeout > 0 && timeout < d {
+		d = timeout
+	}
+	if atomic.LoadUint32(addr) == val {
+		time.Sleep(d)
+	}
+}
+
+// Wake is a no-op, waiters poll
+func Wake(addr *uint32, n int) {
+}
@@ -0,0 +1,2 @@
+// Package proc report on other processes
+package proc
@@ -0,0 +1,13 @@
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package proc
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// Alive report whether the process pid exists
+func Alive(pid int) bool {
+	err := unix.Kill(pid, 0)
+	return err == nil || err == unix.EPERM
+}
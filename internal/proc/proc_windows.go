@@ -0,0 +1,25 @@
+// +build windows
+
+package proc
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// exit code of a running process
+const stillActive = 259
+
+// Alive report whether the process pid exists
+func Alive(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// exists but not ours to query
+		return err == windows.ERROR_ACCESS_DENIED
+	}
+	defer windows.CloseHandle(h)
+	var code uint32
+	if err = windows.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
@@ -0,0 +1,287 @@
+package shm
+
+import (
+	"context"
+	"errors"
+	"github.com/fengyoulin/shm/internal/proc"
+	"github.com/fengyoulin/shm/lease"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// ErrStarted on Start of a started Janitor
+var ErrStarted = errors.New("janitor started")
+
+// JanitorStats of the runs of a Janitor in this process
+type JanitorStats struct {
+	// Runs as the leader
+	Runs uint64
+	// Expired entries deleted
+	Expired uint64
+	// Unlocked chains left locked by a crashed process
+	Unlocked uint64
+	// Scavenged buckets allocated by a crashed process, never linked
+	Scavenged uint64
+	// Fences lifted, raised by a crashed process
+	Fences uint64
+	// Err of the last sync, nil if it succeeded
+	Err error
+	// Backups done by Backup
+	Backups uint64
+	// BackupErr of the last backup, nil if it succeeded
+	BackupErr error
+}
+
+// Janitor run the maintenance of a map every interval: delete the
+// expired entries, release the chain locks and free the buckets left
+// by crashed processes, sync the mapping, and back it up if scheduled
+// by Backup
+// one process runs it at a time, elected by a lease in the header,
+// the others stand by to take over
+// a lock or a bucket is recovered when it is found so in two runs in
+// a row, an interval longer than any operation holds it
+type Janitor struct {
+	m        *Map
+	l        *lease.Lease
+	interval time.Duration
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+	stats  JanitorStats
+	// scheduled backup, and when it last ran in this process
+	backup     func(m *Map) error
+	every      time.Duration
+	lastBackup time.Time
+
+	// one run at a time
+	run sync.Mutex
+	// suspects of the last run, slot to serial and bucket indexes
+	locked  map[int32]int32
+	orphans map[int32]struct{}
+}
+
+// Janitor return a janitor of m running every interval
+func (m *Map) Janitor(interval time.Duration) (*Janitor, error) {
+	if m.readOnly {
+		return nil, ErrReadOnly
+	}
+	ttl := 3 * interval
+	// heartbeats are in seconds
+	if ttl < 3*time.Second {
+		ttl = 3 * time.Second
+	}
+	b := (*[lease.Size]byte)(unsafe.Pointer(&m.head.janitor))[:]
+	l, err := lease.At(b, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &Janitor{m: m, l: l, interval: interval}, nil
+}
+
+// Start running in the background until Stop or ctx is done
+func (j *Janitor) Start(ctx context.Context) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.cancel != nil {
+		return ErrStarted
+	}
+	ctx, j.cancel = context.WithCancel(ctx)
+	j.done = make(chan struct{})
+	go j.loop(ctx, j.done)
+	return nil
+}
+
+// Stop running and release the lease, wait for a run in progress
+func (j *Janitor) Stop() {
+	j.mu.Lock()
+	cancel, done := j.cancel, j.done
+	j.cancel, j.done = nil, nil
+	j.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// Leader report whether this process runs the maintenance
+func (j *Janitor) Leader() bool {
+	return j.l.Leader()
+}
+
+// Stats of the runs so far
+func (j *Janitor) Stats() JanitorStats {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.stats
+}
+
+// Backup run fn with the map every interval by the leader, after
+// the maintenance of a run, so a backup is late by up to the interval
+// of the janitor; a process taking the lead backs up at its first run
+// fn nil stops the backups
+func (j *Janitor) Backup(every time.Duration, fn func(m *Map) error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.backup, j.every, j.lastBackup = fn, every, time.Time{}
+}
+
+// run the backup if due, renewing the lease meanwhile, so a backup
+// longer than its ttl keeps the lead
+func (j *Janitor) runBackup() {
+	j.mu.Lock()
+	fn, due := j.backup, time.Since(j.lastBackup) >= j.every
+	j.mu.Unlock()
+	if fn == nil || !due {
+		return
+	}
+	done := make(chan struct{})
+	beat := make(chan struct{})
+	go func() {
+		defer close(beat)
+		// a third of the ttl of the lease
+		every := j.interval
+		if every < time.Second {
+			every = time.Second
+		}
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				j.l.Heartbeat()
+			}
+		}
+	}()
+	err := fn(j.m)
+	close(done)
+	<-beat
+	j.mu.Lock()
+	j.lastBackup = time.Now()
+	j.stats.Backups++
+	j.stats.BackupErr = err
+	j.mu.Unlock()
+}
+
+// run every interval while ctx is not done
+func (j *Janitor) loop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	defer j.l.Release()
+	t := time.NewTicker(j.interval)
+	defer t.Stop()
+	for {
+		j.Run()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Run the maintenance once if this process holds or takes the lease,
+// report whether it did
+func (j *Janitor) Run() bool {
+	j.run.Lock()
+	defer j.run.Unlock()
+	if !j.l.TryAcquire() || !j.l.Heartbeat() {
+		j.locked, j.orphans = nil, nil
+		j.mu.Lock()
+		j.lastBackup = time.Time{}
+		j.mu.Unlock()
+		return false
+	}
+	fences := j.m.liftDeadFence()
+	expired := j.m.ExpireDue()
+	unlocked := j.unlock()
+	scavenged := j.scavenge()
+	err := j.m.Sync()
+	j.mu.Lock()
+	j.stats.Runs++
+	j.stats.Expired += uint64(expired)
+	j.stats.Unlocked += uint64(unlocked)
+	j.stats.Scavenged += uint64(scavenged)
+	if fences {
+		j.stats.Fences++
+	}
+	j.stats.Err = err
+	j.mu.Unlock()
+	j.runBackup()
+	return true
+}
+
+// release the chain locks held with the same serial since the last
+// run by a dead process, bumping the serial against the chain changed
+// half way; a slow holder alive keeps its lock
+// SingleProcess and SingleWriter chains take no shared locks
+func (j *Janitor) unlock() (n int) {
+	m := j.m
+	if m.shards != nil || m.writer {
+		return 0
+	}
+	locked := make(map[int32]int32)
+	for i := int32(0); i < m.nslots; i++ {
+		ptr := &(*m.hash)[i]
+		holder := atomic.LoadInt32(&ptr[2])
+		if holder <= 0 {
+			continue
+		}
+		serial := atomic.LoadInt32(&ptr[1])
+		if last, ok := j.locked[i]; ok && last == serial && !proc.Alive(int(holder)) {
+			atomic.AddInt32(&ptr[1], 1)
+			if atomic.CompareAndSwapInt32(&ptr[2], holder, 0) {
+				n++
+			}
+			continue
+		}
+		locked[i] = serial
+	}
+	j.locked = locked
+	return
+}
+
+// free the buckets neither used nor free since the last run, nor in
+// the FreeBatch of a process alive
+func (j *Janitor) scavenge() (n int) {
+	m := j.m
+	next := atomic.LoadInt32(&m.head.next)
+	free := make(map[int32]struct{})
+	for i, k := atomic.LoadInt32(&m.head.deleteLink), int32(0); i >= 0 && i < next && k < next; k++ {
+		free[i] = struct{}{}
+		i = atomic.LoadInt32(&m.bucket(i).next)
+	}
+	// nor the continuation buckets of the entries
+	var spans map[int32]struct{}
+	if m.meta.span != 0 {
+		spans = m.spanned(next, false)
+	}
+	orphans := make(map[int32]struct{})
+	for i := int32(0); i < next; i++ {
+		if _, ok := free[i]; ok || atomic.LoadInt32(&m.bucket(i).used) != 0 {
+			continue
+		}
+		if _, ok := spans[i]; ok {
+			continue
+		}
+		if next := atomic.LoadInt32(&m.bucket(i).next); next < -1 && proc.Alive(int(^next)) {
+			continue
+		}
+		if _, ok := j.orphans[i]; !ok {
+			orphans[i] = struct{}{}
+			continue
+		}
+		if m.shards != nil || m.writer {
+			m.freeSingle(i)
+		} else {
+			m.free(i)
+		}
+		n++
+	}
+	j.orphans = orphans
+	return
+}
@@ -0,0 +1,100 @@
+package shm
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// a pid no process has
+const deadPID = 1<<31 - 1
+
+func TestMap_Janitor(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8, Expiration())
+	defer m.Close()
+	for _, k := range []string{"a", "b"} {
+		if err := m.Set(k, []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Touch("a", -1); err != nil {
+		t.Fatal(err)
+	}
+	// a crash holding the chain of b, and one between alloc and link
+	ss, _, _ := m.slots("b")
+	atomic.StoreInt32(&ss[0].ptr[2], deadPID)
+	if m.alloc() < 0 {
+		t.Fatal("alloc failed")
+	}
+	// a slow holder alive, and a bucket in the batch of a process alive
+	live, _, _ := m.slots("live")
+	if live[0].ptr == ss[0].ptr {
+		t.Fatal("expect two chains")
+	}
+	atomic.StoreInt32(&live[0].ptr[2], selfPID)
+	batched := m.alloc()
+	if batched < 0 {
+		t.Fatal("alloc failed")
+	}
+	m.bucket(batched).next = batchNext(selfPID)
+	j, err := m.Janitor(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if !j.Run() {
+			t.Fatal("expect the lease taken")
+		}
+	}
+	if s := j.Stats(); s.Runs != 2 || s.Expired != 1 || s.Unlocked != 1 || s.Scavenged != 1 || s.Err != nil {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	if atomic.LoadInt32(&live[0].ptr[2]) != selfPID {
+		t.Error("expect the lock of a holder alive kept")
+	}
+	atomic.StoreInt32(&live[0].ptr[2], 0)
+	m.free(batched)
+	if err = m.Verify(); err != nil {
+		t.Error(err)
+	}
+	if err = m.Set("b", []byte("B")); err != nil {
+		t.Error(err)
+	}
+	if err = j.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err = j.Start(context.Background()); err != ErrStarted {
+		t.Errorf("expect ErrStarted, got %v", err)
+	}
+	j.Stop()
+	if j.Leader() || atomic.LoadUint64(&m.head.janitor) != 0 {
+		t.Error("expect the lease released")
+	}
+}
+
+func TestJanitor_BackupLease(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8)
+	defer m.Close()
+	j, err := m.Janitor(10 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the heartbeats go on while a backup runs
+	var before, after uint64
+	j.Backup(time.Hour, func(m *Map) error {
+		before = atomic.LoadUint64(&m.head.janitor)
+		time.Sleep(2100 * time.Millisecond)
+		after = atomic.LoadUint64(&m.head.janitor)
+		return nil
+	})
+	if !j.Run() {
+		t.Fatal("expect the lease taken")
+	}
+	if uint32(after) <= uint32(before) || after>>32 != before>>32 {
+		t.Errorf("expect the lease renewed, %#x then %#x", before, after)
+	}
+	if s := j.Stats(); s.Backups != 1 || s.BackupErr != nil {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
@@ -0,0 +1,102 @@
+package shm
+
+import (
+	"context"
+	"errors"
+	shmsync "github.com/fengyoulin/shm/sync"
+	"reflect"
+	"unsafe"
+)
+
+var (
+	// ErrNoKeyLocks on LockKey of a map without KeyLocks
+	ErrNoKeyLocks = errors.New("map without key locks")
+	// ErrKeyOwnerDead on lock a key whose holder died, the lock is
+	// acquired, but the work it guards may be half done
+	ErrKeyOwnerDead = shmsync.ErrOwnerDead
+	// ErrKeyNotOwner on unlock a key not locked by this process
+	ErrKeyNotOwner = shmsync.ErrNotOwner
+)
+
+// key locks after the tickets
+func (h *header) keyLockOff() uint32 {
+	off := h.ticketOff()
+	if h.features&featTickets != 0 {
+		off += uint32(unsafe.Sizeof(ticket{})) * uint32(h.slotCount())
+	}
+	return off
+}
+
+// the hash slot of the key lock of key
+func (m *Map) keySlot(key string) (int32, error) {
+	if m.keyLocks == 0 {
+		return 0, ErrNoKeyLocks
+	}
+	ss, _, err := m.slots(key)
+	if err != nil {
+		return 0, err
+	}
+	return int32(uint(ss[0].h) % uint(m.nslots)), nil
+}
+
+// the key lock of hash slot i
+func (m *Map) slotLock(i int32) *shmsync.Mutex {
+	var b []byte
+	h := (*reflect.SliceHeader)(unsafe.Pointer(&b))
+	h.Data = m.keyLocks + uintptr(i)*shmsync.MutexSize
+	h.Len = shmsync.MutexSize
+	h.Cap = h.Len
+	// aligned and long enough
+	mu, _ := shmsync.MutexAt(b)
+	return mu
+}
+
+// the key lock of key
+func (m *Map) keyLock(key string) (*shmsync.Mutex, error) {
+	i, err := m.keySlot(key)
+	if err != nil {
+		return nil, err
+	}
+	return m.slotLock(i), nil
+}
+
+// LockKey lock key for the work of an application on it, against the
+// LockKey of the other processes, waiting as long as it takes, see
+// LockKeyContext
+func (m *Map) LockKey(key string) error {
+	return m.LockKeyContext(context.Background(), key)
+}
+
+// LockKeyContext lock key as LockKey, waiting until ctx is done
+// the locks are those of the hash slots, so keys of a slot share one,
+// and none guards the map operations on a key, nor is reentrant: a
+// process must not lock a key of a slot it holds, and the processes
+// locking several keys must take them in the order of their slots, as
+// Txn.Lock does;
+// a lock whose holder died is taken over with ErrKeyOwnerDead, the
+// goroutines of a process share its locks
+func (m *Map) LockKeyContext(ctx context.Context, key string) error {
+	mu, err := m.keyLock(key)
+	if err != nil {
+		return err
+	}
+	return mu.LockContext(ctx)
+}
+
+// TryLockKey lock key as LockKey if free, report whether locked
+func (m *Map) TryLockKey(key string) (bool, error) {
+	mu, err := m.keyLock(key)
+	if err != nil {
+		return false, err
+	}
+	return mu.TryLock(), nil
+}
+
+// UnlockKey release the lock of key held by this process
+func (m *Map) UnlockKey(key string) error {
+	mu, err := m.keyLock(key)
+	if err != nil {
+		return err
+	}
+	return mu.Unlock()
+}
@@ -0,0 +1,56 @@
+package shm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMap_LockKey(t *testing.T) {
+	name := "testlockkey.db"
+	defer os.Remove(name)
+	m := newTestFile(t, name, 64, 16, 16, KeyLocks())
+	defer m.Close()
+	var err error
+	if err = m.LockKey("a"); err != nil {
+		t.Fatal(err)
+	}
+	// the key need not be in the map
+	if ok, err := m.TryLockKey("a"); err != nil || ok {
+		t.Errorf("expect a held, got %v, %v", ok, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err = m.LockKeyContext(ctx, "a"); err != context.DeadlineExceeded {
+		t.Errorf("expect a deadline, got %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- m.LockKey("a")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err = m.UnlockKey("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != nil {
+		t.Errorf("expect a locked by the waiter, got %v", err)
+	}
+	if err = m.UnlockKey("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.UnlockKey("a"); err != ErrKeyNotOwner {
+		t.Errorf("expect ErrKeyNotOwner, got %v", err)
+	}
+	if p := m.Params(); !strings.Contains(fmt.Sprint(p.Features), "KeyLocks") {
+		t.Errorf("expect KeyLocks in %v", p.Features)
+	}
+	n := newTestFile(t, "testnokeylocks.db", 64, 16, 16)
+	defer os.Remove("testnokeylocks.db")
+	defer n.Close()
+	if err = n.LockKey("a"); err != ErrNoKeyLocks {
+		t.Errorf("expect ErrNoKeyLocks, got %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+// Package keys encodes tuples of strings, uint64s and times into map
+// keys ordered as the tuples, component by component, so that keys
+// of a tuple prefix share the encoding of the prefix as a prefix, the
+// encoded keys must fit the key length of the map
+package keys
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// string escapes, a zero byte is followed by 0xff within a string
+// and by 0x01 at its end, which sorts a string before its extensions
+const (
+	escape  = 0x00
+	escZero = 0xff
+	escEnd  = 0x01
+)
+
+var (
+	// ErrType on a component of an unsupported type
+	ErrType = errors.New("unsupported key component")
+	// ErrKey on decode a key not encoded of such components
+	ErrKey = errors.New("malformed key")
+)
+
+// AppendString append the encoding of s to b
+func AppendString(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		b = append(b, s[i])
+		if s[i] == escape {
+			b = append(b, escZero)
+		}
+	}
+	return append(b, escape, escEnd)
+}
+
+// AppendUint64 append the encoding of v to b, 8 bytes big endian
+func AppendUint64(b []byte, v uint64) []byte {
+	var a [8]byte
+	binary.BigEndian.PutUint64(a[:], v)
+	return append(b, a[:]...)
+}
+
+// AppendTime append the encoding of t to b, its unix nanoseconds with
+// the sign bit flipped, 8 bytes, times before 1678 or after 2262 are
+// clamped and the location is dropped
+func AppendTime(b []byte, t time.Time) []byte {
+	var n int64
+	switch {
+	case t.Before(time.Unix(0, math.MinInt64)):
+		n = math.MinInt64
+	case t.After(time.Unix(0, math.MaxInt64)):
+		n = math.MaxInt64
+	default:
+		n = t.UnixNano()
+	}
+	return AppendUint64(b, uint64(n)^1<<63)
+}
+
+// Append append the encoding of parts, each a string, uint64 or
+// time.Time, to b
+func Append(b []byte, parts ...interface{}) ([]byte, error) {
+	for _, p := range parts {
+		switch v := p.(type) {
+		case string:
+			b = AppendString(b, v)
+		case uint64:
+			b = AppendUint64(b, v)
+		case time.Time:
+			b = AppendTime(b, v)
+		default:
+			return nil, fmt.Errorf("%w: %T", ErrType, p)
+		}
+	}
+	return b, nil
+}
+
+// Encode parts, each a string, uint64 or time.Time, into a key, the
+// key of leading parts is a prefix of it, for prefix scans
+func Encode(parts ...interface{}) (string, error) {
+	b, err := Append(nil, parts...)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Decode key into parts, each a *string, *uint64 or *time.Time, of
+// the types encoded, it may have more components than parts
+// rest is what remains of key after them
+func Decode(key string, parts ...interface{}) (rest string, err error) {
+	for _, p := range parts {
+		switch v := p.(type) {
+		case *string:
+			*v, key, err = cutString(key)
+		case *uint64:
+			*v, key, err = cutUint64(key)
+		case *time.Time:
+			var n uint64
+			if n, key, err = cutUint64(key); err == nil {
+				*v = time.Unix(0, int64(n^1<<63))
+			}
+		default:
+			err = fmt.Errorf("%w: %T", ErrType, p)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return key, nil
+}
+
+// the string at the start of key and the rest
+func cutString(key string) (s, rest string, err error) {
+	b := make([]byte, 0, len(key))
+	for i := 0; i < len(key); i++ {
+		if key[i] != escape {
+			b = append(b, key[i])
+			continue
+		}
+		if i++; i == len(key) {
+			break
+		}
+		switch key[i] {
+		case escZero:
+			b = append(b, escape)
+		case escEnd:
+			return string(b), key[i+1:], nil
+		default:
+			return "", key, ErrKey
+		}
+	}
+	return "", key, ErrKey
+}
+
+// the uint64 at the start of key and the rest
+func cutUint64(key string) (v uint64, rest string, err error) {
+	if len(key) < 8 {
+		return 0, key, ErrKey
+	}
+	for i := 0; i < 8; i++ {
+		v = v<<8 | uint64(key[i])
+	}
+	return v, key[8:], nil
+}
@@ -0,0 +1,57 @@
+package keys
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestOrder(t *testing.T) {
+	t0 := time.Unix(1600000000, 0)
+	tuples := [][]interface{}{
+		{"", uint64(0), t0},
+		{"a", uint64(0), t0},
+		{"a", uint64(1), t0.Add(-time.Hour)},
+		{"a", uint64(1), t0},
+		{"a", uint64(256), t0},
+		{"a\x00", uint64(0), t0},
+		{"a\x00b", uint64(0), t0},
+		{"a\x01", uint64(0), t0},
+		{"ab", uint64(0), time.Unix(-1, 0)},
+		{"ab", uint64(0), t0},
+		{"b", uint64(0), t0},
+	}
+	var encoded []string
+	for _, tu := range tuples {
+		k, err := Encode(tu...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded = append(encoded, k)
+	}
+	if !sort.StringsAreSorted(encoded) {
+		t.Errorf("keys out of order: %q", encoded)
+	}
+	for i, k := range encoded {
+		var s string
+		var n uint64
+		var tm time.Time
+		rest, err := Decode(k, &s, &n, &tm)
+		if err != nil || rest != "" {
+			t.Fatalf("decode %q: %q, %v", k, rest, err)
+		}
+		if s != tuples[i][0] || n != tuples[i][1] || !tm.Equal(tuples[i][2].(time.Time)) {
+			t.Errorf("expect %v, got %q %d %v", tuples[i], s, n, tm)
+		}
+	}
+	prefix, _ := Encode("a")
+	if encoded[3][:len(prefix)] != prefix {
+		t.Errorf("expect prefix %q of %q", prefix, encoded[3])
+	}
+	if _, err := Encode(1); err == nil {
+		t.Error("expect error on int")
+	}
+	if _, err := Decode("a", new(string)); err != ErrKey {
+		t.Errorf("expect ErrKey, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+// Package lease elects a leader among processes with a lease in
+// shared memory, held by a pid and renewed by heartbeats
+package lease
+
+import (
+	"errors"
+	"github.com/fengyoulin/shm/internal/proc"
+	"os"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Size of a lease in bytes
+const Size = 8
+
+var (
+	// ErrSize on a memory too small for a lease
+	ErrSize = errors.New("memory too small")
+	// ErrAlign on a memory not aligned to 8 bytes
+	ErrAlign = errors.New("memory not aligned")
+	// ErrTTL on a ttl under a second, the resolution of heartbeats
+	ErrTTL = errors.New("ttl under a second")
+)
+
+// Lease is a leadership held by one process at a time, for ttl since
+// its last heartbeat, or until the holder dies
+// the word packs the holder pid in the high 32 bits and the heartbeat
+// in unix seconds in the low 32 bits, 0 if free
+type Lease struct {
+	word *uint64
+	pid  uint32
+	ttl  time.Duration
+}
+
+// At return the lease in the first Size bytes of b, expiring ttl
+// after the last heartbeat, zeroed memory is a free lease
+func At(b []byte, ttl time.Duration) (*Lease, error) {
+	if ttl < time.Second {
+		return nil, ErrTTL
+	}
+	if len(b) < Size {
+		return nil, ErrSize
+	}
+	p := unsafe.Pointer(&b[0])
+	if uintptr(p)&7 != 0 {
+		return nil, ErrAlign
+	}
+	return &Lease{word: (*uint64)(p), pid: uint32(os.Getpid()), ttl: ttl}, nil
+}
+
+// TryAcquire take the lease if free, expired or held by a dead
+// process or renew it if held, true if this process holds it after
+// the call
+func (l *Lease) TryAcquire() bool {
+	for {
+		old := atomic.LoadUint64(l.word)
+		pid, beat := uint32(old>>32), uint32(old)
+		if pid != l.pid && old != 0 && !l.expired(beat) && proc.Alive(int(pid)) {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(l.word, old, l.pack()) {
+			return true
+		}
+	}
+}
+
+// Heartbeat renew the lease, false if this process lost it
+func (l *Lease) Heartbeat() bool {
+	for {
+		old := atomic.LoadUint64(l.word)
+		if uint32(old>>32) != l.pid {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(l.word, old, l.pack()) {
+			return true
+		}
+	}
+}
+
+// Release the lease if this process holds it
+func (l *Lease) Release() {
+	old := atomic.LoadUint64(l.word)
+	if uint32(old>>32) == l.pid {
+		atomic.CompareAndSwapUint64(l.word, old, 0)
+	}
+}
+
+// Holder return the pid holding the lease and its last heartbeat,
+// pid 0 if free or expired
+func (l *Lease) Holder() (pid int, beat time.Time) {
+	w := atomic.LoadUint64(l.word)
+	if w == 0 || l.expired(uint32(w)) {
+		return 0, time.Time{}
+	}
+	return int(w >> 32), time.Unix(int64(uint32(w)), 0)
+}
+
+// Leader report whether this process holds the lease
+func (l *Lease) Leader() bool {
+	w := atomic.LoadUint64(l.word)
+	return uint32(w>>32) == l.pid && !l.expired(uint32(w))
+}
+
+// the word of this process beating now
+func (l *Lease) pack() uint64 {
+	return uint64(l.pid)<<32 | uint64(uint32(time.Now().Unix()))
+}
+
+// a heartbeat at beat too old
+func (l *Lease) expired(beat uint32) bool {
+	return time.Since(time.Unix(int64(beat), 0)) > l.ttl
+}
@@ -0,0 +1,54 @@
+package lease
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLease(t *testing.T) {
+	b := make([]byte, Size)
+	l, err := At(b, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.TryAcquire() || !l.Leader() {
+		t.Fatal("free lease not acquired")
+	}
+	// acquiring again renews the heartbeat
+	*l.word = uint64(l.pid)<<32 | uint64(uint32(time.Now().Add(-time.Hour).Unix()))
+	if !l.TryAcquire() || !l.Leader() {
+		t.Fatal("held lease not renewed")
+	}
+	if pid, _ := l.Holder(); pid != os.Getpid() {
+		t.Errorf("expect holder %d, got %d", os.Getpid(), pid)
+	}
+	// another process holding a fresh lease
+	other := &Lease{word: l.word, pid: l.pid + 1, ttl: time.Minute}
+	if other.TryAcquire() {
+		t.Error("held lease acquired")
+	}
+	if !l.Heartbeat() {
+		t.Error("holder lost the lease")
+	}
+	l.Release()
+	if !other.TryAcquire() {
+		t.Error("released lease not acquired")
+	}
+	// a stale heartbeat of a live process
+	stale := &Lease{word: l.word, pid: l.pid, ttl: time.Minute}
+	*l.word = uint64(other.pid)<<32 | uint64(uint32(time.Now().Add(-time.Hour).Unix()))
+	if !stale.TryAcquire() {
+		t.Error("expired lease not acquired")
+	}
+	if other.Heartbeat() {
+		t.Error("heartbeat of a lost lease")
+	}
+}
+
+func TestLeaseTTL(t *testing.T) {
+	b := make([]byte, Size)
+	if _, err := At(b, time.Second-1); err != ErrTTL {
+		t.Errorf("expect ErrTTL, got %v", err)
+	}
+}
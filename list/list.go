@@ -0,0 +1,200 @@
+// Package list provides bounded lists of fixed size elements shared by
+// processes, each a ring in the value of its name in a map, such as the
+// recent history of a key
+package list
+
+import (
+	"errors"
+	"github.com/fengyoulin/shm"
+	"github.com/fengyoulin/shm/sync"
+	"time"
+	"unsafe"
+)
+
+// bytes of the ring header in a value
+const headerSize = 16
+
+var (
+	// ErrElemSize on an element size out of range
+	ErrElemSize = errors.New("element size out of range")
+	// ErrValueSize on map values too small for an element
+	ErrValueSize = errors.New("map values too small")
+)
+
+// ring header, then the elements
+type ring struct {
+	mu uint32
+	// index of the front element
+	head uint32
+	len  uint32
+	_    uint32
+}
+
+// Lists of a map
+type Lists struct {
+	m    *shm.Map
+	size int
+}
+
+// Open the lists in the file at path, for at most lists names of at
+// most nameLen bytes, each of at most capacity elements of size bytes
+// waiting for at most wait for the lock of the file
+func Open(path string, lists, nameLen, capacity, size int, wait time.Duration, opts ...shm.Option) (*Lists, error) {
+	if size <= 0 || capacity <= 0 {
+		return nil, ErrElemSize
+	}
+	m, err := shm.Create(path, lists, nameLen, headerSize+capacity*size, 0, wait, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return New(m, size)
+}
+
+// New use the values of m as lists of elements of size bytes, the
+// capacity of a list follows from the value length
+// the values must not be compressed nor set other than by Lists
+func New(m *shm.Map, size int) (*Lists, error) {
+	if size <= 0 {
+		return nil, ErrElemSize
+	}
+	return &Lists{m: m, size: size}, nil
+}
+
+// a locked list
+type list struct {
+	*ring
+	mu    *sync.Mutex
+	elems []byte
+	cap   uint32
+	size  int
+}
+
+// lock the list name, add it if add
+func (l *Lists) lock(name string, add bool) (r *list, err error) {
+	v, err := l.m.Get(name, add)
+	if err != nil {
+		return
+	}
+	if len(v) < headerSize+l.size {
+		return nil, ErrValueSize
+	}
+	mu, err := sync.MutexAt(v)
+	if err != nil {
+		return
+	}
+	// a dead holder left at most an element half written
+	if err = mu.Lock(); err != nil && err != sync.ErrOwnerDead {
+		return nil, err
+	}
+	elems := v[headerSize:]
+	return &list{
+		ring:  (*ring)(unsafe.Pointer(&v[0])),
+		mu:    mu,
+		elems: elems,
+		cap:   uint32(len(elems) / l.size),
+		size:  l.size,
+	}, nil
+}
+
+// unlock the list, keep the first error
+func (r *list) unlock(err *error) {
+	if e := r.mu.Unlock(); *err == nil {
+		*err = e
+	}
+}
+
+// element i from the front
+func (r *list) elem(i uint32) []byte {
+	off := int((r.head+i)%r.cap) * r.size
+	return r.elems[off : off+r.size]
+}
+
+// LPush elem to the front of the list name, dropping the back element
+// of a full list, elem shorter than the element size is padded with 0
+func (l *Lists) LPush(name string, elem []byte) (dropped bool, err error) {
+	if len(elem) > l.size {
+		return false, ErrElemSize
+	}
+	r, err := l.lock(name, true)
+	if err != nil {
+		return
+	}
+	defer r.unlock(&err)
+	if dropped = r.len == r.cap; !dropped {
+		r.len++
+	}
+	r.head = (r.head + r.cap - 1) % r.cap
+	e := r.elem(0)
+	for i := copy(e, elem); i < len(e); i++ {
+		e[i] = 0
+	}
+	return
+}
+
+// RPop the back element of the list name into dst, appending to it
+// false if the list is empty
+func (l *Lists) RPop(name string, dst []byte) (b []byte, ok bool, err error) {
+	r, err := l.lock(name, false)
+	if err == shm.ErrKeyNot {
+		return dst, false, nil
+	}
+	if err != nil {
+		return dst, false, err
+	}
+	defer r.unlock(&err)
+	if r.len == 0 {
+		return dst, false, nil
+	}
+	r.len--
+	return append(dst, r.elem(r.len)...), true, nil
+}
+
+// Trim the list name to at most n elements from the front
+func (l *Lists) Trim(name string, n int) (err error) {
+	r, err := l.lock(name, false)
+	if err == shm.ErrKeyNot {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	defer r.unlock(&err)
+	if n >= 0 && uint32(n) < r.len {
+		r.len = uint32(n)
+	}
+	return
+}
+
+// Len of the list name, 0 if none
+func (l *Lists) Len(name string) (n int, err error) {
+	r, err := l.lock(name, false)
+	if err == shm.ErrKeyNot {
+		return 0, nil
+	}
+	if err != nil {
+		return
+	}
+	defer r.unlock(&err)
+	return int(r.len), nil
+}
+
+// Range call fn with the elements of the list name from the front,
+// until fn return false, the list is locked meanwhile
+func (l *Lists) Range(name string, fn func(elem []byte) bool) (err error) {
+	r, err := l.lock(name, false)
+	if err == shm.ErrKeyNot {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	defer r.unlock(&err)
+	for i := uint32(0); i < r.len && fn(r.elem(i)); i++ {
+	}
+	return
+}
+
+// Close the map
+func (l *Lists) Close() error {
+	return l.m.Close()
+}
@@ -0,0 +1,49 @@
+package list
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLists(t *testing.T) {
+	name := "testlist.db"
+	defer os.Remove(name)
+	l, err := Open(name, 16, 8, 3, 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for i, e := range []string{"a", "b", "c", "d"} {
+		dropped, err := l.LPush("k", []byte(e))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dropped != (i == 3) {
+			t.Errorf("push %s: dropped %v", e, dropped)
+		}
+	}
+	var got string
+	if err = l.Range("k", func(e []byte) bool {
+		got += string(e[:1])
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got != "dcb" {
+		t.Errorf("expect dcb, got %s", got)
+	}
+	b, ok, err := l.RPop("k", nil)
+	if err != nil || !ok || string(b) != "b\x00" {
+		t.Errorf("pop: %q, %v, %v", b, ok, err)
+	}
+	if err = l.Trim("k", 1); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := l.Len("k"); err != nil || n != 1 {
+		t.Errorf("expect len 1, got %d, %v", n, err)
+	}
+	if _, ok, err = l.RPop("none", nil); err != nil || ok {
+		t.Errorf("pop none: %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,237 @@
+// Package loader turns a map into a read-through, write-through cache
+// shared by processes: a miss calls a Loader, once per key across the
+// goroutines of a process, and with Shared once across the processes,
+// storing the value loaded for the next reader
+package loader
+
+import (
+	"context"
+	"errors"
+	"github.com/fengyoulin/shm"
+	"sync"
+	"time"
+)
+
+// poll interval of a wait on the load of another process
+const pollInterval = 5 * time.Millisecond
+
+// ErrFlags on Shared flags zero, overlapping or outside shm.FlagMask
+var ErrFlags = errors.New("loading and loaded flags invalid")
+
+// Loader load the value of key missing from the cache
+type Loader func(ctx context.Context, key string) (value []byte, err error)
+
+// Storer write the value of key through to the source of the Loader
+type Storer func(ctx context.Context, key string, value []byte) error
+
+// Option changes the behaviour of New
+type Option func(*options)
+
+// options collected from New
+type options struct {
+	store   Storer
+	ttl     time.Duration
+	loading uint32
+	loaded  uint32
+	wait    time.Duration
+}
+
+// WriteThrough make Set write the value with s before the map
+func WriteThrough(s Storer) Option {
+	return func(o *options) {
+		o.store = s
+	}
+}
+
+// TTL of the values loaded or set, for a map with Expiration, so that
+// they are loaded again once expired
+func TTL(d time.Duration) Option {
+	return func(o *options) {
+		o.ttl = d
+	}
+}
+
+// Shared deduplicate the loads of processes sharing the map by the
+// application flags of the keys: loading is set on a key added for a
+// load in progress, loaded on a value stored, the cache owns the flags
+// of its keys
+// a process waits up to wait on the load of another, which may have
+// died, then loads the key itself
+func Shared(loading, loaded uint32, wait time.Duration) Option {
+	return func(o *options) {
+		o.loading = loading
+		o.loaded = loaded
+		o.wait = wait
+	}
+}
+
+// Cache over a map
+type Cache struct {
+	m     *shm.Map
+	load  Loader
+	o     options
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// a load in progress in this process
+type call struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// New cache of the values of m loaded by load
+func New(m *shm.Map, load Loader, opts ...Option) (*Cache, error) {
+	c := &Cache{m: m, load: load, calls: make(map[string]*call)}
+	for _, opt := range opts {
+		opt(&c.o)
+	}
+	if l, d := c.o.loading, c.o.loaded; l|d != 0 {
+		if l == 0 || d == 0 || l&d != 0 || (l|d)&^shm.FlagMask != 0 {
+			return nil, ErrFlags
+		}
+	}
+	return c, nil
+}
+
+// Map of the cache
+func (c *Cache) Map() *shm.Map {
+	return c.m
+}
+
+// Get append the value of key to dst, loading it on a miss
+// a value loaded is appended as the Loader returned it, a value
+// stored as Load of the map return it, padded to the value capacity
+// unless compressed
+// the callers waiting on a load in this process share its result, the
+// context error of the first caller included
+func (c *Cache) Get(ctx context.Context, key string, dst []byte) ([]byte, error) {
+	if b, err := c.cached(key, dst); err != shm.ErrKeyNot {
+		return b, err
+	}
+	v, err := c.do(ctx, key)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, v...), nil
+}
+
+// Set the value of key, written through the Storer of WriteThrough
+// first, if any
+func (c *Cache) Set(ctx context.Context, key string, value []byte) error {
+	if c.o.store != nil {
+		if err := c.o.store(ctx, key, value); err != nil {
+			return err
+		}
+	}
+	return c.store(key, value)
+}
+
+// the value of key stored in the map, ErrKeyNot if none
+func (c *Cache) cached(key string, dst []byte) ([]byte, error) {
+	if c.o.loaded == 0 {
+		return c.m.Load(key, dst)
+	}
+	if !c.isLoaded(key) {
+		return dst, shm.ErrKeyNot
+	}
+	b, err := c.m.Load(key, dst)
+	// deleted and added again for a load meanwhile
+	if err == nil && !c.isLoaded(key) {
+		return dst, shm.ErrKeyNot
+	}
+	return b, err
+}
+
+// key has a value stored, with Shared
+func (c *Cache) isLoaded(key string) bool {
+	f, err := c.m.GetFlags(key)
+	return err == nil && f&c.o.loaded != 0
+}
+
+// load key once for the callers in this process
+func (c *Cache) do(ctx context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	if cl, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-cl.done:
+			return cl.value, cl.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	cl := &call{done: make(chan struct{})}
+	c.calls[key] = cl
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+		close(cl.done)
+	}()
+	cl.value, cl.err = c.fill(ctx, key)
+	return cl.value, cl.err
+}
+
+// load and store key, once across the processes with Shared
+func (c *Cache) fill(ctx context.Context, key string) ([]byte, error) {
+	if c.o.loading == 0 {
+		return c.loadStore(ctx, key)
+	}
+	deadline := time.Now().Add(c.o.wait)
+	for {
+		claimed, err := c.m.CompareAndSwapFlags(key, 0, c.o.loading, true)
+		if err != nil {
+			return nil, err
+		}
+		if claimed {
+			v, err := c.loadStore(ctx, key)
+			if err != nil {
+				// for the waiters to claim it
+				c.m.Delete(key)
+			}
+			return v, err
+		}
+		// loaded by another process meanwhile
+		if b, err := c.cached(key, nil); err != shm.ErrKeyNot {
+			return b, err
+		}
+		if time.Now().After(deadline) {
+			return c.loadStore(ctx, key)
+		}
+		t := time.NewTimer(pollInterval)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// load key and store its value
+func (c *Cache) loadStore(ctx context.Context, key string) ([]byte, error) {
+	v, err := c.load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return v, c.store(key, v)
+}
+
+// store the value of key in the map
+func (c *Cache) store(key string, value []byte) error {
+	if err := c.m.Set(key, value); err != nil {
+		return err
+	}
+	if c.o.ttl > 0 {
+		if err := c.m.Touch(key, c.o.ttl); err != nil {
+			return err
+		}
+	}
+	if c.o.loaded == 0 {
+		return nil
+	}
+	return c.m.SetFlags(key, c.o.loaded)
+}
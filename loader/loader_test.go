@@ -0,0 +1,90 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"github.com/fengyoulin/shm"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	m, err := shm.Create("", 64, 15, 8, 0, time.Second, shm.InMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	var loads int32
+	release := make(chan struct{})
+	load := func(ctx context.Context, key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		if key == "bad" {
+			return nil, errors.New("no such key")
+		}
+		return []byte(key), nil
+	}
+	if _, err = New(m, load, Shared(1, 1, 0)); err != ErrFlags {
+		t.Errorf("expect ErrFlags, got %v", err)
+	}
+	// two caches sharing the map, as two processes
+	var cs [2]*Cache
+	for i := range cs {
+		if cs[i], err = New(m, load, Shared(1, 2, time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(c *Cache) {
+			defer wg.Done()
+			if v, err := c.Get(ctx, "k", nil); err != nil || string(v[:1]) != "k" {
+				t.Errorf("unexpected %q, %v", v, err)
+			}
+		}(cs[i%2])
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("expect 1 load, got %d", n)
+	}
+	if _, err = cs[0].Get(ctx, "bad", nil); err == nil {
+		t.Error("expect the error of the loader")
+	}
+	if m.Exists("bad") {
+		t.Error("expect the key of a failed load deleted")
+	}
+	// a load claimed by a process which died
+	if _, err = m.CompareAndSwapFlags("dead", 0, 1, true); err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(m, load, Shared(1, 2, 20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "dead", nil); err != nil || string(v) != "dead" {
+		t.Errorf("unexpected %q, %v", v, err)
+	}
+	if f, _ := m.GetFlags("dead"); f != 2 {
+		t.Errorf("expect flags loaded, got %d", f)
+	}
+	var stored string
+	wc, err := New(m, load, WriteThrough(func(ctx context.Context, key string, value []byte) error {
+		stored = key + "=" + string(value)
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = wc.Set(ctx, "w", []byte("1")); err != nil || stored != "w=1" {
+		t.Errorf("unexpected %q, %v", stored, err)
+	}
+	if v, err := wc.Get(ctx, "w", nil); err != nil || string(v[:1]) != "1" {
+		t.Errorf("unexpected %q, %v", v, err)
+	}
+}
@@ -0,0 +1,213 @@
+// Package log provides an append only log of records in memory shared
+// by processes, each record with its length and a crc, committed to
+// readers tailing it by an offset in the header, for streaming events
+// between processes or as a write ahead log
+package log
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/fengyoulin/shm/database"
+	"github.com/fengyoulin/shm/internal/futex"
+	"github.com/fengyoulin/shm/mapping"
+	"github.com/fengyoulin/shm/sync"
+	"hash/crc32"
+	"io"
+	"math"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// HeaderSize is the bytes before the records
+const HeaderSize = 64
+
+// bytes of a record before its data, the length and the crc
+const recordHeader = 8
+
+// longest a tailing reader sleeps before checking the log again
+const checkInterval = 100 * time.Millisecond
+
+var (
+	// ErrSize on a memory too small
+	ErrSize = errors.New("memory too small")
+	// ErrAlign on a memory not aligned to 8 bytes
+	ErrAlign = errors.New("memory not aligned")
+	// ErrFull on append with no more space
+	ErrFull = errors.New("log full")
+	// ErrCorrupt on read a record of a bad length or crc
+	ErrCorrupt = errors.New("log record corrupt")
+)
+
+// crc of the data of records
+var table = crc32.MakeTable(crc32.Castagnoli)
+
+// header in the memory
+type header struct {
+	// lock of appenders
+	mu uint32
+	// bumped on each commit, readers wait on it
+	seq uint32
+	// end of the committed records, readers read below it
+	committed uint64
+}
+
+// Log of records, each aligned to 8 bytes
+type Log struct {
+	head    *header
+	mu      *sync.Mutex
+	records []byte
+	// mapping of Open, nil with At
+	mp *mapping.Mapping
+}
+
+// At return the log in b, zeroed memory is an empty log
+func At(b []byte) (*Log, error) {
+	if len(b) < HeaderSize+recordHeader {
+		return nil, ErrSize
+	}
+	p := unsafe.Pointer(&b[0])
+	if uintptr(p)&7 != 0 {
+		return nil, ErrAlign
+	}
+	mu, err := sync.MutexAt(b)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{head: (*header)(p), mu: mu, records: b[HeaderSize:]}, nil
+}
+
+// Open the log in the file at path of size bytes, created if not
+// exist, waiting for at most wait for its initialization lock
+func Open(path string, size int, wait time.Duration) (l *Log, err error) {
+	mp, unlock, err := database.Open(path, size, wait)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if e := unlock(); err == nil {
+			err = e
+		}
+		if err != nil {
+			_ = mp.Close()
+			l = nil
+		}
+	}()
+	if l, err = At(mp.Bytes()); err != nil {
+		return
+	}
+	l.mp = mp
+	return
+}
+
+// bytes of a record of n bytes of data
+func recordSize(n int) uint64 {
+	return uint64(recordHeader+n+7) &^ 7
+}
+
+// Append a record of data, return its offset
+// it is visible to readers when Append returns
+func (l *Log) Append(data []byte) (off uint64, err error) {
+	if uint64(len(data)) > math.MaxUint32 {
+		return 0, ErrFull
+	}
+	if err = l.mu.Lock(); err != nil && err != sync.ErrOwnerDead {
+		return
+	}
+	// a dead appender did not commit, its record is overwritten
+	err = nil
+	defer func() {
+		if e := l.mu.Unlock(); err == nil {
+			err = e
+		}
+	}()
+	off = atomic.LoadUint64(&l.head.committed)
+	end := off + recordSize(len(data))
+	if end > uint64(len(l.records)) {
+		return 0, ErrFull
+	}
+	r := l.records[off:end]
+	binary.LittleEndian.PutUint32(r, uint32(len(data)))
+	binary.LittleEndian.PutUint32(r[4:], crc32.Checksum(data, table))
+	copy(r[recordHeader:], data)
+	atomic.StoreUint64(&l.head.committed, end)
+	atomic.AddUint32(&l.head.seq, 1)
+	futex.Wake(&l.head.seq, math.MaxInt32)
+	return
+}
+
+// Committed return the end of the committed records
+func (l *Log) Committed() uint64 {
+	return atomic.LoadUint64(&l.head.committed)
+}
+
+// ReadAt read the record at off, return its data and the offset of
+// the next record, io.EOF at the end of the committed records
+// the data is in the log memory, valid while it is open
+func (l *Log) ReadAt(off uint64) (data []byte, next uint64, err error) {
+	committed := atomic.LoadUint64(&l.head.committed)
+	if off >= committed {
+		return nil, off, io.EOF
+	}
+	if off&7 != 0 || committed-off < recordHeader {
+		return nil, off, ErrCorrupt
+	}
+	r := l.records[off:committed]
+	n := uint64(binary.LittleEndian.Uint32(r))
+	next = off + recordSize(int(n))
+	if next > committed {
+		return nil, off, ErrCorrupt
+	}
+	data = r[recordHeader : recordHeader+n : recordHeader+n]
+	if crc32.Checksum(data, table) != binary.LittleEndian.Uint32(r[4:]) {
+		return nil, off, ErrCorrupt
+	}
+	return
+}
+
+// Reader of the records from an offset
+type Reader struct {
+	l   *Log
+	off uint64
+}
+
+// Tail return a reader from the record at off, 0 for the first one,
+// Committed() for the records appended from now on
+func (l *Log) Tail(off uint64) *Reader {
+	return &Reader{l: l, off: off}
+}
+
+// Offset of the next record to read
+func (r *Reader) Offset() uint64 {
+	return r.off
+}
+
+// Next read the next record, waiting for one to be appended until ctx
+// is done, the data is in the log memory, valid while it is open
+func (r *Reader) Next(ctx context.Context) (data []byte, err error) {
+	for {
+		seq := atomic.LoadUint32(&r.l.head.seq)
+		var next uint64
+		data, next, err = r.l.ReadAt(r.off)
+		if err != io.EOF {
+			if err == nil {
+				r.off = next
+			}
+			return
+		}
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		futex.Wait(&r.l.head.seq, seq, checkInterval)
+	}
+}
+
+// Close the mapping of Open
+func (l *Log) Close() (err error) {
+	if l.mp != nil {
+		err = l.mp.Close()
+		l.mp = nil
+	}
+	return
+}
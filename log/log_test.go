@@ -0,0 +1,55 @@
+package log
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	name := "testlog.db"
+	defer os.Remove(name)
+	l, err := Open(name, HeaderSize+64, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	r := l.Tail(0)
+	got := make(chan string)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for {
+			data, err := r.Next(ctx)
+			if err != nil {
+				close(got)
+				return
+			}
+			got <- string(data)
+		}
+	}()
+	for _, s := range []string{"a", "hello", "", "0123456789"} {
+		if _, err = l.Append([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		if g := <-got; g != s {
+			t.Errorf("expect %q, got %q", s, g)
+		}
+	}
+	cancel()
+	<-got
+	if _, err = l.Append(make([]byte, 32)); err != ErrFull {
+		t.Errorf("expect ErrFull, got %v", err)
+	}
+	if data, next, err := l.ReadAt(16); err != nil || string(data) != "hello" || next != 32 {
+		t.Errorf("read at 16: %q, %d, %v", data, next, err)
+	}
+	if _, _, err = l.ReadAt(l.Committed()); err != io.EOF {
+		t.Errorf("expect io.EOF, got %v", err)
+	}
+	l.records[16+recordHeader] ^= 1
+	if _, _, err = l.ReadAt(16); err != ErrCorrupt {
+		t.Errorf("expect ErrCorrupt, got %v", err)
+	}
+}
@@ -1,11 +1,17 @@
 package shm
 
 import (
+	"context"
 	"errors"
 	"github.com/fengyoulin/shm/database"
 	"github.com/fengyoulin/shm/mapping"
+	shmsync "github.com/fengyoulin/shm/sync"
 	"hash/crc32"
+	"math/rand"
+	"os"
 	"reflect"
+	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -13,11 +19,104 @@ import (
 
 // Map is a shared map
 type Map struct {
-	mp   *mapping.Mapping
+	mp   Mapping
 	head *header
 	hash *[maxMapCap]hash
 	data uintptr
 	try  int
+	// value capacity of a bucket
+	vcap int
+	// hash slot count
+	nslots int32
+	// optional metadata in a bucket
+	meta metaLayout
+	// key offset in a bucket
+	keyOff uintptr
+	// operation counters of hash slots, nil if not kept
+	ops *[maxMapCap]uint32
+	// slot tickets of FairLocks, nil if not used, taken as owner
+	tickets *[maxMapCap]ticket
+	owner   uint32
+	// pages touched since the last flush, nil if not tracked
+	dirty []uint32
+	// background flush goroutine
+	stop chan struct{}
+	done chan struct{}
+	// called at crash points, for fault injection
+	hook func(point string)
+	// chain length bound, 0 if unbounded
+	maxChain int
+	// evict the chain tail at maxChain instead of failing
+	evict bool
+	// bound of the retries of an operation instead of try, 0 if none
+	lockTimeout time.Duration
+	// called with copies of evicted and expired entries
+	onEvict  func(key string, value []byte)
+	onExpire func(key string, value []byte)
+	// of Events, nil if not fed
+	onSet    func(key string, value []byte)
+	onDelete func(key string, value []byte)
+	events   *eventLog
+	// value compressor, nil if values are stored as is
+	comp *compressor
+	// turn faults on the mapping into ErrDetached
+	protect bool
+	// the backing file, empty if none
+	path string
+	// of CreateTemp, removed by the last closing it, waiting for at
+	// most wait for the database lock
+	temp bool
+	wait time.Duration
+	// generation of the file at open
+	gen uint32
+	// in-process chain locks of SingleProcess, nil if not used
+	shards []shardLock
+	// in-process lock of the free list with shards or single writer
+	list sync.Mutex
+	// buckets freed not yet on the free list, nil if not batched
+	batch *freeBatch
+	// free list record of FreeFIFO, nil if LIFO, locked as pid
+	fifo *fifo
+	pid  uint32
+	// a single writer, readers validate with the serial
+	writer bool
+	// of ReadOnly, the writes fail
+	readOnly bool
+	// address of the key locks, 0 if none
+	keyLocks uintptr
+	// words of WaitForKey, nil if none
+	keyWaits *[maxMapCap]uint32
+	// counter seeding the versions of new buckets, nil if none
+	verSeq *verSeq
+	// ring of AuditLog and its entries, nil if none, recorded as pid
+	auditLog  *audit
+	auditSize int
+}
+
+// Mapping is the memory a map lives in
+type Mapping interface {
+	// Bytes return the whole memory
+	Bytes() []byte
+	// Close release the memory
+	Close() error
+	// Sync flush the memory to its backing store
+	Sync() error
+	// Resize to size bytes, Bytes may move
+	Resize(size int) error
+}
+
+// RangeSyncer is a Mapping able to flush part of its memory
+type RangeSyncer interface {
+	// SyncRange flush [off, off+n) to the backing store
+	SyncRange(off, n int) error
+}
+
+// Reclaimer is a Mapping able to drop pages from memory, keeping
+// their content in the backing store
+type Reclaimer interface {
+	// DontNeed drop the pages of [off, off+n) from memory, off page
+	// aligned
+	DontNeed(off, n int) error
 }
 
 // header in database
@@ -30,9 +129,74 @@ type header struct {
 	dataOff    uint32
 	next       int32
 	deleteLink int32
-	_          [8]int32
+	valueSize  int32
+	features   uint32
+	align      int32
+	statOff    uint32
+	gen        uint32
+	// format a library must have to attach, at offset 52
+	format uint32
+	// lease of the Janitor, at offset 56
+	janitor uint64
 }
 
+// hash slot count, two tables for cuckoo
+func (h *header) slotCount() int32 {
+	if h.features&featCuckoo != 0 {
+		return 2 * h.cap
+	}
+	return h.cap
+}
+
+// features of the database format
+const (
+	// valueSize is recorded
+	featValueSize uint32 = 1 << iota
+	// buckets have created and updated timestamps
+	featTimes
+	// buckets have an access counter
+	featHits
+	// hash slots have an operation counter
+	featSlotOps
+	// keys live in the shorter of two chains
+	featTwoChoice
+	// two tables of slots, one bucket per slot
+	featCuckoo
+	// values may be snappy compressed
+	featSnappy
+	// values may be zstd compressed
+	featZstd
+	// one writer, readers validate chains with the serial
+	featSingleWriter
+	// buckets have an expiry time
+	featExpiry
+	// buckets have a version counter, a record seeds those of new ones
+	featVersion
+	// buckets have an in-flight marker
+	featFlight
+	// an origin record follows the header
+	featOrigin
+	// the last map closing the file removes it
+	featTemp
+	// hash slots have tickets ordering their writers
+	featTickets
+	// a fifo record follows the origin record
+	featFIFO
+	// values may span continuation buckets
+	featSpan
+	// hash slots have key locks for applications
+	featKeyLocks
+	// hash slots have a word to wait on for keys added
+	featKeyWaits
+	// an audit ring follows the acl record
+	featAudit
+	// an acl record follows the fifo record
+	featACL
+)
+
+// features changing the layout, must match on open
+const layoutFeatures = featTimes | featHits | featSlotOps | featTwoChoice | featCuckoo | featSnappy | featZstd | featSingleWriter | featExpiry | featVersion | featFlight | featOrigin | featTickets | featFIFO | featSpan | featKeyLocks | featKeyWaits | featAudit | featACL
+
 // hash as [4]int32
 // 1st for index
 // 2nd for serial
@@ -42,25 +206,28 @@ type hash [4]int32
 
 // bucket header
 type bucket struct {
-	next int32
-	hash int32
-	used int32
-	_    int32
+	next  int32
+	hash  int32
+	used  int32
+	flags uint32
 	// key [keySize]byte
 	// value [bucketSize]byte
 }
 
+// pid of the process, the holder of the chain locks it takes
+var selfPID = int32(os.Getpid())
+
 const (
 	maxMapCap  = 64 * 1024 * 1024
-	minKeySize = 8
 	maxKeySize = 256
 	maxBktSize = 4096
+	minAlign   = 16
 )
 
 var (
 	// ErrMapCap on param validate
 	ErrMapCap = errors.New("map cap too large or too small")
-	// ErrKeyLen on param validate
+	// ErrKeyLen on param validate, or a key longer than KeyCap
 	ErrKeyLen = errors.New("key too long or too short")
 	// ErrValLen on param validate
 	ErrValLen = errors.New("value too large or too small")
@@ -72,15 +239,73 @@ var (
 	ErrDbFull = errors.New("no more space in map")
 	// ErrTryEnd on add or delete
 	ErrTryEnd = errors.New("cannot add after too many tries")
+	// ErrLockTimeout on an operation failing for LockTimeout
+	ErrLockTimeout = errors.New("lock wait timed out")
+	// ErrAlign on param validate
+	ErrAlign = errors.New("bucket alignment invalid")
+	// ErrChainLong on add to a chain at MaxChain
+	ErrChainLong = errors.New("hash chain too long")
+	// ErrNoSpace on Create of a map the file system has no room for
+	ErrNoSpace = database.ErrNoSpace
+	// ErrAttached on Exclusive of a map other processes are attached to
+	ErrAttached = database.ErrAttached
+	// ErrReadOnly on a write to a map opened ReadOnly
+	ErrReadOnly = errors.New("map opened read only")
 )
 
 // Create or open a shared map database
-func Create(path string, mapCap, keyLen, valueLen, maxTry int, wait time.Duration) (m *Map, err error) {
-	var hdr header
-	if maxTry <= 0 {
-		maxTry = 20
+func Create(path string, mapCap, keyLen, valueLen, maxTry int, wait time.Duration, opts ...Option) (m *Map, err error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	hdr, size, err := layout(mapCap, keyLen, valueLen, &o)
+	if err != nil {
+		return
+	}
+	mp, ul, err := open(path, size, wait, &o)
+	if err != nil {
+		return
+	}
+	defer func() {
+		// close db if unlock failed
+		if e := ul(); e != nil && err == nil {
+			err = e
+			_ = m.Close()
+		}
+	}()
+	m, err = newMap(mp, &hdr, maxTry, &o)
+	if err == nil && !o.memory {
+		m.path = path
+		m.temp = m.head.features&featTemp != 0
+		m.wait = wait
 	}
-	if mapCap <= 0 || mapCap > maxMapCap {
+	return
+}
+
+// NewFromMapping create or open a map in a user supplied mapping
+// which must be at least EstimateSize bytes, Close of the map close mp
+// the caller must serialize concurrent initialization of mp
+func NewFromMapping(mp Mapping, mapCap, keyLen, valueLen, maxTry int, opts ...Option) (m *Map, err error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	hdr, size, err := layout(mapCap, keyLen, valueLen, &o)
+	if err != nil {
+		return
+	}
+	if len(mp.Bytes()) < size {
+		err = ErrDbSize
+		return
+	}
+	return newMap(mp, &hdr, maxTry, &o)
+}
+
+// compute the header and the total size of a map
+func layout(mapCap, keyLen, valueLen int, o *options) (hdr header, size int, err error) {
+	// the two tables of cuckoo share the slot array
+	if mapCap <= 0 || mapCap > maxMapCap || o.cuckoo && mapCap > maxMapCap/2 {
 		err = ErrMapCap
 		return
 	}
@@ -95,54 +320,265 @@ func Create(path string, mapCap, keyLen, valueLen, maxTry int, wait time.Duratio
 		mapCap = 8
 	}
 	hdr.cap = int32(mapCap)
-	if keyLen < minKeySize-1 || keyLen > maxKeySize-1 {
+	// the length byte and the alignment bound the minimum
+	if keyLen < 0 || keyLen > maxKeySize-1 {
 		err = ErrKeyLen
 		return
 	}
 	// plus one byte for length
 	keyLen = (keyLen + 1 + 3) & (^3)
 	hdr.keySize = int32(keyLen)
-	if valueLen < 0 || valueLen > maxBktSize-int(unsafe.Sizeof(bucket{}))-keyLen {
+	hdr.features |= featValueSize
+	if o.timestamps {
+		hdr.features |= featTimes
+	}
+	if o.counters {
+		hdr.features |= featHits
+	}
+	if o.expiry {
+		hdr.features |= featExpiry
+	}
+	if o.versions {
+		hdr.features |= featVersion
+	}
+	if o.flight {
+		hdr.features |= featFlight
+	}
+	if o.slotStats {
+		hdr.features |= featSlotOps
+	}
+	if o.origin {
+		hdr.features |= featOrigin
+	}
+	if o.temp {
+		hdr.features |= featTemp
+	}
+	if o.fair {
+		hdr.features |= featTickets
+	}
+	if o.fifo {
+		hdr.features |= featFIFO
+	}
+	if o.span {
+		hdr.features |= featSpan
+	}
+	if o.keyLocks {
+		hdr.features |= featKeyLocks
+	}
+	if o.keyWaits {
+		hdr.features |= featKeyWaits
+	}
+	if o.audit > 0 {
+		hdr.features |= featAudit
+	}
+	if o.acl {
+		if len(o.aclWriters) > aclUIDs || len(o.aclReaders) > aclUIDs {
+			err = ErrACLSize
+			return
+		}
+		hdr.features |= featACL
+	}
+	if o.twoChoice {
+		hdr.features |= featTwoChoice
+	}
+	if o.cuckoo {
+		hdr.features |= featCuckoo
+	}
+	if o.writer {
+		if o.twoChoice || o.cuckoo {
+			err = ErrWriterSlots
+			return
+		}
+		hdr.features |= featSingleWriter
+	}
+	if o.codec != 0 {
+		f := o.codec.feature()
+		if f == 0 {
+			err = ErrCodec
+			return
+		}
+		hdr.features |= f
+	}
+	var ml metaLayout
+	keyOff := int(ml.init(hdr.features))
+	if valueLen < 0 || valueLen > maxBktSize-keyOff-keyLen {
 		err = ErrValLen
 		return
 	}
-	bktLen := int(unsafe.Sizeof(bucket{})) + keyLen + valueLen
-	// round up to multiples of 16
-	bktLen = (bktLen + 15) & (^15)
+	hdr.valueSize = int32(valueLen)
+	align := o.align
+	if align == 0 {
+		align = minAlign
+	}
+	if align < minAlign || align > maxBktSize || align&(align-1) != 0 {
+		err = ErrAlign
+		return
+	}
+	hdr.align = int32(align)
+	bktLen := keyOff + keyLen + valueLen
+	// round up to multiples of align
+	bktLen = (bktLen + align - 1) & (^(align - 1))
 	hdr.bucketSize = int32(bktLen)
-	// hash area after header
+	// hash area after header, the origin, the fifo, the acl, the
+	// version counter and the audit records
 	hdr.hashOff = uint32(unsafe.Sizeof(hdr))
+	if hdr.features&featOrigin != 0 {
+		hdr.hashOff += uint32(unsafe.Sizeof(origin{}))
+	}
+	if hdr.features&featFIFO != 0 {
+		hdr.hashOff += uint32(unsafe.Sizeof(fifo{}))
+	}
+	if hdr.features&featACL != 0 {
+		hdr.hashOff += uint32(unsafe.Sizeof(acl{}))
+	}
+	if hdr.features&featVersion != 0 {
+		hdr.hashOff += uint32(unsafe.Sizeof(verSeq{}))
+	}
+	if hdr.features&featAudit != 0 {
+		hdr.hashOff += uint32(unsafe.Sizeof(audit{}) + uintptr(o.audit)*unsafe.Sizeof(auditEntry{}))
+	}
 	// hash area size
-	hashSize := int(unsafe.Sizeof(hash{})) * mapCap
+	hashSize := int(unsafe.Sizeof(hash{})) * int(hdr.slotCount())
 	hdr.dataOff = hdr.hashOff + uint32(hashSize)
+	// slot counters after hash area
+	if hdr.features&featSlotOps != 0 {
+		hdr.statOff = hdr.dataOff
+		hdr.dataOff += uint32(unsafe.Sizeof(uint32(0))) * uint32(mapCap)
+	}
+	// slot tickets after them
+	if hdr.features&featTickets != 0 {
+		hdr.dataOff += uint32(unsafe.Sizeof(ticket{})) * uint32(hdr.slotCount())
+	}
+	// key locks after them
+	if hdr.features&featKeyLocks != 0 {
+		hdr.dataOff += shmsync.MutexSize * uint32(hdr.slotCount())
+	}
+	// key waits after them
+	if hdr.features&featKeyWaits != 0 {
+		hdr.dataOff += uint32(unsafe.Sizeof(uint32(0))) * uint32(hdr.slotCount())
+	}
+	// buckets start aligned too
+	hdr.dataOff = (hdr.dataOff + uint32(align) - 1) & (^(uint32(align) - 1))
 	// total size, header + hash + buckets
-	size := int(hdr.dataOff) + int(hdr.cap*hdr.bucketSize)
-	mp, ul, err := database.Open(path, size, wait)
-	if err != nil {
-		return
+	size = int(hdr.dataOff) + int(hdr.cap*hdr.bucketSize)
+	return
+}
+
+// EstimateSize return the bytes a map with the params needs
+func EstimateSize(mapCap, keyLen, valueLen int, opts ...Option) (int, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	_, size, err := layout(mapCap, keyLen, valueLen, &o)
+	return size, err
+}
+
+// init a map in mp, close mp on failure
+func newMap(mp Mapping, hdr *header, maxTry int, o *options) (m *Map, err error) {
+	if maxTry <= 0 {
+		maxTry = 20
 	}
-	defer func() {
-		// close db if unlock failed
-		if e := ul(); e != nil && err == nil {
-			err = e
-			_ = m.Close()
-		}
-	}()
 	m = &Map{
 		mp:  mp,
 		try: maxTry,
 	}
-	err = m.init(&hdr)
+	err = m.init(hdr, o)
+	// a uid not in the acl fails, a reader opens read only
+	readOnly := o.readOnly
+	if err == nil && m.head.features&featACL != 0 {
+		var ro bool
+		ro, err = aclOf(m.head).check()
+		readOnly = readOnly || ro
+	}
 	// close db if init failed
 	if err != nil {
 		_ = m.Close()
+		m = nil
+		return
+	}
+	m.comp, err = newCompressor(m.head.features, o.threshold)
+	if err != nil {
+		_ = m.Close()
+		m = nil
+		return
+	}
+	m.hook = o.hook
+	if o.freeBatch > 1 {
+		m.batch = &freeBatch{size: o.freeBatch, age: o.freeAge}
+	}
+	m.protect = o.protect
+	m.readOnly = readOnly
+	// chains shared by keys of two slots keep the slot locks
+	m.writer = m.head.features&featSingleWriter != 0
+	if o.single && !m.writer && m.head.features&(featTwoChoice|featCuckoo) == 0 {
+		m.shards = make([]shardLock, numShards)
+	}
+	m.maxChain = o.maxChain
+	m.evict = o.evict
+	m.lockTimeout = o.lockTimeout
+	m.onEvict = o.onEvict
+	m.onExpire = o.onExpire
+	if o.events != nil {
+		m.feedEvents(o.events, o.eventKinds)
+	}
+	m.startSync(o.syncInterval, o.syncDirty)
+	return
+}
+
+// open the backend, return with the database lock held
+func open(path string, size int, wait time.Duration, o *options) (mp Mapping, unlock func() error, err error) {
+	if o.memory {
+		mp = mapping.NewMemory(size)
+		unlock = func() error { return nil }
+		return
+	}
+	var dbOpts []database.Option
+	if o.prefault {
+		dbOpts = append(dbOpts, database.Prefault())
+	}
+	if o.reserve {
+		dbOpts = append(dbOpts, database.Reserve())
+	}
+	ctx := o.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	if o.segSize > 0 {
+		sm, ul, e := database.OpenSegments(ctx, path, size, o.segSize, dbOpts...)
+		if e != nil {
+			return nil, nil, e
+		}
+		return sm, ul, nil
+	}
+	fm, unlock, err := database.OpenContext(ctx, path, size, dbOpts...)
+	if err != nil {
 		return
 	}
+	mp = fm
 	return
 }
 
-// Close the shared map database
+// Close the shared map database, the last map of CreateTemp closing
+// the file removes it
 func (m *Map) Close() error {
+	if m.temp {
+		return m.CloseWith()
+	}
+	return m.close()
+}
+
+// stop the background flush and unmap
+func (m *Map) close() error {
+	m.stopSync()
+	if m.batch != nil {
+		m.batch.close(m)
+	}
+	if m.comp != nil && m.comp.dec != nil {
+		m.comp.dec.Close()
+	}
 	err := m.mp.Close()
 	m.mp = nil
 	m.head = nil
@@ -152,18 +588,119 @@ func (m *Map) Close() error {
 }
 
 // Get or add an key
-// return the value in a byte slice on success
+// return the value in a byte slice on success, the slice has
+// the valueLen of Create, empty but not nil if valueLen is 0
+// the bucket padding after valueLen is not in it, of a map created
+// before the value size was recorded the slice has the whole space
 // return error on failure if !add, maybe because of:
 // too many tries on a highly parallel situation, or
 // no more space in the database, or
 // hash func failed
+// a value stored compressed is returned as a decompressed copy, one
+// spanning continuation buckets as an assembled copy of its length,
+// writes to them do not reach the map
+// a key added is audited as set and wakes WaitForKey before the caller
+// writes through b, so the waiter may read the value zero, Set to wake
+// it on a value; one already in the map is neither
 func (m *Map) Get(key string, add bool) (b []byte, err error) {
-	h, err := hashFunc(key)
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	// tells whether the key was added
+	var c *claim
+	if add {
+		c = &claim{}
+	}
+	bkt, err := m.lookupClaim(key, add, c)
 	if err != nil {
 		return
 	}
-	ptr := m.hashPtr(h)
-	try := m.try
+	if c != nil && c.added {
+		m.auditOp(AuditSet, key)
+		m.wakeKey(key)
+	}
+	if missing(bkt) {
+		return nil, ErrMissing
+	}
+	// the caller may write through b
+	m.markDirty(unsafe.Pointer(bkt), uintptr(m.head.bucketSize))
+	m.addHit(bkt)
+	return m.valueOf(bkt)
+}
+
+// Set the value of key, add the key if not exist
+// a value shorter than the value capacity is zero padded
+// a longer one return a *ValueLenError, unless it compresses to fit
+func (m *Map) Set(key string, value []byte) (err error) {
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	b, compressed, err := m.encode(value)
+	if err != nil {
+		return err
+	}
+	err = m.locked(key, true, func(bkt *bucket) error {
+		if err := m.store(bkt, b, compressed, len(value)); err != nil {
+			return err
+		}
+		m.setUpdated(bkt)
+		m.bumpVersion(bkt)
+		return nil
+	})
+	if err == nil && m.onSet != nil {
+		m.onSet(key, value)
+	}
+	return
+}
+
+// find or add the bucket of key
+// an expired entry is deleted by writers only: readers of ReadOnly or
+// of SingleWriter, which takes no locks from the other processes, leave
+// it to the writer
+func (m *Map) lookup(key string, add bool) (bkt *bucket, err error) {
+	return m.lookupClaim(key, add, nil)
+}
+
+// lookup adding key with the in-flight marker of c, if not nil
+func (m *Map) lookupClaim(key string, add bool, c *claim) (bkt *bucket, err error) {
+	if m.readOnly && add {
+		return nil, ErrReadOnly
+	}
+	if m.readOnly || m.writer && !add {
+		if bkt, err = m.lookupBucket(key, false, nil); err == nil && m.expired(bkt) {
+			return nil, ErrKeyNot
+		}
+		return
+	}
+	bkt, err = m.lookupBucket(key, add, c)
+	if err == nil && m.expired(bkt) {
+		m.deleteNotify(key, m.expired, AuditExpire, m.onExpire)
+		bkt, err = m.lookupBucket(key, add, c)
+	}
+	return
+}
+
+// lookup of a bucket expired or not, a bucket added with the in-flight
+// marker of c, if not nil
+func (m *Map) lookupBucket(key string, add bool, c *claim) (bkt *bucket, err error) {
+	if err = m.waitFence(); err != nil {
+		return
+	}
+	ss, n, err := m.slots(key)
+	if err != nil {
+		return
+	}
+	m.countOp(ss[0].h)
+	if m.shards != nil {
+		return m.lookupSingle(&ss[0], key, add, c)
+	}
+	if m.writer {
+		return m.lookupWriter(&ss[0], key, add, c)
+	}
+	if add {
+		defer m.turn(ss[0].h)()
+	}
+	r := m.retries()
 	var newIdx int32
 	var target *bucket
 	defer func() {
@@ -172,19 +709,15 @@ func (m *Map) Get(key string, add bool) (b []byte, err error) {
 		}
 	}()
 	var lastCheck bool
-	for try > 0 {
-		try--
-		index := ptr.index()
-		serial := ptr.serial()
-		// traverse the bucket chain
-		for idx := index; idx >= 0; {
-			bkt := m.bucket(idx)
-			if key != bkt.key() {
-				idx = bkt.next
-				continue
+	for r.next() {
+		// traverse the bucket chains
+		for i := 0; i < n; i++ {
+			ss[i].load()
+		}
+		for i := 0; i < n; i++ {
+			if _, bkt, _ = m.find(ss[i].index, key); bkt != nil {
+				return
 			}
-			b = bkt.value(m)
-			return
 		}
 		// last check on no space
 		if lastCheck {
@@ -193,14 +726,22 @@ func (m *Map) Get(key string, add bool) (b []byte, err error) {
 		}
 		// not found
 		if !add {
+			// maybe moved between its slots meanwhile, by a cuckoo add
+			if n > 1 && changed(ss[:n]) {
+				continue
+			}
 			err = ErrKeyNot
 			return
 		}
+		if m.nslots == m.head.cap && m.maxChain > 0 && !m.evict && shortest(ss[:n]).ptr.length() >= m.maxChain {
+			err = ErrChainLong
+			return
+		}
 		if target == nil {
 			newIdx = m.alloc()
 			if newIdx < 0 {
 				// maybe just added by some other, do last check
-				if ptr.serial() != serial {
+				if changed(ss[:n]) {
 					lastCheck = true
 					continue
 				}
@@ -209,88 +750,340 @@ func (m *Map) Get(key string, add bool) (b []byte, err error) {
 			}
 			target = m.bucket(newIdx)
 			target.setKey(m, key)
-			target.hash = h
+			target.flags = 0
+			m.setCreated(target)
+			m.resetHits(target)
+			m.resetExpiry(target)
+			m.resetVersion(target)
+			m.resetFlight(target, c)
+			m.point("get.alloc")
 		}
-		// lock succeed if serial not changed
-		if ptr.lock(serial) {
-			target.next = index
+		if m.nslots != m.head.cap {
+			var ok bool
+			if ok, err = m.cuckooAdd(ss[:n], target, newIdx); err != nil {
+				return
+			}
+			if ok {
+				bkt, target = target, nil
+				c.link()
+				return
+			}
+			continue
+		}
+		// lock succeed if serials not changed
+		if lockAll(ss[:n]) {
+			dst := shortest(ss[:n])
+			ptr := dst.ptr
+			evicted := int32(-1)
+			if m.maxChain > 0 && ptr.length() >= m.maxChain {
+				if !m.evict {
+					unlockAll(ss[:n])
+					err = ErrChainLong
+					return
+				}
+				if evicted = m.evictTail(ptr); evicted < 0 {
+					unlockAll(ss[:n])
+					err = ErrChainLong
+					return
+				}
+			}
+			target.hash = dst.h
+			target.next = ptr.index()
 			ptr.setIndex(newIdx)
+			m.point("get.link")
 			target.used = 1
 			ptr.addLength(1)
-			ptr.unlock()
+			unlockAll(ss[:n])
 			atomic.AddInt32(&m.head.len, 1)
-			b = target.value(m)
-			target = nil
+			m.markBucket(target, ptr)
+			if evicted >= 0 {
+				// unlinked, not reused before freed
+				ev := m.removal(m.bucket(evicted), m.onEvict)
+				m.auditOp(AuditEvict, m.bucket(evicted).key(m))
+				m.free(evicted)
+				ev.notify()
+			}
+			bkt, target = target, nil
+			c.link()
 			return
 		}
 	}
-	return nil, ErrTryEnd
+	return nil, r.err()
+}
+
+// find key in the chain from index, with the bucket before it
+func (m *Map) find(index int32, key string) (last, bkt *bucket, idx int32) {
+	for idx = index; idx >= 0; {
+		b := m.bucket(idx)
+		if b.next >= 0 {
+			m.touch(b.next)
+		}
+		if key != b.key(m) {
+			last = b
+			idx = b.next
+			continue
+		}
+		bkt = b
+		return
+	}
+	return nil, nil, -1
+}
+
+// run fn on the bucket of key with its chain locked, wake the waiters
+// for key once added
+func (m *Map) locked(key string, add bool, fn func(bkt *bucket) error) error {
+	err := m.lockedKey(key, add, nil, fn)
+	if add && err == nil {
+		m.wakeKey(key)
+	}
+	return err
+}
+
+// lockedKey without waking, key added with the in-flight marker of c,
+// if not nil
+func (m *Map) lockedKey(key string, add bool, c *claim, fn func(bkt *bucket) error) error {
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	if err := m.waitFence(); err != nil {
+		return err
+	}
+	if m.shards != nil || m.writer {
+		if m.meta.expiry != 0 {
+			m.deleteNotify(key, m.expired, AuditExpire, m.onExpire)
+		}
+		ss, _, err := m.slots(key)
+		if err != nil {
+			return err
+		}
+		m.countOp(ss[0].h)
+		if m.writer {
+			return m.lockedWriter(&ss[0], key, add, c, fn)
+		}
+		return m.lockedSingle(&ss[0], key, add, c, fn)
+	}
+	if m.tickets != nil {
+		ss, _, err := m.slots(key)
+		if err != nil {
+			return err
+		}
+		defer m.turn(ss[0].h)()
+	}
+	r := m.retries()
+	for r.next() {
+		bkt, err := m.lookupClaim(key, add, c)
+		if err != nil {
+			return err
+		}
+		ptr := bkt.hashPtr(m)
+		if !ptr.lock(ptr.serial()) {
+			continue
+		}
+		// deleted or reused before locked
+		if bkt.used == 0 || bkt.key(m) != key {
+			ptr.unlock()
+			continue
+		}
+		err = fn(bkt)
+		m.markBucket(bkt, ptr)
+		ptr.unlock()
+		return err
+	}
+	return r.err()
+}
+
+// Exists report whether key is in the map, with a valueLen of 0
+// the map is a set shared by processes, Get(key, true) to add
+func (m *Map) Exists(key string) bool {
+	_, err := m.Get(key, false)
+	return err == nil
 }
 
 // Delete a key
 // return false on failure, maybe because of:
 // too many tries on a highly parallel situation, or
-// hash func failed
+// hash func failed, or
+// the map opened ReadOnly
 func (m *Map) Delete(key string) bool {
-	h, err := hashFunc(key)
+	if m.protect {
+		var err error
+		defer m.protected(&err)()
+	}
+	_, ok := m.deleteIfNotify(key, nil, AuditDelete, m.onDelete)
+	return ok
+}
+
+// delete key if cond is nil or true of its bucket, called under the
+// chain lock, ok false on failure as of Delete
+func (m *Map) deleteIf(key string, cond func(bkt *bucket) bool) (deleted, ok bool) {
+	if m.readOnly {
+		return
+	}
+	ss, n, err := m.slots(key)
 	if err != nil {
-		return false
-	}
-	ptr := m.hashPtr(h)
-	try := m.try
-	for try > 0 {
-		try--
-		index := ptr.index()
-		serial := ptr.serial()
+		return
+	}
+	m.countOp(ss[0].h)
+	if m.shards != nil {
+		return m.deleteSingle(&ss[0], key, cond), true
+	}
+	if m.writer {
+		return m.deleteWriter(&ss[0], key, cond)
+	}
+	defer m.turn(ss[0].h)()
+	for r := m.retries(); r.next(); {
 		var last, target *bucket
-		// traverse the bucket chain
-		idx := index
-		for idx >= 0 {
-			bkt := m.bucket(idx)
-			if key != bkt.key() {
-				last = bkt
-				idx = bkt.next
-				continue
+		var idx int32
+		var sl *slot
+		// traverse the bucket chains
+		for i := 0; i < n; i++ {
+			sl = &ss[i]
+			sl.load()
+			if last, target, idx = m.find(sl.index, key); target != nil {
+				break
 			}
-			target = bkt
-			break
 		}
 		// not found
 		if target == nil {
-			return true
+			return false, true
 		}
+		ptr := sl.ptr
 		// lock succeed if serial not changed
-		if ptr.lock(serial) {
+		if ptr.lock(sl.serial) {
+			if cond != nil && !cond(target) {
+				ptr.release()
+				return false, true
+			}
 			target.used = 0
+			m.point("delete.mark")
 			if last != nil {
 				last.next = target.next
+				m.markDirty(unsafe.Pointer(last), unsafe.Sizeof(bucket{}))
 			} else {
 				ptr.setIndex(target.next)
 			}
 			ptr.addLength(-1)
 			ptr.unlock()
 			atomic.AddInt32(&m.head.len, -1)
+			m.markBucket(target, ptr)
+			m.point("delete.unlink")
 			m.free(idx)
-			return true
+			return true, true
 		}
 	}
-	return false
+	return
 }
 
 // Foreach key/value pair in the map call fn
 // stop on fn return false or finished
+// values failing to decompress are skipped
 func (m *Map) Foreach(fn func(key string, value []byte) bool) {
+	if m.protect {
+		var err error
+		defer m.protected(&err)()
+	}
 	for i := int32(0); i < m.head.cap; i++ {
+		if j := i + prefetchAhead; j < m.head.cap {
+			m.touch(j)
+		}
 		bkt := m.bucket(i)
-		if bkt.used == 0 {
+		if bkt.used == 0 || missing(bkt) {
+			continue
+		}
+		v, err := m.valueOf(bkt)
+		if err != nil {
 			continue
 		}
-		if !fn(bkt.key(), bkt.value(m)) {
+		if !fn(bkt.key(m), v) {
 			return
 		}
 	}
 }
 
+// ForeachParallel is Foreach with the buckets split across workers goroutines
+// fn is called concurrently and must be safe for that
+// stop all workers on fn return false, workers <= 0 use one per cpu
+func (m *Map) ForeachParallel(workers int, fn func(key string, value []byte) bool) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	n := m.head.cap
+	if int32(workers) > n {
+		workers = int(n)
+	}
+	if workers <= 1 {
+		m.Foreach(fn)
+		return
+	}
+	var stop int32
+	var wg sync.WaitGroup
+	part := (n + int32(workers) - 1) / int32(workers)
+	for from := int32(0); from < n; from += part {
+		to := from + part
+		if to > n {
+			to = n
+		}
+		wg.Add(1)
+		go func(from, to int32) {
+			defer wg.Done()
+			if m.protect {
+				var err error
+				defer m.protected(&err)()
+			}
+			for i := from; i < to; i++ {
+				if j := i + prefetchAhead; j < to {
+					m.touch(j)
+				}
+				bkt := m.bucket(i)
+				if bkt.used == 0 || missing(bkt) {
+					continue
+				}
+				// check now and then, an atomic load per bucket is wasteful
+				if i&63 == 0 && atomic.LoadInt32(&stop) != 0 {
+					return
+				}
+				v, err := m.valueOf(bkt)
+				if err != nil {
+					continue
+				}
+				if !fn(bkt.key(m), v) {
+					atomic.StoreInt32(&stop, 1)
+					return
+				}
+			}
+		}(from, to)
+	}
+	wg.Wait()
+}
+
+// Sample return up to n distinct live keys chosen uniformly at random
+// by probing random buckets, fewer if the map is sparse or changing
+// expired entries and those known missing are skipped
+func (m *Map) Sample(n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	next := atomic.LoadInt32(&m.head.next)
+	if next == 0 {
+		return nil
+	}
+	keys := make([]string, 0, n)
+	seen := make(map[int32]struct{}, n)
+	for try := 64 * n; try > 0 && len(keys) < n; try-- {
+		i := rand.Int31n(next)
+		if _, ok := seen[i]; ok {
+			continue
+		}
+		bkt := m.bucket(i)
+		if bkt.used == 0 || missing(bkt) || m.expired(bkt) {
+			continue
+		}
+		seen[i] = struct{}{}
+		keys = append(keys, bkt.key(m))
+	}
+	return keys
+}
+
 // Cap return map capacity, cannot grow
 func (m *Map) Cap() int {
 	return int(m.head.cap)
@@ -302,12 +1095,15 @@ func (m *Map) Len() int {
 }
 
 // from a exist db, or a newly created one
-func (m *Map) init(h *header) error {
+func (m *Map) init(h *header, o *options) error {
 	data := m.mp.Bytes()
 	sh := (*reflect.SliceHeader)(unsafe.Pointer(&data))
 	head := (*header)(unsafe.Pointer(sh.Data))
 	if head.cap != 0 {
 		// this branch opened a exist db
+		if err := checkFormat(head); err != nil {
+			return err
+		}
 		if head.cap != h.cap ||
 			head.keySize != h.keySize ||
 			head.bucketSize != h.bucketSize ||
@@ -315,10 +1111,21 @@ func (m *Map) init(h *header) error {
 			head.dataOff != h.dataOff {
 			return ErrDbSize
 		}
+		// older databases did not record the value size
+		if head.features&featValueSize != 0 && head.valueSize != h.valueSize {
+			return ErrDbSize
+		}
+		if head.features&layoutFeatures != h.features&layoutFeatures {
+			return ErrDbSize
+		}
+		// nor the alignment, which was always 16
+		if align := head.align; align != h.align && (align != 0 || h.align != minAlign) {
+			return ErrDbSize
+		}
 	} else {
 		// new db, init hash area, set index to -1
 		hs := (*[maxMapCap]hash)(unsafe.Pointer(sh.Data + uintptr(h.hashOff)))
-		for i := 0; i < int(h.cap); i++ {
+		for i := 0; i < int(h.slotCount()); i++ {
 			(*hs)[i][0] = -1
 		}
 		// set deleted link to -1
@@ -328,19 +1135,127 @@ func (m *Map) init(h *header) error {
 		head.bucketSize = h.bucketSize
 		head.hashOff = h.hashOff
 		head.dataOff = h.dataOff
+		head.valueSize = h.valueSize
+		head.features = h.features
+		head.align = h.align
+		head.statOff = h.statOff
+		head.format = formatVersion
+		if h.features&featOrigin != 0 {
+			originOf(head).record()
+		}
+		if h.features&featFIFO != 0 {
+			fifoOf(head).init(o.quarantine)
+		}
+		if h.features&featACL != 0 {
+			aclOf(head).init(o.aclWriters, o.aclReaders)
+		}
 		// set cap at the end
 		head.cap = h.cap
 	}
 	m.head = head
+	m.gen = atomic.LoadUint32(&head.gen)
 	m.hash = (*[maxMapCap]hash)(unsafe.Pointer(sh.Data + uintptr(head.hashOff)))
+	m.nslots = head.slotCount()
 	m.data = sh.Data + uintptr(head.dataOff)
+	if head.features&featSlotOps != 0 {
+		m.ops = (*[maxMapCap]uint32)(unsafe.Pointer(&data[head.statOff]))
+	}
+	if head.features&featTickets != 0 {
+		m.tickets = (*[maxMapCap]ticket)(unsafe.Pointer(&data[head.ticketOff()]))
+		m.owner = turnOwner()
+	}
+	if head.features&featKeyLocks != 0 {
+		m.keyLocks = sh.Data + uintptr(head.keyLockOff())
+	}
+	if head.features&featKeyWaits != 0 {
+		m.keyWaits = (*[maxMapCap]uint32)(unsafe.Pointer(&data[head.keyWaitOff()]))
+	}
+	if head.features&featFIFO != 0 {
+		m.fifo = fifoOf(head)
+		m.pid = uint32(os.Getpid())
+	}
+	if head.features&featVersion != 0 {
+		m.verSeq = verSeqOf(head)
+	}
+	if head.features&featAudit != 0 {
+		m.auditLog = auditOf(head)
+		m.auditSize = head.auditSize()
+		m.pid = uint32(os.Getpid())
+	}
+	m.keyOff = m.meta.init(head.features)
+	if head.features&featValueSize != 0 {
+		m.vcap = int(head.valueSize)
+	} else {
+		m.vcap = int(head.bucketSize) - int(m.keyOff) - int(head.keySize)
+	}
 	return nil
 }
 
+// the last bucket of the chain from index, with the one before it
+func (m *Map) tail(index int32) (last, bkt *bucket, idx int32) {
+	idx = index
+	if idx < 0 {
+		return
+	}
+	bkt = m.bucket(idx)
+	for bkt.next >= 0 {
+		last = bkt
+		idx = bkt.next
+		bkt = m.bucket(idx)
+	}
+	return
+}
+
+// unlink the last unpinned bucket of a locked chain, return its
+// index, -1 if all are pinned
+func (m *Map) evictTail(ptr *hash) int32 {
+	var prev, last, bkt *bucket
+	idx := int32(-1)
+	for i := ptr.index(); i >= 0; {
+		b := m.bucket(i)
+		if !pinned(b) {
+			last, bkt, idx = prev, b, i
+		}
+		prev, i = b, b.next
+	}
+	if bkt == nil {
+		return -1
+	}
+	bkt.used = 0
+	if last != nil {
+		last.next = bkt.next
+		m.markDirty(unsafe.Pointer(last), unsafe.Sizeof(bucket{}))
+	} else {
+		ptr.setIndex(bkt.next)
+	}
+	ptr.addLength(-1)
+	atomic.AddInt32(&m.head.len, -1)
+	m.markBucket(bkt, ptr)
+	return idx
+}
+
+// a crash point reached
+func (m *Map) point(name string) {
+	if m.hook != nil {
+		m.hook(name)
+	}
+}
+
 // bucket index
 func (m *Map) alloc() int32 {
-	// from deleted first
-	for {
+	// from the batch of this map first
+	if m.batch != nil {
+		if i := m.batch.alloc(m); i >= 0 {
+			return i
+		}
+	}
+	// from deleted then, after the quarantine with FreeFIFO
+	if m.fifo != nil {
+		if i := m.allocFIFO(false); i >= 0 {
+			return i
+		}
+	}
+	for m.fifo == nil {
 		del := m.head.deleteLink
 		if del < 0 {
 			break
@@ -351,7 +1266,7 @@ func (m *Map) alloc() int32 {
 			return del
 		}
 	}
-	// from "next" second
+	// from "next" last
 	for {
 		next := m.head.next
 		if next >= m.head.cap {
@@ -362,17 +1277,39 @@ func (m *Map) alloc() int32 {
 			return next
 		}
 	}
+	// in quarantine last
+	if m.fifo != nil {
+		return m.allocFIFO(true)
+	}
 	return -1
 }
 
 // bucket index
 func (m *Map) free(i int32) {
-	bkt := m.bucket(i)
-	// put to deleted link
+	// with its continuation buckets
+	if m.meta.span != 0 {
+		if first, last := m.dropSpan(m.bucket(i)); first >= 0 {
+			m.freeChain(first, last)
+		}
+	}
+	if m.batch != nil {
+		m.batch.free(m, i)
+		return
+	}
+	m.freeChain(i, i)
+}
+
+// put the buckets linked from first to last to deleted link
+func (m *Map) freeChain(first, last int32) {
+	if m.fifo != nil {
+		m.freeFIFO(first, last)
+		return
+	}
+	bkt := m.bucket(last)
 	for {
-		last := m.head.deleteLink
-		bkt.next = last
-		if atomic.CompareAndSwapInt32(&m.head.deleteLink, last, i) {
+		del := m.head.deleteLink
+		bkt.next = del
+		if atomic.CompareAndSwapInt32(&m.head.deleteLink, del, first) {
 			return
 		}
 	}
@@ -390,7 +1327,7 @@ func (m *Map) index(b *bucket) int32 {
 
 // hash pointer
 func (m *Map) hashPtr(h int32) *hash {
-	return &(*m.hash)[int(uint(h)%uint(m.head.cap))]
+	return &(*m.hash)[int(uint(h)%uint(m.nslots))]
 }
 
 // the first bucket's index in chain
@@ -408,9 +1345,9 @@ func (h *hash) setIndex(index int32) {
 	(*h)[0] = index
 }
 
-// lock the bucket chain
+// lock the bucket chain as the process
 func (h *hash) lock(serial int32) bool {
-	if atomic.CompareAndSwapInt32(&(*h)[2], 0, 1) {
+	if atomic.CompareAndSwapInt32(&(*h)[2], 0, selfPID) {
 		if serial == (*h)[1] {
 			return true
 		}
@@ -419,6 +1356,11 @@ func (h *hash) lock(serial int32) bool {
 	return false
 }
 
+// release the lock of an unchanged chain
+func (h *hash) release() {
+	atomic.StoreInt32(&(*h)[2], 0)
+}
+
 // unlock the bucket chain
 func (h *hash) unlock() {
 	(*h)[1]++
@@ -441,8 +1383,8 @@ func (b *bucket) hashPtr(m *Map) *hash {
 }
 
 // bucket key
-func (b *bucket) key() (s string) {
-	a := uintptr(unsafe.Pointer(b)) + unsafe.Sizeof(bucket{})
+func (b *bucket) key(m *Map) (s string) {
+	a := uintptr(unsafe.Pointer(b)) + m.keyOff
 	h := (*reflect.StringHeader)(unsafe.Pointer(&s))
 	h.Data = a + 1
 	h.Len = int(*(*uint8)(unsafe.Pointer(a)))
@@ -451,10 +1393,10 @@ func (b *bucket) key() (s string) {
 
 // bucket value
 func (b *bucket) value(m *Map) (d []byte) {
-	a := uintptr(unsafe.Pointer(b)) + unsafe.Sizeof(bucket{}) + uintptr(m.head.keySize)
+	a := uintptr(unsafe.Pointer(b)) + m.keyOff + uintptr(m.head.keySize)
 	h := (*reflect.SliceHeader)(unsafe.Pointer(&d))
 	h.Data = a
-	h.Cap = int(m.head.bucketSize) - int(unsafe.Sizeof(bucket{})) - int(m.head.keySize)
+	h.Cap = m.vcap
 	h.Len = h.Cap
 	return
 }
@@ -463,7 +1405,7 @@ func (b *bucket) value(m *Map) (d []byte) {
 func (b *bucket) setKey(m *Map, s string) {
 	var d []byte
 	h := (*reflect.SliceHeader)(unsafe.Pointer(&d))
-	a := uintptr(unsafe.Pointer(b)) + unsafe.Sizeof(bucket{})
+	a := uintptr(unsafe.Pointer(b)) + m.keyOff
 	h.Data = a + 1
 	h.Cap = int(m.head.keySize - 1)
 	h.Len = h.Cap
@@ -471,6 +1413,22 @@ func (b *bucket) setKey(m *Map, s string) {
 	*(*uint8)(unsafe.Pointer(a)) = uint8(l)
 }
 
+// castagnoli table for the second hash
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// second string hash func, independent of the first
+func hashFunc2(s string) (int32, error) {
+	var b []byte
+	*(*string)(unsafe.Pointer(&b)) = s
+	(*reflect.SliceHeader)(unsafe.Pointer(&b)).Cap = len(s)
+	hs := crc32.New(castagnoli)
+	_, err := hs.Write(b)
+	if err != nil {
+		return 0, err
+	}
+	return int32(hs.Sum32()), nil
+}
+
 // string hash func
 func hashFunc(s string) (int32, error) {
 	var b []byte
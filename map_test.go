@@ -2,8 +2,12 @@ package shm
 
 import (
 	"encoding/hex"
+	"fmt"
+	"github.com/fengyoulin/shm/mapping"
 	"math/rand"
 	"os"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 	"unsafe"
@@ -19,6 +23,27 @@ const (
 
 var testMap *Map
 
+// an in-memory map for a test, closed by the caller
+
+func newTestMap(tb testing.TB, mapCap, keyLen, valueLen int, opts ...Option) *Map {
+	tb.Helper()
+	m, err := Create("", mapCap, keyLen, valueLen, testMaxTry, initWait, append([]Option{InMemory()}, opts...)...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return m
+}
+
+// a map in the file name for a test, closed and removed by the caller
+func newTestFile(tb testing.TB, name string, mapCap, keyLen, valueLen int, opts ...Option) *Map {
+	tb.Helper()
+	m, err := Create(name, mapCap, keyLen, valueLen, testMaxTry, initWait, opts...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return m
+}
+
 func TestCreate(t *testing.T) {
 	var err error
 	testMap, err = Create(testFileName, testMapCap, 2*testValLen, testValLen, testMaxTry, initWait)
@@ -117,3 +142,358 @@ func BenchmarkMap_GetOrAdd(b *testing.B) {
 		b.Error(err)
 	}
 }
+
+func TestMap_InMemory(t *testing.T) {
+	name := "testmemory.db"
+	m := newTestFile(t, name, 64, 16, 8, InMemory())
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("file created for memory map: %v", err)
+	}
+	v, err := m.Get("memory", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(v, "12345678")
+	if v, err = m.Get("memory", false); err != nil || string(v[:8]) != "12345678" {
+		t.Errorf("unexpected value %q, %v", v, err)
+	}
+	if !m.Delete("memory") || m.Len() != 0 {
+		t.Error("delete failed")
+	}
+	if err = m.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewFromMapping(t *testing.T) {
+	size, err := EstimateSize(64, 16, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewFromMapping(mapping.NewMemory(size-1), 64, 16, 8, testMaxTry); err != ErrDbSize {
+		t.Errorf("expect ErrDbSize, got %v", err)
+	}
+	mp := mapping.NewMemory(size)
+	m, err := NewFromMapping(mp, 64, 16, 8, testMaxTry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Get("mapping", true); err != nil {
+		t.Error(err)
+	}
+	// attach a second map to the same memory
+	m2, err := NewFromMapping(mp, 64, 16, 8, testMaxTry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m2.Get("mapping", false); err != nil {
+		t.Error(err)
+	}
+	if err = m.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCreate_ShortKey(t *testing.T) {
+	m := newTestMap(t, 64, 4, 4)
+	defer m.Close()
+	if m.head.keySize != 8 || m.head.bucketSize != 32 {
+		t.Errorf("unexpected key size %d, bucket size %d", m.head.keySize, m.head.bucketSize)
+	}
+	if _, err := m.Get("abcd", true); err != nil {
+		t.Error(err)
+	}
+	if _, err := m.Get("abcd", false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMap_ZeroValue(t *testing.T) {
+	m := newTestMap(t, 64, 16, 0)
+	defer m.Close()
+	v, err := m.Get("member", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v == nil || len(v) != 0 {
+		t.Errorf("expect empty non-nil value, got %v", v)
+	}
+	if !m.Exists("member") || m.Exists("other") {
+		t.Error("unexpected membership")
+	}
+}
+
+func TestCreate_BucketAlign(t *testing.T) {
+	if _, err := Create("", 64, 16, 8, testMaxTry, initWait, InMemory(), BucketAlign(48)); err != ErrAlign {
+		t.Errorf("expect ErrAlign, got %v", err)
+	}
+	m := newTestMap(t, 64, 16, 8, BucketAlign(64))
+	defer m.Close()
+	if m.head.bucketSize != 64 || m.head.dataOff%64 != 0 || m.head.align != 64 {
+		t.Errorf("unexpected bucket size %d, data offset %d", m.head.bucketSize, m.head.dataOff)
+	}
+}
+
+func TestMap_Sample(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8)
+	defer m.Close()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if _, err := m.Get(key, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Delete("b")
+	keys := m.Sample(3)
+	if len(keys) != 3 {
+		t.Fatalf("expect 3 keys, got %v", keys)
+	}
+	for _, key := range keys {
+		if key == "b" || !m.Exists(key) {
+			t.Errorf("sampled dead key %s", key)
+		}
+	}
+}
+
+func TestMap_SampleSkip(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8, Expiration())
+	defer m.Close()
+	for _, key := range []string{"a", "b"} {
+		if _, err := m.Get(key, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.SetMissing("m", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Touch("b", 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if keys := m.Sample(3); len(keys) != 1 || keys[0] != "a" {
+			t.Fatalf("expect only a sampled, got %v", keys)
+		}
+	}
+}
+
+func TestMap_ForeachParallel(t *testing.T) {
+	m := newTestMap(t, 1000, 16, 8)
+	defer m.Close()
+	for i := 0; i < 1000; i++ {
+		if _, err := m.Get(strconv.Itoa(i), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var count int32
+	m.ForeachParallel(4, func(key string, value []byte) bool {
+		atomic.AddInt32(&count, 1)
+		return true
+	})
+	if count != 1000 {
+		t.Errorf("expect 1000 keys, got %d", count)
+	}
+}
+
+func TestMap_MaxChain(t *testing.T) {
+	// one key per slot, some of the keys collide
+	m := newTestMap(t, 64, 16, 8, MaxChain(1))
+	defer m.Close()
+	var err error
+	var errs int
+	for i := 0; i < 64; i++ {
+		_, err = m.Get(strconv.Itoa(i), true)
+		if err == ErrChainLong {
+			errs++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if errs == 0 || m.Len()+errs != 64 {
+		t.Errorf("expect chain errors, got %d, len %d", errs, m.Len())
+	}
+	m, err = Create("", 64, 16, 8, testMaxTry, initWait, InMemory(), MaxChain(1), EvictChainTail())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	for i := 0; i < 64; i++ {
+		if _, err = m.Get(strconv.Itoa(i), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.Len()+errs != 64 {
+		t.Errorf("expect %d evicted, len %d", errs, m.Len())
+	}
+	if err = m.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func BenchmarkMap_Get(b *testing.B) {
+	m := newTestMap(b, 1024, 64, 8)
+	defer m.Close()
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%064d", i)
+		if _, err := m.Get(keys[i], true); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := m.Get(keys[i%len(keys)], false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMap_Set(b *testing.B) {
+	for _, single := range []bool{false, true} {
+		b.Run("single="+strconv.FormatBool(single), func(b *testing.B) {
+			opts := []Option{InMemory()}
+			if single {
+				opts = append(opts, SingleProcess())
+			}
+			m, err := Create("", 1024, 16, 8, testMaxTry, initWait, opts...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer m.Close()
+			value := []byte("12345678")
+			b.RunParallel(func(pb *testing.PB) {
+				var i int
+				for pb.Next() {
+					_ = m.Set(strconv.Itoa(i&1023), value)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestMap_OnEvict(t *testing.T) {
+	for _, single := range []bool{false, true} {
+		evicted := map[string]string{}
+		opts := []Option{InMemory(), MaxChain(1), EvictChainTail(), OnEvict(func(key string, value []byte) {
+			evicted[key] = string(value[:len(key)])
+		})}
+		if single {
+			opts = append(opts, SingleProcess())
+		}
+		m, err := Create("", 64, 16, 8, testMaxTry, initWait, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 64; i++ {
+			k := strconv.Itoa(i)
+			if err = m.Set(k, []byte(k)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(evicted) == 0 || m.Len()+len(evicted) != 64 {
+			t.Errorf("expect %d evicted, got %d", 64-m.Len(), len(evicted))
+		}
+		for k, v := range evicted {
+			if k != v || m.Exists(k) {
+				t.Errorf("unexpected evicted %q: %q", k, v)
+			}
+		}
+		_ = m.Close()
+	}
+}
+
+func TestMap_Segmented(t *testing.T) {
+	name := "testsegmented.db"
+	ps := os.Getpagesize()
+	m, err := Create(name, 1024, 16, 8, testMaxTry, initWait, Segmented(ps))
+	if err == mapping.ErrSegments {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := (len(m.mp.Bytes()) + ps - 1) / ps
+	defer func() {
+		for i := 0; i < n; i++ {
+			_ = os.Remove(mapping.SegmentName(name, i))
+		}
+	}()
+	if n < 2 {
+		t.Fatalf("expect segments, got %d", n)
+	}
+	for i := 0; i < m.Cap(); i++ {
+		if err = m.Set(strconv.Itoa(i), []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("expect no file at the path, got %v", err)
+	}
+	if m, err = Open(name, testMaxTry, initWait); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	for i := 0; i < m.Cap(); i++ {
+		k := strconv.Itoa(i)
+		if v, err := m.Load(k, nil); err != nil || string(v[:len(k)]) != k {
+			t.Fatalf("%s: unexpected %q, %v", k, v, err)
+		}
+	}
+}
+
+func TestMap_Reserve(t *testing.T) {
+	name := "testreserve.db"
+	m := newTestFile(t, name, 1024, 16, 8, Reserve())
+	defer os.Remove(name)
+	defer m.Close()
+	info, err := os.Stat(name)
+	if err != nil || info.Size() != int64(len(m.mp.Bytes())) {
+		t.Fatalf("unexpected file, %v", err)
+	}
+	if err = m.Set("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMap_ReadOnly(t *testing.T) {
+	name := "testreadonly.db"
+	defer os.Remove(name)
+	m := newTestFile(t, name, 64, 16, 16, Expiration())
+	defer m.Close()
+	var err error
+	if err = m.Set("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Set("old", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Touch("old", -1); err != nil {
+		t.Fatal(err)
+	}
+	r := newTestFile(t, name, 64, 16, 16, Expiration(), ReadOnly())
+	defer r.Close()
+	if v, err := r.Get("a", false); err != nil || v[0] != '1' {
+		t.Errorf("expect a read, got %q, %v", v, err)
+	}
+	if _, err = r.Get("b", true); err != ErrReadOnly {
+		t.Errorf("expect ErrReadOnly on add, got %v", err)
+	}
+	if err = r.Set("a", []byte("3")); err != ErrReadOnly {
+		t.Errorf("expect ErrReadOnly on set, got %v", err)
+	}
+	if err = r.SetFlags("a", 1); err != ErrReadOnly {
+		t.Errorf("expect ErrReadOnly on set flags, got %v", err)
+	}
+	if r.Delete("a") {
+		t.Error("expect no delete")
+	}
+	// expired, left to the writer
+	if _, err = r.Get("old", false); err != ErrKeyNot {
+		t.Errorf("expect ErrKeyNot of expired, got %v", err)
+	}
+	if r.Len() != 2 {
+		t.Errorf("expect 2 keys left, got %d", r.Len())
+	}
+}
@@ -0,0 +1,12 @@
+package mapping
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// evict [off, off+n) of f from the page cache, the pages written back
+// first, those mapped by other processes stay
+func evict(f *os.File, off, n int) error {
+	return unix.Fadvise(int(f.Fd()), int64(off), int64(n), unix.FADV_DONTNEED)
+}
@@ -0,0 +1,12 @@
+// +build aix darwin dragonfly freebsd netbsd openbsd solaris
+
+package mapping
+
+import (
+	"os"
+)
+
+// evict [off, off+n) of f from the page cache, left to the kernel here
+func evict(f *os.File, off, n int) error {
+	return nil
+}
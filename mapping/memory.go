@@ -0,0 +1,47 @@
+package mapping
+
+// Memory is a mapping backed by anonymous memory, no file, shared only
+// inside one process, off the Go heap where the platform allows, so
+// the race detector takes pointers into it as it does into a file
+// mapping
+type Memory struct {
+	data []byte
+}
+
+// NewMemory create a zeroed memory mapping of size bytes
+func NewMemory(size int) *Memory {
+	return &Memory{
+		data: alloc(size),
+	}
+}
+
+// Bytes return the memory
+func (m *Memory) Bytes() []byte {
+	return m.data
+}
+
+// Sync is a no-op
+func (m *Memory) Sync() error {
+	return nil
+}
+
+// SyncRange is a no-op
+func (m *Memory) SyncRange(off, n int) error {
+	return nil
+}
+
+// Resize to size bytes, keeping the content
+func (m *Memory) Resize(size int) error {
+	data := alloc(size)
+	copy(data, m.data)
+	release(m.data)
+	m.data = data
+	return nil
+}
+
+// Close release the memory
+func (m *Memory) Close() error {
+	release(m.data)
+	m.data = nil
+	return nil
+}
@@ -0,0 +1,11 @@
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package mapping
+
+// alloc size zeroed bytes
+func alloc(size int) []byte {
+	return make([]byte, size)
+}
+
+// release memory of alloc, left to the collector
+func release(b []byte) {}
@@ -0,0 +1,23 @@
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package mapping
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// alloc size zeroed bytes of anonymous memory, on the Go heap if the
+// mmap fails
+func alloc(size int) []byte {
+	if size > 0 {
+		if b, err := unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE); err == nil {
+			return b
+		}
+	}
+	return make([]byte, size)
+}
+
+// release memory of alloc, left to the collector if on the heap
+func release(b []byte) {
+	_ = unix.Munmap(b)
+}
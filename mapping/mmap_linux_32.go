@@ -0,0 +1,10 @@
+// +build linux,386 linux,arm linux,mips linux,mipsle
+
+package mapping
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// mmap with the offset in pages, 0 for a segment
+const sysMmap = unix.SYS_MMAP2
@@ -0,0 +1,10 @@
+// +build linux,!386,!arm,!mips,!mipsle
+
+package mapping
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// mmap with the offset in bytes, 0 for a segment
+const sysMmap = unix.SYS_MMAP
@@ -0,0 +1,27 @@
+package mapping
+
+import (
+	"os"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Prefault touch every page of the mapping for write, so the
+// first access of a bucket does not take a page fault
+func (m *Mapping) Prefault() {
+	prefault(m.Bytes())
+}
+
+// Prefault touch every page of the segments for write
+func (m *Segments) Prefault() {
+	prefault(m.Bytes())
+}
+
+// touch every page of b for write
+func prefault(b []byte) {
+	ps := os.Getpagesize()
+	for off := 0; off < len(b); off += ps {
+		// an atomic add of zero is a write that races with nobody
+		atomic.AddInt32((*int32)(unsafe.Pointer(&b[off])), 0)
+	}
+}
@@ -0,0 +1,118 @@
+package mapping
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	// ErrSegments on segments where files cannot be mapped contiguously
+	ErrSegments = errors.New("segments not supported")
+	// ErrSegmentSize on a segment size not a multiple of the page size
+	ErrSegmentSize = errors.New("segment size not a multiple of the page size")
+)
+
+// Segments is a mapping of files of a fixed size mapped one after
+// another in one range of addresses, so that a map larger than a file
+// may be on a file system limiting the size of files, as tmpfs mounts
+// the files are named by SegmentName, path.000, path.001 and so on
+type Segments struct {
+	path  string
+	size  int
+	files []*os.File
+	data  []byte
+}
+
+// SegmentName return the name of the file of segment i of path
+func SegmentName(path string, i int) string {
+	return fmt.Sprintf("%s.%03d", path, i)
+}
+
+// CreateSegments map files of size bytes each, the segments of path
+// the mapping owns files on success, and close them on Close
+func CreateSegments(path string, size int, files []*os.File) (m *Segments, err error) {
+	if size <= 0 || size%os.Getpagesize() != 0 {
+		return nil, ErrSegmentSize
+	}
+	data, err := mapSegments(files, size)
+	if err != nil {
+		return
+	}
+	m = &Segments{
+		path:  path,
+		size:  size,
+		files: files,
+		data:  data,
+	}
+	return
+}
+
+// Bytes return mapped memory, of all the segments
+func (m *Segments) Bytes() []byte {
+	return m.data
+}
+
+// Sync flush the whole mapping to the files
+func (m *Segments) Sync() error {
+	return syncSegments(m.data)
+}
+
+// SyncRange flush [off, off+n) of the mapping to the files
+func (m *Segments) SyncRange(off, n int) error {
+	// msync needs a page aligned address
+	start := off &^ (os.Getpagesize() - 1)
+	return syncSegments(m.data[start : off+n])
+}
+
+// Resize to the segments holding size bytes, adding or removing
+// files at the end, and remap them, the address may change
+func (m *Segments) Resize(size int) (err error) {
+	n := (size + m.size - 1) / m.size
+	if err = unmapSegments(m.data); err != nil {
+		return
+	}
+	m.data = nil
+	for len(m.files) > n {
+		i := len(m.files) - 1
+		if err = m.files[i].Close(); err != nil {
+			return
+		}
+		m.files = m.files[:i]
+		if err = os.Remove(SegmentName(m.path, i)); err != nil {
+			return
+		}
+	}
+	for len(m.files) < n {
+		var f *os.File
+		if f, err = os.OpenFile(SegmentName(m.path, len(m.files)), os.O_RDWR|os.O_CREATE, 0664); err != nil {
+			return
+		}
+		m.files = append(m.files, f)
+		if err = f.Truncate(int64(m.size)); err != nil {
+			return
+		}
+	}
+	m.data, err = mapSegments(m.files, m.size)
+	return
+}
+
+// File return the file of the first segment
+func (s *Segments) File() *os.File {
+	return s.files[0]
+}
+
+// Close the mapping and its files
+func (m *Segments) Close() (err error) {
+	if m.data != nil {
+		err = unmapSegments(m.data)
+		m.data = nil
+	}
+	for _, f := range m.files {
+		if e := f.Close(); err == nil {
+			err = e
+		}
+	}
+	m.files = nil
+	return
+}
@@ -0,0 +1,40 @@
+package mapping
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+	"unsafe"
+)
+
+// map files of size bytes each at the addresses following each other
+// of a range reserved at once
+func mapSegments(files []*os.File, size int) (b []byte, err error) {
+	if len(files) == 0 {
+		return nil, ErrSegments
+	}
+	b, err = unix.Mmap(-1, 0, len(files)*size, unix.PROT_NONE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return
+	}
+	base := uintptr(unsafe.Pointer(&b[0]))
+	for i, f := range files {
+		// replaces the reserved pages of the segment
+		_, _, e := unix.Syscall6(sysMmap, base+uintptr(i*size), uintptr(size), unix.PROT_READ|unix.PROT_WRITE,
+			unix.MAP_SHARED|unix.MAP_FIXED, f.Fd(), 0)
+		if e != 0 {
+			_ = unix.Munmap(b)
+			return nil, e
+		}
+	}
+	return
+}
+
+// unmap the range of the segments
+func unmapSegments(b []byte) error {
+	return unix.Munmap(b)
+}
+
+// flush b of the segments to their files
+func syncSegments(b []byte) error {
+	return unix.Msync(b, unix.MS_SYNC)
+}
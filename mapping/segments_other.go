@@ -0,0 +1,22 @@
+// +build !linux
+
+package mapping
+
+import (
+	"os"
+)
+
+// map files contiguously, not supported here
+func mapSegments(files []*os.File, size int) ([]byte, error) {
+	return nil, ErrSegments
+}
+
+// unmap the range of the segments
+func unmapSegments(b []byte) error {
+	return nil
+}
+
+// flush b of the segments to their files
+func syncSegments(b []byte) error {
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 
 // Mapping of a file
 type Mapping struct {
+	file *os.File
 	data []byte
 }
 
@@ -18,6 +19,7 @@ func (m *Mapping) Bytes() (b []byte) {
 }
 
 // Create a mapping from a file
+// the mapping owns file on success, and close it on Close
 func Create(file *os.File) (m *Mapping, err error) {
 	info, err := file.Stat()
 	if err != nil {
@@ -29,12 +31,96 @@ func Create(file *os.File) (m *Mapping, err error) {
 		return
 	}
 	m = &Mapping{
+		file: file,
 		data: data,
 	}
 	return
 }
 
-// Close a mapping
+// Private map the file again copy on write, a page of the view is
+// copied on its first write through the view, until then it shows
+// the writes through the shared mapping
+// the view cannot Resize, closing it leaves the file open
+func (m *Mapping) Private() (p *Mapping, err error) {
+	data, err := unix.Mmap(int(m.file.Fd()), 0, len(m.data), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE)
+	if err != nil {
+		return
+	}
+	p = &Mapping{
+		data: data,
+	}
+	return
+}
+
+// Resize the file and remap it, the address may change
+func (m *Mapping) Resize(size int) (err error) {
+	if m.file == nil {
+		return unix.EINVAL
+	}
+	if err = unix.Munmap(m.data); err != nil {
+		return
+	}
+	m.data = nil
+	if err = m.file.Truncate(int64(size)); err != nil {
+		return
+	}
+	m.data, err = unix.Mmap(int(m.file.Fd()), 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	return
+}
+
+// Sync flush the whole mapping to the file
+func (m *Mapping) Sync() error {
+	return unix.Msync(m.data, unix.MS_SYNC)
+}
+
+// SyncRange flush [off, off+n) of the mapping to the file
+func (m *Mapping) SyncRange(off, n int) error {
+	// msync needs a page aligned address
+	start := off &^ (os.Getpagesize() - 1)
+	return unix.Msync(m.data[start:off+n], unix.MS_SYNC)
+}
+
+// DontNeed drop the pages of [off, off+n) of the mapping from the
+// memory of the process, and from the page cache unless mapped by
+// others, read again from the file on access
+// off must be page aligned, a private view would lose its copies
+func (m *Mapping) DontNeed(off, n int) error {
+	if m.file == nil {
+		return unix.EINVAL
+	}
+	b := m.data[off : off+n]
+	// clean pages are evicted, dirty ones only written back
+	if err := unix.Msync(b, unix.MS_SYNC); err != nil {
+		return err
+	}
+	if err := unix.Madvise(b, unix.MADV_DONTNEED); err != nil {
+		return err
+	}
+	return evict(m.file, off, n)
+}
+
+// Close a mapping and its file
 func (m *Mapping) Close() (err error) {
-	return unix.Munmap(m.data)
+	if m.data != nil {
+		err = unix.Munmap(m.data)
+		m.data = nil
+	}
+	// private views do not own the file
+	if m.file == nil {
+		return
+	}
+	if e := m.file.Close(); err == nil {
+		err = e
+	}
+	return
+}
+
+// File return the mapped file, nil for a private view
+func (m *Mapping) File() *os.File {
+	return m.file
+}
+
+// Stat the mapped file
+func (m *Mapping) Stat() (os.FileInfo, error) {
+	return m.file.Stat()
 }
@@ -0,0 +1,23 @@
+package mapping
+
+import (
+	"github.com/fengyoulin/shm/internal/futex"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Wait block while the word at addr, in memory shared by processes,
+// holds old, until Wake on it or timeout passed, forever if timeout
+// <= 0, with a futex on linux and by polling elsewhere
+// may return early, report whether the word changed
+func Wait(addr *int32, old int32, timeout time.Duration) bool {
+	futex.Wait((*uint32)(unsafe.Pointer(addr)), uint32(old), timeout)
+	return atomic.LoadInt32(addr) != old
+}
+
+// Wake up to n processes or goroutines waiting on the word at addr,
+// call it after changing the word
+func Wake(addr *int32, n int) {
+	futex.Wake((*uint32)(unsafe.Pointer(addr)), n)
+}
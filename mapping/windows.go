@@ -11,6 +11,7 @@ import (
 
 // Mapping of a file
 type Mapping struct {
+	file   *os.File
 	handle windows.Handle
 	length int
 	addr   uintptr
@@ -29,15 +30,25 @@ func (m *Mapping) Bytes() (b []byte) {
 }
 
 // Create a mapping from a file
+// the mapping owns file on success, and close it on Close
 func Create(file *os.File) (m *Mapping, err error) {
 	info, err := file.Stat()
 	if err != nil {
 		return
 	}
-	size := info.Size()
-	handle, err := windows.CreateFileMapping(windows.Handle(file.Fd()), nil, windows.PAGE_READWRITE|SEC_COMMIT, 0, 0, nil)
+	m = &Mapping{
+		file: file,
+	}
+	if err = m.view(int(info.Size())); err != nil {
+		m = nil
+	}
+	return
+}
+
+// map a view of size bytes
+func (m *Mapping) view(size int) (err error) {
+	handle, err := windows.CreateFileMapping(windows.Handle(m.file.Fd()), nil, windows.PAGE_READWRITE|SEC_COMMIT, 0, 0, nil)
 	if err != nil {
-		panic(err)
 		return
 	}
 	addr, err := windows.MapViewOfFile(handle, windows.FILE_MAP_WRITE, 0, 0, 0)
@@ -45,19 +56,62 @@ func Create(file *os.File) (m *Mapping, err error) {
 		_ = windows.CloseHandle(handle)
 		return
 	}
-	m = &Mapping{
-		handle: handle,
-		length: int(size),
-		addr:   addr,
-	}
+	m.handle = handle
+	m.length = size
+	m.addr = addr
 	return
 }
 
-// Close a mapping
-func (m *Mapping) Close() (err error) {
+// Resize the file and remap it, the address may change
+func (m *Mapping) Resize(size int) (err error) {
+	if err = m.unview(); err != nil {
+		return
+	}
+	if err = m.file.Truncate(int64(size)); err != nil {
+		return
+	}
+	return m.view(size)
+}
+
+// unmap the view and close the mapping handle
+func (m *Mapping) unview() (err error) {
+	if m.addr == 0 {
+		return
+	}
 	err = windows.UnmapViewOfFile(m.addr)
 	if e := windows.CloseHandle(m.handle); err == nil {
 		err = e
 	}
+	m.addr = 0
+	m.length = 0
 	return
 }
+
+// Sync flush the whole mapping to the file
+func (m *Mapping) Sync() error {
+	return windows.FlushViewOfFile(m.addr, uintptr(m.length))
+}
+
+// SyncRange flush [off, off+n) of the mapping to the file
+func (m *Mapping) SyncRange(off, n int) error {
+	return windows.FlushViewOfFile(m.addr+uintptr(off), uintptr(n))
+}
+
+// Close a mapping and its file
+func (m *Mapping) Close() (err error) {
+	err = m.unview()
+	if e := m.file.Close(); err == nil {
+		err = e
+	}
+	return
+}
+
+// File return the mapped file
+func (m *Mapping) File() *os.File {
+	return m.file
+}
+
+// Stat the mapped file
+func (m *Mapping) Stat() (os.FileInfo, error) {
+	return m.file.Stat()
+}
@@ -0,0 +1,62 @@
+package shm
+
+import (
+	"os"
+	"sync/atomic"
+)
+
+// MemStats of the memory of a map, approximate while it changes
+type MemStats struct {
+	// Virtual size of the mapping in bytes
+	Virtual int64
+	// Resident bytes of the mapping in memory, in the page cache for
+	// a file, -1 where not known
+	Resident int64
+	// Index bytes of the header and the hash slots
+	Index int64
+	// Live bytes in the buckets of entries
+	Live int64
+	// Free bytes in buckets allocated once and freed since
+	Free int64
+	// Unused bytes in buckets never allocated
+	Unused int64
+	// Fragmentation is the share of the allocated buckets free
+	Fragmentation float64
+}
+
+// MemStats report the memory of the map, its residency from mincore
+// on linux, for capacity planning
+func (m *Map) MemStats() (s MemStats, err error) {
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	b := m.mp.Bytes()
+	size := int64(m.head.bucketSize)
+	next := int64(atomic.LoadInt32(&m.head.next))
+	live := int64(atomic.LoadInt32(&m.head.len))
+	if live > next {
+		live = next
+	}
+	s.Virtual = int64(len(b))
+	s.Index = int64(m.head.dataOff)
+	s.Live = live * size
+	s.Free = (next - live) * size
+	s.Unused = (int64(m.head.cap) - next) * size
+	if next > 0 {
+		s.Fragmentation = float64(next-live) / float64(next)
+	}
+	s.Resident = -1
+	vec, err := mincore(b)
+	if vec != nil {
+		s.Resident = int64(residentPages(vec)) * int64(os.Getpagesize())
+	}
+	return
+}
+
+// count of the resident pages of a mincore vector
+func residentPages(vec []byte) (n int) {
+	for _, v := range vec {
+		n += int(v & 1)
+	}
+	return
+}
@@ -0,0 +1,37 @@
+package shm
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestMap_MemStats(t *testing.T) {
+	name := "testmemstats.db"
+	defer os.Remove(name)
+	m := newTestFile(t, name, 100, 16, 8)
+	defer m.Close()
+	for i := 0; i < 10; i++ {
+		if err := m.Set(strconv.Itoa(i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		m.Delete(strconv.Itoa(i))
+	}
+	s, err := m.MemStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(m.BucketSize())
+	if s.Live != 5*size || s.Free != 5*size || s.Unused != int64(m.Cap()-10)*size || s.Fragmentation != 0.5 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	if s.Virtual < s.Index+int64(m.Cap())*size {
+		t.Errorf("unexpected virtual %d of index %d", s.Virtual, s.Index)
+	}
+	// -1 where not known
+	if s.Resident == 0 || s.Resident > s.Virtual+int64(os.Getpagesize()) {
+		t.Errorf("unexpected resident %d", s.Resident)
+	}
+}
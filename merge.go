@@ -0,0 +1,79 @@
+package shm
+
+import (
+	"fmt"
+)
+
+// Merge set the entries of src in m, for consolidating the maps of
+// workers into one
+// on a key in both, the value set is what onConflict return of the
+// key, the value in m and the value in src, nil keeps the value in m;
+// a nil onConflict takes the value in src
+// onConflict is called with the chain of key in m locked, it must not
+// use m, nor keep the slices, which are padded to the value capacity
+// of their maps
+// expired entries of src are skipped, a key added to m by another
+// writer during the merge may be overwritten by the value in src
+// stop on the first entry failing to set, as a *ValueLenError of a
+// value too long for m, or ErrKeyLen of a key too long
+func (m *Map) Merge(src *Map, onConflict func(key string, dst, src []byte) []byte) (err error) {
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	if src.protect {
+		defer src.protected(&err)()
+	}
+	if src == m {
+		return nil
+	}
+	for i := int32(0); i < src.head.cap; i++ {
+		bkt := src.bucket(i)
+		if bkt.used == 0 || missing(bkt) || src.expired(bkt) {
+			continue
+		}
+		var v []byte
+		if v, err = src.valueOf(bkt); err != nil {
+			return
+		}
+		key := bkt.key(src)
+		if err = m.merge(key, v, onConflict); err != nil {
+			return fmt.Errorf("merge %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// merge the value v of key, resolved by onConflict if key is in m
+func (m *Map) merge(key string, v []byte, onConflict func(key string, dst, src []byte) []byte) error {
+	set := func(bkt *bucket, value []byte) error {
+		b, compressed, err := m.encode(value)
+		if err != nil {
+			return err
+		}
+		if err = m.store(bkt, b, compressed, len(value)); err != nil {
+			return err
+		}
+		m.setUpdated(bkt)
+		m.bumpVersion(bkt)
+		return nil
+	}
+	err := m.locked(key, false, func(bkt *bucket) error {
+		value := v
+		if onConflict != nil {
+			cur, err := m.valueOf(bkt)
+			if err != nil {
+				return err
+			}
+			if value = onConflict(key, cur, v); value == nil {
+				return nil
+			}
+		}
+		return set(bkt, value)
+	})
+	if err != ErrKeyNot {
+		return err
+	}
+	return m.locked(key, true, func(bkt *bucket) error {
+		return set(bkt, v)
+	})
+}
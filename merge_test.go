@@ -0,0 +1,59 @@
+package shm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestMap_Merge(t *testing.T) {
+	var ms [2]*Map
+	for i := range ms {
+		name := fmt.Sprintf("testmerge%d.db", i)
+		defer os.Remove(name)
+		m := newTestFile(t, name, 100, 20, 8)
+		defer m.Close()
+		ms[i] = m
+	}
+	dst, src := ms[0], ms[1]
+	u64 := func(v uint64) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, v)
+		return b
+	}
+	for k, v := range map[string]uint64{"a": 1, "b": 2} {
+		_ = dst.Set(k, u64(v))
+	}
+	for k, v := range map[string]uint64{"b": 3, "c": 4} {
+		_ = src.Set(k, u64(v))
+	}
+	sum := func(key string, d, s []byte) []byte {
+		return u64(binary.LittleEndian.Uint64(d) + binary.LittleEndian.Uint64(s))
+	}
+	if err := dst.Merge(src, sum); err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]uint64{"a": 1, "b": 5, "c": 4} {
+		if v, err := dst.Load(k, nil); err != nil || binary.LittleEndian.Uint64(v) != want {
+			t.Errorf("%s: expect %d, got %v, %v", k, want, v, err)
+		}
+	}
+	if err := dst.Merge(src, func(key string, d, s []byte) []byte { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := dst.Load("b", nil); binary.LittleEndian.Uint64(v) != 5 {
+		t.Errorf("expect b kept, got %v", v)
+	}
+	if err := dst.Merge(src, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := dst.Load("b", nil); binary.LittleEndian.Uint64(v) != 3 {
+		t.Errorf("expect b of src, got %v", v)
+	}
+	long := func(key string, d, s []byte) []byte { return make([]byte, 9) }
+	if err := dst.Merge(src, long); !errors.Is(err, ErrValLen) {
+		t.Errorf("expect ErrValLen, got %v", err)
+	}
+}
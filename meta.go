@@ -0,0 +1,141 @@
+package shm
+
+import (
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Meta of an entry
+type Meta struct {
+	// Index of the bucket, as of Ref
+	Index int32
+	// Hash of the key stored in the bucket
+	Hash int32
+	// Flags of the application, see SetFlags
+	Flags uint32
+	// Created when the key was added, zero without Timestamps
+	Created time.Time
+	// Updated by the last Set, zero without Timestamps
+	Updated time.Time
+	// TTL remaining, 0 if none or without Expiration
+	TTL time.Duration
+	// Version of the value, 0 without Versions
+	Version uint64
+	// Pins held on the entry, see Pin
+	Pins int
+	// Owner pid computing the value, see WaitOrCompute, 0 if none
+	Owner int
+}
+
+// offsets of the optional metadata in a bucket, 0 if absent
+type metaLayout struct {
+	// created and updated, unix nano
+	times uintptr
+	// access counter
+	hits uintptr
+	// stored and decompressed value length
+	vlen uintptr
+	// expiry, unix nano, 0 if none
+	expiry uintptr
+	// version counter
+	version uintptr
+	// in-flight marker
+	flight uintptr
+	// first continuation bucket and value length
+	span uintptr
+}
+
+// lay out the metadata after the bucket header,
+// return the offset of the key
+func (l *metaLayout) init(features uint32) uintptr {
+	off := unsafe.Sizeof(bucket{})
+	if features&featTimes != 0 {
+		l.times = off
+		off += 16
+	}
+	if features&featHits != 0 {
+		l.hits = off
+		off += 8
+	}
+	if features&(featSnappy|featZstd) != 0 {
+		l.vlen = off
+		off += 8
+	}
+	if features&featExpiry != 0 {
+		l.expiry = off
+		off += 8
+	}
+	if features&featVersion != 0 {
+		l.version = off
+		off += 8
+	}
+	if features&featFlight != 0 {
+		l.flight = off
+		off += 8
+	}
+	if features&featSpan != 0 {
+		l.span = off
+		off += 8
+	}
+	return off
+}
+
+// GetWithMeta get the value of key with its metadata
+func (m *Map) GetWithMeta(key string) (b []byte, meta Meta, err error) {
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	bkt, err := m.lookup(key, false)
+	if err != nil {
+		return
+	}
+	if missing(bkt) {
+		return nil, meta, ErrMissing
+	}
+	meta.Index = m.index(bkt)
+	meta.Hash = atomic.LoadInt32(&bkt.hash)
+	meta.Flags = atomic.LoadUint32(&bkt.flags) & FlagMask
+	meta.Pins = int(atomic.LoadUint32(&bkt.flags) >> pinShift)
+	meta.TTL = m.ttlOf(bkt)
+	meta.Owner = m.ownerOf(bkt)
+	if m.meta.version != 0 {
+		meta.Version = atomic.LoadUint64(bkt.version(m))
+	}
+	if m.meta.times != 0 {
+		ts := bkt.times(m)
+		meta.Created = time.Unix(0, atomic.LoadInt64(&ts[0]))
+		meta.Updated = time.Unix(0, atomic.LoadInt64(&ts[1]))
+	}
+	b, err = m.valueOf(bkt)
+	return
+}
+
+// stamp a new bucket
+func (m *Map) setCreated(b *bucket) {
+	if m.meta.times == 0 {
+		return
+	}
+	ts := b.times(m)
+	now := time.Now().UnixNano()
+	atomic.StoreInt64(&ts[0], now)
+	atomic.StoreInt64(&ts[1], now)
+}
+
+// stamp an updated bucket and audit the write, a value set is no
+// longer missing
+func (m *Map) setUpdated(b *bucket) {
+	m.auditOp(AuditSet, b.key(m))
+	if atomic.LoadUint32(&b.flags)&flagMissing != 0 {
+		setMissing(b, false)
+	}
+	if m.meta.times == 0 {
+		return
+	}
+	atomic.StoreInt64(&b.times(m)[1], time.Now().UnixNano())
+}
+
+// created and updated of a bucket
+func (b *bucket) times(m *Map) *[2]int64 {
+	return (*[2]int64)(unsafe.Pointer(uintptr(unsafe.Pointer(b)) + m.meta.times))
+}
@@ -0,0 +1,40 @@
+package shm
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMap_GetWithMeta(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8, Timestamps(), Expiration())
+	defer m.Close()
+	before := time.Now()
+	if err := m.Set("meta", []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetFlags("meta", 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Touch("meta", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	v, meta, err := m.GetWithMeta("meta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "1234\x00\x00\x00\x00" {
+		t.Errorf("unexpected value %q", v)
+	}
+	if meta.Created.Before(before) || meta.Updated.Before(meta.Created) {
+		t.Errorf("unexpected meta %+v", meta)
+	}
+	ref, _ := m.Ref("meta", false)
+	if meta.Index != ref || meta.Flags != 5 || meta.TTL <= 59*time.Minute || meta.Hash != m.bucket(ref).hash {
+		t.Errorf("unexpected meta %+v", meta)
+	}
+	err = m.Set("meta", []byte("123456789"))
+	if ve, ok := err.(*ValueLenError); !ok || ve.Len != 9 || ve.Cap != m.ValueCap() || !errors.Is(err, ErrValLen) {
+		t.Errorf("expect ErrValLen of 9 bytes, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package shm
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// flagMissing marks an entry known missing, above flagCompressed
+const flagMissing uint32 = 1 << 25
+
+// ErrMissing on reading an entry stored by SetMissing
+var ErrMissing = errors.New("key known missing")
+
+// SetMissing store key as known missing upstream for ttl, a negative
+// cache entry suppressing the lookups of key until it expires
+// reads of the key return ErrMissing instead of a value, iterations
+// skip it, and a write of a value replaces it
+func (m *Map) SetMissing(key string, ttl time.Duration) (err error) {
+	if m.meta.expiry == 0 {
+		return ErrNoExpiry
+	}
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	at := time.Now().UnixNano()
+	if ttl > 0 {
+		at += int64(ttl)
+	}
+	return m.locked(key, true, func(bkt *bucket) error {
+		if err := m.store(bkt, nil, false, 0); err != nil {
+			return err
+		}
+		m.setUpdated(bkt)
+		m.bumpVersion(bkt)
+		setMissing(bkt, true)
+		atomic.StoreInt64(bkt.expiry(m), at)
+		return nil
+	})
+}
+
+// report whether a bucket is known missing
+func missing(b *bucket) bool {
+	return atomic.LoadUint32(&b.flags)&flagMissing != 0
+}
+
+// set or clear the missing flag of a bucket
+func setMissing(b *bucket, on bool) {
+	for {
+		old := atomic.LoadUint32(&b.flags)
+		f := old &^ flagMissing
+		if on {
+			f |= flagMissing
+		}
+		if atomic.CompareAndSwapUint32(&b.flags, old, f) {
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package shm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMap_SetMissing(t *testing.T) {
+	for _, opts := range [][]Option{{InMemory(), Expiration()}, {InMemory(), Expiration(), SingleWriter()}} {
+		m, err := Create("", 64, 16, 8, testMaxTry, initWait, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = m.Set("k", []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		if err = m.SetMissing("k", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if err = m.SetMissing("none", time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"k", "none"} {
+			if _, err = m.Get(key, false); err != ErrMissing {
+				t.Errorf("%s: expect ErrMissing, got %v", key, err)
+			}
+			if _, err = m.Load(key, nil); err != ErrMissing {
+				t.Errorf("%s: expect ErrMissing of Load, got %v", key, err)
+			}
+			if m.Exists(key) {
+				t.Errorf("%s: expect not exists", key)
+			}
+		}
+		m.Foreach(func(key string, value []byte) bool {
+			t.Errorf("expect %s skipped", key)
+			return true
+		})
+		if m.Len() != 2 {
+			t.Errorf("expect 2 entries, got %d", m.Len())
+		}
+		time.Sleep(2 * time.Millisecond)
+		if _, _, err = m.GetStale("none"); err != ErrKeyNot {
+			t.Errorf("expect ErrKeyNot of expired, got %v", err)
+		}
+		if _, err = m.Get("none", false); err != ErrKeyNot {
+			t.Errorf("expect ErrKeyNot of expired, got %v", err)
+		}
+		if err = m.Set("k", []byte("found")); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := m.Get("k", false); err != nil || string(v[:5]) != "found" {
+			t.Errorf("unexpected %q, %v", v, err)
+		}
+		_ = m.Close()
+	}
+	m := newTestMap(t, 64, 16, 8)
+	defer m.Close()
+	var err error
+	if err = m.SetMissing("k", time.Hour); err != ErrNoExpiry {
+		t.Errorf("expect ErrNoExpiry, got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+// Package mpsc provides a bounded ring of records in memory shared by
+// processes, written by many producers and read by a single consumer
+// in batches, producers claim slots by sequence and never wait longer
+// than their timeout
+package mpsc
+
+import (
+	"context"
+	"errors"
+	"github.com/fengyoulin/shm/internal/futex"
+	"github.com/fengyoulin/shm/internal/proc"
+	"math"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// HeaderSize is the bytes before the slots
+const HeaderSize = 64
+
+// bytes of a slot before the record: sequence, producer pid, length
+const slotHeader = 16
+
+// longest a waiter sleeps before checking the ring again
+const checkInterval = 100 * time.Millisecond
+
+var (
+	// ErrSize on a memory too small for a slot
+	ErrSize = errors.New("memory too small")
+	// ErrAlign on a memory not aligned to 8 bytes
+	ErrAlign = errors.New("memory not aligned")
+	// ErrRecordSize on a record size out of range
+	ErrRecordSize = errors.New("record size out of range")
+	// ErrFull on push to a ring full until the timeout
+	ErrFull = errors.New("ring full")
+)
+
+// header in the memory
+type header struct {
+	// next sequence to claim
+	tail uint64
+	// next sequence to consume
+	head uint64
+	// bumped by the consumer on freeing slots, producers wait on it
+	space uint32
+	// bumped by producers on commit, the consumer waits on it
+	ready uint32
+	// the consumer waits on ready
+	sleeping uint32
+	// producers waiting on space
+	waiters uint32
+}
+
+// slot of a record, its sequence is that claiming it plus 1 once the
+// record is committed, the pid of the producer is set before the tail
+// is moved past the slot, so a claimed slot always names its producer
+type slot struct {
+	seq uint64
+	pid uint32
+	len uint32
+}
+
+// Ring of records
+type Ring struct {
+	head  *header
+	slots []byte
+	cap   uint64
+	size  int
+	// bytes of a slot
+	slot int
+	pid  uint32
+}
+
+// At return the ring in b, of records of at most size bytes
+// zeroed memory is an empty ring
+// all processes must pass memory of the same length
+func At(b []byte, size int) (*Ring, error) {
+	if size <= 0 || size > math.MaxInt32 {
+		return nil, ErrRecordSize
+	}
+	s := slotHeader + (size+7)&^7
+	if len(b) < HeaderSize+s {
+		return nil, ErrSize
+	}
+	p := unsafe.Pointer(&b[0])
+	if uintptr(p)&7 != 0 {
+		return nil, ErrAlign
+	}
+	slots := b[HeaderSize:]
+	return &Ring{
+		head:  (*header)(p),
+		slots: slots,
+		cap:   uint64(len(slots) / s),
+		size:  size,
+		slot:  s,
+		pid:   uint32(os.Getpid()),
+	}, nil
+}
+
+// slot of sequence seq, and its record bytes
+func (r *Ring) at(seq uint64) (*slot, []byte) {
+	off := int(seq%r.cap) * r.slot
+	b := r.slots[off : off+r.slot]
+	return (*slot)(unsafe.Pointer(&b[0])), b[slotHeader:]
+}
+
+// Push a record of data, waiting for at most timeout for a free slot
+// if the ring is full, not at all if timeout <= 0
+func (r *Ring) Push(data []byte, timeout time.Duration) error {
+	if len(data) > r.size {
+		return ErrRecordSize
+	}
+	h := r.head
+	var deadline time.Time
+	for {
+		space := atomic.LoadUint32(&h.space)
+		tail := atomic.LoadUint64(&h.tail)
+		if tail-atomic.LoadUint64(&h.head) < r.cap {
+			s, rec := r.at(tail)
+			if !r.claim(s) {
+				runtime.Gosched()
+				continue
+			}
+			if !atomic.CompareAndSwapUint64(&h.tail, tail, tail+1) {
+				atomic.StoreUint32(&s.pid, 0)
+				continue
+			}
+			s.len = uint32(len(data))
+			copy(rec, data)
+			atomic.StoreUint64(&s.seq, tail+1)
+			atomic.AddUint32(&h.ready, 1)
+			if atomic.LoadUint32(&h.sleeping) != 0 {
+				futex.Wake(&h.ready, 1)
+			}
+			return nil
+		}
+		if timeout <= 0 {
+			return ErrFull
+		}
+		if deadline.IsZero() {
+			deadline = time.Now().Add(timeout)
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			return ErrFull
+		}
+		atomic.AddUint32(&h.waiters, 1)
+		futex.Wait(&h.space, space, left)
+		atomic.AddUint32(&h.waiters, ^uint32(0))
+	}
+}
+
+// claim slot s for this process, free or left by a producer that
+// died before moving the tail, false if another producer claims it
+func (r *Ring) claim(s *slot) bool {
+	pid := atomic.LoadUint32(&s.pid)
+	if pid != 0 && proc.Alive(int(pid)) {
+		return false
+	}
+	return atomic.CompareAndSwapUint32(&s.pid, pid, r.pid)
+}
+
+// Consume wait until a record is committed or ctx is done, then call
+// fn with the committed records in order, at most max of them, return
+// how many, the record is only valid during fn
+// only one process may consume at a time
+// the slot of a producer that died before committing is skipped
+func (r *Ring) Consume(ctx context.Context, max int, fn func(rec []byte)) (n int, err error) {
+	h := r.head
+	for {
+		ready := atomic.LoadUint32(&h.ready)
+		if n = r.consume(max, fn); n > 0 {
+			return
+		}
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		atomic.StoreUint32(&h.sleeping, 1)
+		if atomic.LoadUint32(&h.ready) == ready {
+			futex.Wait(&h.ready, ready, checkInterval)
+		}
+		atomic.StoreUint32(&h.sleeping, 0)
+	}
+}
+
+// consume the committed records, at most max
+func (r *Ring) consume(max int, fn func(rec []byte)) (n int) {
+	h := r.head
+	head := atomic.LoadUint64(&h.head)
+	start := head
+	for ; n < max && head < atomic.LoadUint64(&h.tail); head++ {
+		s, rec := r.at(head)
+		if atomic.LoadUint64(&s.seq) != head+1 {
+			// claimed, not committed
+			pid := atomic.LoadUint32(&s.pid)
+			if pid == 0 || proc.Alive(int(pid)) {
+				break
+			}
+		} else {
+			fn(rec[:s.len])
+			n++
+		}
+		atomic.StoreUint32(&s.pid, 0)
+		atomic.StoreUint64(&h.head, head+1)
+	}
+	if head != start {
+		atomic.AddUint32(&h.space, 1)
+		if atomic.LoadUint32(&h.waiters) != 0 {
+			futex.Wake(&h.space, math.MaxInt32)
+		}
+	}
+	return
+}
+
+// Len return the records claimed and not consumed
+func (r *Ring) Len() int {
+	return int(atomic.LoadUint64(&r.head.tail) - atomic.LoadUint64(&r.head.head))
+}
+
+// Cap return the most records
+func (r *Ring) Cap() int {
+	return int(r.cap)
+}
+
+// Size return the largest record
+func (r *Ring) Size() int {
+	return r.size
+}
@@ -0,0 +1,91 @@
+package mpsc
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRing(t *testing.T) {
+	b := make([]byte, HeaderSize+4*(slotHeader+8))
+	r, err := At(b, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if err = r.Push([]byte{byte(i)}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = r.Push(nil, time.Millisecond); err != ErrFull {
+		t.Errorf("expect ErrFull, got %v", err)
+	}
+	var got []byte
+	n, err := r.Consume(context.Background(), 3, func(rec []byte) {
+		got = append(got, rec...)
+	})
+	if err != nil || n != 3 || string(got) != "\x00\x01\x02" {
+		t.Errorf("consume: %d, %v, %v", n, got, err)
+	}
+	// a producer dead after its claim is skipped
+	if err = r.Push([]byte{4}, 0); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := r.at(4)
+	s.seq, s.pid = 0, 1<<31-1
+	got = got[:0]
+	if n, err = r.Consume(context.Background(), 10, func(rec []byte) {
+		got = append(got, rec...)
+	}); err != nil || n != 1 || string(got) != "\x03" || r.Len() != 0 {
+		t.Errorf("consume: %d, %v, %v, left %d", n, got, err, r.Len())
+	}
+	// a producer dead after its claim, before moving the tail
+	s, _ = r.at(r.head.tail)
+	s.pid = 1<<31 - 1
+	if err = r.Push([]byte{5}, 0); err != nil || r.Len() != 1 {
+		t.Errorf("push past a dead claim: %v, len %d", err, r.Len())
+	}
+}
+
+func TestRing_Producers(t *testing.T) {
+	r, err := At(make([]byte, HeaderSize+16*(slotHeader+8)), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const producers, records = 4, 2000
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			var rec [8]byte
+			for i := 0; i < records; i++ {
+				binary.LittleEndian.PutUint32(rec[:], uint32(p))
+				binary.LittleEndian.PutUint32(rec[4:], uint32(i))
+				if err := r.Push(rec[:], time.Minute); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(p)
+	}
+	next := make([]uint32, producers)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for total := 0; total < producers*records; {
+		n, err := r.Consume(ctx, 64, func(rec []byte) {
+			p, i := binary.LittleEndian.Uint32(rec), binary.LittleEndian.Uint32(rec[4:])
+			if i != next[p] {
+				t.Fatalf("producer %d: expect %d, got %d", p, next[p], i)
+			}
+			next[p]++
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += n
+	}
+	wg.Wait()
+}
@@ -0,0 +1,151 @@
+package multimap
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/fengyoulin/shm"
+	"github.com/fengyoulin/shm/arena"
+)
+
+// DefragStats of Defrag
+type DefragStats struct {
+	// Keys whose values were gone over
+	Keys int
+	// Moved values, to free blocks lower in the arena
+	Moved int
+	// MovedBytes of the blocks moved
+	MovedBytes int
+	// Reclaimed bytes given back to the free space of the arena
+	Reclaimed int
+}
+
+// Defrag move the values in blocks high in the arena to free blocks
+// lower, with the set of each key locked in turn, so that the free
+// space left above them is given back to the arena as one, without
+// blocks of the size classes no longer used
+// progress, if not nil, is called with the stats after each key, the
+// moves stop when ctx is done, return its error with the stats
+// the free space is trimmed only if the map is Exclusive, the other
+// processes and goroutines must not add values meanwhile
+func (mm *MultiMap) Defrag(ctx context.Context, progress func(DefragStats)) (st DefragStats, err error) {
+	var keys []string
+	mm.m.Foreach(func(key string, value []byte) bool {
+		keys = append(keys, string([]byte(key)))
+		return true
+	})
+	live, err := mm.liveBytes(keys)
+	if err != nil {
+		return
+	}
+	limit := uint32(arena.HeaderSize + live)
+	c := mm.values.Compactor(limit)
+	defer func() {
+		if e := c.Release(); err == nil {
+			err = e
+		}
+	}()
+	for _, key := range keys {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		if err = mm.moveDown(key, c, limit, &st); err != nil {
+			return
+		}
+		st.Keys++
+		if progress != nil {
+			progress(st)
+		}
+	}
+	release, err := mm.m.Exclusive()
+	if err == shm.ErrAttached {
+		return st, nil
+	}
+	if err != nil {
+		return
+	}
+	defer func() {
+		if e := release(); err == nil {
+			err = e
+		}
+	}()
+	end := uint32(0)
+	if err = mm.foreachNode(keys, func(off uint32) {
+		if e := off + uint32(mm.values.Size(off)); e > end {
+			end = e
+		}
+	}); err != nil {
+		return
+	}
+	st.Reclaimed = c.Trim(end)
+	return
+}
+
+// bytes of the blocks of the values of keys
+func (mm *MultiMap) liveBytes(keys []string) (n int, err error) {
+	err = mm.foreachNode(keys, func(off uint32) {
+		n += mm.values.Size(off)
+	})
+	return
+}
+
+// call fn with the offsets of the nodes of keys, each set locked
+func (mm *MultiMap) foreachNode(keys []string, fn func(off uint32)) error {
+	for _, key := range keys {
+		head, mu, _, err := mm.list(key, false)
+		if err == shm.ErrKeyNot {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = lock(mu); err != nil {
+			return err
+		}
+		for off := binary.LittleEndian.Uint32(head); off != 0; off = binary.LittleEndian.Uint32(mm.values.Bytes(off)) {
+			fn(off)
+		}
+		if err = mu.Unlock(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// move the nodes of key at or above limit below it, a node is linked
+// in its new block when complete
+func (mm *MultiMap) moveDown(key string, c *arena.Compactor, limit uint32, st *DefragStats) (err error) {
+	head, mu, _, err := mm.list(key, false)
+	if err == shm.ErrKeyNot {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	if err = lock(mu); err != nil {
+		return
+	}
+	defer func() {
+		if e := mu.Unlock(); err == nil {
+			err = e
+		}
+	}()
+	link := head
+	for off := binary.LittleEndian.Uint32(link); off != 0; off = binary.LittleEndian.Uint32(link) {
+		node := mm.values.Bytes(off)
+		if off >= limit {
+			if to, ok := c.Alloc(len(node)); ok {
+				moved := mm.values.Bytes(to)
+				copy(moved, node)
+				binary.LittleEndian.PutUint32(link, to)
+				if err = mm.values.Free(off); err != nil {
+					return
+				}
+				st.Moved++
+				st.MovedBytes += mm.values.Size(to)
+				node = moved
+			}
+		}
+		link = node[:nodeHeader]
+	}
+	return
+}
@@ -0,0 +1,269 @@
+// Package multimap maps a key to a set of values, shared by processes,
+// the keys in a map, the values in lists of an arena, or a small one
+// inline with its key
+package multimap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/fengyoulin/shm"
+	"github.com/fengyoulin/shm/arena"
+	"github.com/fengyoulin/shm/database"
+	"github.com/fengyoulin/shm/mapping"
+	"github.com/fengyoulin/shm/sync"
+	"time"
+)
+
+// bytes of the map value of a key, the list head and a mutex
+const (
+	headSize  = 4
+	valueSize = headSize + sync.MutexSize
+)
+
+// bytes of a list node before the value, the next offset
+const nodeHeader = 4
+
+// the longest value inline, after its length byte
+const maxInline = 254
+
+var (
+	// ErrValueSize on a map of too small values
+	ErrValueSize = errors.New("map values too small")
+	// ErrInline on InlineValues out of range
+	ErrInline = errors.New("inline values out of range")
+)
+
+// MultiMap of keys to sets of values
+type MultiMap struct {
+	m      *shm.Map
+	values *arena.Arena
+	// mapping of Open, nil with New
+	mp *mapping.Mapping
+}
+
+// Option of Open
+type Option func(*options)
+
+type options struct {
+	inline int
+}
+
+// InlineValues keep a value of a key of at most n bytes in the map
+// with the key, one value for each key, the others in the arena, so
+// that a key with a small value takes no trip to the arena, n at most
+// 254, taking n+1 bytes of each key
+func InlineValues(n int) Option {
+	return func(o *options) {
+		o.inline = n
+	}
+}
+
+// Open the multimap in the files at path and path.values, for at most
+// keys keys of at most keyLen bytes and values taking valuesSize bytes
+// waiting for at most wait for their locks
+// all processes must pass the same options
+func Open(path string, keys, keyLen, valuesSize int, wait time.Duration, opts ...Option) (mm *MultiMap, err error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.inline < 0 || o.inline > maxInline {
+		return nil, ErrInline
+	}
+	size := valueSize
+	if o.inline > 0 {
+		size += 1 + o.inline
+	}
+	m, err := shm.Create(path, keys, keyLen, size, 0, wait)
+	if err != nil {
+		return
+	}
+	mp, unlock, err := database.Open(path+".values", arena.HeaderSize+valuesSize, wait)
+	if err != nil {
+		_ = m.Close()
+		return
+	}
+	if err = unlock(); err == nil {
+		mm, err = New(m, mp.Bytes())
+	}
+	if err != nil {
+		_ = mp.Close()
+		_ = m.Close()
+		return nil, err
+	}
+	mm.mp = mp
+	return
+}
+
+// New use m for the keys, with values of at least 8 bytes, and the
+// arena in values for the values, the keys must not be deleted
+// map values longer keep a value inline as InlineValues of the bytes
+// beyond, less one
+func New(m *shm.Map, values []byte) (*MultiMap, error) {
+	a, err := arena.At(values)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiMap{m: m, values: a}, nil
+}
+
+// the list head, the mutex and the inline slot of key, the slot
+// empty if values are not inline
+func (mm *MultiMap) list(key string, add bool) (head []byte, mu *sync.Mutex, in []byte, err error) {
+	v, err := mm.m.Get(key, add)
+	if err != nil {
+		return
+	}
+	if len(v) < valueSize {
+		return nil, nil, nil, ErrValueSize
+	}
+	if mu, err = sync.MutexAt(v[headSize:]); err != nil {
+		return
+	}
+	in = v[valueSize:]
+	if len(in) > 1+maxInline {
+		in = in[:1+maxInline]
+	}
+	return v[:headSize], mu, in, nil
+}
+
+// the value in the inline slot, its length plus one in the first byte,
+// 0 if empty
+func inlined(in []byte) (value []byte, ok bool) {
+	if len(in) == 0 || in[0] == 0 {
+		return nil, false
+	}
+	return in[1:in[0]], true
+}
+
+// lock mu, a dead holder may have left the list in any state, but the
+// links are only changed after the node is complete
+func lock(mu *sync.Mutex) error {
+	if err := mu.Lock(); err != nil && err != sync.ErrOwnerDead {
+		return err
+	}
+	return nil
+}
+
+// AddValue add value to the set of key, false if it was there
+func (mm *MultiMap) AddValue(key string, value []byte) (added bool, err error) {
+	head, mu, in, err := mm.list(key, true)
+	if err != nil {
+		return
+	}
+	if err = lock(mu); err != nil {
+		return
+	}
+	defer func() {
+		if e := mu.Unlock(); err == nil {
+			err = e
+		}
+	}()
+	if v, ok := inlined(in); ok && bytes.Equal(v, value) {
+		return false, nil
+	}
+	if _, _, ok := mm.find(head, value); ok {
+		return false, nil
+	}
+	// the length last, a dead holder leaves the slot empty
+	if _, ok := inlined(in); !ok && len(in) > len(value) {
+		copy(in[1:], value)
+		in[0] = byte(len(value) + 1)
+		return true, nil
+	}
+	off, err := mm.values.Alloc(nodeHeader + len(value))
+	if err != nil {
+		return
+	}
+	node := mm.values.Bytes(off)
+	copy(node[nodeHeader:], value)
+	copy(node[:nodeHeader], head)
+	binary.LittleEndian.PutUint32(head, off)
+	return true, nil
+}
+
+// RemoveValue remove value from the set of key, false if it was not there
+func (mm *MultiMap) RemoveValue(key string, value []byte) (removed bool, err error) {
+	head, mu, in, err := mm.list(key, false)
+	if err == shm.ErrKeyNot {
+		return false, nil
+	}
+	if err != nil {
+		return
+	}
+	if err = lock(mu); err != nil {
+		return
+	}
+	defer func() {
+		if e := mu.Unlock(); err == nil {
+			err = e
+		}
+	}()
+	if v, ok := inlined(in); ok && bytes.Equal(v, value) {
+		in[0] = 0
+		return true, nil
+	}
+	prev, off, ok := mm.find(head, value)
+	if !ok {
+		return false, nil
+	}
+	copy(prev, mm.values.Bytes(off)[:nodeHeader])
+	return true, mm.values.Free(off)
+}
+
+// ForeachValue call fn with the values of key, until fn return false,
+// the set is locked meanwhile, fn must not change it
+func (mm *MultiMap) ForeachValue(key string, fn func(value []byte) bool) (err error) {
+	head, mu, in, err := mm.list(key, false)
+	if err == shm.ErrKeyNot {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	if err = lock(mu); err != nil {
+		return
+	}
+	defer func() {
+		if e := mu.Unlock(); err == nil {
+			err = e
+		}
+	}()
+	if v, ok := inlined(in); ok && !fn(v) {
+		return
+	}
+	for off := binary.LittleEndian.Uint32(head); off != 0; {
+		node := mm.values.Bytes(off)
+		if !fn(node[nodeHeader:]) {
+			return
+		}
+		off = binary.LittleEndian.Uint32(node)
+	}
+	return
+}
+
+// find value in the list from head, with the link to it
+func (mm *MultiMap) find(head, value []byte) (link []byte, off uint32, ok bool) {
+	link = head
+	for off = binary.LittleEndian.Uint32(link); off != 0; off = binary.LittleEndian.Uint32(link) {
+		node := mm.values.Bytes(off)
+		if bytes.Equal(node[nodeHeader:], value) {
+			return link, off, true
+		}
+		link = node[:nodeHeader]
+	}
+	return nil, 0, false
+}
+
+// Close the map and the values
+func (mm *MultiMap) Close() error {
+	err := mm.m.Close()
+	if mm.mp != nil {
+		if e := mm.mp.Close(); err == nil {
+			err = e
+		}
+		mm.mp = nil
+	}
+	return err
+}
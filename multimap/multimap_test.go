@@ -0,0 +1,144 @@
+package multimap
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultiMap(t *testing.T) {
+	name := "testmultimap.db"
+	defer os.Remove(name)
+	defer os.Remove(name + ".values")
+	mm, err := Open(name, 64, 16, 4096, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mm.Close()
+	for i, v := range []string{"1", "2", "3", "2"} {
+		added, err := mm.AddValue("tag", []byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != (i < 3) {
+			t.Errorf("add %q: expect %v, got %v", v, i < 3, added)
+		}
+	}
+	if removed, err := mm.RemoveValue("tag", []byte("1")); err != nil || !removed {
+		t.Errorf("remove: %v, %v", removed, err)
+	}
+	if removed, err := mm.RemoveValue("none", []byte("1")); err != nil || removed {
+		t.Errorf("remove from no key: %v, %v", removed, err)
+	}
+	var values []string
+	if err = mm.ForeachValue("tag", func(v []byte) bool {
+		values = append(values, string(v))
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(values)
+	if strings.Join(values, ",") != "2,3" {
+		t.Errorf("expect 2,3, got %v", values)
+	}
+}
+
+func TestMultiMap_InlineValues(t *testing.T) {
+	name := "testmultimapinline.db"
+	defer os.Remove(name)
+	defer os.Remove(name + ".values")
+	mm, err := Open(name, 64, 16, 4096, time.Second, InlineValues(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mm.Close()
+	// the first small value inline, the long one and the next in the arena
+	for _, v := range []string{"a", "a long value", "b"} {
+		if added, err := mm.AddValue("tag", []byte(v)); err != nil || !added {
+			t.Fatalf("add %q: %v, %v", v, added, err)
+		}
+	}
+	if added, err := mm.AddValue("tag", []byte("a")); err != nil || added {
+		t.Errorf("add inline again: %v, %v", added, err)
+	}
+	if used := mm.values.Used(); used != 32+16 {
+		t.Errorf("expect 2 blocks of 32 and 16 in the arena, used %d", used)
+	}
+	if removed, err := mm.RemoveValue("tag", []byte("a")); err != nil || !removed {
+		t.Errorf("remove inline: %v, %v", removed, err)
+	}
+	if added, err := mm.AddValue("tag", []byte("c")); err != nil || !added {
+		t.Errorf("add to the slot freed: %v, %v", added, err)
+	}
+	var values []string
+	if err = mm.ForeachValue("tag", func(v []byte) bool {
+		values = append(values, string(v))
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(values)
+	if strings.Join(values, ",") != "a long value,b,c" {
+		t.Errorf("expect a long value,b,c, got %v", values)
+	}
+	if used := mm.values.Used(); used != 32+16 {
+		t.Errorf("expect c inline, used %d", used)
+	}
+	if _, err = Open("testmultimapbad.db", 64, 16, 4096, time.Second, InlineValues(255)); err != ErrInline {
+		t.Errorf("expect ErrInline, got %v", err)
+	}
+}
+
+func TestMultiMap_Defrag(t *testing.T) {
+	name := "testmultimapdefrag.db"
+	defer os.Remove(name)
+	defer os.Remove(name + ".values")
+	mm, err := Open(name, 64, 16, 4096, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mm.Close()
+	for i := 0; i < 20; i++ {
+		if _, err = mm.AddValue("tag", []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the low blocks free, the high ones in use
+	for i := 0; i < 10; i++ {
+		if _, err = mm.RemoveValue("tag", []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	used := mm.values.Used()
+	calls := 0
+	st, err := mm.Defrag(context.Background(), func(DefragStats) { calls++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Keys != 1 || calls != 1 || st.Moved != 10 {
+		t.Errorf("expect 10 moved of 1 key, got %+v, %d calls", st, calls)
+	}
+	if st.Reclaimed != used/2 || mm.values.Used() != used/2 {
+		t.Errorf("expect %d reclaimed, got %+v, used %d", used/2, st, mm.values.Used())
+	}
+	var values []string
+	if err = mm.ForeachValue("tag", func(v []byte) bool {
+		values = append(values, string(v))
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(values)
+	if strings.Join(values, ",") != "10,11,12,13,14,15,16,17,18,19" {
+		t.Errorf("expect 10 to 19, got %v", values)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = mm.Defrag(ctx, nil); err != context.Canceled {
+		t.Errorf("expect context.Canceled, got %v", err)
+	}
+}
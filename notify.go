@@ -0,0 +1,55 @@
+package shm
+
+// an entry removed from the map, copied for a callback
+type removal struct {
+	fn    func(key string, value []byte)
+	key   string
+	value []byte
+}
+
+// copy the entry of b for fn, nil if fn is nil, with b unreachable
+// or its chain locked
+func (m *Map) removal(b *bucket, fn func(key string, value []byte)) *removal {
+	if fn == nil {
+		return nil
+	}
+	r := &removal{fn: fn, key: string([]byte(b.key(m)))}
+	if v, err := m.valueOf(b); err == nil {
+		r.value = append([]byte(nil), v...)
+	}
+	return r
+}
+
+// call the callback of r, if any
+func (r *removal) notify() {
+	if r != nil {
+		r.fn(r.key, r.value)
+	}
+}
+
+// delete key as deleteIf, audited as op, and call fn with a copy of
+// the entry if deleted, once unlocked
+func (m *Map) deleteNotify(key string, cond func(bkt *bucket) bool, op AuditOp, fn func(key string, value []byte)) bool {
+	deleted, _ := m.deleteIfNotify(key, cond, op, fn)
+	return deleted
+}
+
+// deleteIf calling fn as deleteNotify, cond nil for any entry
+func (m *Map) deleteIfNotify(key string, cond func(bkt *bucket) bool, op AuditOp, fn func(key string, value []byte)) (deleted, ok bool) {
+	var r *removal
+	c := cond
+	if fn != nil {
+		c = func(b *bucket) bool {
+			if cond != nil && !cond(b) {
+				return false
+			}
+			r = m.removal(b, fn)
+			return true
+		}
+	}
+	if deleted, ok = m.deleteIf(key, c); deleted {
+		m.auditOp(op, key)
+		r.notify()
+	}
+	return
+}
@@ -0,0 +1,50 @@
+package shm
+
+import (
+	"github.com/fengyoulin/shm/mapping"
+	"os"
+	"time"
+	"unsafe"
+)
+
+// Open an existing map at path with the parameters in its header,
+// as created, opts after those of the layout, such as Compress with
+// another threshold than 0
+// a map of Segmented files is opened by the path of its segments
+func Open(path string, maxTry int, wait time.Duration, opts ...Option) (m *Map, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		seg, e := os.Open(mapping.SegmentName(path, 0))
+		if e != nil {
+			return
+		}
+		f, err = seg, nil
+		var info os.FileInfo
+		if info, err = f.Stat(); err != nil {
+			_ = f.Close()
+			return
+		}
+		opts = append([]Option{Segmented(int(info.Size()))}, opts...)
+	}
+	if err != nil {
+		return
+	}
+	var hdr header
+	b := (*[unsafe.Sizeof(header{})]byte)(unsafe.Pointer(&hdr))
+	_, err = f.ReadAt(b[:], 0)
+	_ = f.Close()
+	if err != nil || hdr.cap == 0 {
+		return nil, ErrDbSize
+	}
+	if err = checkFormat(&hdr); err != nil {
+		return
+	}
+	valueLen := int(hdr.valueSize)
+	if hdr.features&featValueSize == 0 {
+		// older databases, the value fills the bucket
+		var ml metaLayout
+		valueLen = int(hdr.bucketSize) - int(ml.init(hdr.features)) - int(hdr.keySize)
+	}
+	opts = append(headerOptions(&hdr), opts...)
+	return Create(path, int(hdr.cap), int(hdr.keySize)-1, valueLen, maxTry, wait, opts...)
+}
@@ -0,0 +1,33 @@
+package shm
+
+import (
+	"os"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	name := "testopen.db"
+	defer os.Remove(name)
+	m := newTestFile(t, name, 100, 20, 12, Timestamps(), Compress(Snappy, 8))
+	var err error
+	if err = m.Set("k", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	p := m.Params()
+	if err = m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = Open(name, testMaxTry, initWait); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err = m.Check(p); err != nil {
+		t.Error(err)
+	}
+	if v, err := m.Load("k", nil); err != nil || string(v[:5]) != "value" {
+		t.Errorf("unexpected %q, %v", v, err)
+	}
+	if _, err = Open("testopen.none", testMaxTry, initWait); err == nil {
+		t.Error("expect error on a missing file")
+	}
+}
@@ -0,0 +1,418 @@
+package shm
+
+import (
+	"context"
+	"github.com/fengyoulin/shm/log"
+	"time"
+)
+
+// Option changes the behaviour of Create
+type Option func(*options)
+
+// options collected from Create
+type options struct {
+	syncInterval time.Duration
+	syncDirty    bool
+	prefault     bool
+	reserve      bool
+	ctx          context.Context
+	memory       bool
+	segSize      int
+	hook         func(point string)
+	align        int
+	timestamps   bool
+	counters     bool
+	expiry       bool
+	versions     bool
+	flight       bool
+	slotStats    bool
+	origin       bool
+	temp         bool
+	fair         bool
+	freeBatch    int
+	freeAge      time.Duration
+	fifo         bool
+	quarantine   time.Duration
+	span         bool
+	maxChain     int
+	evict        bool
+	lockTimeout  time.Duration
+	onEvict      func(key string, value []byte)
+	onExpire     func(key string, value []byte)
+	twoChoice    bool
+	cuckoo       bool
+	codec        Codec
+	threshold    int
+	protect      bool
+	single       bool
+	writer       bool
+	readOnly     bool
+	keyLocks     bool
+	keyWaits     bool
+	audit        int
+	acl          bool
+	aclWriters   []int
+	aclReaders   []int
+	events       *log.Log
+	eventKinds   EventKind
+}
+
+// SyncInterval start a background goroutine flushing the mapping
+// to the backing file every d, bounding the data loss on a crash
+func SyncInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.syncInterval = d
+	}
+}
+
+// SyncDirty make the background flush only write the pages this
+// process has touched since the last flush, instead of the whole file
+func SyncDirty() Option {
+	return func(o *options) {
+		o.syncDirty = true
+	}
+}
+
+// Prefault reserve the space of the backing file and fault in
+// every page at Create, trading a slower Create for no page
+// fault latency on the first touch of each bucket
+func Prefault() Option {
+	return func(o *options) {
+		o.prefault = true
+	}
+}
+
+// Reserve allocate the space of the backing file at Create, so that
+// a full file system fails Create with ErrNoSpace instead of a SIGBUS
+// on the first touch of a bucket deep in the file
+func Reserve() Option {
+	return func(o *options) {
+		o.reserve = true
+	}
+}
+
+// Context cancel waiting for the database lock when ctx is done
+// the wait argument of Create still bounds the waiting
+func Context(ctx context.Context) Option {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
+
+// InMemory back the map with an ordinary byte slice instead of
+// a mapped file, path is ignored and nothing is shared with other
+// processes, for hermetic tests of code embedding a Map
+func InMemory() Option {
+	return func(o *options) {
+		o.memory = true
+	}
+}
+
+// Segmented back the map with files of size bytes each, mapped one
+// after another, named path.000, path.001 and so on, for file systems
+// limiting the size of a file, as tmpfs mounts, on linux only
+// size is a multiple of the page size
+func Segmented(size int) Option {
+	return func(o *options) {
+		o.segSize = size
+	}
+}
+
+// Hook call fn at the crash points inside the operations of the map,
+// a panicking fn simulates a process killed at that point
+// meant for crash consistency tests, see package shmtest
+func Hook(fn func(point string)) Option {
+	return func(o *options) {
+		o.hook = fn
+	}
+}
+
+// BucketAlign round the bucket size up to a multiple of n, a power
+// of two from 16 to 4096, 64 keep buckets from straddling cache lines
+func BucketAlign(n int) Option {
+	return func(o *options) {
+		o.align = n
+	}
+}
+
+// Timestamps keep created and updated times in every bucket,
+// reported by GetWithMeta
+func Timestamps() Option {
+	return func(o *options) {
+		o.timestamps = true
+	}
+}
+
+// Expiration keep an expiry time in every bucket, set by Touch,
+// expired entries are not found and deleted by the lookups and
+// ExpireDue, iterations may still see them until then
+func Expiration() Option {
+	return func(o *options) {
+		o.expiry = true
+	}
+}
+
+// Versions keep a version counter in every bucket, bumped by every
+// Set and Update, for GetVersioned and SetIfVersion; the high 32 bits
+// are drawn from a counter of the map when a key is added, so a key
+// deleted and added again does not repeat a version it had
+func Versions() Option {
+	return func(o *options) {
+		o.versions = true
+	}
+}
+
+// InFlight keep an in-flight marker in every bucket, the process
+// computing its value, for WaitOrCompute, with Versions
+func InFlight() Option {
+	return func(o *options) {
+		o.flight = true
+		o.versions = true
+	}
+}
+
+// AccessCounters count the Get of every bucket, reported by HotKeys
+func AccessCounters() Option {
+	return func(o *options) {
+		o.counters = true
+	}
+}
+
+// SlotCounters count the operations on every hash slot,
+// reported by Contention
+func SlotCounters() Option {
+	return func(o *options) {
+		o.slotStats = true
+	}
+}
+
+// RecordOrigin record the pid, host name and library version of the
+// process creating the map with the time, reported by Origin
+func RecordOrigin() Option {
+	return func(o *options) {
+		o.origin = true
+	}
+}
+
+// FairLocks make the processes writing a chain take turns in the
+// order they come, so that one losing the race for the chain lock to
+// the others again and again does not run out of tries under heavy
+// contention, at the cost of a ticket of 16 bytes per hash slot
+// SingleProcess and SingleWriter maps do not race for the locks
+func FairLocks() Option {
+	return func(o *options) {
+		o.fair = true
+	}
+}
+
+// FreeBatch keep up to n buckets freed by the map in the process, and
+// push them to the free list shared with the other processes at once,
+// or after maxAge, cutting the contention on the free list in bulk
+// deletes; the map takes its own back first when adding
+// the buckets of a batch are marked with the pid of the process, left
+// by the Janitor while it is alive; those of a crashed process are
+// recovered by the Janitor, or Repair
+func FreeBatch(n int, maxAge time.Duration) Option {
+	return func(o *options) {
+		o.freeBatch = n
+		o.freeAge = maxAge
+	}
+}
+
+// FreeFIFO reuse the freed buckets in the order they were freed,
+// each no sooner than quarantine after, unless none is left else,
+// instead of the one freed last, so that a reader going over a bucket
+// without the chain lock is less likely to see it reused under it,
+// the free list is then guarded by a lock shared by the processes
+// the quarantine is that of the map created
+func FreeFIFO(quarantine time.Duration) Option {
+	return func(o *options) {
+		o.fifo = true
+		o.quarantine = quarantine
+	}
+}
+
+// SpanValues let a value longer than the value capacity of a bucket
+// span continuation buckets taken from the free buckets, linked from
+// its bucket, so that a few large values need no large buckets for
+// all, at the cost of 8 bytes per bucket, a full map return ErrDbFull,
+// leaving a key added by the operation with an empty value
+// the value of such an entry is got as a copy, assembled
+func SpanValues() Option {
+	return func(o *options) {
+		o.span = true
+	}
+}
+
+// KeyLocks add a lock to each hash slot for LockKey and UnlockKey
+func KeyLocks() Option {
+	return func(o *options) {
+		o.keyLocks = true
+	}
+}
+
+// KeyWaits add a word to each hash slot for WaitForKey, bumped by the
+// writes adding keys to its chain
+func KeyWaits() Option {
+	return func(o *options) {
+		o.keyWaits = true
+	}
+}
+
+// AuditLog record the Set and Delete of every process with its pid,
+// the key and the time in a ring of n entries of 64 bytes in the file,
+// 1024 if n is 0, up to 1<<20, as well as the entries expired and
+// evicted, reported by Audit, to tell which process removed a key
+// the first 40 bytes of a key are kept, the other writes not recorded
+func AuditLog(n int) Option {
+	return func(o *options) {
+		switch {
+		case n <= 0:
+			n = defaultAuditSize
+		case n > maxAuditSize:
+			n = maxAuditSize
+		}
+		o.audit = n
+	}
+}
+
+// ACL record the uids allowed to attach to the map created, writers
+// and readers, up to 16 each, checked by this library on Create and
+// Open: a process of a uid among the readers opens it as ReadOnly, one
+// of a uid in neither list fails with ErrAccess, the creating one too,
+// so that a map shared by a group can be written by the publisher only
+// the file must still be writable by all to map it, the ACL guards
+// against processes going wrong, not against other code; the lists of
+// the map created are kept on open, and not checked on windows
+func ACL(writers, readers []int) Option {
+	return func(o *options) {
+		o.acl = true
+		o.aclWriters = writers
+		o.aclReaders = readers
+	}
+}
+
+// MaxChain bound the length of a hash chain to n, adding a key to
+// a full chain return ErrChainLong instead of growing the chain
+func MaxChain(n int) Option {
+	return func(o *options) {
+		o.maxChain = n
+	}
+}
+
+// EvictChainTail make adding a key to a chain at MaxChain evict the
+// last bucket of the chain, the oldest there, instead of failing
+func EvictChainTail() Option {
+	return func(o *options) {
+		o.evict = true
+	}
+}
+
+// LockTimeout bound the retries of an operation on busy chains by d
+// instead of maxTry, failing with ErrLockTimeout
+// no effect with SingleProcess, whose operations block on the locks
+func LockTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.lockTimeout = d
+	}
+}
+
+// OnEvict call fn with copies of the key and value of every entry
+// evicted by EvictChainTail, after the chain is unlocked, in the
+// goroutine adding the key evicting it
+func OnEvict(fn func(key string, value []byte)) Option {
+	return func(o *options) {
+		o.onEvict = fn
+	}
+}
+
+// OnExpire call fn with copies of the key and value of every entry
+// deleted by ExpireBefore, ExpireDue or a lookup finding it expired,
+// after the chain is unlocked, in the goroutine deleting it
+func OnExpire(fn func(key string, value []byte)) Option {
+	return func(o *options) {
+		o.onExpire = fn
+	}
+}
+
+// Events append the events of kinds to l, for processes tailing it
+// with Subscribe, such as one writing the entries evicted or expired
+// to a store behind the map; the events are appended by the process
+// causing them, so all processes writing the map must give it, after
+// the OnEvict and OnExpire of the process, and dropped on an error of
+// the log, see DroppedEvents
+func Events(l *log.Log, kinds EventKind) Option {
+	return func(o *options) {
+		o.events = l
+		o.eventKinds = kinds
+	}
+}
+
+// TwoChoice hash every key to two slots and add it to the shorter
+// chain, lookups check both, bounding the chain length on skewed keys
+func TwoChoice() Option {
+	return func(o *options) {
+		o.twoChoice = true
+	}
+}
+
+// Cuckoo use cuckoo hashing, two tables of slots holding one bucket
+// each, an add displace residents to their other slots, lookups
+// check exactly two slots, at the cost of a slower add and twice
+// the hash area
+func Cuckoo() Option {
+	return func(o *options) {
+		o.cuckoo = true
+	}
+}
+
+// Compress store values longer than threshold bytes compressed with
+// codec when that shrinks them, so values larger than valueLen fit
+// if they compress to it, up to 64 times valueLen, Get return a
+// decompressed copy of those
+func Compress(codec Codec, threshold int) Option {
+	return func(o *options) {
+		o.codec = codec
+		o.threshold = threshold
+	}
+}
+
+// Protect turn the faults of an access to a backing file truncated
+// by another process into ErrDetached instead of a crash, Delete
+// return false and the iterations stop on it, see Detached
+func Protect() Option {
+	return func(o *options) {
+		o.protect = true
+	}
+}
+
+// SingleProcess declare the map is used by this process only, the
+// chains are guarded by in-process read write locks instead of the
+// shared slot locks and serials, with no retries
+// no effect with TwoChoice or Cuckoo, whose chains keep the slot locks
+func SingleProcess() Option {
+	return func(o *options) {
+		o.single = true
+	}
+}
+
+// SingleWriter declare one goroutine of one process writes the map,
+// the writer does not lock the chains but bumps their serials, which
+// readers validate like a seqlock, all processes must give it
+// ErrWriterSlots with TwoChoice or Cuckoo, SingleProcess is ignored
+// with it
+func SingleWriter() Option {
+	return func(o *options) {
+		o.writer = true
+	}
+}
+
+// ReadOnly make the writes of this process fail with ErrReadOnly, and
+// leave the expired entries to the writers, for the processes reading
+// a map written by one, as a replica applied by a follower, see
+// SingleWriter; the slices of Get may still be written through
+func ReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
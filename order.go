@@ -0,0 +1,70 @@
+package shm
+
+import (
+	"sort"
+)
+
+// Order of ForeachOrdered
+type Order int
+
+const (
+	// OrderBucket is the order of the buckets, as Foreach
+	OrderBucket Order = iota
+	// OrderHash is the order of the key hashes, then of the keys
+	OrderHash
+	// OrderKey is the order of the keys
+	OrderKey
+)
+
+// ForeachOrdered is Foreach in order, the same across runs and
+// processes for the same entries except OrderBucket, sorting an index
+// of the keys first, entries deleted meanwhile are skipped
+func (m *Map) ForeachOrdered(order Order, fn func(key string, value []byte) bool) {
+	if order == OrderBucket {
+		m.Foreach(fn)
+		return
+	}
+	if m.protect {
+		var err error
+		defer m.protected(&err)()
+	}
+	type entry struct {
+		idx  int32
+		hash uint32
+		key  string
+	}
+	var index []entry
+	for i := int32(0); i < m.head.cap; i++ {
+		bkt := m.bucket(i)
+		if bkt.used == 0 {
+			continue
+		}
+		key := string([]byte(bkt.key(m)))
+		e := entry{idx: i, key: key}
+		if order == OrderHash {
+			h, _ := hashFunc(key)
+			e.hash = uint32(h)
+		}
+		index = append(index, e)
+	}
+	sort.Slice(index, func(i, j int) bool {
+		a, b := &index[i], &index[j]
+		if a.hash != b.hash {
+			return a.hash < b.hash
+		}
+		return a.key < b.key
+	})
+	for _, e := range index {
+		bkt := m.bucket(e.idx)
+		if bkt.used == 0 || missing(bkt) || bkt.key(m) != e.key {
+			continue
+		}
+		v, err := m.valueOf(bkt)
+		if err != nil {
+			continue
+		}
+		if !fn(e.key, v) {
+			return
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package shm
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMap_ForeachOrdered(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8)
+	defer m.Close()
+	for _, k := range []string{"c", "a", "d", "b"} {
+		if err := m.Set(k, []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var keys, hashed []string
+	m.ForeachOrdered(OrderKey, func(key string, value []byte) bool {
+		if key != string(value[:1]) {
+			t.Errorf("key %q of value %q", key, value)
+		}
+		keys = append(keys, key)
+		return true
+	})
+	if strings.Join(keys, "") != "abcd" {
+		t.Errorf("expect abcd, got %v", keys)
+	}
+	m.ForeachOrdered(OrderHash, func(key string, value []byte) bool {
+		hashed = append(hashed, key)
+		return len(hashed) < 3
+	})
+	if len(hashed) != 3 || !sort.SliceIsSorted(hashed, func(i, j int) bool {
+		hi, _ := hashFunc(hashed[i])
+		hj, _ := hashFunc(hashed[j])
+		return uint32(hi) < uint32(hj)
+	}) {
+		t.Errorf("expect 3 keys in hash order, got %v", hashed)
+	}
+}
@@ -0,0 +1,97 @@
+package shm
+
+import (
+	"errors"
+	"os"
+	"runtime/debug"
+	"time"
+	"unsafe"
+)
+
+// modulePath of this library in the build info
+const modulePath = "github.com/fengyoulin/shm"
+
+// ErrNoOrigin on Origin of a map created without RecordOrigin
+var ErrNoOrigin = errors.New("map without origin")
+
+// Origin of a map, the process creating its file
+type Origin struct {
+	// PID of the creating process
+	PID int
+	// Host name of its machine
+	Host string
+	// Version of this library it was built with, "(devel)" if not
+	// known, as the module version in its build info
+	Version string
+	// Created time of the map
+	Created time.Time
+}
+
+// origin record after the header, 128 bytes
+type origin struct {
+	pid     int32
+	_       int32
+	created int64
+	version [48]byte
+	host    [64]byte
+}
+
+// Origin return the process creating the map, recorded at Create with
+// RecordOrigin, to tell who made a file and with which version
+func (m *Map) Origin() (o Origin, err error) {
+	if m.head.features&featOrigin == 0 {
+		return o, ErrNoOrigin
+	}
+	r := originOf(m.head)
+	return Origin{
+		PID:     int(r.pid),
+		Host:    cString(r.host[:]),
+		Version: cString(r.version[:]),
+		Created: time.Unix(0, r.created),
+	}, nil
+}
+
+// record the running process in r
+func (r *origin) record() {
+	r.pid = int32(os.Getpid())
+	r.created = time.Now().UnixNano()
+	host, _ := os.Hostname()
+	copy(r.host[:len(r.host)-1], host)
+	copy(r.version[:len(r.version)-1], libVersion())
+}
+
+// the origin record of a map of header h
+func originOf(h *header) *origin {
+	return (*origin)(unsafe.Pointer(uintptr(unsafe.Pointer(h)) + unsafe.Sizeof(header{})))
+}
+
+// module version of this library in the build info of the binary
+func libVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, d := range info.Deps {
+		if d.Path != modulePath {
+			continue
+		}
+		if d.Replace != nil && d.Replace.Version != "" {
+			return d.Replace.Version
+		}
+		return d.Version
+	}
+	return "(devel)"
+}
+
+// the bytes of b up to the first zero
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
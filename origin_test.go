@@ -0,0 +1,45 @@
+package shm
+
+import (
+	"github.com/fengyoulin/shm/mapping"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMap_Origin(t *testing.T) {
+	m, err := NewFromMapping(mapping.NewMemory(1<<16), 64, 16, 8, testMaxTry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Origin(); err != ErrNoOrigin {
+		t.Errorf("expect ErrNoOrigin, got %v", err)
+	}
+	name := "testorigin.db"
+	start := time.Now().Add(-time.Second)
+	if m, err = Create(name, 64, 16, 8, testMaxTry, initWait, RecordOrigin()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	if err = m.Set("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = Open(name, testMaxTry, initWait); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	o, err := m.Origin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, _ := os.Hostname()
+	if o.PID != os.Getpid() || o.Host != host || o.Version == "" || o.Created.Before(start) {
+		t.Errorf("unexpected origin %+v", o)
+	}
+	if v, err := m.Load("a", nil); err != nil || v[0] != '1' {
+		t.Errorf("unexpected %q, %v", v, err)
+	}
+}
@@ -0,0 +1,182 @@
+package shm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// hash algorithms of the slots, the second for TwoChoice and Cuckoo
+const (
+	// HashCRC32 is crc32 with the IEEE polynomial
+	HashCRC32 = "crc32"
+	// HashCRC32C is crc32 with the Castagnoli polynomial
+	HashCRC32C = "crc32c"
+)
+
+// layout features by the name of their options
+var featureNames = []struct {
+	feature uint32
+	name    string
+}{
+	{featTimes, "Timestamps"},
+	{featHits, "AccessCounters"},
+	{featSlotOps, "SlotCounters"},
+	{featTwoChoice, "TwoChoice"},
+	{featCuckoo, "Cuckoo"},
+	{featSnappy, "Compress(Snappy)"},
+	{featZstd, "Compress(Zstd)"},
+	{featSingleWriter, "SingleWriter"},
+	{featExpiry, "Expiration"},
+	{featVersion, "Versions"},
+	{featFlight, "InFlight"},
+	{featOrigin, "RecordOrigin"},
+	{featTickets, "FairLocks"},
+	{featFIFO, "FreeFIFO"},
+	{featSpan, "SpanValues"},
+	{featKeyLocks, "KeyLocks"},
+	{featKeyWaits, "KeyWaits"},
+	{featAudit, "AuditLog"},
+	{featACL, "ACL"},
+}
+
+// Params of a map in effect, after rounding
+type Params struct {
+	// Cap of entries, see Cap
+	Cap int
+	// KeyCap is the longest key in bytes
+	KeyCap int
+	// ValueCap is the longest value in bytes
+	ValueCap int
+	// BucketSize in bytes, key, value and metadata rounded to Align
+	BucketSize int
+	// Align of the buckets, see BucketAlign
+	Align int
+	// Hash algorithms of the slots, the second with two choices
+	Hash []string
+	// Features of the layout, by the name of their options
+	Features []string
+}
+
+// ValueLenError on set a value longer than the value capacity
+type ValueLenError struct {
+	// Len of the value, compressed if it was
+	Len int
+	// Cap of the buckets, see ValueCap
+	Cap int
+}
+
+// Error implements error
+func (e *ValueLenError) Error() string {
+	return fmt.Sprintf("%s: %d bytes, capacity %d", ErrValLen.Error(), e.Len, e.Cap)
+}
+
+// Is make errors.Is(err, ErrValLen) true
+func (e *ValueLenError) Is(target error) bool {
+	return target == ErrValLen
+}
+
+// ValueCap return the bytes a value may take, the value length given
+// to Create rounded up with the bucket
+func (m *Map) ValueCap() int {
+	return m.vcap
+}
+
+// KeyCap return the longest key in bytes, the key length given to
+// Create rounded up
+func (m *Map) KeyCap() int {
+	return int(m.head.keySize) - 1
+}
+
+// BucketSize return the bytes of a bucket, key, value and metadata
+// rounded up to the BucketAlign
+func (m *Map) BucketSize() int {
+	return int(m.head.bucketSize)
+}
+
+// Params return the parameters of the map in effect
+func (m *Map) Params() Params {
+	p := Params{
+		Cap:        m.Cap(),
+		KeyCap:     m.KeyCap(),
+		ValueCap:   m.ValueCap(),
+		BucketSize: m.BucketSize(),
+		Align:      int(m.head.align),
+		Hash:       []string{HashCRC32},
+	}
+	// files of before BucketAlign
+	if p.Align == 0 {
+		p.Align = minAlign
+	}
+	f := m.head.features
+	if f&(featTwoChoice|featCuckoo) != 0 {
+		p.Hash = append(p.Hash, HashCRC32C)
+	}
+	for _, fn := range featureNames {
+		if f&fn.feature != 0 {
+			p.Features = append(p.Features, fn.name)
+		}
+	}
+	return p
+}
+
+// ErrParams on Check of a map of other parameters
+var ErrParams = errors.New("map parameters mismatch")
+
+// ParamsError list the mismatches found by Check
+type ParamsError struct {
+	Mismatches []string
+}
+
+// Error implements error
+func (e *ParamsError) Error() string {
+	return ErrParams.Error() + ": " + strings.Join(e.Mismatches, "; ")
+}
+
+// Is make errors.Is(err, ErrParams) true
+func (e *ParamsError) Is(target error) bool {
+	return target == ErrParams
+}
+
+// Check compare the parameters of the map with expected, as built
+// for, return a *ParamsError listing the mismatches
+// zero fields of expected are not checked, the capacities must be at
+// least those expected, the other fields equal, Features in any order
+func (m *Map) Check(expected Params) error {
+	var pe ParamsError
+	report := func(name string, got, want interface{}) {
+		pe.Mismatches = append(pe.Mismatches, fmt.Sprintf("%s %v, expect %v", name, got, want))
+	}
+	p := m.Params()
+	if p.Cap < expected.Cap {
+		report("cap", p.Cap, expected.Cap)
+	}
+	if p.KeyCap < expected.KeyCap {
+		report("key cap", p.KeyCap, expected.KeyCap)
+	}
+	if p.ValueCap < expected.ValueCap {
+		report("value cap", p.ValueCap, expected.ValueCap)
+	}
+	if expected.BucketSize != 0 && p.BucketSize != expected.BucketSize {
+		report("bucket size", p.BucketSize, expected.BucketSize)
+	}
+	if expected.Align != 0 && p.Align != expected.Align {
+		report("align", p.Align, expected.Align)
+	}
+	if expected.Hash != nil && strings.Join(p.Hash, ",") != strings.Join(expected.Hash, ",") {
+		report("hash", p.Hash, expected.Hash)
+	}
+	if expected.Features != nil {
+		got, want := append([]string(nil), p.Features...), append([]string(nil), expected.Features...)
+		sort.Strings(got)
+		sort.Strings(want)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			report("features", p.Features, expected.Features)
+		}
+	}
+	if len(pe.Mismatches) > 0 {
+		return &pe
+	}
+	return nil
+}
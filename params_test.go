@@ -0,0 +1,42 @@
+package shm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMap_Params(t *testing.T) {
+	m := newTestMap(t, 64, 10, 5, Timestamps(), TwoChoice())
+	defer m.Close()
+	var err error
+	p := m.Params()
+	if p.KeyCap != 11 || p.ValueCap < 5 || p.BucketSize%p.Align != 0 || p.Cap != m.Cap() {
+		t.Errorf("unexpected params %+v", p)
+	}
+	if strings.Join(p.Hash, ",") != "crc32,crc32c" || strings.Join(p.Features, ",") != "Timestamps,TwoChoice" {
+		t.Errorf("unexpected hash %v, features %v", p.Hash, p.Features)
+	}
+	if err = m.Set(strings.Repeat("k", p.KeyCap), make([]byte, p.ValueCap)); err != nil {
+		t.Error(err)
+	}
+	if err = m.Set(strings.Repeat("k", p.KeyCap+1), nil); err != ErrKeyLen {
+		t.Errorf("expect ErrKeyLen, got %v", err)
+	}
+}
+
+func TestMap_Check(t *testing.T) {
+	m := newTestMap(t, 64, 15, 8, Versions(), Timestamps())
+	defer m.Close()
+	if err := m.Check(Params{KeyCap: 15, ValueCap: 8, Features: []string{"Versions", "Timestamps"}}); err != nil {
+		t.Error(err)
+	}
+	if err := m.Check(m.Params()); err != nil {
+		t.Error(err)
+	}
+	err := m.Check(Params{KeyCap: 16, Hash: []string{HashCRC32C}, Features: []string{}})
+	pe, ok := err.(*ParamsError)
+	if !ok || len(pe.Mismatches) != 3 || !errors.Is(err, ErrParams) {
+		t.Errorf("expect 3 mismatches, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package shm
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// the pin count in the reserved flag bits above flagMissing
+const (
+	pinShift        = 26
+	pinOne   uint32 = 1 << pinShift
+	// MaxPins is the most pins held on an entry at once
+	MaxPins = 1<<(32-pinShift) - 1
+)
+
+var (
+	// ErrPinned on CompactTo of a map with pinned entries
+	ErrPinned = errors.New("entries pinned")
+	// ErrPins on Pin of an entry with MaxPins, or Unpin of one with none
+	ErrPins = errors.New("pin count out of range")
+)
+
+// Pin the entry of key against eviction, expiration and CompactTo,
+// under the lock of its chain, for a holder of a slice of Get
+// pins are counted, each must be released by Unpin, and held by the
+// entry in the shared memory, beyond the process pinning
+func (m *Map) Pin(key string) (err error) {
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	return m.locked(key, false, func(bkt *bucket) error {
+		return addPin(bkt, 1)
+	})
+}
+
+// Unpin release a pin of the entry of key, under the lock of its chain
+func (m *Map) Unpin(key string) (err error) {
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	return m.locked(key, false, func(bkt *bucket) error {
+		return addPin(bkt, -1)
+	})
+}
+
+// add d to the pin count of a bucket
+func addPin(b *bucket, d int) error {
+	for {
+		old := atomic.LoadUint32(&b.flags)
+		n := int(old>>pinShift) + d
+		if n < 0 || n > MaxPins {
+			return ErrPins
+		}
+		if atomic.CompareAndSwapUint32(&b.flags, old, old&^(MaxPins<<pinShift)|uint32(n)*pinOne) {
+			return nil
+		}
+	}
+}
+
+// report whether a bucket is pinned
+func pinned(b *bucket) bool {
+	return atomic.LoadUint32(&b.flags) >= pinOne
+}
+
+// report whether a locked chain has an unpinned bucket to evict
+func (m *Map) evictable(ptr *hash) bool {
+	for i := ptr.index(); i >= 0; {
+		b := m.bucket(i)
+		if !pinned(b) {
+			return true
+		}
+		i = b.next
+	}
+	return false
+}
+
+// report whether any bucket in use is pinned
+func (m *Map) anyPinned() bool {
+	for i := int32(0); i < m.head.cap; i++ {
+		if b := m.bucket(i); b.used != 0 && pinned(b) {
+			return true
+		}
+	}
+	return false
+}
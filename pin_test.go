@@ -0,0 +1,70 @@
+package shm
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestMap_Pin(t *testing.T) {
+	for _, single := range []bool{false, true} {
+		opts := []Option{InMemory(), MaxChain(1), EvictChainTail(), Expiration()}
+		if single {
+			opts = append(opts, SingleProcess())
+		}
+		m, err := Create("", 64, 16, 8, testMaxTry, initWait, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = m.Set("p", []byte("p")); err != nil {
+			t.Fatal(err)
+		}
+		if err = m.Pin("p"); err != nil {
+			t.Fatal(err)
+		}
+		if err = m.Touch("p", -1); err != nil {
+			t.Fatal(err)
+		}
+		long := 0
+		for i := 0; i < 1024; i++ {
+			k := strconv.Itoa(i)
+			if err = m.Set(k, []byte("v")); err == ErrChainLong {
+				long++
+			} else if err != nil {
+				t.Fatal(err)
+			} else {
+				m.Delete(k)
+			}
+		}
+		if long == 0 {
+			t.Error("expect adds to the chain of p to fail")
+		}
+		if v, err := m.Load("p", nil); err != nil || v[0] != 'p' {
+			t.Errorf("expect pinned p kept, got %q, %v", v, err)
+		}
+		if n := m.ExpireDue(); n != 0 {
+			t.Errorf("expect none expired, got %d", n)
+		}
+		if _, meta, _ := m.GetWithMeta("p"); meta.Pins != 1 || meta.Flags != 0 {
+			t.Errorf("unexpected meta %+v", meta)
+		}
+		if err = m.CompactTo("testpin.db", initWait); err != ErrPinned {
+			t.Errorf("expect ErrPinned, got %v", err)
+		}
+		if err = m.Unpin("p"); err != nil {
+			t.Fatal(err)
+		}
+		if err = m.Set("q", []byte("q")); err != nil {
+			t.Fatal(err)
+		}
+		if err = m.Unpin("q"); err != ErrPins {
+			t.Errorf("expect ErrPins, got %v", err)
+		}
+		if m.Exists("p") {
+			t.Error("expect p expired once unpinned")
+		}
+		if err = m.Verify(); err != nil {
+			t.Error(err)
+		}
+		_ = m.Close()
+	}
+}
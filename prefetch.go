@@ -0,0 +1,14 @@
+package shm
+
+import "sync/atomic"
+
+// buckets ahead touched by Foreach, a var for the benchmarks
+var prefetchAhead int32 = 4
+
+// touch the header of bucket i, start loading its cache line
+// while the caller still works on the current bucket
+// go has no prefetch, an atomic load is not optimized away and
+// nothing depends on it, so the cpu does not wait for it
+func (m *Map) touch(i int32) {
+	atomic.LoadInt32(&m.bucket(i).used)
+}
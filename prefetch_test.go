@@ -0,0 +1,31 @@
+package shm
+
+import (
+	"fmt"
+	"testing"
+)
+
+func BenchmarkMap_Foreach(b *testing.B) {
+	m := newTestMap(b, 1<<19, 16, 8)
+	defer m.Close()
+	for i := 0; i < 1<<19; i++ {
+		if _, err := m.Get(fmt.Sprintf("%016d", i), true); err != nil {
+			b.Fatal(err)
+		}
+	}
+	defer func(ahead int32) {
+		prefetchAhead = ahead
+	}(prefetchAhead)
+	for _, ahead := range []int32{0, 4} {
+		b.Run(fmt.Sprintf("ahead=%d", ahead), func(b *testing.B) {
+			prefetchAhead = ahead
+			for i := 0; i < b.N; i++ {
+				n := 0
+				m.Foreach(func(key string, value []byte) bool {
+					n++
+					return true
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// Package ratelimit is a rate limiter in shared memory, so the
+// processes sharing it enforce one limit together
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Size of a limiter in bytes
+const Size = 8
+
+var (
+	// ErrSize on a memory too small for a limiter
+	ErrSize = errors.New("memory too small")
+	// ErrAlign on a memory not aligned to 8 bytes
+	ErrAlign = errors.New("memory not aligned")
+	// ErrRate on a rate or burst out of range
+	ErrRate = errors.New("rate or burst invalid")
+)
+
+// Limiter allow rate events a second with bursts of burst events,
+// the generic cell rate algorithm over a word holding the theoretical
+// arrival time in unix nanoseconds, updated by CAS
+// the processes share the wall clock, not the monotonic one
+type Limiter struct {
+	tat      *int64
+	interval int64
+	burst    int64
+}
+
+// At return the limiter in the first Size bytes of b, all users
+// must agree on rate and burst, zeroed memory is a full bucket
+func At(b []byte, rate float64, burst int) (*Limiter, error) {
+	if rate <= 0 || burst <= 0 {
+		return nil, ErrRate
+	}
+	if len(b) < Size {
+		return nil, ErrSize
+	}
+	p := unsafe.Pointer(&b[0])
+	if uintptr(p)&7 != 0 {
+		return nil, ErrAlign
+	}
+	interval := int64(float64(time.Second) / rate)
+	if interval <= 0 {
+		return nil, ErrRate
+	}
+	return &Limiter{tat: (*int64)(p), interval: interval, burst: int64(burst)}, nil
+}
+
+// Allow one event now
+func (l *Limiter) Allow() bool {
+	ok, _ := l.AllowN(1)
+	return ok
+}
+
+// AllowN allow n events now, or report how long until they would be
+func (l *Limiter) AllowN(n int) (ok bool, retryAfter time.Duration) {
+	cost := int64(n) * l.interval
+	// the time the bucket may run ahead of now
+	limit := l.burst * l.interval
+	for {
+		now := time.Now().UnixNano()
+		old := atomic.LoadInt64(l.tat)
+		tat := old
+		if tat < now {
+			tat = now
+		}
+		next := tat + cost
+		if ahead := next - now; ahead > limit {
+			return false, time.Duration(ahead - limit)
+		}
+		if atomic.CompareAndSwapInt64(l.tat, old, next) {
+			return true, 0
+		}
+	}
+}
+
+// Wait until one event is allowed or ctx is done
+func (l *Limiter) Wait(ctx context.Context) error {
+	for {
+		ok, after := l.AllowN(1)
+		if ok {
+			return nil
+		}
+		t := time.NewTimer(after)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	mem := make([]byte, Size)
+	a, err := At(mem, 100, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := At(mem, 100, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var allowed int
+	for i := 0; i < 10; i++ {
+		if a.Allow() {
+			allowed++
+		}
+		if b.Allow() {
+			allowed++
+		}
+	}
+	// the burst shared by both, maybe one more refilled meanwhile
+	if allowed < 5 || allowed > 6 {
+		t.Errorf("expect a burst of 5, allowed %d", allowed)
+	}
+	ok, after := a.AllowN(1)
+	if ok || after <= 0 || after > 10*time.Millisecond {
+		t.Errorf("expect retry within 10ms, got %v %v", ok, after)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = b.Wait(ctx); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,103 @@
+package shm
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrReclaim on Reclaim of a map whose mapping cannot drop pages
+var ErrReclaim = errors.New("mapping cannot reclaim pages")
+
+// ReclaimReport of Reclaim, in pages of the buckets
+type ReclaimReport struct {
+	// Pages holding buckets only
+	Pages int
+	// Resident pages before the reclaim, in the page cache for a
+	// file, -1 where not known
+	Resident int
+	// Free pages holding no entry
+	Free int
+	// Reclaimed pages, free and resident, dropped from memory
+	Reclaimed int
+}
+
+// Reclaim drop the pages holding no entry from memory, keeping their
+// content in the backing file, so a large map sparsely used does not
+// pin memory, return the residency of the pages
+// a page is read again from the file on access, only mappings of
+// files, as Reclaimer, drop pages, others return ErrReclaim
+func (m *Map) Reclaim() (r ReclaimReport, err error) {
+	if m.readOnly {
+		return r, ErrReadOnly
+	}
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	rc, ok := m.mp.(Reclaimer)
+	if !ok {
+		return r, ErrReclaim
+	}
+	b := m.mp.Bytes()
+	vec, err := mincore(b)
+	if err != nil {
+		return
+	}
+	ps := os.Getpagesize()
+	size := int(m.head.bucketSize)
+	data := int(m.head.dataOff)
+	end := data + int(m.head.cap)*size
+	r.Resident = -1
+	if vec != nil {
+		r.Resident = 0
+	}
+	// continuation buckets are in use
+	var spans map[int32]struct{}
+	if m.meta.span != 0 {
+		spans = m.spanned(m.head.next, false)
+	}
+	// a run of free resident pages to drop, from off
+	off, n := 0, 0
+	drop := func() error {
+		if n == 0 {
+			return nil
+		}
+		l := n * ps
+		if off+l > len(b) {
+			l = len(b) - off
+		}
+		err := rc.DontNeed(off, l)
+		r.Reclaimed += n
+		n = 0
+		return err
+	}
+	for p := (data + ps - 1) / ps * ps; p < end; p += ps {
+		r.Pages++
+		resident := vec == nil || vec[p/ps]&1 != 0
+		if vec != nil && resident {
+			r.Resident++
+		}
+		free := true
+		// the buckets overlapping the page
+		for i := (p - data) / size; i*size+data < p+ps && i < int(m.head.cap); i++ {
+			if _, ok := spans[int32(i)]; ok || m.bucket(int32(i)).used != 0 {
+				free = false
+				break
+			}
+		}
+		if free {
+			r.Free++
+		}
+		if !free || !resident {
+			if err = drop(); err != nil {
+				return
+			}
+			continue
+		}
+		if n == 0 {
+			off = p
+		}
+		n++
+	}
+	err = drop()
+	return
+}
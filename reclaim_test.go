@@ -0,0 +1,50 @@
+package shm
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestMap_Reclaim(t *testing.T) {
+	name := "testreclaim.db"
+	defer os.Remove(name)
+	m := newTestFile(t, name, 4096, 16, 8)
+	defer m.Close()
+	for i := 0; i < m.Cap(); i++ {
+		if err := m.Set(strconv.Itoa(i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// keep the entries of the first buckets
+	for i := int32(100); i < m.head.cap; i++ {
+		if bkt := m.bucket(i); bkt.used != 0 {
+			m.Delete(bkt.key(m))
+		}
+	}
+	r, err := m.Reclaim()
+	if err == ErrReclaim {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Free == 0 || r.Free >= r.Pages || r.Reclaimed > r.Free {
+		t.Errorf("unexpected report %+v", r)
+	}
+	if r.Resident >= 0 && r.Reclaimed == 0 {
+		t.Errorf("expect pages reclaimed, got %+v", r)
+	}
+	if v, err := m.Get(m.bucket(0).key(m), false); err != nil || string(v[:1]) != "v" {
+		t.Errorf("unexpected %q, %v", v, err)
+	}
+	if r2, err := m.Reclaim(); err != nil || r2.Resident > r.Resident {
+		// the page cache of some file systems keeps the pages
+		t.Errorf("expect at most %d resident, got %+v, %v", r.Resident, r2, err)
+	}
+	n := newTestMap(t, 64, 16, 8)
+	defer n.Close()
+	if _, err = n.Reclaim(); err != ErrReclaim {
+		t.Errorf("expect ErrReclaim, got %v", err)
+	}
+}
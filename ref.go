@@ -0,0 +1,40 @@
+package shm
+
+import (
+	"sync/atomic"
+)
+
+// Ref return the index of the bucket of key, adding key if add,
+// stable until key is deleted, to refer to keys compactly
+func (m *Map) Ref(key string, add bool) (ref int32, err error) {
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	var c *claim
+	if add {
+		c = &claim{}
+	}
+	bkt, err := m.lookupClaim(key, add, c)
+	if err != nil {
+		return -1, err
+	}
+	if c != nil && c.added {
+		m.wakeKey(key)
+	}
+	return m.index(bkt), nil
+}
+
+// KeyOf return the key of the bucket ref, ErrKeyNot if unused
+func (m *Map) KeyOf(ref int32) (key string, err error) {
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	if ref < 0 || ref >= atomic.LoadInt32(&m.head.next) {
+		return "", ErrKeyNot
+	}
+	bkt := m.bucket(ref)
+	if bkt.used == 0 {
+		return "", ErrKeyNot
+	}
+	return bkt.key(m), nil
+}
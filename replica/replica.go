@@ -0,0 +1,383 @@
+// Package replica replicates a map by a change feed: a Primary applies
+// writes to its map and appends them to a shm/log, which followers
+// tail on the same host or over TCP from Serve; a Follower applies them
+// to a map of its own, the only process writing it, so every process
+// opens it with shm.SingleWriter and the application processes with
+// shm.ReadOnly as well, fanning a remote source of truth out to the
+// processes of a host
+// the changes are idempotent in order, so a follower of a map lost may
+// apply the feed again from its start
+package replica
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/fengyoulin/shm"
+	"github.com/fengyoulin/shm/log"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ops of a change
+const (
+	opSet byte = 1 + iota
+	opDelete
+)
+
+// ErrChange on a change of the feed malformed
+var ErrChange = errors.New("malformed change")
+
+// a change is the op, the key as a uvarint length and the bytes, the
+// ttl in milliseconds as a uvarint, 0 to keep the expiry, and the value
+func appendChange(b []byte, op byte, key string, ttl time.Duration, value []byte) []byte {
+	var n [binary.MaxVarintLen64]byte
+	b = append(b, op)
+	b = append(b, n[:binary.PutUvarint(n[:], uint64(len(key)))]...)
+	b = append(b, key...)
+	b = append(b, n[:binary.PutUvarint(n[:], uint64(ttl/time.Millisecond))]...)
+	return append(b, value...)
+}
+
+// apply the change in b to m
+func apply(m *shm.Map, b []byte) error {
+	if len(b) == 0 {
+		return ErrChange
+	}
+	op := b[0]
+	n, k := binary.Uvarint(b[1:])
+	if k <= 0 || n > uint64(len(b)-1-k) {
+		return ErrChange
+	}
+	b = b[1+k:]
+	key := string(b[:n])
+	ttl, k := binary.Uvarint(b[n:])
+	if k <= 0 {
+		return ErrChange
+	}
+	value := b[n+uint64(k):]
+	switch op {
+	case opSet:
+		if err := m.Set(key, value); err != nil {
+			return err
+		}
+		if ttl > 0 {
+			return m.Touch(key, time.Duration(ttl)*time.Millisecond)
+		}
+	case opDelete:
+		if !m.Delete(key) {
+			return shm.ErrTryEnd
+		}
+	default:
+		return ErrChange
+	}
+	return nil
+}
+
+// Primary write a map and feed its changes to a log, in the order
+// applied; the writes to the map by other means are not fed, so one
+// Primary is the only writer of it
+type Primary struct {
+	m   *shm.Map
+	l   *log.Log
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewPrimary of m feeding l
+func NewPrimary(m *shm.Map, l *log.Log) *Primary {
+	return &Primary{m: m, l: l}
+}
+
+// Set the value of key and feed it
+// the write is undone when the feed fails, as on log.ErrFull
+func (p *Primary) Set(key string, value []byte) error {
+	return p.SetTTL(key, value, 0)
+}
+
+// SetTTL set the value of key to expire ttl from now, the expiry kept
+// if ttl is 0, and feed it; the followers expire it from when applied
+func (p *Primary) SetTTL(key string, value []byte, ttl time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	old := p.entry(key)
+	err := p.m.Set(key, value)
+	if err == nil && ttl > 0 {
+		err = p.m.Touch(key, ttl)
+	}
+	if err == nil {
+		err = p.feed(opSet, key, ttl, value)
+	}
+	if err != nil {
+		old.restore(p.m, key)
+	}
+	return err
+}
+
+// Delete key and feed it
+func (p *Primary) Delete(key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	old := p.entry(key)
+	if !p.m.Delete(key) {
+		return shm.ErrTryEnd
+	}
+	err := p.feed(opDelete, key, 0, nil)
+	if err != nil {
+		old.restore(p.m, key)
+	}
+	return err
+}
+
+// an entry of the map before a write, to undo it
+type entry struct {
+	value []byte
+	ttl   time.Duration
+	ok    bool
+}
+
+// the entry of key, not ok if missing
+func (p *Primary) entry(key string) (e entry) {
+	var err error
+	if e.value, err = p.m.Load(key, nil); err != nil {
+		return
+	}
+	e.ok = true
+	e.ttl, _ = p.m.TTL(key)
+	return
+}
+
+// write the entry back as key, delete key if it was missing
+func (e entry) restore(m *shm.Map, key string) {
+	if !e.ok {
+		m.Delete(key)
+		return
+	}
+	if m.Set(key, e.value) != nil {
+		return
+	}
+	if e.ttl > 0 {
+		_ = m.Touch(key, e.ttl)
+	} else {
+		_ = m.Persist(key)
+	}
+}
+
+func (p *Primary) feed(op byte, key string, ttl time.Duration, value []byte) error {
+	p.buf = appendChange(p.buf[:0], op, key, ttl, value)
+	_, err := p.l.Append(p.buf)
+	return err
+}
+
+// Feed of changes, as a log.Reader, Next return the next change,
+// waiting for it until ctx is done, Offset that of the change after it
+type Feed interface {
+	Next(ctx context.Context) ([]byte, error)
+	Offset() uint64
+}
+
+// Follower apply a feed to a map
+type Follower struct {
+	m   *shm.Map
+	off uint64
+}
+
+// NewFollower applying to m the changes from offset off of the feed
+func NewFollower(m *shm.Map, off uint64) *Follower {
+	return &Follower{m: m, off: off}
+}
+
+// Offset of the next change to apply, to follow from on restart
+func (f *Follower) Offset() uint64 {
+	return atomic.LoadUint64(&f.off)
+}
+
+// Apply the changes of feed, until ctx is done or an error
+func (f *Follower) Apply(ctx context.Context, feed Feed) error {
+	for {
+		b, err := feed.Next(ctx)
+		if err != nil {
+			return err
+		}
+		if err = apply(f.m, b); err != nil {
+			return err
+		}
+		atomic.StoreUint64(&f.off, feed.Offset())
+	}
+}
+
+// Run apply the feeds of open from the offset to apply, a feed ended
+// or failed open again after retry, until ctx is done
+func (f *Follower) Run(ctx context.Context, open func(ctx context.Context, off uint64) (Feed, error), retry time.Duration) error {
+	for {
+		feed, err := open(ctx, f.Offset())
+		if err == nil {
+			err = f.Apply(ctx, feed)
+			if c, ok := feed.(io.Closer); ok {
+				_ = c.Close()
+			}
+		}
+		if e := ctx.Err(); e != nil {
+			return e
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retry):
+		}
+	}
+}
+
+// Local open the feed of l, for Follower.Run
+func Local(l *log.Log) func(ctx context.Context, off uint64) (Feed, error) {
+	return func(ctx context.Context, off uint64) (Feed, error) {
+		return l.Tail(off), nil
+	}
+}
+
+// TCP open the feed served at addr, for Follower.Run
+func TCP(addr string) func(ctx context.Context, off uint64) (Feed, error) {
+	return func(ctx context.Context, off uint64) (Feed, error) {
+		c, err := Dial(ctx, addr, off)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+// bytes of a frame header over TCP, the length of the change and the
+// offset after it
+const frameHeader = 12
+
+// the longest change a Conn reads, a longer length is a damaged frame
+const maxChange = 1 << 26
+
+// Serve the log to the followers connecting to ln, until ln is closed
+// a follower sends the offset to follow from, 8 bytes big endian, and
+// receives the changes from it as frames
+func Serve(ln net.Listener, l *log.Log) error {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go serveConn(c, l)
+	}
+}
+
+func serveConn(c net.Conn, l *log.Log) {
+	defer c.Close()
+	var off [8]byte
+	if _, err := io.ReadFull(c, off[:]); err != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the follower sends no more, its close ends the feed
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, c)
+		cancel()
+	}()
+	r := l.Tail(binary.BigEndian.Uint64(off[:]))
+	w := bufio.NewWriter(c)
+	var h [frameHeader]byte
+	for {
+		b, err := r.Next(ctx)
+		if err != nil {
+			return
+		}
+		binary.BigEndian.PutUint32(h[:], uint32(len(b)))
+		binary.BigEndian.PutUint64(h[4:], r.Offset())
+		if _, err = w.Write(h[:]); err == nil {
+			_, err = w.Write(b)
+		}
+		if err == nil && r.Offset() == l.Committed() {
+			err = w.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Conn is a Feed from Serve over TCP
+type Conn struct {
+	c    net.Conn
+	r    *bufio.Reader
+	off  uint64
+	buf  []byte
+	done chan struct{}
+	once sync.Once
+}
+
+// Dial the feed served at addr from offset off, the connection closed
+// when ctx is done
+func Dial(ctx context.Context, addr string, off uint64) (*Conn, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], off)
+	if _, err = c.Write(b[:]); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	conn := &Conn{c: c, r: bufio.NewReader(c), off: off, done: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-conn.done:
+		}
+	}()
+	return conn, nil
+}
+
+// Next change, valid until the next call
+func (c *Conn) Next(ctx context.Context) ([]byte, error) {
+	var h [frameHeader]byte
+	if _, err := io.ReadFull(c.r, h[:]); err != nil {
+		if e := ctx.Err(); e != nil {
+			return nil, e
+		}
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(h[:])
+	if n > maxChange {
+		return nil, ErrChange
+	}
+	if cap(c.buf) < int(n) {
+		c.buf = make([]byte, n)
+	}
+	c.buf = c.buf[:n]
+	if _, err := io.ReadFull(c.r, c.buf); err != nil {
+		if e := ctx.Err(); e != nil {
+			return nil, e
+		}
+		return nil, err
+	}
+	c.off = binary.BigEndian.Uint64(h[4:])
+	return c.buf, nil
+}
+
+// Offset of the change after the last one
+func (c *Conn) Offset() uint64 {
+	return c.off
+}
+
+// Close the connection
+func (c *Conn) Close() (err error) {
+	c.once.Do(func() {
+		close(c.done)
+		err = c.c.Close()
+	})
+	return
+}
@@ -0,0 +1,167 @@
+package replica
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/fengyoulin/shm"
+	"github.com/fengyoulin/shm/log"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFollower(t *testing.T) {
+	names := []string{"testprimary.db", "testprimary.log", "testlocal.db", "testtcp.db"}
+	for _, name := range names {
+		defer os.Remove(name)
+	}
+	m, err := shm.Create(names[0], 256, 16, 16, 10, time.Second, shm.Expiration())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	l, err := log.Open(names[1], 1<<20, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	p := NewPrimary(m, l)
+	for i := 0; i < 100; i++ {
+		if err = p.Set(strconv.Itoa(i), []byte("v"+strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = p.Delete("7"); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetTTL("ttl", []byte("t"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go Serve(ln, l)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	follow := func(name string, open func(ctx context.Context, off uint64) (Feed, error)) (*shm.Map, *Follower) {
+		r, err := shm.Create(name, 256, 16, 16, 10, time.Second, shm.Expiration(), shm.SingleWriter())
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := NewFollower(r, 0)
+		go f.Run(ctx, open, 10*time.Millisecond)
+		return r, f
+	}
+	local, lf := follow(names[2], Local(l))
+	defer local.Close()
+	remote, rf := follow(names[3], TCP(ln.Addr().String()))
+	defer remote.Close()
+	// applied after the followers caught up
+	if err = p.Set("late", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for lf.Offset() != l.Committed() || rf.Offset() != l.Committed() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect the followers at %d, got %d and %d", l.Committed(), lf.Offset(), rf.Offset())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for _, r := range []*shm.Map{local, remote} {
+		if r.Len() != m.Len() {
+			t.Errorf("expect %d keys, got %d", m.Len(), r.Len())
+		}
+		m.Foreach(func(key string, value []byte) bool {
+			if v, err := r.Get(key, false); err != nil || !bytes.Equal(v, value) {
+				t.Errorf("expect %s of %q, got %q, %v", key, value, v, err)
+			}
+			return true
+		})
+		if ttl, err := r.TTL("ttl"); err != nil || ttl <= 0 {
+			t.Errorf("expect a ttl, got %v, %v", ttl, err)
+		}
+	}
+
+	// an application process attached read only
+	ro, err := shm.Create(names[2], 256, 16, 16, 10, time.Second, shm.Expiration(), shm.SingleWriter(), shm.ReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	if v, err := ro.Get("late", false); err != nil || v[0] != 'x' {
+		t.Errorf("expect late read, got %q, %v", v, err)
+	}
+	if err = ro.Set("late", []byte("y")); err != shm.ErrReadOnly {
+		t.Errorf("expect ErrReadOnly, got %v", err)
+	}
+	if ro.Delete("late") {
+		t.Error("expect no delete")
+	}
+}
+
+func TestPrimaryFeedFull(t *testing.T) {
+	names := []string{"testfull.db", "testfull.log"}
+	for _, name := range names {
+		defer os.Remove(name)
+	}
+	m, err := shm.Create(names[0], 256, 16, 16, 10, time.Second, shm.Expiration())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	l, err := log.Open(names[1], 4096, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	p := NewPrimary(m, l)
+	if err = p.SetTTL("k", []byte("old"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	// fill the log, the writes failing to feed are undone
+	var last string
+	for i := 0; err == nil; i++ {
+		last = "n" + strconv.Itoa(i)
+		err = p.Set(last, []byte("v"))
+	}
+	if err != log.ErrFull {
+		t.Fatalf("expect ErrFull, got %v", err)
+	}
+	if m.Exists(last) {
+		t.Errorf("expect %s not set", last)
+	}
+	if err = p.Set("k", []byte("new")); err != log.ErrFull {
+		t.Fatalf("expect ErrFull, got %v", err)
+	}
+	if v, err := m.Load("k", nil); err != nil || string(v[:3]) != "old" {
+		t.Errorf("expect the old value, got %q, %v", v, err)
+	}
+	if ttl, err := m.TTL("k"); err != nil || ttl <= 0 {
+		t.Errorf("expect the old ttl, got %v, %v", ttl, err)
+	}
+	if err = p.Delete("k"); err != log.ErrFull || !m.Exists("k") {
+		t.Errorf("expect the delete undone, got %v", err)
+	}
+}
+
+func TestConnFrameBound(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	c := &Conn{c: a, r: bufio.NewReader(a), done: make(chan struct{})}
+	go func() {
+		var h [frameHeader]byte
+		binary.BigEndian.PutUint32(h[:], maxChange+1)
+		_, _ = b.Write(h[:])
+	}()
+	if _, err := c.Next(context.Background()); err != ErrChange {
+		t.Errorf("expect ErrChange, got %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+package shm
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+	"unsafe"
+)
+
+// residency of the pages of b by mincore, a byte a page from the one
+// holding b[0], bit 0 set if resident, nil where not known
+func mincore(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	ps := uintptr(os.Getpagesize())
+	addr := uintptr(unsafe.Pointer(&b[0]))
+	start := addr &^ (ps - 1)
+	n := addr + uintptr(len(b)) - start
+	vec := make([]byte, (n+ps-1)/ps)
+	_, _, e := unix.Syscall(unix.SYS_MINCORE, start, n, uintptr(unsafe.Pointer(&vec[0])))
+	if e != 0 {
+		return nil, e
+	}
+	return vec, nil
+}
@@ -0,0 +1,53 @@
+package shm
+
+import (
+	"runtime"
+	"time"
+)
+
+// retries of an operation, maxTry of them or until the LockTimeout
+type retries struct {
+	m        *Map
+	left     int
+	deadline time.Time
+	tried    bool
+	fenced   bool
+}
+
+// retries of a new operation
+func (m *Map) retries() retries {
+	if m.lockTimeout > 0 {
+		return retries{m: m, deadline: time.Now().Add(m.lockTimeout)}
+	}
+	return retries{m: m, left: m.try}
+}
+
+// next report whether to try again, yielding between the tries
+// against a deadline, waiting for a Fence to lift
+func (r *retries) next() bool {
+	if r.m.waitFence() != nil {
+		r.fenced = true
+		return false
+	}
+	if r.deadline.IsZero() {
+		r.left--
+		return r.left >= 0
+	}
+	if !r.tried {
+		r.tried = true
+		return true
+	}
+	runtime.Gosched()
+	return time.Now().Before(r.deadline)
+}
+
+// err of the tries run out
+func (r *retries) err() error {
+	if r.fenced {
+		return ErrFenced
+	}
+	if r.deadline.IsZero() {
+		return ErrTryEnd
+	}
+	return ErrLockTimeout
+}
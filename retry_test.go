@@ -0,0 +1,31 @@
+package shm
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMap_LockTimeout(t *testing.T) {
+	for _, timeout := range []time.Duration{0, 10 * time.Millisecond} {
+		m := newTestMap(t, 64, 16, 8, LockTimeout(timeout))
+		var err error
+		if err = m.Set("k", []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+		ss, _, _ := m.slots("k")
+		atomic.StoreInt32(&ss[0].ptr[2], 1)
+		expect := ErrTryEnd
+		if timeout > 0 {
+			expect = ErrLockTimeout
+		}
+		start := time.Now()
+		if err = m.Set("k", []byte("2")); err != expect {
+			t.Errorf("expect %v, got %v", expect, err)
+		}
+		if d := time.Since(start); d < timeout || d > time.Second {
+			t.Errorf("unexpected wait %v of timeout %v", d, timeout)
+		}
+		_ = m.Close()
+	}
+}
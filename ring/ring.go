@@ -0,0 +1,295 @@
+// Package ring routes keys to one of several maps by consistent
+// hashing, for sharding beyond the size of one file, or across tmpfs
+// mounts: each map is a node of many points on a ring of hashes, and a
+// key belongs to the node of the first point after its hash, so adding
+// or removing a node moves only the keys of its share
+package ring
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fengyoulin/shm"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// default points of a node
+const defaultPoints = 128
+
+var (
+	// ErrEmpty on route a key on a ring of no nodes
+	ErrEmpty = errors.New("ring of no nodes")
+	// ErrNode on add a node of a name on the ring
+	ErrNode = errors.New("node already on the ring")
+	// ErrNoNode on a node not on the ring
+	ErrNoNode = errors.New("node not on the ring")
+)
+
+type point struct {
+	h    uint64
+	node string
+}
+
+// Ring of maps by name
+type Ring struct {
+	points int
+	mu     sync.RWMutex
+	ring   []point
+	nodes  map[string]*shm.Map
+}
+
+// New ring of points points a node, 128 if 0
+func New(points int) *Ring {
+	if points <= 0 {
+		points = defaultPoints
+	}
+	return &Ring{points: points, nodes: make(map[string]*shm.Map)}
+}
+
+// Open the maps at paths with the params of shm.Create, as the nodes
+// of a ring named by their paths
+func Open(paths []string, points, mapCap, keyLen, valueLen, maxTry int, wait time.Duration, opts ...shm.Option) (r *Ring, err error) {
+	r = New(points)
+	for _, path := range paths {
+		var m *shm.Map
+		if m, err = shm.Create(path, mapCap, keyLen, valueLen, maxTry, wait, opts...); err == nil {
+			err = r.Add(path, m)
+		}
+		if err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+	}
+	return
+}
+
+// hash of a key, a 64-bit fnv-1a mixed, the map hashing its keys by
+// crc32 apart from it
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// Add the map m as the node name, whose share of the keys in the other
+// nodes are moved to it by Rebalance
+func (r *Ring) Add(name string, m *shm.Map) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.nodes[name]; ok {
+		return ErrNode
+	}
+	r.nodes[name] = m
+	for i := 0; i < r.points; i++ {
+		r.ring = append(r.ring, point{h: hash(name + "#" + strconv.Itoa(i)), node: name})
+	}
+	sort.Slice(r.ring, func(i, j int) bool {
+		if r.ring[i].h != r.ring[j].h {
+			return r.ring[i].h < r.ring[j].h
+		}
+		return r.ring[i].node < r.ring[j].node
+	})
+	return nil
+}
+
+// Remove the node name, return its map, the keys in it left there, see
+// Drain
+func (r *Ring) Remove(name string) (*shm.Map, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.nodes[name]
+	if !ok {
+		return nil, ErrNoNode
+	}
+	delete(r.nodes, name)
+	ring := r.ring[:0]
+	for _, p := range r.ring {
+		if p.node != name {
+			ring = append(ring, p)
+		}
+	}
+	r.ring = ring
+	return m, nil
+}
+
+// Nodes of the ring, sorted
+func (r *Ring) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.nodes))
+	for name := range r.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Node of key, its name and map
+func (r *Ring) Node(key string) (string, *shm.Map, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.node(key)
+}
+
+func (r *Ring) node(key string) (string, *shm.Map, error) {
+	if len(r.ring) == 0 {
+		return "", nil, ErrEmpty
+	}
+	h := hash(key)
+	i := sort.Search(len(r.ring), func(i int) bool {
+		return r.ring[i].h >= h
+	})
+	if i == len(r.ring) {
+		i = 0
+	}
+	name := r.ring[i].node
+	return name, r.nodes[name], nil
+}
+
+// Load a copy of the value of key from its node, see Map.Load
+func (r *Ring) Load(key string, dst []byte) ([]byte, error) {
+	_, m, err := r.Node(key)
+	if err != nil {
+		return nil, err
+	}
+	return m.Load(key, dst)
+}
+
+// Set the value of key in its node
+func (r *Ring) Set(key string, value []byte) error {
+	_, m, err := r.Node(key)
+	if err != nil {
+		return err
+	}
+	return m.Set(key, value)
+}
+
+// Delete key from its node, false as of Map.Delete
+func (r *Ring) Delete(key string) bool {
+	_, m, err := r.Node(key)
+	return err == nil && m.Delete(key)
+}
+
+// Rebalance move the keys not in their nodes to them, after Add, and
+// return the keys moved; a key is missed at its node until moved, and
+// a write to it in the node it is moved from meanwhile may be lost
+func (r *Ring) Rebalance() (moved int, err error) {
+	for _, name := range r.Nodes() {
+		r.mu.RLock()
+		m := r.nodes[name]
+		r.mu.RUnlock()
+		if m == nil {
+			continue
+		}
+		n, err := r.move(m, name)
+		moved += n
+		if err != nil {
+			return moved, err
+		}
+	}
+	return
+}
+
+// Drain remove the node name and move its keys to the other nodes,
+// return its map and the keys moved
+func (r *Ring) Drain(name string) (m *shm.Map, moved int, err error) {
+	if m, err = r.Remove(name); err != nil {
+		return
+	}
+	moved, err = r.move(m, "")
+	return
+}
+
+// move the keys of m not belonging to node from to their nodes, those
+// known missing included
+func (r *Ring) move(m *shm.Map, from string) (moved int, err error) {
+	var keys []string
+	for ref := int32(0); ref < int32(m.Cap()); ref++ {
+		if key, e := m.KeyOf(ref); e == nil {
+			// a copy, the bucket may be reused
+			keys = append(keys, string([]byte(key)))
+		}
+	}
+	var buf []byte
+	for _, key := range keys {
+		r.mu.RLock()
+		name, dst, e := r.node(key)
+		r.mu.RUnlock()
+		if e != nil {
+			return moved, e
+		}
+		if name == from {
+			continue
+		}
+		if buf, err = m.Load(key, buf[:0]); err == shm.ErrKeyNot {
+			continue
+		}
+		if err == nil {
+			err = copyEntry(dst, m, key, buf)
+		} else if err == shm.ErrMissing {
+			err = copyMissing(dst, m, key)
+		}
+		if err != nil {
+			return moved, fmt.Errorf("move %q to %s: %w", key, name, err)
+		}
+		m.Delete(key)
+		moved++
+	}
+	return moved, nil
+}
+
+// set key of value in dst with its flags and ttl in src
+func copyEntry(dst, src *shm.Map, key string, value []byte) error {
+	if err := dst.Set(key, value); err != nil {
+		return err
+	}
+	if f, err := src.GetFlags(key); err == nil && f != 0 {
+		if err = dst.SetFlags(key, f); err != nil {
+			return err
+		}
+	}
+	if ttl, err := src.TTL(key); err == nil && ttl > 0 {
+		if err = dst.Touch(key, ttl); err != nil && err != shm.ErrNoExpiry {
+			return err
+		}
+	}
+	return nil
+}
+
+// set key known missing in dst for its ttl in src, if dst has
+// Expiration, a key expired meanwhile not set
+func copyMissing(dst, src *shm.Map, key string) error {
+	ttl, err := src.TTL(key)
+	if err == shm.ErrKeyNot || err == nil && ttl <= 0 {
+		return nil
+	}
+	if err == nil {
+		err = dst.SetMissing(key, ttl)
+	}
+	if err == shm.ErrNoExpiry {
+		return nil
+	}
+	return err
+}
+
+// Close the maps of the nodes
+func (r *Ring) Close() (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, m := range r.nodes {
+		if e := m.Close(); err == nil {
+			err = e
+		}
+		delete(r.nodes, name)
+	}
+	r.ring = nil
+	return
+}
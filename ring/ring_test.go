@@ -0,0 +1,129 @@
+package ring
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fengyoulin/shm"
+)
+
+func TestRing(t *testing.T) {
+	paths := []string{"testring0.db", "testring1.db", "testring2.db"}
+	for _, path := range paths {
+		defer os.Remove(path)
+	}
+	r, err := Open(paths[:2], 0, 2048, 16, 16, 10, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err = r.Add(paths[0], nil); err != ErrNode {
+		t.Errorf("expect ErrNode, got %v", err)
+	}
+	const n = 1000
+	for i := 0; i < n; i++ {
+		if err = r.Set(strconv.Itoa(i), []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := func() (total int, lens []int) {
+		for _, name := range r.Nodes() {
+			r.mu.RLock()
+			l := r.nodes[name].Len()
+			r.mu.RUnlock()
+			lens = append(lens, l)
+			total += l
+		}
+		return
+	}
+	check := func() {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			k := strconv.Itoa(i)
+			if v, err := r.Load(k, nil); err != nil || !bytes.Equal(bytes.TrimRight(v, "\x00"), []byte(k)) {
+				t.Fatalf("expect %s, got %q, %v", k, v, err)
+			}
+		}
+		if total, lens := count(); total != n {
+			t.Errorf("expect %d keys, got %v", n, lens)
+		}
+	}
+	check()
+
+	r3, err := Open(paths[2:], 0, 2048, 16, 16, 10, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := r3.Remove(paths[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Add(paths[2], m); err != nil {
+		t.Fatal(err)
+	}
+	moved, err := r.Rebalance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// about a third moves to the node added
+	if moved < n/6 || moved > n/2 || m.Len() != moved {
+		t.Errorf("expect about %d keys moved, got %d, %d in the node", n/3, moved, m.Len())
+	}
+	check()
+	if moved, err = r.Rebalance(); err != nil || moved != 0 {
+		t.Errorf("expect none moved again, got %d, %v", moved, err)
+	}
+
+	d, moved, err := r.Drain(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if d.Len() != 0 || moved == 0 {
+		t.Errorf("expect the node drained, got %d left, %d moved", d.Len(), moved)
+	}
+	check()
+}
+
+func TestRing_Missing(t *testing.T) {
+	paths := []string{"testringmiss0.db", "testringmiss1.db"}
+	for _, path := range paths {
+		defer os.Remove(path)
+	}
+	r, err := Open(paths, 0, 256, 16, 16, 10, time.Second, shm.Expiration())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	const n = 50
+	for i := 0; i < n; i++ {
+		_, m, err := r.Node(strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = m.SetMissing(strconv.Itoa(i), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d, moved, err := r.Drain(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if d.Len() != 0 || moved == 0 {
+		t.Errorf("expect the node drained, got %d left, %d moved", d.Len(), moved)
+	}
+	for i := 0; i < n; i++ {
+		k := strconv.Itoa(i)
+		if _, err := r.Load(k, nil); err != shm.ErrMissing {
+			t.Fatalf("expect %s missing, got %v", k, err)
+		}
+		_, m, _ := r.Node(k)
+		if ttl, err := m.TTL(k); err != nil || ttl <= 59*time.Minute {
+			t.Errorf("expect the ttl of %s kept, got %v, %v", k, ttl, err)
+		}
+	}
+}
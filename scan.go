@@ -0,0 +1,42 @@
+package shm
+
+// Scan call fn with the entries of the buckets from cursor, at most
+// count of them, until fn return false, return the cursor to scan on
+// from, 0 when done, a scan from 0 to done visits each key present
+// throughout exactly once, keys set or deleted meanwhile maybe not
+// expired entries, those known missing, and values failing to
+// decompress are skipped
+// a fault of a detached map return ErrDetached, with cursor as next
+func (m *Map) Scan(cursor, count int, fn func(key string, value []byte) bool) (next int, err error) {
+	if m.protect {
+		defer func() {
+			if err != nil {
+				next = cursor
+			}
+		}()
+		defer m.protected(&err)()
+	}
+	i := int32(cursor)
+	if cursor < 0 || cursor >= int(m.head.cap) {
+		return 0, nil
+	}
+	for n := 0; i < m.head.cap && n < count; i++ {
+		bkt := m.bucket(i)
+		if bkt.used == 0 || missing(bkt) || m.expired(bkt) {
+			continue
+		}
+		v, err := m.valueOf(bkt)
+		if err != nil {
+			continue
+		}
+		n++
+		if !fn(bkt.key(m), v) {
+			i++
+			break
+		}
+	}
+	if i >= m.head.cap {
+		return 0, nil
+	}
+	return int(i), nil
+}
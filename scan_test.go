@@ -0,0 +1,65 @@
+package shm
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMap_Scan(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8)
+	defer m.Close()
+	for i := 0; i < 40; i++ {
+		if err := m.Set(strconv.Itoa(i), []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := make(map[string]int)
+	cursor, calls := 0, 0
+	for {
+		var err error
+		cursor, err = m.Scan(cursor, 7, func(key string, value []byte) bool {
+			seen[key]++
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		calls++
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 40 || calls < 6 {
+		t.Errorf("expect 40 keys in at least 6 calls, got %d in %d", len(seen), calls)
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("key %s seen %d times", key, n)
+		}
+	}
+}
+
+func TestMap_ScanExpired(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8, Expiration())
+	defer m.Close()
+	for _, k := range []string{"a", "b"} {
+		if err := m.Set(k, []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Touch("a", time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	var keys []string
+	if next, err := m.Scan(0, 64, func(key string, value []byte) bool {
+		keys = append(keys, key)
+		return true
+	}); next != 0 || err != nil {
+		t.Fatalf("unexpected %d, %v", next, err)
+	}
+	if len(keys) != 1 || keys[0] != "b" {
+		t.Errorf("expect only b, got %q", keys)
+	}
+}
@@ -0,0 +1,234 @@
+package shm
+
+import (
+	"errors"
+	"runtime"
+	"sync/atomic"
+)
+
+// ErrWriterSlots on create a map of SingleWriter with TwoChoice or
+// Cuckoo, whose adds lock two chains
+var ErrWriterSlots = errors.New("single writer with two hash slots")
+
+// begin a write of the single writer, readers see an odd serial
+func (h *hash) beginWrite() {
+	atomic.AddInt32(&(*h)[1], 1)
+}
+
+// end a write of the single writer
+func (h *hash) endWrite() {
+	atomic.AddInt32(&(*h)[1], 1)
+}
+
+// change the serial of a chain changed by a maintenance, to the next
+// even one with SingleWriter, a write cut by a crash ended
+func (m *Map) bumpSerial(ptr *hash) {
+	if !m.writer {
+		atomic.AddInt32(&(*ptr)[1], 1)
+		return
+	}
+	for {
+		old := atomic.LoadInt32(&(*ptr)[1])
+		if atomic.CompareAndSwapInt32(&(*ptr)[1], old, (old|1)+1) {
+			return
+		}
+	}
+}
+
+// begin a write of the single writer with no Fence raised, the flag
+// checked once the serial is odd, so a Fence raised meanwhile waits
+// for the write to end
+func (m *Map) beginWrite(ptr *hash) error {
+	for {
+		ptr.beginWrite()
+		if atomic.LoadUint32(&m.head.features)&fenceBit == 0 {
+			return nil
+		}
+		ptr.endWrite()
+		if err := m.waitFence(); err != nil {
+			return err
+		}
+	}
+}
+
+// begin reading the chain of ptr, false if a writer is inside
+func (m *Map) readBegin(ptr *hash) (serial int32, ok bool) {
+	serial = atomic.LoadInt32(&(*ptr)[1])
+	if m.writer {
+		return serial, serial&1 == 0
+	}
+	return serial, atomic.LoadInt32(&(*ptr)[2]) == 0
+}
+
+// the chain of ptr unchanged since readBegin
+func (m *Map) readValid(ptr *hash, serial int32) bool {
+	if atomic.LoadInt32(&(*ptr)[1]) != serial {
+		return false
+	}
+	return m.writer || atomic.LoadInt32(&(*ptr)[2]) == 0
+}
+
+// find key in the chain of ptr, validated by the serial
+func (m *Map) readFind(ptr *hash, key string) (bkt *bucket, err error) {
+	r := m.retries()
+	for r.next() {
+		serial, ok := m.readBegin(ptr)
+		if !ok {
+			runtime.Gosched()
+			continue
+		}
+		_, bkt, _ = m.find(ptr.index(), key)
+		if m.readValid(ptr, serial) {
+			if bkt == nil {
+				err = ErrKeyNot
+			}
+			return
+		}
+	}
+	return nil, r.err()
+}
+
+// lookup of SingleWriter, readers validate, the writer adds
+func (m *Map) lookupWriter(s *slot, key string, add bool, c *claim) (bkt *bucket, err error) {
+	if bkt, err = m.readFind(s.ptr, key); err != ErrKeyNot || !add {
+		return
+	}
+	if err = m.beginWrite(s.ptr); err != nil {
+		return
+	}
+	bkt, ev, err := m.addSingle(s, key, c)
+	s.ptr.endWrite()
+	ev.notify()
+	return
+}
+
+// locked of SingleWriter, the write is seen by readers as a change
+func (m *Map) lockedWriter(s *slot, key string, add bool, c *claim, fn func(bkt *bucket) error) error {
+	var ev *removal
+	defer func() { ev.notify() }()
+	ptr := s.ptr
+	if err := m.beginWrite(ptr); err != nil {
+		return err
+	}
+	defer ptr.endWrite()
+	var bkt *bucket
+	var err error
+	if add {
+		bkt, ev, err = m.addSingle(s, key, c)
+	} else if _, bkt, _ = m.find(ptr.index(), key); bkt == nil {
+		err = ErrKeyNot
+	}
+	if err != nil {
+		return err
+	}
+	err = fn(bkt)
+	m.markBucket(bkt, ptr)
+	return err
+}
+
+// delete of SingleWriter, return whether deleted, ok false if fenced
+func (m *Map) deleteWriter(s *slot, key string, cond func(bkt *bucket) bool) (deleted, ok bool) {
+	ptr := s.ptr
+	if m.beginWrite(ptr) != nil {
+		return false, false
+	}
+	last, target, idx := m.find(ptr.index(), key)
+	if target == nil || cond != nil && !cond(target) {
+		ptr.endWrite()
+		return false, true
+	}
+	target.used = 0
+	m.point("delete.mark")
+	if last != nil {
+		last.next = target.next
+	} else {
+		ptr.setIndex(target.next)
+	}
+	ptr.addLength(-1)
+	ptr.endWrite()
+	atomic.AddInt32(&m.head.len, -1)
+	if last != nil {
+		m.markBucket(last, ptr)
+	}
+	m.markBucket(target, ptr)
+	m.point("delete.unlink")
+	m.freeSingle(idx)
+	return true, true
+}
+
+// Load append a copy of the value of key to dst, consistent with
+// the writes of Set, unlike the slice of Get which a writer may
+// change while it is read
+func (m *Map) Load(key string, dst []byte) (b []byte, err error) {
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	var e error
+	err = m.read(key, func(bkt *bucket) {
+		var v []byte
+		v, e = m.valueOf(bkt)
+		b = append(dst, v...)
+	})
+	if err != nil {
+		return dst, err
+	}
+	return b, e
+}
+
+// read the entry of key by fn, which may be called again until the
+// chain is seen unchanged, without a lock nor a write to the map, its
+// last call valid on nil; the entry missing return ErrMissing
+func (m *Map) read(key string, fn func(bkt *bucket)) error {
+	ss, n, err := m.slots(key)
+	if err != nil {
+		return err
+	}
+	if m.shards != nil {
+		mu := m.shard(ss[0].h)
+		mu.RLock()
+		defer mu.RUnlock()
+		_, bkt, _ := m.find(ss[0].ptr.index(), key)
+		if bkt == nil || m.expired(bkt) {
+			return ErrKeyNot
+		}
+		if missing(bkt) {
+			return ErrMissing
+		}
+		fn(bkt)
+		return nil
+	}
+	r := m.retries()
+	for r.next() {
+		valid := true
+		for i := 0; i < n && valid; i++ {
+			ptr := ss[i].ptr
+			serial, ok := m.readBegin(ptr)
+			if !ok {
+				valid = false
+				break
+			}
+			_, bkt, _ := m.find(ptr.index(), key)
+			if bkt == nil || m.expired(bkt) {
+				valid = m.readValid(ptr, serial)
+				continue
+			}
+			if missing(bkt) {
+				if m.readValid(ptr, serial) {
+					return ErrMissing
+				}
+				valid = false
+				continue
+			}
+			fn(bkt)
+			if m.readValid(ptr, serial) {
+				return nil
+			}
+			valid = false
+		}
+		if valid {
+			return ErrKeyNot
+		}
+		runtime.Gosched()
+	}
+	return r.err()
+}
@@ -0,0 +1,90 @@
+package shm
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestMap_SingleWriter(t *testing.T) {
+	m := newTestMap(t, 256, 16, 64, SingleWriter())
+	defer m.Close()
+	var err error
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		value := make([]byte, 64)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			for j := range value {
+				value[j] = byte(i)
+			}
+			key := strconv.Itoa(i % 32)
+			if err := m.Set(key, value); err != nil {
+				t.Error(err)
+				return
+			}
+			if i%7 == 0 {
+				m.Delete(key)
+			}
+		}
+	}()
+	var buf []byte
+	for i := 0; i < 20000; i++ {
+		buf, err = m.Load(strconv.Itoa(i%32), buf[:0])
+		if err == ErrKeyNot || err == ErrTryEnd {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range buf {
+			if c != buf[0] {
+				t.Fatalf("torn value %v", buf)
+			}
+		}
+	}
+	close(stop)
+	<-done
+	if err = m.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMap_SingleWriterExpired(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8, SingleWriter(), Expiration())
+	defer m.Close()
+	if err := m.Set("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Touch("a", -1); err != nil {
+		t.Fatal(err)
+	}
+	ss, _, err := m.slots("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := ss[0].ptr.serial()
+	// a read leaves the expired entry and the serial to the writer
+	if _, err = m.Get("a", false); err != ErrKeyNot {
+		t.Errorf("expect ErrKeyNot, got %v", err)
+	}
+	if m.Len() != 1 || ss[0].ptr.serial() != serial {
+		t.Errorf("expect the entry kept by a read, got %d entries, serial %d to %d", m.Len(), serial, ss[0].ptr.serial())
+	}
+	if _, err = m.Get("a", true); err != nil || m.Len() != 1 {
+		t.Errorf("expect the entry added again, got %d entries, %v", m.Len(), err)
+	}
+}
+
+func TestMap_SingleWriterSlots(t *testing.T) {
+	for _, opt := range []Option{TwoChoice(), Cuckoo()} {
+		if _, err := Create("", 64, 16, 8, testMaxTry, initWait, InMemory(), SingleWriter(), opt); err != ErrWriterSlots {
+			t.Errorf("expect ErrWriterSlots, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Package sequence is a 64-bit sequence in shared memory handing out
+// unique ids to processes, persisted if the memory is a mapped file
+package sequence
+
+import (
+	"errors"
+	"github.com/fengyoulin/shm/database"
+	"github.com/fengyoulin/shm/mapping"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Size of a sequence in bytes
+const Size = 8
+
+var (
+	// ErrSize on a memory too small for a sequence
+	ErrSize = errors.New("memory too small")
+	// ErrAlign on a memory not aligned to 8 bytes
+	ErrAlign = errors.New("memory not aligned")
+)
+
+// Sequence of ids from 1, each handed out once across processes
+// with a block, a process reserves block ids at a time and hands them
+// out itself, ids are then unique but not ordered between processes,
+// and those reserved but not handed out before exit are skipped
+type Sequence struct {
+	word  *uint64
+	block uint64
+	// ids reserved, [next, end)
+	mu   sync.Mutex
+	next uint64
+	end  uint64
+	// mapping of Open, nil with At
+	mp *mapping.Mapping
+}
+
+// At return the sequence in the first Size bytes of b, reserving
+// block ids at a time if block > 1, zeroed memory is a new sequence
+func At(b []byte, block int) (*Sequence, error) {
+	if len(b) < Size {
+		return nil, ErrSize
+	}
+	p := unsafe.Pointer(&b[0])
+	if uintptr(p)&7 != 0 {
+		return nil, ErrAlign
+	}
+	if block < 1 {
+		block = 1
+	}
+	return &Sequence{word: (*uint64)(p), block: uint64(block)}, nil
+}
+
+// Open the sequence in the file at path, created if not exist,
+// waiting for at most wait for its initialization lock
+func Open(path string, block int, wait time.Duration) (s *Sequence, err error) {
+	mp, unlock, err := database.Open(path, Size, wait)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if e := unlock(); err == nil {
+			err = e
+		}
+		if err != nil {
+			_ = mp.Close()
+			s = nil
+		}
+	}()
+	if s, err = At(mp.Bytes(), block); err != nil {
+		return
+	}
+	s.mp = mp
+	return
+}
+
+// Next return the next id
+func (s *Sequence) Next() uint64 {
+	if s.block == 1 {
+		return atomic.AddUint64(s.word, 1)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next == s.end {
+		s.end = atomic.AddUint64(s.word, s.block) + 1
+		s.next = s.end - s.block
+	}
+	id := s.next
+	s.next++
+	return id
+}
+
+// Last return the last id reserved by any process
+func (s *Sequence) Last() uint64 {
+	return atomic.LoadUint64(s.word)
+}
+
+// Close the file of Open, a no-op with At
+func (s *Sequence) Close() error {
+	if s.mp == nil {
+		return nil
+	}
+	err := s.mp.Close()
+	s.mp = nil
+	return err
+}
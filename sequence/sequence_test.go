@@ -0,0 +1,45 @@
+package sequence
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSequence(t *testing.T) {
+	name := "testsequence.db"
+	defer os.Remove(name)
+	a, err := Open(name, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Open(name, 10, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[uint64]bool)
+	for i := 0; i < 25; i++ {
+		for _, s := range []*Sequence{a, b} {
+			id := s.Next()
+			if seen[id] || id == 0 {
+				t.Fatalf("id %d handed out twice", id)
+			}
+			seen[id] = true
+		}
+	}
+	last := a.Last()
+	if err = a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Open(name, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if id := c.Next(); id != last+1 {
+		t.Errorf("expect %d after reopen, got %d", last+1, id)
+	}
+}
@@ -0,0 +1,32 @@
+package shm
+
+import (
+	"github.com/fengyoulin/shm/database"
+	"os"
+	"path/filepath"
+)
+
+// ShmDir return the directory of shared memory files, /dev/shm if the
+// system has it, the temporary directory otherwise
+func ShmDir() string {
+	if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+		return "/dev/shm"
+	}
+	return os.TempDir()
+}
+
+// ShmPath return the path of a map named name in ShmDir
+func ShmPath(name string) string {
+	return filepath.Join(ShmDir(), name)
+}
+
+// CheckSpace return a *database.NoSpaceError, which is ErrNoSpace, if
+// the file system of path has no room for a map with the params, as
+// EstimateSize, before Create, which checks it too
+func CheckSpace(path string, mapCap, keyLen, valueLen int, opts ...Option) error {
+	size, err := EstimateSize(mapCap, keyLen, valueLen, opts...)
+	if err != nil {
+		return err
+	}
+	return database.CheckSpace(path, size)
+}
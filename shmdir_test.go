@@ -0,0 +1,19 @@
+package shm
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestShmPath(t *testing.T) {
+	p := ShmPath("testshm.db")
+	if filepath.Dir(p) != ShmDir() || filepath.Base(p) != "testshm.db" {
+		t.Errorf("unexpected path %s", p)
+	}
+	if err := CheckSpace(p, 1024, 16, 8); err != nil {
+		t.Error(err)
+	}
+	if err := CheckSpace(p, 0, 16, 8); err != ErrMapCap {
+		t.Errorf("expect ErrMapCap, got %v", err)
+	}
+}
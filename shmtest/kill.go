@@ -0,0 +1,61 @@
+package shmtest
+
+import (
+	"github.com/fengyoulin/shm"
+	"sync/atomic"
+	"testing"
+)
+
+// panic value of a killed operation
+type killed struct{}
+
+// Killer simulate a process killed at a crash point,
+// pass its Hook method to shm.Hook, unlike a real kill
+// the deferred functions of the operation still run
+type Killer struct {
+	// Point to kill at, empty for any point
+	Point string
+	// After this many hits of the point
+	After int32
+	hits  int32
+}
+
+// Hook panics at the configured point
+func (k *Killer) Hook(point string) {
+	if k.Point != "" && k.Point != point {
+		return
+	}
+	if atomic.AddInt32(&k.hits, 1) > k.After {
+		panic(killed{})
+	}
+}
+
+// Hits return how many times the point was reached
+func (k *Killer) Hits() int {
+	return int(atomic.LoadInt32(&k.hits))
+}
+
+// Run fn, return true if a Killer killed it
+func Run(fn func()) (dead bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(killed); !ok {
+				panic(r)
+			}
+			dead = true
+		}
+	}()
+	fn()
+	return
+}
+
+// Recover assert Repair bring a damaged map back to a state Verify accepts
+func Recover(t testing.TB, m *shm.Map) {
+	t.Helper()
+	if err := m.Repair(); err != nil {
+		t.Fatalf("repair: %v", err)
+	}
+	if err := m.Verify(); err != nil {
+		t.Fatalf("verify after repair: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package shmtest
+
+import (
+	"github.com/fengyoulin/shm"
+	"strconv"
+	"testing"
+)
+
+func TestKill(t *testing.T) {
+	points := []string{"get.alloc", "get.link", "delete.mark", "delete.unlink"}
+	for _, point := range points {
+		k := &Killer{Point: point, After: 3}
+		size, err := shm.EstimateSize(64, 16, 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mp := NewMapping(size, 1)
+		m, err := shm.NewFromMapping(mp, 64, 16, 8, 20, shm.Hook(k.Hook))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dead := Run(func() {
+			for i := 0; i < 10; i++ {
+				key := strconv.Itoa(i)
+				if _, err := m.Get(key, true); err != nil {
+					t.Fatal(err)
+				}
+				m.Delete(key)
+			}
+		})
+		if !dead {
+			t.Fatalf("%s: not killed", point)
+		}
+		// the deferred free of Get undo a kill at get.alloc
+		if err = m.Verify(); err == nil && point != "get.alloc" {
+			t.Errorf("%s: verify passed a damaged map", point)
+		}
+		Recover(t, m)
+	}
+}
+
+func TestCrash(t *testing.T) {
+	size, err := shm.EstimateSize(64, 16, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp := NewMapping(size, 1)
+	mp.TornPages(0.5)
+	m, err := shm.NewFromMapping(mp, 64, 16, 8, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 40; i++ {
+		if _, err = m.Get(strconv.Itoa(i), true); err != nil {
+			t.Fatal(err)
+		}
+		if i%8 == 0 {
+			_ = m.Sync()
+		}
+	}
+	m, err = shm.NewFromMapping(mp.Crash(), 64, 16, 8, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Recover(t, m)
+}
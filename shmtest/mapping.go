@@ -0,0 +1,107 @@
+// Package shmtest provides fault injection for crash consistency tests of shm maps
+package shmtest
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// page size of the durable image
+const pageSize = 4096
+
+// Mapping is a shm.Mapping in memory, its content survives a Crash
+// only as far as Sync persisted it, subject to the injected faults
+type Mapping struct {
+	mu      sync.Mutex
+	live    []byte
+	durable []byte
+	rnd     *rand.Rand
+	drop    float64
+	torn    float64
+}
+
+// NewMapping create a zeroed mapping of size bytes, seed drive the faults
+func NewMapping(size int, seed int64) *Mapping {
+	return &Mapping{
+		live:    make([]byte, size),
+		durable: make([]byte, size),
+		rnd:     rand.New(rand.NewSource(seed)),
+	}
+}
+
+// DropWrites make Sync skip each changed page with probability p
+func (f *Mapping) DropWrites(p float64) {
+	f.mu.Lock()
+	f.drop = p
+	f.mu.Unlock()
+}
+
+// TornPages make Sync persist only a random prefix of each
+// changed page with probability p
+func (f *Mapping) TornPages(p float64) {
+	f.mu.Lock()
+	f.torn = p
+	f.mu.Unlock()
+}
+
+// Bytes return the live memory
+func (f *Mapping) Bytes() []byte {
+	return f.live
+}
+
+// Sync persist the changed pages to the durable image
+func (f *Mapping) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for off := 0; off < len(f.live); off += pageSize {
+		end := off + pageSize
+		if end > len(f.live) {
+			end = len(f.live)
+		}
+		if string(f.live[off:end]) == string(f.durable[off:end]) {
+			continue
+		}
+		if f.rnd.Float64() < f.drop {
+			continue
+		}
+		if f.rnd.Float64() < f.torn {
+			end = off + f.rnd.Intn(end-off)
+		}
+		copy(f.durable[off:end], f.live[off:end])
+	}
+	return nil
+}
+
+// Resize both the live memory and the durable image
+func (f *Mapping) Resize(size int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	live := make([]byte, size)
+	copy(live, f.live)
+	durable := make([]byte, size)
+	copy(durable, f.durable)
+	f.live, f.durable = live, durable
+	return nil
+}
+
+// Close is a no-op, the durable image is kept for Crash
+func (f *Mapping) Close() error {
+	return nil
+}
+
+// Crash return a mapping holding the durable image as live memory,
+// as seen after a power loss, the faults settings are kept
+func (f *Mapping) Crash() *Mapping {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := &Mapping{
+		live:    make([]byte, len(f.durable)),
+		durable: make([]byte, len(f.durable)),
+		rnd:     f.rnd,
+		drop:    f.drop,
+		torn:    f.torn,
+	}
+	copy(c.live, f.durable)
+	copy(c.durable, f.durable)
+	return c
+}
@@ -0,0 +1,150 @@
+package shm
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// shards of the in-process locks of SingleProcess
+const numShards = 64
+
+// a shard lock on a cache line of its own
+type shardLock struct {
+	sync.RWMutex
+	_ [64 - unsafe.Sizeof(sync.RWMutex{})%64]byte
+}
+
+// the shard lock of the chain of hash h
+func (m *Map) shard(h int32) *shardLock {
+	return &m.shards[uint(h)%uint(m.nslots)%numShards]
+}
+
+// alloc with the free list guarded by the list lock
+func (m *Map) allocSingle() int32 {
+	m.list.Lock()
+	defer m.list.Unlock()
+	return m.alloc()
+}
+
+// free with the free list guarded by the list lock
+func (m *Map) freeSingle(i int32) {
+	m.list.Lock()
+	defer m.list.Unlock()
+	m.free(i)
+}
+
+// lookup with the chain guarded by its shard lock
+func (m *Map) lookupSingle(s *slot, key string, add bool, c *claim) (bkt *bucket, err error) {
+	mu := m.shard(s.h)
+	mu.RLock()
+	_, bkt, _ = m.find(s.ptr.index(), key)
+	mu.RUnlock()
+	if bkt != nil {
+		return
+	}
+	if !add {
+		return nil, ErrKeyNot
+	}
+	mu.Lock()
+	bkt, ev, err := m.addSingle(s, key, c)
+	mu.Unlock()
+	ev.notify()
+	return
+}
+
+// find or add key in the chain of s, with its shard locked, ev of
+// an evicted entry to notify once unlocked, added with the in-flight
+// marker of c, if not nil
+func (m *Map) addSingle(s *slot, key string, c *claim) (bkt *bucket, ev *removal, err error) {
+	ptr := s.ptr
+	if _, bkt, _ = m.find(ptr.index(), key); bkt != nil {
+		return
+	}
+	full := m.maxChain > 0 && ptr.length() >= m.maxChain
+	if full && (!m.evict || !m.evictable(ptr)) {
+		return nil, nil, ErrChainLong
+	}
+	idx := m.allocSingle()
+	if idx < 0 {
+		return nil, nil, ErrDbFull
+	}
+	bkt = m.bucket(idx)
+	bkt.setKey(m, key)
+	bkt.flags = 0
+	m.setCreated(bkt)
+	m.resetHits(bkt)
+	m.resetExpiry(bkt)
+	m.resetVersion(bkt)
+	m.resetFlight(bkt, c)
+	m.point("get.alloc")
+	evicted := int32(-1)
+	if full {
+		evicted = m.evictTail(ptr)
+	}
+	bkt.hash = s.h
+	bkt.next = ptr.index()
+	ptr.setIndex(idx)
+	m.point("get.link")
+	bkt.used = 1
+	ptr.addLength(1)
+	atomic.AddInt32(&m.head.len, 1)
+	m.markBucket(bkt, ptr)
+	c.link()
+	if evicted >= 0 {
+		ev = m.removal(m.bucket(evicted), m.onEvict)
+		m.auditOp(AuditEvict, m.bucket(evicted).key(m))
+		m.freeSingle(evicted)
+	}
+	return
+}
+
+// locked with the chain guarded by its shard lock
+func (m *Map) lockedSingle(s *slot, key string, add bool, c *claim, fn func(bkt *bucket) error) error {
+	var ev *removal
+	defer func() { ev.notify() }()
+	mu := m.shard(s.h)
+	mu.Lock()
+	defer mu.Unlock()
+	var bkt *bucket
+	var err error
+	if add {
+		bkt, ev, err = m.addSingle(s, key, c)
+	} else if _, bkt, _ = m.find(s.ptr.index(), key); bkt == nil {
+		err = ErrKeyNot
+	}
+	if err != nil {
+		return err
+	}
+	err = fn(bkt)
+	m.markBucket(bkt, s.ptr)
+	return err
+}
+
+// delete with the chain guarded by its shard lock, return whether deleted
+func (m *Map) deleteSingle(s *slot, key string, cond func(bkt *bucket) bool) bool {
+	mu := m.shard(s.h)
+	mu.Lock()
+	defer mu.Unlock()
+	ptr := s.ptr
+	last, target, idx := m.find(ptr.index(), key)
+	if target == nil || cond != nil && !cond(target) {
+		return false
+	}
+	target.used = 0
+	m.point("delete.mark")
+	if last != nil {
+		last.next = target.next
+	} else {
+		ptr.setIndex(target.next)
+	}
+	ptr.addLength(-1)
+	atomic.AddInt32(&m.head.len, -1)
+	if last != nil {
+		m.markBucket(last, ptr)
+	}
+	m.markBucket(target, ptr)
+	m.point("delete.unlink")
+	m.freeSingle(idx)
+	return true
+}
@@ -0,0 +1,37 @@
+package shm
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestMap_SingleProcess(t *testing.T) {
+	m := newTestMap(t, 1024, 16, 8, SingleProcess())
+	defer m.Close()
+	done := make(chan struct{})
+	for g := 0; g < 4; g++ {
+		go func(g int) {
+			defer func() { done <- struct{}{} }()
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(i % 200)
+				switch (i + g) % 3 {
+				case 0:
+					if err := m.Set(key, []byte(key)); err != nil {
+						t.Error(err)
+						return
+					}
+				case 1:
+					_, _ = m.Get(key, false)
+				case 2:
+					m.Delete(key)
+				}
+			}
+		}(g)
+	}
+	for g := 0; g < 4; g++ {
+		<-done
+	}
+	if err := m.Verify(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,89 @@
+package shm
+
+// a hash slot a key may live in
+type slot struct {
+	h      int32
+	ptr    *hash
+	index  int32
+	serial int32
+}
+
+// snapshot the chain head
+func (s *slot) load() {
+	s.serial = s.ptr.serial()
+	s.index = s.ptr.index()
+}
+
+// the slots of key, 2 with TwoChoice unless both hash the same
+func (m *Map) slots(key string) (ss [2]slot, n int, err error) {
+	if len(key) >= int(m.head.keySize) {
+		err = ErrKeyLen
+		return
+	}
+	ss[0].h, err = hashFunc(key)
+	if err != nil {
+		return
+	}
+	n = 1
+	if m.head.features&(featTwoChoice|featCuckoo) == 0 {
+		ss[0].ptr = m.hashPtr(ss[0].h)
+		return
+	}
+	ss[1].h, err = hashFunc2(key)
+	if err != nil {
+		return
+	}
+	if m.nslots != m.head.cap {
+		// a slot in each table
+		c := uint32(m.head.cap)
+		ss[0].h = int32(uint32(ss[0].h) % c)
+		ss[1].h = int32(c + uint32(ss[1].h)%c)
+	}
+	ss[0].ptr = m.hashPtr(ss[0].h)
+	ss[1].ptr = m.hashPtr(ss[1].h)
+	if ss[1].ptr != ss[0].ptr {
+		n = 2
+	}
+	return
+}
+
+// the slot with the shortest chain
+func shortest(ss []slot) *slot {
+	s := &ss[0]
+	for i := 1; i < len(ss); i++ {
+		if ss[i].ptr.length() < s.ptr.length() {
+			s = &ss[i]
+		}
+	}
+	return s
+}
+
+// any chain changed since load
+func changed(ss []slot) bool {
+	for i := range ss {
+		if ss[i].ptr.serial() != ss[i].serial {
+			return true
+		}
+	}
+	return false
+}
+
+// lock all the chains, fail if any changed since load
+func lockAll(ss []slot) bool {
+	for i := range ss {
+		if !ss[i].ptr.lock(ss[i].serial) {
+			for j := 0; j < i; j++ {
+				ss[j].ptr.release()
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// unlock all the chains
+func unlockAll(ss []slot) {
+	for i := range ss {
+		ss[i].ptr.unlock()
+	}
+}
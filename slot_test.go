@@ -0,0 +1,42 @@
+package shm
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestMap_TwoChoice(t *testing.T) {
+	maxChain := func(m *Map) (n int) {
+		for i := 0; i < m.Cap(); i++ {
+			if l := (*m.hash)[i].length(); l > n {
+				n = l
+			}
+		}
+		return
+	}
+	one := newTestMap(t, 1024, 16, 8)
+	defer one.Close()
+	two := newTestMap(t, 1024, 16, 8, TwoChoice())
+	defer two.Close()
+	for i := 0; i < 1024; i++ {
+		for _, m := range []*Map{one, two} {
+			if _, err := m.Get(strconv.Itoa(i), true); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if maxChain(two) >= maxChain(one) {
+		t.Errorf("two choice chain %d, one choice %d", maxChain(two), maxChain(one))
+	}
+	if err := two.Verify(); err != nil {
+		t.Error(err)
+	}
+	for i := 0; i < 1024; i++ {
+		if !two.Delete(strconv.Itoa(i)) || two.Exists(strconv.Itoa(i)) {
+			t.Fatalf("failed to delete %d", i)
+		}
+	}
+	if two.Len() != 0 {
+		t.Errorf("len %d after delete", two.Len())
+	}
+}
@@ -0,0 +1,62 @@
+package shm
+
+import (
+	"context"
+	"github.com/fengyoulin/shm/mapping"
+	"os"
+	"sync/atomic"
+	"unsafe"
+)
+
+// a Mapping able to map its file again copy on write
+type privateMapper interface {
+	Private() (*mapping.Mapping, error)
+}
+
+// FencedSnapshot return a frozen copy of the map for a backup, which
+// may iterate and serialize it, private to the process, to be closed
+// it is not a copy on write: the pages of a private view of the file
+// still see the writes to the map until copied, so all processes are
+// fenced, the writer of SingleWriter too, see Fence, while the pages
+// of the view are copied, or the memory where no such view is
+// available; writers wait for the copy, and go on once it returns
+// ctx bounds the wait for the fence, writes through the slices from
+// Get are not fenced
+func (m *Map) FencedSnapshot(ctx context.Context) (s *Map, err error) {
+	var mp Mapping
+	pm, cow := m.mp.(privateMapper)
+	if cow {
+		var p *mapping.Mapping
+		if p, err = pm.Private(); err != nil {
+			return
+		}
+		mp = p
+	} else {
+		mp = mapping.NewMemory(len(m.mp.Bytes()))
+	}
+	lift, err := m.Fence(ctx)
+	if err != nil {
+		_ = mp.Close()
+		return
+	}
+	data := mp.Bytes()
+	if cow {
+		// a write to each page copies it into the view
+		ps := os.Getpagesize()
+		for off := 0; off < len(data); off += ps {
+			atomic.AddUint32((*uint32)(unsafe.Pointer(&data[off])), 0)
+		}
+	} else {
+		copy(data, m.mp.Bytes())
+	}
+	lift()
+	// the copy was taken fenced
+	hdr := *m.head
+	hdr.features &^= fenceBit
+	(*header)(unsafe.Pointer(&data[0])).features = hdr.features
+	hs := (*[maxMapCap]hash)(unsafe.Pointer(&data[m.head.hashOff]))
+	for i := int32(0); i < m.nslots; i++ {
+		(*hs)[i][2] = 0
+	}
+	return newMap(mp, &hdr, m.try, &options{})
+}
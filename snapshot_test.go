@@ -0,0 +1,98 @@
+package shm
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMap_FencedSnapshot(t *testing.T) {
+	name := "testsnapshot.db"
+	defer os.Remove(name)
+	file := newTestFile(t, name, 64, 16, 8)
+	defer file.Close()
+	memory := newTestMap(t, 64, 16, 8)
+	defer memory.Close()
+	for _, m := range []*Map{file, memory} {
+		if err := m.Set("a", []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+		s, err := m.FencedSnapshot(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = m.Set("a", []byte("2")); err != nil {
+			t.Fatal(err)
+		}
+		if err = m.Set("b", []byte("3")); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := s.Get("a", false); err != nil || v[0] != '1' {
+			t.Errorf("expect a frozen value, got %q, %v", v, err)
+		}
+		if s.Exists("b") || s.Len() != 1 {
+			t.Error("key added after the snapshot")
+		}
+		if err = s.Verify(); err != nil {
+			t.Error(err)
+		}
+		if err = s.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestMap_SnapshotWriter(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8, SingleWriter(), LockTimeout(10*time.Millisecond))
+	defer m.Close()
+	if err := m.Set("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	// the writer takes no chain locks, the fence stops it still
+	lift, err := m.Fence(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Set("a", []byte("2")); err != ErrFenced {
+		t.Errorf("expect ErrFenced, got %v", err)
+	}
+	if m.Delete("a") {
+		t.Error("delete under the fence")
+	}
+	lift()
+	for i := int32(0); i < m.nslots; i++ {
+		if (*m.hash)[i][1]&1 != 0 {
+			t.Fatalf("slot %d left with an odd serial", i)
+		}
+	}
+	s, err := m.FencedSnapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = m.Set("a", []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Load("a", nil); err != nil || v[0] != '1' {
+		t.Errorf("expect a frozen value, got %q, %v", v, err)
+	}
+	if v, err := m.Load("a", nil); err != nil || v[0] != '3' {
+		t.Errorf("unexpected %q, %v", v, err)
+	}
+}
+
+func TestMap_FencedSnapshotContext(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8)
+	defer m.Close()
+	lift, err := m.Fence(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lift()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err = m.FencedSnapshot(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expect DeadlineExceeded, got %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package shm
+
+import (
+	"errors"
+	"github.com/fengyoulin/shm/arena"
+	"reflect"
+	"sync/atomic"
+	"unsafe"
+)
+
+// ErrSpan on get a value whose continuation buckets are damaged
+var ErrSpan = errors.New("value span damaged")
+
+// span of a bucket, the index of the first continuation bucket plus
+// one, 0 if none, and the length of the value
+func (b *bucket) span(m *Map) *[2]uint32 {
+	return (*[2]uint32)(unsafe.Pointer(uintptr(unsafe.Pointer(b)) + m.meta.span))
+}
+
+// bytes of a continuation bucket, all after the bucket header
+func (m *Map) spanCap() int {
+	return int(m.head.bucketSize) - int(unsafe.Sizeof(bucket{}))
+}
+
+// the bytes of a continuation bucket
+func (b *bucket) more(m *Map) (d []byte) {
+	h := (*reflect.SliceHeader)(unsafe.Pointer(&d))
+	h.Data = uintptr(unsafe.Pointer(b)) + unsafe.Sizeof(bucket{})
+	h.Cap = m.spanCap()
+	h.Len = h.Cap
+	return
+}
+
+// the continuation buckets of a map as an arena.Pool, marked dirty
+// as they are written
+type spans Map
+
+func (p *spans) Len() int32 {
+	return p.head.cap
+}
+
+func (p *spans) Get() int32 {
+	return (*Map)(p).allocAny()
+}
+
+func (p *spans) Put(first, last int32) {
+	(*Map)(p).freeSpan(first, last)
+}
+
+func (p *spans) Block(i int32) []byte {
+	m := (*Map)(p)
+	return m.bucket(i).more(m)
+}
+
+func (p *spans) Fill(i int32, b []byte) int {
+	m := (*Map)(p)
+	bkt := m.bucket(i)
+	n := copy(bkt.more(m), b)
+	m.markDirty(unsafe.Pointer(bkt), uintptr(m.head.bucketSize))
+	return n
+}
+
+func (p *spans) Next(i int32) int32 {
+	return (*Map)(p).bucket(i).next
+}
+
+func (p *spans) Link(i, next int32) {
+	bkt := (*Map)(p).bucket(i)
+	bkt.next = next
+	(*Map)(p).markDirty(unsafe.Pointer(bkt), unsafe.Sizeof(bucket{}))
+}
+
+// the continuation buckets of b bytes, linked by next, marked dirty,
+// return the index of the first, ErrDbFull if the free buckets are
+// too few
+func (m *Map) allocSpan(b []byte) (first int32, err error) {
+	if first, err = arena.WriteChain((*spans)(m), b); err == arena.ErrFull {
+		err = ErrDbFull
+	}
+	return
+}
+
+// replace the span of a locked bucket, free the continuation buckets
+// of the old one
+func (m *Map) setSpan(bkt *bucket, first int32, n int) {
+	if m.meta.span == 0 {
+		return
+	}
+	old, last := m.dropSpan(bkt)
+	if first >= 0 {
+		sp := bkt.span(m)
+		atomic.StoreUint32(&sp[1], uint32(n))
+		atomic.StoreUint32(&sp[0], uint32(first+1))
+	}
+	if old >= 0 {
+		m.freeSpan(old, last)
+	}
+}
+
+// clear the span of a bucket, return its first and last continuation
+// bucket, -1 if none, a damaged span is cut where it leaves the map
+func (m *Map) dropSpan(bkt *bucket) (first, last int32) {
+	sp := bkt.span(m)
+	first = int32(atomic.SwapUint32(&sp[0], 0)) - 1
+	last = arena.ChainEnd((*spans)(m), first, int(atomic.LoadUint32(&sp[1]))-m.vcap)
+	if last < 0 {
+		first = -1
+	}
+	return
+}
+
+// the value of a bucket with a span, a copy
+func (m *Map) spanValue(bkt *bucket) ([]byte, error) {
+	sp := bkt.span(m)
+	i, n := int32(atomic.LoadUint32(&sp[0]))-1, int(atomic.LoadUint32(&sp[1]))
+	if n <= m.vcap || n-m.vcap > m.spanCap()*int(m.head.cap) {
+		return nil, ErrSpan
+	}
+	b := make([]byte, n)
+	off := copy(b, bkt.value(m))
+	if arena.ReadChain((*spans)(m), i, b[off:]) != nil {
+		return nil, ErrSpan
+	}
+	return b, nil
+}
+
+// the continuation buckets of the used buckets before next, a span
+// out of range or sharing buckets is cut if cut, or left out
+func (m *Map) spanned(next int32, cut bool) map[int32]struct{} {
+	seen := make(map[int32]struct{})
+	n := m.spanCap()
+	for i := int32(0); i < next; i++ {
+		bkt := m.bucket(i)
+		if atomic.LoadInt32(&bkt.used) == 0 {
+			continue
+		}
+		sp := bkt.span(m)
+		first := int32(atomic.LoadUint32(&sp[0])) - 1
+		if first < 0 {
+			continue
+		}
+		var chain []int32
+		ok := true
+		for j, k := first, int(atomic.LoadUint32(&sp[1]))-m.vcap; k > 0; k -= n {
+			if _, dup := seen[j]; dup || j < 0 || j >= next || atomic.LoadInt32(&m.bucket(j).used) != 0 {
+				ok = false
+				break
+			}
+			chain = append(chain, j)
+			j = atomic.LoadInt32(&m.bucket(j).next)
+		}
+		if !ok {
+			if cut {
+				atomic.StoreUint32(&sp[0], 0)
+			}
+			continue
+		}
+		for _, j := range chain {
+			seen[j] = struct{}{}
+		}
+	}
+	return seen
+}
+
+// alloc from any map, with the free list guarded by the list lock
+func (m *Map) allocAny() int32 {
+	if m.shards != nil || m.writer {
+		return m.allocSingle()
+	}
+	return m.alloc()
+}
+
+// free the continuation buckets from first to last to the free list,
+// guarded by the list lock
+func (m *Map) freeSpan(first, last int32) {
+	if m.shards != nil || m.writer {
+		m.list.Lock()
+		defer m.list.Unlock()
+	}
+	m.freeChain(first, last)
+}
@@ -0,0 +1,87 @@
+package shm
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"unsafe"
+)
+
+func TestMap_SpanValues(t *testing.T) {
+	name := "testspanvalues.db"
+	defer os.Remove(name)
+	m := newTestFile(t, name, 16, 16, 16, SpanValues())
+	defer m.Close()
+	var err error
+	large := bytes.Repeat([]byte("0123456789"), 10)
+	if err = m.Set("large", large); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Set("small", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := m.Get("large", false); err != nil || !bytes.Equal(v, large) {
+		t.Errorf("expect the large value, got %q, %v", v, err)
+	}
+	if err = m.Verify(); err != nil {
+		t.Error(err)
+	}
+	// the continuation buckets freed on set smaller
+	next := m.head.next
+	if err = m.Set("large", []byte("short")); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Set("large", large); err != nil {
+		t.Fatal(err)
+	}
+	if m.head.next != next {
+		t.Errorf("expect continuation buckets reused, next %d, was %d", m.head.next, next)
+	}
+	if err = m.Repair(); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Verify(); err != nil {
+		t.Error(err)
+	}
+	if v, err := m.Get("large", false); err != nil || !bytes.Equal(v, large) {
+		t.Errorf("expect the large value after repair, got %q, %v", v, err)
+	}
+	if !m.Delete("large") {
+		t.Error("expect large deleted")
+	}
+	if err = m.Verify(); err != nil {
+		t.Error(err)
+	}
+	if err = m.Set("huge", make([]byte, 700)); err != ErrDbFull {
+		t.Errorf("expect ErrDbFull, got %v", err)
+	}
+	var vle *ValueLenError
+	if err = m.Set("huge", make([]byte, 1<<20)); !errors.As(err, &vle) {
+		t.Errorf("expect a *ValueLenError, got %v", err)
+	}
+}
+
+func TestMap_SpanDirty(t *testing.T) {
+	m := newTestMap(t, 1024, 16, 16, SpanValues(), SyncDirty())
+	defer m.Close()
+	if err := m.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	// continuation buckets over pages apart from that of the entry
+	ps := os.Getpagesize()
+	n := 2*ps/int(m.head.bucketSize) + 2
+	if err := m.Set("long", bytes.Repeat([]byte("x"), 16+n*m.spanCap())); err != nil {
+		t.Fatal(err)
+	}
+	bkt, err := m.lookup("long", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, k := int32(bkt.span(m)[0])-1, 0; k < n; i, k = m.bucket(i).next, k+1 {
+		page := (uintptr(unsafe.Pointer(m.bucket(i))) - uintptr(unsafe.Pointer(m.head))) / uintptr(ps)
+		if m.dirty[page/32]&(1<<(page%32)) == 0 {
+			t.Fatalf("continuation bucket %d not dirty", i)
+		}
+	}
+}
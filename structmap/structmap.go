@@ -0,0 +1,168 @@
+// Package structmap stores flat go structs as the values of a map, in
+// the bucket memory as laid out by the compiler, Get returning a typed
+// pointer into it, so that fields are read and written without
+// marshaling
+// a flat struct has fields of booleans, numbers, arrays and structs of
+// those, no pointers, strings, slices, maps, channels, funcs nor
+// interfaces, short strings go in byte arrays, see SetString
+package structmap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/fengyoulin/shm"
+	"reflect"
+	"unsafe"
+)
+
+var (
+	// ErrType on a type not a flat struct
+	ErrType = errors.New("not a flat struct")
+	// ErrSize on a struct larger than the map values
+	ErrSize = errors.New("struct larger than the values")
+	// ErrAlign on a value not aligned for the struct, key lengths of
+	// 8n-1 align values to 8 bytes
+	ErrAlign = errors.New("value not aligned for the struct")
+)
+
+// Map of keys to structs of a type
+type Map struct {
+	m   *shm.Map
+	typ reflect.Type
+}
+
+// New use the values of m for structs of the type of proto, a struct
+// or a pointer to one
+func New(m *shm.Map, proto interface{}) (*Map, error) {
+	t := reflect.TypeOf(proto)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, ErrType
+	}
+	if err := flat(t); err != nil {
+		return nil, err
+	}
+	return &Map{m: m, typ: t}, nil
+}
+
+// flat check t holds no references
+func flat(t reflect.Type) error {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return nil
+	case reflect.Array:
+		return flat(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if err := flat(f.Type); err != nil {
+				return fmt.Errorf("%w: field %s: %v", ErrType, f.Name, f.Type)
+			}
+		}
+		return nil
+	}
+	return ErrType
+}
+
+// view the value v as a pointer to the struct
+func (sm *Map) view(v []byte) (reflect.Value, error) {
+	if len(v) < int(sm.typ.Size()) {
+		return reflect.Value{}, ErrSize
+	}
+	if sm.typ.Size() == 0 {
+		return reflect.New(sm.typ), nil
+	}
+	p := unsafe.Pointer(&v[0])
+	if uintptr(p)%uintptr(sm.typ.Align()) != 0 {
+		return reflect.Value{}, ErrAlign
+	}
+	return reflect.NewAt(sm.typ, p), nil
+}
+
+// Get a pointer to the struct of key in the bucket memory, add the key
+// if not exist and add, as an interface{} of a pointer to the type
+// writes through it reach the map, unsynchronized with other writers,
+// of a value stored compressed it points to a copy, use Set then
+func (sm *Map) Get(key string, add bool) (p interface{}, err error) {
+	v, err := sm.m.Get(key, add)
+	if err != nil {
+		return
+	}
+	pv, err := sm.view(v)
+	if err != nil {
+		return
+	}
+	return pv.Interface(), nil
+}
+
+// Load copy the struct of key to dst, a pointer to the type
+func (sm *Map) Load(key string, dst interface{}) error {
+	d, err := sm.ptr(dst)
+	if err != nil {
+		return err
+	}
+	v, err := sm.m.Load(key, nil)
+	if err != nil {
+		return err
+	}
+	if len(v) < int(sm.typ.Size()) {
+		return ErrSize
+	}
+	copy(bytesOf(d, sm.typ.Size()), v)
+	return nil
+}
+
+// Set the struct of key to src, a pointer to the type, under the lock
+// of its chain, add the key if not exist
+func (sm *Map) Set(key string, src interface{}) error {
+	s, err := sm.ptr(src)
+	if err != nil {
+		return err
+	}
+	b := bytesOf(s, sm.typ.Size())
+	return sm.m.Update(key, true, func(v []byte) error {
+		if len(v) < len(b) {
+			return ErrSize
+		}
+		copy(v, b)
+		return nil
+	})
+}
+
+// the address of a pointer to the type
+func (sm *Map) ptr(p interface{}) (unsafe.Pointer, error) {
+	v := reflect.ValueOf(p)
+	if v.Kind() != reflect.Ptr || v.Type().Elem() != sm.typ || v.IsNil() {
+		return nil, fmt.Errorf("%w: %T for *%v", ErrType, p, sm.typ)
+	}
+	return unsafe.Pointer(v.Pointer()), nil
+}
+
+// the n bytes at p
+func bytesOf(p unsafe.Pointer, n uintptr) []byte {
+	if n == 0 {
+		return nil
+	}
+	return (*[1 << 30]byte)(p)[:n:n]
+}
+
+// SetString copy s to the byte array b, zero padded, truncated to it
+func SetString(b []byte, s string) {
+	n := copy(b, s)
+	for i := n; i < len(b); i++ {
+		b[i] = 0
+	}
+}
+
+// String of the byte array b, up to the first zero byte
+func String(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
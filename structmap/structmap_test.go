@@ -0,0 +1,60 @@
+package structmap
+
+import (
+	"github.com/fengyoulin/shm"
+	"testing"
+	"time"
+)
+
+type point struct {
+	X, Y  int64
+	Score float64
+	Name  [12]byte
+	Flags struct {
+		Hidden bool
+		Level  uint8
+	}
+}
+
+func TestStructMap(t *testing.T) {
+	m, err := shm.Create("", 64, 15, 48, 0, time.Second, shm.InMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if _, err = New(m, struct{ S string }{}); err == nil {
+		t.Error("expect error on a string field")
+	}
+	if _, err = New(m, 1); err != ErrType {
+		t.Errorf("expect ErrType, got %v", err)
+	}
+	sm, err := New(m, (*point)(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := sm.Get("a", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := v.(*point)
+	p.X, p.Y, p.Score = 3, -4, 1.5
+	SetString(p.Name[:], "alpha")
+	p.Flags.Level = 7
+	var q point
+	if err = sm.Load("a", &q); err != nil {
+		t.Fatal(err)
+	}
+	if q != *p || String(q.Name[:]) != "alpha" {
+		t.Errorf("expect %+v, got %+v", *p, q)
+	}
+	q.Y = 10
+	if err = sm.Set("b", &q); err != nil {
+		t.Fatal(err)
+	}
+	if v, err = sm.Get("b", false); err != nil || v.(*point).Y != 10 {
+		t.Errorf("get b: %v, %v", v, err)
+	}
+	if err = sm.Set("c", q); err == nil {
+		t.Error("expect error on a non pointer")
+	}
+}
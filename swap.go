@@ -0,0 +1,88 @@
+package shm
+
+import (
+	"context"
+	"github.com/fengyoulin/shm/database"
+	"github.com/fengyoulin/shm/mapping"
+	"os"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// SwapIn atomically replace the database at path with the one built
+// at newPath, which must be closed, then bump the generation of the
+// replaced one so the processes still attached to it see Replaced
+// and reopen path, wait for at most wait for the database lock
+// on windows it fails while path is open
+func SwapIn(path, newPath string, wait time.Duration) (err error) {
+	if err = checkDatabase(newPath); err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+	unlock, err := database.Lock(ctx, path)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if e := unlock(); err == nil {
+			err = e
+		}
+	}()
+	// map the old one before the rename, path names the new one after
+	var old *mapping.Mapping
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	switch {
+	case os.IsNotExist(err):
+		err = nil
+	case err != nil:
+		return
+	default:
+		var info os.FileInfo
+		if info, err = f.Stat(); err == nil && info.Size() >= int64(unsafe.Sizeof(header{})) {
+			old, err = mapping.Create(f)
+		}
+		// the mapping owns f
+		if old == nil {
+			_ = f.Close()
+		}
+		if err != nil {
+			return
+		}
+	}
+	if err = os.Rename(newPath, path); err != nil {
+		if old != nil {
+			_ = old.Close()
+		}
+		return
+	}
+	if old == nil {
+		return
+	}
+	head := (*header)(unsafe.Pointer(&old.Bytes()[0]))
+	atomic.AddUint32(&head.gen, 1)
+	return old.Close()
+}
+
+// Replaced report whether the backing file was replaced by SwapIn
+// since the map was opened, the map then should be closed and
+// opened again by path
+func (m *Map) Replaced() bool {
+	return atomic.LoadUint32(&m.head.gen) != m.gen
+}
+
+// check the file at path holds an initialized map
+func checkDatabase(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var hdr header
+	b := (*[unsafe.Sizeof(header{})]byte)(unsafe.Pointer(&hdr))
+	if _, err = f.ReadAt(b[:], 0); err != nil || hdr.cap == 0 {
+		return ErrDbSize
+	}
+	return checkFormat(&hdr)
+}
@@ -0,0 +1,38 @@
+package shm
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSwapIn(t *testing.T) {
+	name, newName := "testswap.db", "testswap.new.db"
+	defer os.Remove(name)
+	defer os.Remove(newName)
+	m := newTestFile(t, name, 64, 16, 8)
+	defer m.Close()
+	n, err := Create(newName, 64, 16, 8, testMaxTry, initWait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = n.Set("new", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err = n.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Replaced() {
+		t.Fatal("replaced before swap")
+	}
+	if err = SwapIn(name, newName, initWait); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Replaced() {
+		t.Error("not replaced after swap")
+	}
+	r := newTestFile(t, name, 64, 16, 8)
+	defer r.Close()
+	if !r.Exists("new") || r.Replaced() {
+		t.Error("reopened the old map")
+	}
+}
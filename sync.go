@@ -0,0 +1,140 @@
+package shm
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Sync flush the mapping to the backing file
+// only the dirty pages if SyncDirty was given to Create, those failing
+// to flush stay dirty for the next Sync
+func (m *Map) Sync() error {
+	if m.dirty == nil {
+		return m.mp.Sync()
+	}
+	ps := os.Getpagesize()
+	// flush runs of contiguous dirty pages
+	start := -1
+	for i := range m.dirty {
+		// clear before flush, writes during flush mark it again
+		w := atomic.SwapUint32(&m.dirty[i], 0)
+		for j := 0; j < 32; j++ {
+			page := i*32 + j
+			if w&(1<<uint(j)) != 0 {
+				if start < 0 {
+					start = page
+				}
+				continue
+			}
+			if start >= 0 {
+				if err := m.syncPages(start, page, ps); err != nil {
+					m.redirty(start, page)
+					orBits(&m.dirty[i], w>>uint(j)<<uint(j))
+					return err
+				}
+				start = -1
+			}
+		}
+	}
+	if start >= 0 {
+		if err := m.syncPages(start, len(m.dirty)*32, ps); err != nil {
+			m.redirty(start, len(m.dirty)*32)
+			return err
+		}
+	}
+	return nil
+}
+
+// mark pages [from, to) dirty again, cleared for a flush which failed
+func (m *Map) redirty(from, to int) {
+	for page := from; page < to; page++ {
+		orBits(&m.dirty[page/32], 1<<uint(page%32))
+	}
+}
+
+// set bits in the word w of the dirty pages
+func orBits(w *uint32, bits uint32) {
+	for {
+		old := atomic.LoadUint32(w)
+		if old&bits == bits || atomic.CompareAndSwapUint32(w, old, old|bits) {
+			return
+		}
+	}
+}
+
+// flush pages [from, to)
+func (m *Map) syncPages(from, to, ps int) error {
+	size := len(m.mp.Bytes())
+	off := from * ps
+	end := to * ps
+	if end > size {
+		end = size
+	}
+	rs, ok := m.mp.(RangeSyncer)
+	if !ok {
+		return m.mp.Sync()
+	}
+	return rs.SyncRange(off, end-off)
+}
+
+// mark the pages covering [p, p+n) as dirty
+func (m *Map) markDirty(p unsafe.Pointer, n uintptr) {
+	if m.dirty == nil {
+		return
+	}
+	ps := uintptr(os.Getpagesize())
+	off := uintptr(p) - uintptr(unsafe.Pointer(m.head))
+	for page := off / ps; page <= (off+n-1)/ps; page++ {
+		orBits(&m.dirty[page/32], uint32(1)<<(page%32))
+	}
+}
+
+// mark a bucket, its hash slot and the header as dirty
+func (m *Map) markBucket(b *bucket, ptr *hash) {
+	if m.dirty == nil {
+		return
+	}
+	m.markDirty(unsafe.Pointer(b), uintptr(m.head.bucketSize))
+	m.markDirty(unsafe.Pointer(ptr), unsafe.Sizeof(hash{}))
+	m.markDirty(unsafe.Pointer(m.head), unsafe.Sizeof(header{}))
+}
+
+// start the background flush goroutine
+func (m *Map) startSync(interval time.Duration, dirty bool) {
+	if dirty {
+		pages := (len(m.mp.Bytes()) + os.Getpagesize() - 1) / os.Getpagesize()
+		m.dirty = make([]uint32, (pages+31)/32)
+	}
+	if interval <= 0 {
+		return
+	}
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go func() {
+		defer close(m.done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-t.C:
+				// errors are retried on the next tick
+				_ = m.Sync()
+			}
+		}
+	}()
+}
+
+// stop the background flush goroutine
+func (m *Map) stopSync() {
+	if m.stop == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+	m.stop = nil
+	m.done = nil
+}
@@ -0,0 +1,50 @@
+package sync
+
+import (
+	"context"
+	"github.com/fengyoulin/shm/internal/futex"
+	"sync/atomic"
+)
+
+// Event is a manual reset event between processes
+type Event struct {
+	state *uint32
+}
+
+// EventAt return the event in the first EventSize bytes of b,
+// zeroed memory is an event not set
+func EventAt(b []byte) (*Event, error) {
+	w, err := word(b, EventSize)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{state: w}, nil
+}
+
+// Set the event, waking all waiters
+func (e *Event) Set() {
+	if atomic.SwapUint32(e.state, 1) == 0 {
+		futex.Wake(e.state, 1<<30)
+	}
+}
+
+// Reset the event
+func (e *Event) Reset() {
+	atomic.StoreUint32(e.state, 0)
+}
+
+// IsSet report whether the event is set
+func (e *Event) IsSet() bool {
+	return atomic.LoadUint32(e.state) != 0
+}
+
+// Wait until the event is set or ctx is done
+func (e *Event) Wait(ctx context.Context) error {
+	for !e.IsSet() {
+		futex.Wait(e.state, 0, checkInterval)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Package sync provides mutexes, semaphores and events living in
+// memory shared by processes, such as a region of a mapping or the
+// value of a map, waiting with a futex on linux and polling elsewhere
+package sync
+
+import (
+	"context"
+	"errors"
+	"github.com/fengyoulin/shm/internal/futex"
+	"github.com/fengyoulin/shm/internal/proc"
+	"os"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+var (
+	// ErrSize on a memory too small for the primitive
+	ErrSize = errors.New("memory too small")
+	// ErrAlign on a memory not aligned to 4 bytes
+	ErrAlign = errors.New("memory not aligned")
+	// ErrOwnerDead on lock a mutex whose holder died, the lock is
+	// acquired, but the data it guards may be inconsistent
+	ErrOwnerDead = errors.New("mutex holder died")
+	// ErrNotOwner on unlock a mutex held by another process
+	ErrNotOwner = errors.New("mutex not held by this process")
+)
+
+// bytes of the primitives
+const (
+	MutexSize     = 4
+	SemaphoreSize = 8
+	EventSize     = 4
+)
+
+// how long a waiter sleeps before checking the holder and ctx
+const checkInterval = 100 * time.Millisecond
+
+// the waiters bit of a mutex word, the low bits hold the holder pid
+const waiters = 1 << 31
+
+// a word of the primitive at b
+func word(b []byte, size int) (*uint32, error) {
+	if len(b) < size {
+		return nil, ErrSize
+	}
+	p := unsafe.Pointer(&b[0])
+	if uintptr(p)&3 != 0 {
+		return nil, ErrAlign
+	}
+	return (*uint32)(p), nil
+}
+
+// Mutex is a robust mutex between processes, a lock held by a dead
+// process is taken over by the next Lock, which return ErrOwnerDead
+// goroutines of a process share the lock, it is not reentrant
+type Mutex struct {
+	// holder pid, with the waiters bit
+	state *uint32
+	pid   uint32
+}
+
+// MutexAt return the mutex in the first MutexSize bytes of b,
+// zeroed memory is an unlocked mutex
+func MutexAt(b []byte) (*Mutex, error) {
+	w, err := word(b, MutexSize)
+	if err != nil {
+		return nil, err
+	}
+	return &Mutex{state: w, pid: uint32(os.Getpid())}, nil
+}
+
+// TryLock lock the mutex if it is free
+func (m *Mutex) TryLock() bool {
+	return atomic.CompareAndSwapUint32(m.state, 0, m.pid)
+}
+
+// Lock the mutex, waiting as long as it takes
+func (m *Mutex) Lock() error {
+	return m.LockContext(context.Background())
+}
+
+// LockContext lock the mutex, waiting until ctx is done
+func (m *Mutex) LockContext(ctx context.Context) error {
+	if m.TryLock() {
+		return nil
+	}
+	for {
+		old := atomic.LoadUint32(m.state)
+		if old == 0 {
+			// others may wait, keep the bit for the unlock to wake them
+			if atomic.CompareAndSwapUint32(m.state, 0, m.pid|waiters) {
+				return nil
+			}
+			continue
+		}
+		if old&waiters == 0 && !atomic.CompareAndSwapUint32(m.state, old, old|waiters) {
+			continue
+		}
+		futex.Wait(m.state, old|waiters, checkInterval)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// take over from a dead holder
+		if cur := atomic.LoadUint32(m.state); cur == old|waiters && !proc.Alive(int(old&^waiters)) {
+			if atomic.CompareAndSwapUint32(m.state, cur, m.pid|waiters) {
+				return ErrOwnerDead
+			}
+		}
+	}
+}
+
+// Unlock the mutex held by this process
+func (m *Mutex) Unlock() error {
+	for {
+		old := atomic.LoadUint32(m.state)
+		if old&^waiters != m.pid {
+			return ErrNotOwner
+		}
+		if atomic.CompareAndSwapUint32(m.state, old, 0) {
+			if old&waiters != 0 {
+				futex.Wake(m.state, 1)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package sync
+
+import (
+	"context"
+	"github.com/fengyoulin/shm/internal/futex"
+	"sync/atomic"
+)
+
+// Semaphore is a counting semaphore between processes
+// units held by a process are not released when it dies
+type Semaphore struct {
+	count   *uint32
+	waiters *uint32
+}
+
+// SemaphoreAt return the semaphore in the first SemaphoreSize bytes
+// of b, zeroed memory is a semaphore of no units, see Release
+func SemaphoreAt(b []byte) (*Semaphore, error) {
+	c, err := word(b, SemaphoreSize)
+	if err != nil {
+		return nil, err
+	}
+	w, _ := word(b[4:], 4)
+	return &Semaphore{count: c, waiters: w}, nil
+}
+
+// TryAcquire take a unit if one is available
+func (s *Semaphore) TryAcquire() bool {
+	for {
+		c := atomic.LoadUint32(s.count)
+		if c == 0 {
+			return false
+		}
+		if atomic.CompareAndSwapUint32(s.count, c, c-1) {
+			return true
+		}
+	}
+}
+
+// Acquire take a unit, waiting until ctx is done
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	for {
+		if s.TryAcquire() {
+			return nil
+		}
+		atomic.AddUint32(s.waiters, 1)
+		futex.Wait(s.count, 0, checkInterval)
+		atomic.AddUint32(s.waiters, ^uint32(0))
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// Release n units, waking as many waiters
+func (s *Semaphore) Release(n int) {
+	atomic.AddUint32(s.count, uint32(n))
+	if atomic.LoadUint32(s.waiters) != 0 {
+		futex.Wake(s.count, n)
+	}
+}
+
+// Count return the available units
+func (s *Semaphore) Count() int {
+	return int(atomic.LoadUint32(s.count))
+}
@@ -0,0 +1,117 @@
+package sync
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 8 byte aligned memory
+func memory(n int) []byte {
+	return make([]byte, n)
+}
+
+func TestMutex(t *testing.T) {
+	b := memory(MutexSize)
+	var counter, running int32
+	done := make(chan struct{})
+	for g := 0; g < 4; g++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			m, err := MutexAt(b)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for i := 0; i < 1000; i++ {
+				if err = m.Lock(); err != nil {
+					t.Error(err)
+					return
+				}
+				if atomic.AddInt32(&running, 1) != 1 {
+					t.Error("two holders")
+				}
+				counter++
+				atomic.AddInt32(&running, -1)
+				if err = m.Unlock(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	for g := 0; g < 4; g++ {
+		<-done
+	}
+	if counter != 4000 {
+		t.Errorf("expect 4000, got %d", counter)
+	}
+}
+
+func TestMutex_OwnerDead(t *testing.T) {
+	b := memory(MutexSize)
+	m, err := MutexAt(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a pid beyond pid_max
+	*m.state = 1<<31 - 1
+	if err = m.Unlock(); err != ErrNotOwner {
+		t.Errorf("expect ErrNotOwner, got %v", err)
+	}
+	if err = m.Lock(); err != ErrOwnerDead {
+		t.Errorf("expect ErrOwnerDead, got %v", err)
+	}
+	if err = m.Unlock(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSemaphore(t *testing.T) {
+	s, err := SemaphoreAt(memory(SemaphoreSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.TryAcquire() {
+		t.Fatal("acquired an empty semaphore")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err = s.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expect deadline exceeded, got %v", err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.Release(2)
+	}()
+	for i := 0; i < 2; i++ {
+		if err = s.Acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.Count() != 0 {
+		t.Errorf("expect 0 units, got %d", s.Count())
+	}
+}
+
+func TestEvent(t *testing.T) {
+	e, err := EventAt(memory(EventSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		e.Set()
+	}()
+	if err = e.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	e.Reset()
+	if e.IsSet() {
+		t.Error("set after reset")
+	}
+	if _, err = EventAt(memory(8)[1:]); err != ErrAlign {
+		t.Errorf("expect ErrAlign, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package shm
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMap_Sync(t *testing.T) {
+	name := "testsync.db"
+	m := newTestFile(t, name, 1024, 16, 8, SyncInterval(10*time.Millisecond), SyncDirty())
+	defer os.Remove(name)
+	v, err := m.Get("sync", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(v, "12345678")
+	if err = m.Sync(); err != nil {
+		t.Error(err)
+	}
+	for i := range m.dirty {
+		if atomic.LoadUint32(&m.dirty[i]) != 0 {
+			t.Error("dirty pages left after sync")
+		}
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err = m.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+// a mapping whose range flushes fail
+type failSync struct {
+	Mapping
+}
+
+var errSync = errors.New("sync failed")
+
+func (failSync) SyncRange(off, n int) error {
+	return errSync
+}
+
+func TestMap_SyncFailed(t *testing.T) {
+	m := newTestMap(t, 1024, 16, 8, SyncDirty())
+	defer m.Close()
+	if err := m.Set("sync", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	dirty := append([]uint32(nil), m.dirty...)
+	mp := m.mp
+	m.mp = failSync{mp}
+	err := m.Sync()
+	m.mp = mp
+	if err != errSync {
+		t.Fatalf("expect the sync error, got %v", err)
+	}
+	for i, w := range m.dirty {
+		if w != dirty[i] {
+			t.Errorf("dirty word %d %#x, expect %#x", i, w, dirty[i])
+		}
+	}
+}
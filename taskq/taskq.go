@@ -0,0 +1,336 @@
+// Package taskq distributes tasks among worker processes without a
+// broker, each worker with a local deque in memory shared by them all,
+// stealing from the others when idle, with a shared overflow queue,
+// a task taken is leased so that the task of a crashed worker is run
+// again by another one
+package taskq
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/fengyoulin/shm/deque"
+	"github.com/fengyoulin/shm/internal/proc"
+	"github.com/fengyoulin/shm/sync"
+	"os"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// HeaderSize is the bytes before the worker slots
+const HeaderSize = 64
+
+// bytes of a worker slot before the task taken
+const slotHeader = 24
+
+// idle polls of Take back off up to this
+const maxPoll = 50 * time.Millisecond
+
+var (
+	// ErrSize on a memory too small
+	ErrSize = errors.New("memory too small")
+	// ErrParam on a parameter out of range
+	ErrParam = errors.New("parameter out of range")
+	// ErrTaskSize on a task too long
+	ErrTaskSize = errors.New("task too long")
+	// ErrFull on push with the local deque and the overflow full
+	ErrFull = errors.New("queue full")
+	// ErrNoSlot on join with all the worker slots taken
+	ErrNoSlot = errors.New("no free worker slot")
+	// ErrDamaged on take of a task whose length is out of range, the
+	// task is dropped
+	ErrDamaged = errors.New("task damaged")
+)
+
+// slot of a worker, the task it took and its lease, guarded by mu,
+// followed by the local deque
+type slot struct {
+	mu    uint32
+	owner uint32
+	// lease deadline in unix nanoseconds, 0 if no task taken
+	deadline int64
+	len      uint32
+	_        uint32
+}
+
+// Queue of tasks
+// tasks are stored in deques with a length prefix
+type Queue struct {
+	mem      []byte
+	workers  int
+	taskSize int
+	// bytes of a slot
+	slotSize int
+	// bytes of a local deque
+	localSize int
+	overflow  *deque.Deque
+}
+
+// round n up to 8 bytes
+func align(n int) int {
+	return (n + 7) &^ 7
+}
+
+// Size return the bytes of a queue of workers local deques of
+// localCap tasks of at most taskSize bytes, and an overflow of
+// overflowCap tasks
+func Size(workers, taskSize, localCap, overflowCap int) int {
+	elem := 4 + taskSize
+	return HeaderSize + workers*(slotHeader+align(taskSize)+align(deque.HeaderSize+localCap*elem)) +
+		deque.HeaderSize + overflowCap*elem
+}
+
+// At return the queue in b, for at most workers workers, with local
+// deques of localCap tasks of at most taskSize bytes, the rest of b is
+// the overflow, zeroed memory is an empty queue
+// all processes must pass the same parameters
+func At(b []byte, workers, taskSize, localCap int) (*Queue, error) {
+	if workers <= 0 || taskSize <= 0 || localCap <= 0 {
+		return nil, ErrParam
+	}
+	q := &Queue{
+		mem:       b,
+		workers:   workers,
+		taskSize:  taskSize,
+		localSize: align(deque.HeaderSize + localCap*(4+taskSize)),
+	}
+	q.slotSize = slotHeader + align(taskSize) + q.localSize
+	off := HeaderSize + workers*q.slotSize
+	if len(b) < off {
+		return nil, ErrSize
+	}
+	var err error
+	if q.overflow, err = deque.At(b[off:], 4+taskSize); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// slot i and its mutex, task and local deque
+func (q *Queue) slot(i int) (s *slot, mu *sync.Mutex, task []byte, local *deque.Deque) {
+	b := q.mem[HeaderSize+i*q.slotSize : HeaderSize+(i+1)*q.slotSize]
+	s = (*slot)(unsafe.Pointer(&b[0]))
+	mu, _ = sync.MutexAt(b)
+	task = b[slotHeader : slotHeader+q.taskSize]
+	local, _ = deque.At(b[q.slotSize-q.localSize:], 4+q.taskSize)
+	return
+}
+
+// lock mu, a dead holder left the slot consistent or being recovered
+func lock(mu *sync.Mutex) error {
+	if err := mu.Lock(); err != nil && err != sync.ErrOwnerDead {
+		return err
+	}
+	return nil
+}
+
+// frame a task with its length
+func frame(buf, task []byte) []byte {
+	buf = append(buf[:0], 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(buf, uint32(len(task)))
+	return append(buf, task...)
+}
+
+// the task framed in an element of a deque, false if its length is
+// out of the element
+func unframe(elem []byte) ([]byte, bool) {
+	n := binary.LittleEndian.Uint32(elem)
+	if uint64(n) > uint64(len(elem)-4) {
+		return nil, false
+	}
+	return elem[4 : 4+n], true
+}
+
+// Worker of a process, holding a slot
+// a worker is not safe for concurrent use
+type Worker struct {
+	q     *Queue
+	i     int
+	local *deque.Deque
+	buf   []byte
+}
+
+// Join take a free worker slot, or one of a dead process after
+// recovering its tasks
+func (q *Queue) Join() (*Worker, error) {
+	pid := uint32(os.Getpid())
+	for i := 0; i < q.workers; i++ {
+		s, _, _, local := q.slot(i)
+		owner := atomic.LoadUint32(&s.owner)
+		if owner != 0 {
+			if proc.Alive(int(owner)) {
+				continue
+			}
+			if _, err := q.recover(i, true); err != nil {
+				return nil, err
+			}
+			owner = 0
+		}
+		if atomic.CompareAndSwapUint32(&s.owner, owner, pid) {
+			return &Worker{q: q, i: i, local: local}, nil
+		}
+	}
+	return nil, ErrNoSlot
+}
+
+// recover slot i, requeue its task taken if its lease expired or if
+// dead, then also its local tasks and free the slot
+func (q *Queue) recover(i int, dead bool) (n int, err error) {
+	s, mu, task, local := q.slot(i)
+	if err = lock(mu); err != nil {
+		return
+	}
+	defer func() {
+		if e := mu.Unlock(); err == nil {
+			err = e
+		}
+	}()
+	if deadline := atomic.LoadInt64(&s.deadline); deadline != 0 && (dead || deadline < time.Now().UnixNano()) {
+		if s.len <= uint32(len(task)) {
+			if err = q.overflow.PushBack(frame(nil, task[:s.len])); err != nil {
+				return
+			}
+			n++
+		}
+		atomic.StoreInt64(&s.deadline, 0)
+	}
+	if !dead {
+		return
+	}
+	for {
+		// a task is in the overflow before it leaves the local deque,
+		// kept there if the overflow is full
+		var ok bool
+		if ok, err = local.PopFrontFunc(q.overflow.PushBack); err != nil || !ok {
+			break
+		}
+		n++
+	}
+	if err == nil {
+		atomic.StoreUint32(&s.owner, 0)
+	}
+	return
+}
+
+// Recover requeue the tasks of dead workers and those taken with an
+// expired lease, return how many, Take calls it when idle
+// a task whose lease expired while its worker is alive may run twice
+func (q *Queue) Recover() (n int, err error) {
+	for i := 0; i < q.workers; i++ {
+		s, _, _, _ := q.slot(i)
+		owner := atomic.LoadUint32(&s.owner)
+		if owner == 0 {
+			continue
+		}
+		var k int
+		k, err = q.recover(i, !proc.Alive(int(owner)))
+		n += k
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Push task to the local deque, to the overflow if it is full
+func (w *Worker) Push(task []byte) (err error) {
+	if len(task) > w.q.taskSize {
+		return ErrTaskSize
+	}
+	w.buf = frame(w.buf, task)
+	if err = w.local.PushBack(w.buf); err != deque.ErrFull {
+		return
+	}
+	if err = w.q.overflow.PushBack(w.buf); err == deque.ErrFull {
+		err = ErrFull
+	}
+	return
+}
+
+// next task from the local deque, the overflow, or stolen from another
+// worker, given to take before it leaves its deque
+func (w *Worker) next(take func(elem []byte) error) (ok bool, err error) {
+	if ok, err = w.local.PopBackFunc(take); err != nil || ok {
+		return
+	}
+	if ok, err = w.q.overflow.PopFrontFunc(take); err != nil || ok {
+		return
+	}
+	for k := 1; k < w.q.workers; k++ {
+		_, _, _, other := w.q.slot((w.i + k) % w.q.workers)
+		if ok, err = other.PopFrontFunc(take); err != nil || ok {
+			return
+		}
+	}
+	return
+}
+
+// Take the next task appending it to dst, waiting until ctx is done if
+// none, the task is leased for lease until Done, then run again by
+// another worker
+func (w *Worker) Take(ctx context.Context, lease time.Duration, dst []byte) (task []byte, err error) {
+	s, mu, t, _ := w.q.slot(w.i)
+	// the task is leased in the slot before it leaves its deque, so
+	// that it is run again if this process dies in between
+	var damaged bool
+	take := func(elem []byte) error {
+		task, ok := unframe(elem)
+		if !ok {
+			damaged = true
+			return nil
+		}
+		atomic.StoreInt64(&s.deadline, 0)
+		s.len = uint32(copy(t, task))
+		atomic.StoreInt64(&s.deadline, time.Now().Add(lease).UnixNano())
+		dst = append(dst, task...)
+		return nil
+	}
+	poll := time.Millisecond
+	for {
+		if err = lock(mu); err != nil {
+			return dst, err
+		}
+		ok, err := w.next(take)
+		if e := mu.Unlock(); err == nil {
+			err = e
+		}
+		if err != nil {
+			return dst, err
+		}
+		if damaged {
+			return dst, ErrDamaged
+		}
+		if ok {
+			return dst, nil
+		}
+		if _, err = w.q.Recover(); err != nil {
+			return dst, err
+		}
+		select {
+		case <-ctx.Done():
+			return dst, ctx.Err()
+		case <-time.After(poll):
+		}
+		if poll *= 2; poll > maxPoll {
+			poll = maxPoll
+		}
+	}
+}
+
+// Done end the lease of the task taken
+func (w *Worker) Done() (err error) {
+	s, mu, _, _ := w.q.slot(w.i)
+	if err = lock(mu); err != nil {
+		return
+	}
+	atomic.StoreInt64(&s.deadline, 0)
+	return mu.Unlock()
+}
+
+// Leave requeue the local tasks and the task taken to the overflow,
+// and free the slot
+func (w *Worker) Leave() error {
+	_, err := w.q.recover(w.i, true)
+	return err
+}
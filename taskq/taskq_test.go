@@ -0,0 +1,96 @@
+package taskq
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	b := make([]byte, Size(2, 8, 2, 4))
+	q, err := At(b, 2, 8, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w0, err := q.Join()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w1, err := q.Join()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = q.Join(); err != ErrNoSlot {
+		t.Errorf("expect ErrNoSlot, got %v", err)
+	}
+	// two local, the rest to the overflow
+	for _, task := range []string{"a", "b", "c", "d"} {
+		if err = w0.Push([]byte(task)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var got string
+	for _, w := range []*Worker{w0, w1, w1, w1} {
+		task, err := w.Take(ctx, time.Minute, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got += string(task)
+		if err = w.Done(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// w0 takes its newest, w1 the overflow in order, then steals
+	if got != "bcda" {
+		t.Errorf("expect bcda, got %s", got)
+	}
+	// w1 crashes holding a task
+	if err = w0.Push([]byte("e")); err != nil {
+		t.Fatal(err)
+	}
+	if task, err := w1.Take(ctx, time.Minute, nil); err != nil || string(task) != "e" {
+		t.Fatalf("take: %q, %v", task, err)
+	}
+	s, _, _, _ := q.slot(w1.i)
+	atomic.StoreUint32(&s.owner, 1<<31-1)
+	if n, err := q.Recover(); err != nil || n != 1 {
+		t.Errorf("recover: %d, %v", n, err)
+	}
+	if task, err := w0.Take(ctx, time.Millisecond, nil); err != nil || string(task) != "e" {
+		t.Fatalf("take recovered: %q, %v", task, err)
+	}
+	// the lease expires
+	time.Sleep(2 * time.Millisecond)
+	if task, err := w0.Take(ctx, time.Minute, nil); err != nil || string(task) != "e" {
+		t.Fatalf("take expired: %q, %v", task, err)
+	}
+	if err = w0.Leave(); err != nil {
+		t.Fatal(err)
+	}
+	if w, err := q.Join(); err != nil || w.i != 0 {
+		t.Errorf("join: %v", err)
+	}
+}
+
+func TestQueue_Damaged(t *testing.T) {
+	q, err := At(make([]byte, Size(1, 8, 2, 4)), 1, 8, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := q.Join()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = q.overflow.PushBack([]byte{0xff, 0xff, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Take(context.Background(), time.Minute, nil); err != ErrDamaged {
+		t.Errorf("expect ErrDamaged, got %v", err)
+	}
+	if q.overflow.Len() != 0 {
+		t.Errorf("expect the damaged task dropped, left %d", q.overflow.Len())
+	}
+}
@@ -0,0 +1,55 @@
+package shm
+
+import (
+	"github.com/fengyoulin/shm/mapping"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// CreateTemp create a map in a new file in dir, named by pattern as
+// ioutil.TempFile does, removed by the last map closing it in any
+// process, for scratch state shared by the processes of a test or a
+// pipeline, which open it by its Path
+// dir "" is ShmDir, the other params are those of Create
+// a file left by processes killed is not removed
+func CreateTemp(dir, pattern string, mapCap, keyLen, valueLen, maxTry int, wait time.Duration, opts ...Option) (m *Map, err error) {
+	if dir == "" {
+		dir = ShmDir()
+	}
+	f, err := ioutil.TempFile(dir, pattern)
+	if err != nil {
+		return
+	}
+	path := f.Name()
+	if err = f.Close(); err != nil {
+		_ = os.Remove(path)
+		return
+	}
+	opts = append(opts, temporary())
+	if m, err = Create(path, mapCap, keyLen, valueLen, maxTry, wait, opts...); err != nil {
+		_ = removeMap(path)
+		return
+	}
+	// Segmented maps are opened by the segments, which reserve the name
+	if _, ok := m.mp.(*mapping.Segments); ok {
+		err = os.Remove(path)
+	}
+	if err != nil {
+		_ = m.Close()
+		m = nil
+	}
+	return
+}
+
+// Path return the backing file of the map, empty if none
+func (m *Map) Path() string {
+	return m.path
+}
+
+// the map is removed by the last closing it
+func temporary() Option {
+	return func(o *options) {
+		o.temp = true
+	}
+}
@@ -0,0 +1,46 @@
+package shm
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestCreateTemp(t *testing.T) {
+	m, err := CreateTemp("", "testtemp-*.db", 64, 16, 8, testMaxTry, initWait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := m.Path()
+	defer os.Remove(name)
+	if filepath.Dir(name) != ShmDir() {
+		t.Errorf("expect a file in %s, got %s", ShmDir(), name)
+	}
+	if err = m.Set("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	o, err := Open(name, testMaxTry, initWait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS == "windows" {
+		_ = o.Close()
+		return
+	}
+	if _, err = os.Stat(name); err != nil {
+		t.Fatalf("expect the file kept for o, got %v", err)
+	}
+	if v, err := o.Load("a", nil); err != nil || v[0] != '1' {
+		t.Errorf("unexpected %q, %v", v, err)
+	}
+	if err = o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("expect the file removed, got %v", err)
+	}
+}
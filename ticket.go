@@ -0,0 +1,98 @@
+package shm
+
+import (
+	"github.com/fengyoulin/shm/internal/proc"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// ticket of a hash slot with FairLocks, the writers of its chain take
+// turns in the order of their tickets
+type ticket struct {
+	// next ticket to take
+	next uint32
+	// ticket holding the turn
+	serving uint32
+	// turnOwner of the map holding the turn, 0 if none
+	owner uint32
+	_     uint32
+}
+
+// ticketStall is how long a turn may stay unclaimed by its ticket
+// before the waiters skip it, taken by a process crashed or descheduled
+// before it saw its turn, a turn claimed is skipped only once the
+// process holding it is dead
+const ticketStall = time.Second
+
+// tickets after the hash slots and the slot counters
+func (h *header) ticketOff() uint32 {
+	off := h.hashOff + uint32(unsafe.Sizeof(hash{}))*uint32(h.slotCount())
+	if h.features&featSlotOps != 0 {
+		off += uint32(unsafe.Sizeof(uint32(0))) * uint32(h.cap)
+	}
+	return off
+}
+
+// wait for the turn of the map on the chain of slot h, done passes
+// it on, the turns order the lock attempts of the maps so that none
+// loses the race for the chain lock again and again
+// the operations nested in one holding the turn of its map, and the
+// other goroutines using the map, go on without a turn
+func (m *Map) turn(h int32) (done func()) {
+	if m.tickets == nil {
+		return func() {}
+	}
+	t := &m.tickets[int(uint(h)%uint(m.nslots))]
+	if atomic.LoadUint32(&t.owner) == m.owner {
+		return func() {}
+	}
+	my := atomic.AddUint32(&t.next, 1) - 1
+	last, since := atomic.LoadUint32(&t.serving), time.Now()
+	for spins := 1; ; spins++ {
+		s := atomic.LoadUint32(&t.serving)
+		if s == my {
+			break
+		}
+		// skipped past
+		if int32(s-my) > 0 {
+			return func() {}
+		}
+		if s != last {
+			last, since = s, time.Now()
+		} else if spins%64 == 0 && t.stalled(since) {
+			atomic.CompareAndSwapUint32(&t.serving, s, s+1)
+			since = time.Now()
+		}
+		runtime.Gosched()
+	}
+	atomic.StoreUint32(&t.owner, m.owner)
+	return func() {
+		atomic.CompareAndSwapUint32(&t.owner, m.owner, 0)
+		atomic.CompareAndSwapUint32(&t.serving, my, my+1)
+	}
+}
+
+// the turn serving since since stalled: claimed by a dead process,
+// cleared then, or not claimed for ticketStall
+func (t *ticket) stalled(since time.Time) bool {
+	if o := atomic.LoadUint32(&t.owner); o != 0 {
+		if proc.Alive(int(o & (1<<22 - 1))) {
+			return false
+		}
+		return atomic.CompareAndSwapUint32(&t.owner, o, 0)
+	}
+	return time.Since(since) > ticketStall
+}
+
+// maps opened in the process, telling their turns apart
+var turnMaps uint32
+
+// owner of the turns of a map, the pid in the low 22 bits, which
+// linux pids fit in, with a count of the maps of the process
+func turnOwner() uint32 {
+	n := atomic.AddUint32(&turnMaps, 1)
+	return uint32(os.Getpid())&(1<<22-1) | n<<22
+}
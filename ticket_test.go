@@ -0,0 +1,90 @@
+package shm
+
+import (
+	"encoding/binary"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMap_FairLocks(t *testing.T) {
+	name := "testfair.db"
+	defer os.Remove(name)
+	const procs, incs = 4, 500
+	var ms [procs]*Map
+	for i := range ms {
+		m := newTestFile(t, name, 64, 16, 8, FairLocks())
+		defer m.Close()
+		ms[i] = m
+	}
+	if p := ms[0].Params(); p.Features[len(p.Features)-1] != "FairLocks" {
+		t.Errorf("unexpected features %v", p.Features)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, procs)
+	for _, m := range ms {
+		wg.Add(1)
+		go func(m *Map) {
+			defer wg.Done()
+			for i := 0; i < incs; i++ {
+				err := m.Update("n", true, func(v []byte) error {
+					binary.LittleEndian.PutUint64(v, binary.LittleEndian.Uint64(v)+1)
+					return nil
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+				m.Delete(strconv.Itoa(i))
+			}
+		}(m)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	v, err := ms[0].Load("n", nil)
+	if err != nil || binary.LittleEndian.Uint64(v) != procs*incs {
+		t.Fatalf("unexpected %v, %v", v, err)
+	}
+	m := ms[0]
+	for i := int32(0); i < m.nslots; i++ {
+		if tk := &m.tickets[i]; tk.next != tk.serving || tk.owner != 0 {
+			t.Fatalf("slot %d: turn not passed, %+v", i, *tk)
+		}
+	}
+}
+
+func TestMap_FairLocksStalled(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8, FairLocks())
+	defer m.Close()
+	// every turn held by a live process, then by a dead one
+	for i := int32(0); i < m.nslots; i++ {
+		tk := &m.tickets[i]
+		tk.next, tk.owner = 1, uint32(os.Getpid())
+	}
+	done := make(chan error)
+	go func() {
+		done <- m.Set("k", []byte("v"))
+	}()
+	select {
+	case <-done:
+		t.Fatal("turn of a live process skipped")
+	case <-time.After(100 * time.Millisecond):
+	}
+	for i := int32(0); i < m.nslots; i++ {
+		atomic.StoreUint32(&m.tickets[i].owner, 1<<22-1)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(ticketStall / 2):
+		t.Fatal("turn of a dead process not skipped")
+	}
+}
@@ -0,0 +1,284 @@
+// Package timerq provides a queue of payloads by deadline in memory
+// shared by processes, a binary heap any process pushes into, popped by
+// a janitor elected among them with a lease
+package timerq
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/fengyoulin/shm/lease"
+	"github.com/fengyoulin/shm/sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// HeaderSize is the bytes before the heap
+const HeaderSize = 32
+
+var (
+	// ErrSize on a memory too small for an item and the scratch
+	ErrSize = errors.New("memory too small")
+	// ErrItemSize on a payload size out of range
+	ErrItemSize = errors.New("payload size out of range")
+	// ErrFull on push to a full queue
+	ErrFull = errors.New("queue full")
+)
+
+// header in the memory
+type header struct {
+	mu  uint32
+	len uint32
+	// lease of the janitor
+	lease uint64
+	// the swap in progress, 0 if none
+	swap uint64
+}
+
+// stages of a swap of items i and j through the scratch item: i is
+// copied to the scratch, then j to i, then the scratch to j
+const (
+	swapScratch = 1
+	swapMoved   = 2
+)
+
+// most items, the index of a swap is in 30 bits
+const maxItems = 1<<30 - 1
+
+// Queue of payloads by deadline, each item a deadline in unix
+// nanoseconds and the payload, the last item is a scratch for swaps
+// an item is pushed past the heap then counted, popped by a swap to
+// the end then uncounted, and items are swapped through the scratch,
+// so the next holder of the lock after one died finishes its swap and
+// heapifies to restore the queue, with no item lost
+type Queue struct {
+	head  *header
+	mu    *sync.Mutex
+	lease *lease.Lease
+	items []byte
+	cap   uint32
+	size  int
+	// bytes of an item
+	item int
+}
+
+// At return the queue in b, of payloads of size bytes, its janitor
+// losing the lease ttl after the last heartbeat
+// zeroed memory is an empty queue
+// all processes must pass memory of the same length
+func At(b []byte, size int, ttl time.Duration) (*Queue, error) {
+	if size < 0 {
+		return nil, ErrItemSize
+	}
+	item := 8 + (size+7)&^7
+	if len(b) < HeaderSize+2*item {
+		return nil, ErrSize
+	}
+	mu, err := sync.MutexAt(b)
+	if err != nil {
+		return nil, err
+	}
+	l, err := lease.At(b[unsafe.Offsetof(header{}.lease):], ttl)
+	if err != nil {
+		return nil, err
+	}
+	items := b[HeaderSize:]
+	n := len(items)/item - 1
+	if n > maxItems {
+		n = maxItems
+	}
+	return &Queue{
+		head:  (*header)(unsafe.Pointer(&b[0])),
+		mu:    mu,
+		lease: l,
+		items: items,
+		cap:   uint32(n),
+		size:  size,
+		item:  item,
+	}, nil
+}
+
+// lock the queue, recovering it if the holder died
+func (q *Queue) lock() error {
+	err := q.mu.Lock()
+	if err == sync.ErrOwnerDead {
+		q.recover()
+		err = nil
+	}
+	return err
+}
+
+// recover the queue: finish the swap in progress, then heapify
+func (q *Queue) recover() {
+	if w := atomic.LoadUint64(&q.head.swap); w != 0 {
+		i, j, stage := uint32(w>>32)&^(3<<30), uint32(w), w>>62
+		if i < q.cap && j < q.cap {
+			if stage == swapScratch {
+				copy(q.at(i), q.at(j))
+			}
+			copy(q.at(j), q.at(q.cap))
+		}
+		atomic.StoreUint64(&q.head.swap, 0)
+	}
+	n := atomic.LoadUint32(&q.head.len)
+	if n > q.cap {
+		n = q.cap
+		atomic.StoreUint32(&q.head.len, n)
+	}
+	for i := n / 2; i > 0; i-- {
+		q.down(i-1, n)
+	}
+}
+
+// unlock the queue, keep the first error
+func (q *Queue) unlock(err *error) {
+	if e := q.mu.Unlock(); *err == nil {
+		*err = e
+	}
+}
+
+// item i of the heap
+func (q *Queue) at(i uint32) []byte {
+	off := int(i) * q.item
+	return q.items[off : off+q.item]
+}
+
+func (q *Queue) deadline(i uint32) int64 {
+	return int64(binary.LittleEndian.Uint64(q.at(i)))
+}
+
+// swap items i and j through the scratch, each stage logged
+func (q *Queue) swap(i, j uint32) {
+	a, b := q.at(i), q.at(j)
+	copy(q.at(q.cap), a)
+	atomic.StoreUint64(&q.head.swap, swapScratch<<62|uint64(i)<<32|uint64(j))
+	copy(a, b)
+	atomic.StoreUint64(&q.head.swap, swapMoved<<62|uint64(i)<<32|uint64(j))
+	copy(b, q.at(q.cap))
+	atomic.StoreUint64(&q.head.swap, 0)
+}
+
+func (q *Queue) up(i uint32) {
+	for i > 0 {
+		p := (i - 1) / 2
+		if q.deadline(p) <= q.deadline(i) {
+			return
+		}
+		q.swap(i, p)
+		i = p
+	}
+}
+
+func (q *Queue) down(i, n uint32) {
+	for {
+		c := 2*i + 1
+		if c >= n {
+			return
+		}
+		if c+1 < n && q.deadline(c+1) < q.deadline(c) {
+			c++
+		}
+		if q.deadline(i) <= q.deadline(c) {
+			return
+		}
+		q.swap(i, c)
+		i = c
+	}
+}
+
+// Push payload due at deadline, shorter than the payload size is
+// padded with 0
+func (q *Queue) Push(deadline time.Time, payload []byte) (err error) {
+	if len(payload) > q.size {
+		return ErrItemSize
+	}
+	if err = q.lock(); err != nil {
+		return
+	}
+	defer q.unlock(&err)
+	n := q.head.len
+	if n == q.cap {
+		return ErrFull
+	}
+	it := q.at(n)
+	binary.LittleEndian.PutUint64(it, uint64(deadline.UnixNano()))
+	for i := 8 + copy(it[8:], payload); i < len(it); i++ {
+		it[i] = 0
+	}
+	atomic.StoreUint32(&q.head.len, n+1)
+	q.up(n)
+	return
+}
+
+// Peek the earliest deadline, false if empty
+func (q *Queue) Peek() (deadline time.Time, ok bool, err error) {
+	if err = q.lock(); err != nil {
+		return
+	}
+	defer q.unlock(&err)
+	if q.head.len == 0 {
+		return
+	}
+	return time.Unix(0, q.deadline(0)), true, nil
+}
+
+// PopDue pop the item of the earliest deadline if not after now,
+// appending its payload to dst, false if none is due
+func (q *Queue) PopDue(now time.Time, dst []byte) (payload []byte, deadline time.Time, ok bool, err error) {
+	if err = q.lock(); err != nil {
+		return dst, deadline, false, err
+	}
+	defer q.unlock(&err)
+	n := q.head.len
+	if n == 0 || q.deadline(0) > now.UnixNano() {
+		return dst, deadline, false, nil
+	}
+	deadline = time.Unix(0, q.deadline(0))
+	payload = append(dst, q.at(0)[8:8+q.size]...)
+	n--
+	q.swap(0, n)
+	q.down(0, n)
+	atomic.StoreUint32(&q.head.len, n)
+	return payload, deadline, true, nil
+}
+
+// Len return the number of items, without locking
+func (q *Queue) Len() int {
+	return int(atomic.LoadUint32(&q.head.len))
+}
+
+// Janitor return the lease electing the janitor
+func (q *Queue) Janitor() *lease.Lease {
+	return q.lease
+}
+
+// Run the janitor until ctx is done: every interval take or renew the
+// lease, and while holding it pop the due items calling fn with each
+// the lease is released on return, which is ctx.Err() or a lock error
+func (q *Queue) Run(ctx context.Context, interval time.Duration, fn func(deadline time.Time, payload []byte)) error {
+	defer q.lease.Release()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	var buf []byte
+	for {
+		if q.lease.TryAcquire() && q.lease.Heartbeat() {
+			for {
+				payload, deadline, ok, err := q.PopDue(time.Now(), buf[:0])
+				if err != nil {
+					return err
+				}
+				if !ok {
+					break
+				}
+				fn(deadline, payload)
+				buf = payload
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package timerq
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	b := make([]byte, HeaderSize+7*16)
+	q, err := At(b, 8, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, d := range []int{5, 1, 4, 2, 3, 100} {
+		if err = q.Push(now.Add(time.Duration(d)*time.Millisecond), []byte{byte(d)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = q.Push(now, nil); err != ErrFull {
+		t.Errorf("expect ErrFull, got %v", err)
+	}
+	if d, ok, err := q.Peek(); err != nil || !ok || !d.Equal(now.Add(time.Millisecond)) {
+		t.Errorf("peek: %v, %v, %v", d, ok, err)
+	}
+	var got []byte
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = q.Run(ctx, 5*time.Millisecond, func(deadline time.Time, payload []byte) {
+		got = append(got, payload[0])
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("expect context.DeadlineExceeded, got %v", err)
+	}
+	if string(got) != "\x01\x02\x03\x04\x05" || q.Len() != 1 {
+		t.Errorf("expect 1 to 5, got %v, left %d", got, q.Len())
+	}
+	if pid, _ := q.Janitor().Holder(); pid != 0 {
+		t.Errorf("expect lease released, held by %d", pid)
+	}
+}
+
+func TestQueue_Recover(t *testing.T) {
+	q, err := At(make([]byte, HeaderSize+9*16), 8, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, d := range []int{5, 1, 4, 2, 3, 8, 6, 7} {
+		if err = q.Push(now.Add(time.Duration(d)), []byte{byte(d)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a holder dies in a pop, the last item moved over the first, the
+	// first not yet moved from the scratch to the last
+	n := q.head.len - 1
+	copy(q.at(q.cap), q.at(0))
+	q.head.swap = swapScratch<<62 | uint64(n)
+	copy(q.at(0), q.at(n))
+	q.head.mu = 1<<31 - 1
+	var got []byte
+	for {
+		payload, _, ok, err := q.PopDue(now.Add(time.Hour), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		got = append(got, payload[0])
+	}
+	if string(got) != "\x01\x02\x03\x04\x05\x06\x07\x08" {
+		t.Errorf("expect 1 to 8, got %v", got)
+	}
+}
@@ -0,0 +1,282 @@
+// Package trie provides a prefix tree of byte strings to values in
+// memory shared by processes, for longest prefix match lookups such as
+// routes or flag paths, with nodes in an arena and a lock per subtree
+package trie
+
+import (
+	"errors"
+	"github.com/fengyoulin/shm/arena"
+	"github.com/fengyoulin/shm/database"
+	"github.com/fengyoulin/shm/mapping"
+	"github.com/fengyoulin/shm/sync"
+	"time"
+	"unsafe"
+)
+
+// HeaderSize is the bytes before the arena
+const HeaderSize = 2064
+
+// ErrSize on a memory too small
+var ErrSize = errors.New("memory too small")
+
+// header in the memory, a subtree of each first byte of keys with its
+// lock, then the value of the empty key with its lock
+type header struct {
+	mu        [256]uint32
+	root      [256]uint32
+	rootMu    uint32
+	rootValue uint32
+}
+
+// node of a subtree, the children of a node are a list of siblings
+type node struct {
+	child   uint32
+	sibling uint32
+	// block of the value, 0 if none
+	value uint32
+	label byte
+}
+
+// Trie of keys to values
+type Trie struct {
+	head *header
+	// locks of the subtrees, the last one of the empty key
+	mu    [257]*sync.Mutex
+	nodes *arena.Arena
+	// mapping of Open, nil with At
+	mp *mapping.Mapping
+}
+
+// At return the trie in b, zeroed memory is an empty trie
+// all processes must pass memory of the same length
+func At(b []byte) (*Trie, error) {
+	if len(b) < HeaderSize {
+		return nil, ErrSize
+	}
+	a, err := arena.At(b[HeaderSize:])
+	if err != nil {
+		return nil, err
+	}
+	t := &Trie{head: (*header)(unsafe.Pointer(&b[0])), nodes: a}
+	for i := range t.mu {
+		if t.mu[i], err = sync.MutexAt(b[4*i:]); err != nil {
+			return nil, err
+		}
+	}
+	// the lock of the empty key follows the roots
+	if t.mu[256], err = sync.MutexAt(b[unsafe.Offsetof(header{}.rootMu):]); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Open the trie in the file at path of size bytes, created if not
+// exist, waiting for at most wait for its initialization lock
+func Open(path string, size int, wait time.Duration) (t *Trie, err error) {
+	mp, unlock, err := database.Open(path, size, wait)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if e := unlock(); err == nil {
+			err = e
+		}
+		if err != nil {
+			_ = mp.Close()
+			t = nil
+		}
+	}()
+	if t, err = At(mp.Bytes()); err != nil {
+		return
+	}
+	t.mp = mp
+	return
+}
+
+// lock the subtree of key, a dead holder left at most a node not
+// linked or a value not freed
+func (t *Trie) lock(key string) (mu *sync.Mutex, err error) {
+	mu = t.mu[256]
+	if key != "" {
+		mu = t.mu[key[0]]
+	}
+	if err = mu.Lock(); err != nil && err != sync.ErrOwnerDead {
+		return nil, err
+	}
+	return mu, nil
+}
+
+// unlock mu, keep the first error
+func unlock(mu *sync.Mutex, err *error) {
+	if e := mu.Unlock(); *err == nil {
+		*err = e
+	}
+}
+
+func (t *Trie) node(off uint32) *node {
+	return (*node)(unsafe.Pointer(&t.nodes.Bytes(off)[0]))
+}
+
+// child add a node labeled label at the empty link
+func (t *Trie) child(link *uint32, label byte) (n *node, err error) {
+	off, err := t.nodes.Alloc(int(unsafe.Sizeof(node{})))
+	if err != nil {
+		return
+	}
+	n = t.node(off)
+	*n = node{label: label}
+	*link = off
+	return
+}
+
+// Insert key with value, replacing any value of it
+func (t *Trie) Insert(key string, value []byte) (err error) {
+	mu, err := t.lock(key)
+	if err != nil {
+		return
+	}
+	defer unlock(mu, &err)
+	v, err := t.nodes.Alloc(len(value))
+	if err != nil {
+		return
+	}
+	copy(t.nodes.Bytes(v), value)
+	link, err := t.insert(key)
+	if err != nil {
+		t.nodes.Free(v)
+		return
+	}
+	if *link != 0 {
+		t.nodes.Free(*link)
+	}
+	*link = v
+	return
+}
+
+// insert the nodes of key, return its value link
+func (t *Trie) insert(key string) (link *uint32, err error) {
+	if key == "" {
+		return &t.head.rootValue, nil
+	}
+	link = &t.head.root[key[0]]
+	for i := 1; ; i++ {
+		if *link == 0 {
+			if _, err = t.child(link, key[i-1]); err != nil {
+				return
+			}
+		}
+		n := t.node(*link)
+		if i == len(key) {
+			return &n.value, nil
+		}
+		link = &n.child
+		for *link != 0 && t.node(*link).label != key[i] {
+			link = &t.node(*link).sibling
+		}
+	}
+}
+
+// lookup the node of key, nil if none
+func (t *Trie) lookup(key string) *node {
+	off := t.head.root[key[0]]
+	for i := 1; off != 0 && i < len(key); i++ {
+		off = t.node(off).child
+		for off != 0 && t.node(off).label != key[i] {
+			off = t.node(off).sibling
+		}
+	}
+	if off == 0 {
+		return nil
+	}
+	return t.node(off)
+}
+
+// Get the value of key appending it to dst, false if none
+func (t *Trie) Get(key string, dst []byte) (b []byte, ok bool, err error) {
+	mu, err := t.lock(key)
+	if err != nil {
+		return dst, false, err
+	}
+	defer unlock(mu, &err)
+	v := t.head.rootValue
+	if key != "" {
+		if n := t.lookup(key); n != nil {
+			v = n.value
+		} else {
+			v = 0
+		}
+	}
+	if v == 0 {
+		return dst, false, nil
+	}
+	return append(dst, t.nodes.Bytes(v)...), true, nil
+}
+
+// Delete the value of key, true if it had one, the nodes are kept
+func (t *Trie) Delete(key string) (deleted bool, err error) {
+	mu, err := t.lock(key)
+	if err != nil {
+		return
+	}
+	defer unlock(mu, &err)
+	link := &t.head.rootValue
+	if key != "" {
+		n := t.lookup(key)
+		if n == nil {
+			return
+		}
+		link = &n.value
+	}
+	if *link == 0 {
+		return
+	}
+	t.nodes.Free(*link)
+	*link = 0
+	return true, nil
+}
+
+// LongestPrefix return the longest key with a value that is a prefix
+// of s, appending the value to dst, false if none
+// the subtree of s is locked first, then the empty key
+func (t *Trie) LongestPrefix(s string, dst []byte) (prefix string, b []byte, ok bool, err error) {
+	b = dst
+	if s != "" {
+		var mu *sync.Mutex
+		if mu, err = t.lock(s); err != nil {
+			return
+		}
+		off, best := t.head.root[s[0]], 0
+		var v uint32
+		for i := 1; off != 0; i++ {
+			n := t.node(off)
+			if n.value != 0 {
+				v, best = n.value, i
+			}
+			if i == len(s) {
+				break
+			}
+			for off = n.child; off != 0 && t.node(off).label != s[i]; off = t.node(off).sibling {
+			}
+		}
+		if v != 0 {
+			b = append(dst, t.nodes.Bytes(v)...)
+		}
+		unlock(mu, &err)
+		if v != 0 || err != nil {
+			return s[:best], b, err == nil, err
+		}
+	}
+	if b, ok, err = t.Get("", dst); !ok {
+		return "", b, false, err
+	}
+	return "", b, true, err
+}
+
+// Close the mapping of Open
+func (t *Trie) Close() (err error) {
+	if t.mp != nil {
+		err = t.mp.Close()
+		t.mp = nil
+	}
+	return
+}
@@ -0,0 +1,51 @@
+package trie
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTrie(t *testing.T) {
+	name := "testtrie.db"
+	defer os.Remove(name)
+	tr, err := Open(name, 1<<16, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	if _, _, ok, err := tr.LongestPrefix("/api", nil); ok || err != nil {
+		t.Errorf("empty trie: %v, %v", ok, err)
+	}
+	for _, k := range []string{"", "/api", "/api/v1/", "/apix", "/b"} {
+		if err = tr.Insert(k, []byte("<"+k+">")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = tr.Insert("/b", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err := tr.Delete("/apix"); err != nil || !deleted {
+		t.Errorf("delete: %v, %v", deleted, err)
+	}
+	cases := []struct{ s, prefix, value string }{
+		{"/api/v1/users", "/api/v1/", "</api/v1/>"},
+		{"/api/v2", "/api", "</api>"},
+		{"/apix", "/api", "</api>"},
+		{"/b/c", "/b", "b"},
+		{"/c", "", "<>"},
+		{"", "", "<>"},
+	}
+	for _, c := range cases {
+		prefix, v, ok, err := tr.LongestPrefix(c.s, nil)
+		if err != nil || !ok || prefix != c.prefix || string(v) != c.value {
+			t.Errorf("%q: expect %q %q, got %q %q, %v, %v", c.s, c.prefix, c.value, prefix, v, ok, err)
+		}
+	}
+	if v, ok, err := tr.Get("/api", nil); err != nil || !ok || string(v) != "</api>" {
+		t.Errorf("get: %q, %v, %v", v, ok, err)
+	}
+	if _, ok, err := tr.Get("/ap", nil); err != nil || ok {
+		t.Errorf("get inner node: %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,127 @@
+package shm
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// ErrNoExpiry on ttl operations of a map without Expiration
+var ErrNoExpiry = errors.New("map without expiration")
+
+// Touch set the entry of key to expire ttl from now, under the lock
+// of its chain, ttl <= 0 expires it now, Set keeps the expiry
+func (m *Map) Touch(key string, ttl time.Duration) (err error) {
+	if m.meta.expiry == 0 {
+		return ErrNoExpiry
+	}
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	at := time.Now().UnixNano()
+	if ttl > 0 {
+		at += int64(ttl)
+	}
+	return m.locked(key, false, func(bkt *bucket) error {
+		atomic.StoreInt64(bkt.expiry(m), at)
+		return nil
+	})
+}
+
+// Persist remove the expiry of the entry of key, under the lock of its
+// chain, it is kept until deleted
+func (m *Map) Persist(key string) (err error) {
+	if m.meta.expiry == 0 {
+		return ErrNoExpiry
+	}
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	return m.locked(key, false, func(bkt *bucket) error {
+		atomic.StoreInt64(bkt.expiry(m), 0)
+		return nil
+	})
+}
+
+// TTL return the time to the expiry of the entry of key,
+// 0 if it has none
+func (m *Map) TTL(key string) (ttl time.Duration, err error) {
+	if m.meta.expiry == 0 {
+		return 0, ErrNoExpiry
+	}
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	bkt, err := m.lookup(key, false)
+	if err != nil {
+		return
+	}
+	return m.ttlOf(bkt), nil
+}
+
+// GetStale get a copy of the value of key, an entry past its expiry
+// included, reported stale instead of deleted, for callers to serve it
+// while refreshing it with Set and Touch
+// expired entries are still deleted by Get, ExpireDue and the Janitor
+func (m *Map) GetStale(key string) (b []byte, stale bool, err error) {
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	bkt, err := m.lookupBucket(key, false, nil)
+	if err != nil {
+		return
+	}
+	stale = m.expired(bkt)
+	if missing(bkt) {
+		// a negative entry expired is no longer known
+		if stale {
+			return nil, false, ErrKeyNot
+		}
+		return nil, false, ErrMissing
+	}
+	v, err := m.valueOf(bkt)
+	if err != nil {
+		return nil, false, err
+	}
+	m.addHit(bkt)
+	return append([]byte(nil), v...), stale, nil
+}
+
+// time to the expiry of a bucket, 0 if none or no Expiration
+func (m *Map) ttlOf(b *bucket) time.Duration {
+	if m.meta.expiry == 0 {
+		return 0
+	}
+	at := atomic.LoadInt64(b.expiry(m))
+	if at == 0 {
+		return 0
+	}
+	if ttl := time.Duration(at - time.Now().UnixNano()); ttl > 0 {
+		return ttl
+	}
+	// expired meanwhile, the least ttl
+	return 1
+}
+
+// report whether a bucket is past its expiry and not pinned
+func (m *Map) expired(b *bucket) bool {
+	if m.meta.expiry == 0 || pinned(b) {
+		return false
+	}
+	at := atomic.LoadInt64(b.expiry(m))
+	return at != 0 && at <= time.Now().UnixNano()
+}
+
+// clear the expiry of a new bucket
+func (m *Map) resetExpiry(b *bucket) {
+	if m.meta.expiry == 0 {
+		return
+	}
+	atomic.StoreInt64(b.expiry(m), 0)
+}
+
+// expiry of a bucket, unix nano
+func (b *bucket) expiry(m *Map) *int64 {
+	return (*int64)(unsafe.Pointer(uintptr(unsafe.Pointer(b)) + m.meta.expiry))
+}
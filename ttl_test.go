@@ -0,0 +1,96 @@
+package shm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMap_Touch(t *testing.T) {
+	for _, opts := range [][]Option{{InMemory(), Expiration()}, {InMemory(), Expiration(), SingleWriter()}} {
+		m, err := Create("", 64, 16, 8, testMaxTry, initWait, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []string{"a", "b", "c"} {
+			if err = m.Set(k, []byte(k)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = m.Touch("none", time.Hour); err != ErrKeyNot {
+			t.Errorf("expect ErrKeyNot, got %v", err)
+		}
+		if err = m.Touch("a", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if ttl, err := m.TTL("a"); err != nil || ttl <= 59*time.Minute || ttl > time.Hour {
+			t.Errorf("unexpected ttl %v, %v", ttl, err)
+		}
+		if err = m.Touch("b", time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		if err = m.Touch("c", time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		if err = m.Persist("c"); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+		if ttl, err := m.TTL("c"); err != nil || ttl != 0 {
+			t.Errorf("expect no ttl, got %v, %v", ttl, err)
+		}
+		if _, err = m.Load("b", nil); err != ErrKeyNot {
+			t.Errorf("expect b expired, got %v", err)
+		}
+		if _, err = m.Get("b", true); err != nil {
+			t.Fatal(err)
+		}
+		if ttl, _ := m.TTL("b"); ttl != 0 {
+			t.Errorf("expect no ttl of new b, got %v", ttl)
+		}
+		if err = m.Touch("b", -1); err != nil {
+			t.Fatal(err)
+		}
+		if n := m.ExpireDue(); n != 1 || m.Len() != 2 {
+			t.Errorf("expect 1 expired of 3, got %d, len %d", n, m.Len())
+		}
+		_ = m.Close()
+	}
+	m := newTestMap(t, 64, 16, 8)
+	defer m.Close()
+	var err error
+	if err = m.Touch("a", time.Hour); err != ErrNoExpiry {
+		t.Errorf("expect ErrNoExpiry, got %v", err)
+	}
+}
+
+func TestMap_GetStale(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8, Expiration())
+	defer m.Close()
+	var err error
+	if err = m.Set("k", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if v, stale, err := m.GetStale("k"); err != nil || stale || string(v[:3]) != "old" {
+		t.Errorf("unexpected %q, %v, %v", v, stale, err)
+	}
+	if err = m.Touch("k", -1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if v, stale, err := m.GetStale("k"); err != nil || !stale || string(v[:3]) != "old" {
+			t.Errorf("expect stale old, got %q, %v, %v", v, stale, err)
+		}
+	}
+	if err = m.Set("k", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Touch("k", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if v, stale, err := m.GetStale("k"); err != nil || stale || string(v[:3]) != "new" {
+		t.Errorf("expect fresh new, got %q, %v, %v", v, stale, err)
+	}
+	if _, _, err = m.GetStale("none"); err != ErrKeyNot {
+		t.Errorf("expect ErrKeyNot, got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package shm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	// ErrTxnDone on use a Txn after Commit or Rollback
+	ErrTxnDone = errors.New("transaction done")
+	// ErrTxnLocked on Lock of a Txn holding its locks
+	ErrTxnLocked = errors.New("transaction locked")
+	// ErrTxnKey on a write or read of a key a Txn did not lock
+	ErrTxnKey = errors.New("key not locked by the transaction")
+)
+
+// Txn is a pessimistic transaction on keys locked by KeyLocks: Lock
+// takes the locks of all its keys in the order of their slots, so two
+// transactions never wait on each other, then the writes are staged,
+// and applied by Commit, those applied undone if one fails
+// serializable among the processes locking the keys they write, the
+// writes of the others are not locked out
+type Txn struct {
+	m      *Map
+	slots  []int32
+	writes []txnWrite
+	done   bool
+}
+
+// a staged write, a delete if value is nil
+type txnWrite struct {
+	key   string
+	value []byte
+}
+
+// Txn begin a transaction on m
+func (m *Map) Txn() *Txn {
+	return &Txn{m: m}
+}
+
+// Lock the keys of the transaction, all of them or none, waiting
+// until ctx is done, the deadline of which bounds the whole; the locks
+// of a holder dead are taken over, and ErrKeyOwnerDead return with all
+// of them held
+func (t *Txn) Lock(ctx context.Context, keys ...string) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	if t.slots != nil {
+		return ErrTxnLocked
+	}
+	m := t.m
+	if m.keyLocks == 0 {
+		return ErrNoKeyLocks
+	}
+	seen := make(map[int32]struct{}, len(keys))
+	slots := make([]int32, 0, len(keys))
+	for _, key := range keys {
+		i, err := m.keySlot(key)
+		if err != nil {
+			return err
+		}
+		if _, ok := seen[i]; !ok {
+			seen[i] = struct{}{}
+			slots = append(slots, i)
+		}
+	}
+	sort.Slice(slots, func(i, j int) bool {
+		return slots[i] < slots[j]
+	})
+	var dead error
+	for k, i := range slots {
+		err := m.slotLock(i).LockContext(ctx)
+		if err == ErrKeyOwnerDead {
+			dead, err = err, nil
+		}
+		if err != nil {
+			for _, j := range slots[:k] {
+				_ = m.slotLock(j).Unlock()
+			}
+			return err
+		}
+	}
+	t.slots = slots
+	return dead
+}
+
+// check key is locked by the transaction
+func (t *Txn) check(key string) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	i, err := t.m.keySlot(key)
+	if err != nil {
+		return err
+	}
+	k := sort.Search(len(t.slots), func(k int) bool {
+		return t.slots[k] >= i
+	})
+	if k == len(t.slots) || t.slots[k] != i {
+		return ErrTxnKey
+	}
+	return nil
+}
+
+// Set stage the value of key, a copy
+func (t *Txn) Set(key string, value []byte) error {
+	if err := t.check(key); err != nil {
+		return err
+	}
+	t.writes = append(t.writes, txnWrite{key: key, value: append([]byte{}, value...)})
+	return nil
+}
+
+// Delete stage the delete of key
+func (t *Txn) Delete(key string) error {
+	if err := t.check(key); err != nil {
+		return err
+	}
+	t.writes = append(t.writes, txnWrite{key: key})
+	return nil
+}
+
+// Get a copy of the value of key as staged, or else in the map
+func (t *Txn) Get(key string) ([]byte, error) {
+	if err := t.check(key); err != nil {
+		return nil, err
+	}
+	for i := len(t.writes) - 1; i >= 0; i-- {
+		if w := t.writes[i]; w.key == key {
+			if w.value == nil {
+				return nil, ErrKeyNot
+			}
+			return append([]byte(nil), w.value...), nil
+		}
+	}
+	return t.m.Load(key, nil)
+}
+
+// Commit apply the staged writes in order and release the locks; on
+// a write failing, those applied are undone, the values before them
+// set again, and the error return
+// the flags and expiry of a key deleted and set again are not kept
+func (t *Txn) Commit() (err error) {
+	if t.done {
+		return ErrTxnDone
+	}
+	defer t.release()
+	m := t.m
+	// values before the writes, nil of a key not in the map
+	before := make(map[string][]byte)
+	var order []string
+	for _, w := range t.writes {
+		if _, ok := before[w.key]; ok {
+			continue
+		}
+		v, err := m.Load(w.key, nil)
+		if err != nil && err != ErrKeyNot {
+			return err
+		}
+		if err == nil && v == nil {
+			v = []byte{}
+		}
+		before[w.key] = v
+		order = append(order, w.key)
+	}
+	for k, w := range t.writes {
+		if w.value != nil {
+			err = m.Set(w.key, w.value)
+		} else if !m.Delete(w.key) {
+			err = ErrTryEnd
+		}
+		if err == nil {
+			continue
+		}
+		err = fmt.Errorf("commit %q: %w", w.key, err)
+		if k > 0 {
+			t.undo(order, before)
+		}
+		return
+	}
+	return nil
+}
+
+// set the values before the writes again
+func (t *Txn) undo(keys []string, before map[string][]byte) {
+	for _, key := range keys {
+		if v := before[key]; v != nil {
+			_ = t.m.Set(key, v)
+		} else {
+			t.m.Delete(key)
+		}
+	}
+}
+
+// Rollback drop the staged writes and release the locks
+func (t *Txn) Rollback() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.release()
+	return nil
+}
+
+// end the transaction, unlock its slots in the reverse order
+func (t *Txn) release() {
+	for k := len(t.slots) - 1; k >= 0; k-- {
+		_ = t.m.slotLock(t.slots[k]).Unlock()
+	}
+	t.slots, t.writes, t.done = nil, nil, true
+}
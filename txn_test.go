@@ -0,0 +1,89 @@
+package shm
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMap_Txn(t *testing.T) {
+	name := "testtxn.db"
+	defer os.Remove(name)
+	m := newTestFile(t, name, 64, 16, 8, KeyLocks())
+	defer m.Close()
+	var err error
+	if err = m.Set("a", []byte("10")); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	tx := m.Txn()
+	if err = tx.Lock(ctx, "b", "a", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Lock(ctx, "c"); err != ErrTxnLocked {
+		t.Errorf("expect ErrTxnLocked, got %v", err)
+	}
+	if ok, _ := m.TryLockKey("a"); ok {
+		t.Error("expect a locked by the transaction")
+	}
+	if err = tx.Set("a", []byte("7")); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Set("b", []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := tx.Get("a"); err != nil || string(v) != "7" {
+		t.Errorf("expect a staged, got %q, %v", v, err)
+	}
+	if v, _ := m.Load("a", nil); v[0] != '1' {
+		t.Errorf("expect a not written before commit, got %q", v)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := m.Load("b", nil); v[0] != '3' {
+		t.Errorf("expect b committed, got %q", v)
+	}
+	if err = tx.Set("a", nil); err != ErrTxnDone {
+		t.Errorf("expect ErrTxnDone, got %v", err)
+	}
+
+	// a write failing undoes those before it
+	tx = m.Txn()
+	if err = tx.Lock(ctx, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	_ = tx.Delete("b")
+	_ = tx.Set("a", []byte("1"))
+	_ = tx.Set("a", []byte("too long to set"))
+	if err = tx.Commit(); err == nil {
+		t.Fatal("expect a commit failing")
+	}
+	if v, err := m.Load("a", nil); err != nil || v[0] != '7' {
+		t.Errorf("expect a undone, got %q, %v", v, err)
+	}
+	if v, err := m.Load("b", nil); err != nil || v[0] != '3' {
+		t.Errorf("expect b undone, got %q, %v", v, err)
+	}
+	if ok, _ := m.TryLockKey("a"); !ok {
+		t.Error("expect a unlocked")
+	}
+
+	// all or none within the deadline
+	tx = m.Txn()
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err = tx.Lock(tctx, "b", "a"); err != context.DeadlineExceeded {
+		t.Errorf("expect a deadline, got %v", err)
+	}
+	if ok, _ := m.TryLockKey("b"); !ok {
+		t.Error("expect b released")
+	}
+	if err = tx.Set("b", nil); err != ErrTxnKey {
+		t.Errorf("expect ErrTxnKey, got %v", err)
+	}
+	_ = tx.Rollback()
+	_ = m.UnlockKey("a")
+	_ = m.UnlockKey("b")
+}
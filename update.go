@@ -0,0 +1,41 @@
+package shm
+
+// Update run fn on the value of key with its chain locked, add the key
+// if not exist and add, fn writes to the value in the bucket, so what
+// it wrote before an error stays, of a compressed map, or one with
+// SpanValues, fn gets a copy stored again when fn return nil
+func (m *Map) Update(key string, add bool, fn func(value []byte) error) (err error) {
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	return m.locked(key, add, func(bkt *bucket) error {
+		if m.comp == nil && m.meta.span == 0 {
+			err := fn(bkt.value(m))
+			m.setUpdated(bkt)
+			m.bumpVersion(bkt)
+			return err
+		}
+		v, err := m.valueOf(bkt)
+		if err != nil {
+			return err
+		}
+		buf := make([]byte, m.vcap)
+		if len(v) > m.vcap {
+			buf = make([]byte, len(v))
+		}
+		copy(buf, v)
+		if err = fn(buf); err != nil {
+			return err
+		}
+		b, compressed, err := m.encode(buf)
+		if err != nil {
+			return err
+		}
+		if err = m.store(bkt, b, compressed, len(buf)); err != nil {
+			return err
+		}
+		m.setUpdated(bkt)
+		m.bumpVersion(bkt)
+		return nil
+	})
+}
@@ -0,0 +1,28 @@
+package shm
+
+import "testing"
+
+func TestMap_Update(t *testing.T) {
+	for _, opts := range [][]Option{{InMemory()}, {InMemory(), Compress(Snappy, 0)}} {
+		m, err := Create("", 64, 16, 8, testMaxTry, initWait, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		incr := func(v []byte) error {
+			v[0]++
+			return nil
+		}
+		for i := 0; i < 3; i++ {
+			if err = m.Update("n", true, incr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = m.Update("none", false, incr); err != ErrKeyNot {
+			t.Errorf("expect ErrKeyNot, got %v", err)
+		}
+		if v, err := m.Load("n", nil); err != nil || v[0] != 3 {
+			t.Errorf("expect 3, got %v, %v", v, err)
+		}
+		m.Close()
+	}
+}
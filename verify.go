@@ -0,0 +1,225 @@
+package shm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/fengyoulin/shm/internal/proc"
+)
+
+// ErrCorrupt on verify a damaged map
+var ErrCorrupt = errors.New("map corrupted")
+
+// CorruptError list the problems found by Verify
+type CorruptError struct {
+	Problems []string
+}
+
+// Error implements error
+func (e *CorruptError) Error() string {
+	return ErrCorrupt.Error() + ": " + strings.Join(e.Problems, "; ")
+}
+
+// Is make errors.Is(err, ErrCorrupt) true
+func (e *CorruptError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+// Verify check the chains, the deleted link and the counters
+// return a *CorruptError listing the problems found
+// meant for a quiescent map, a busy one may report transient locks,
+// and the buckets in the FreeBatch of other processes leaked
+func (m *Map) Verify() error {
+	m.flushFree()
+	var ce CorruptError
+	report := func(format string, args ...interface{}) {
+		ce.Problems = append(ce.Problems, fmt.Sprintf(format, args...))
+	}
+	next := m.head.next
+	if next < 0 || next > m.head.cap {
+		report("next %d out of range", next)
+		return &ce
+	}
+	// 1 for chained, 2 for deleted, 3 for continuation
+	seen := make([]uint8, m.head.cap)
+	var count int32
+	for i := int32(0); i < m.nslots; i++ {
+		ptr := &(*m.hash)[i]
+		if w := (*ptr)[2]; w > 0 {
+			report("slot %d locked", i)
+		} else if w < 0 && !proc.Alive(int(-w)) {
+			report("slot %d fenced by dead process %d", i, -w)
+		}
+		var length int
+		for idx := ptr.index(); idx >= 0; {
+			if idx >= next {
+				report("slot %d links bucket %d beyond next", i, idx)
+				break
+			}
+			if seen[idx] != 0 {
+				report("slot %d links bucket %d twice", i, idx)
+				break
+			}
+			seen[idx] = 1
+			bkt := m.bucket(idx)
+			if bkt.used == 0 {
+				report("slot %d links unused bucket %d", i, idx)
+			}
+			if m.hashPtr(bkt.hash) != ptr {
+				report("bucket %d in slot %d by hash", idx, i)
+			}
+			length++
+			idx = bkt.next
+		}
+		if length != ptr.length() {
+			report("slot %d chain length %d, recorded %d", i, length, ptr.length())
+		}
+		count += int32(length)
+	}
+	for i := int32(0); i < next && m.meta.span != 0; i++ {
+		sp := m.bucket(i).span(m)
+		j := int32(sp[0]) - 1
+		if seen[i] != 1 || j < 0 {
+			continue
+		}
+		for k := int(sp[1]) - m.vcap; k > 0; k -= m.spanCap() {
+			if j < 0 || j >= next || seen[j] != 0 {
+				report("bucket %d spans bucket %d, out of range or in use", i, j)
+				break
+			}
+			seen[j] = 3
+			j = m.bucket(j).next
+		}
+	}
+	last := int32(-1)
+	for idx := m.head.deleteLink; idx >= 0; {
+		if idx >= next {
+			report("deleted link has bucket %d beyond next", idx)
+			break
+		}
+		if seen[idx] != 0 {
+			report("deleted link has bucket %d in use or twice", idx)
+			break
+		}
+		seen[idx] = 2
+		last, idx = idx, m.bucket(idx).next
+	}
+	if m.fifo != nil && m.fifo.tail != last {
+		report("deleted link ends at bucket %d, fifo tail %d", last, m.fifo.tail)
+	}
+	for i := int32(0); i < next; i++ {
+		if seen[i] == 0 {
+			report("bucket %d leaked", i)
+		}
+	}
+	if l := atomic.LoadInt32(&m.head.len); l != count {
+		report("len %d, %d buckets chained", l, count)
+	}
+	if len(ce.Problems) > 0 {
+		return &ce
+	}
+	return nil
+}
+
+// Repair rebuild the chains, the deleted link and the counters
+// from the used buckets, release locks and lift a Fence left by crashed
+// processes
+// other processes must not access the map during Repair, it holds
+// the map Exclusive, ErrAttached if they are attached
+// with Cuckoo a repaired slot may hold a chain of two buckets
+func (m *Map) Repair() (err error) {
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	release, err := m.Exclusive()
+	if err != nil {
+		return
+	}
+	if m.batch != nil {
+		m.batch.reset()
+	}
+	defer func() {
+		if e := release(); err == nil {
+			err = e
+		}
+	}()
+	m.liftDeadFence()
+	next := m.head.next
+	if next < 0 || next > m.head.cap {
+		return &CorruptError{Problems: []string{fmt.Sprintf("next %d out of range", next)}}
+	}
+	for i := int32(0); i < m.nslots; i++ {
+		ptr := &(*m.hash)[i]
+		ptr.setIndex(-1)
+		m.bumpSerial(ptr)
+		// a Fence of a process alive keeps its locks
+		if w := (*ptr)[2]; w >= 0 || !proc.Alive(int(-w)) {
+			(*ptr)[2] = 0
+		}
+		(*ptr)[3] = 0
+	}
+	keys := make(map[string]struct{})
+	var count int32
+	m.head.deleteLink = -1
+	if m.fifo != nil {
+		m.fifo.tail = -1
+		m.fifo.unlock()
+	}
+	// continuation buckets kept, unless their entry is dropped
+	var spans map[int32]struct{}
+	if m.meta.span != 0 {
+		spans = m.spanned(next, true)
+	}
+	// link in reverse, keep the bucket order of the chains ascending
+	for i := next - 1; i >= 0; i-- {
+		bkt := m.bucket(i)
+		if _, ok := spans[i]; ok {
+			continue
+		}
+		if bkt.used != 0 {
+			key := bkt.key(m)
+			if _, dup := keys[key]; !dup && len(key) < int(m.head.keySize) {
+				keys[key] = struct{}{}
+				ss, n, _ := m.slots(key)
+				dst := shortest(ss[:n])
+				bkt.hash = dst.h
+				ptr := dst.ptr
+				bkt.next = ptr.index()
+				ptr.setIndex(i)
+				ptr.addLength(1)
+				count++
+				continue
+			}
+			bkt.used = 0
+		}
+		if m.meta.span != 0 {
+			bkt.span(m)[0] = 0
+		}
+		m.repairFree(i)
+	}
+	kept := m.spanned(next, false)
+	for i := range spans {
+		if _, ok := kept[i]; !ok {
+			m.repairFree(i)
+		}
+	}
+	atomic.StoreInt32(&m.head.len, count)
+	return nil
+}
+
+// put bucket i to the free list rebuilt by Repair
+func (m *Map) repairFree(i int32) {
+	bkt := m.bucket(i)
+	bkt.next = m.head.deleteLink
+	m.head.deleteLink = i
+	if m.fifo == nil {
+		return
+	}
+	// ready now, the bucket freed last is the one linked first
+	bkt.hash = int32(m.fifo.now())
+	if bkt.next < 0 {
+		m.fifo.tail = i
+	}
+}
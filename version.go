@@ -0,0 +1,115 @@
+package shm
+
+import (
+	"errors"
+	"sync/atomic"
+	"unsafe"
+)
+
+var (
+	// ErrNoVersion on versioned operations of a map without Versions
+	ErrNoVersion = errors.New("map without versions")
+	// ErrVersion on SetIfVersion of a version not the current one
+	ErrVersion = errors.New("version changed")
+)
+
+// GetVersioned append a copy of the value of key to dst, with its
+// version, both read consistent with the writes, as Load
+func (m *Map) GetVersioned(key string, dst []byte) (b []byte, version uint64, err error) {
+	if m.meta.version == 0 {
+		return dst, 0, ErrNoVersion
+	}
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	var e error
+	err = m.read(key, func(bkt *bucket) {
+		var v []byte
+		v, e = m.valueOf(bkt)
+		b = append(dst, v...)
+		version = atomic.LoadUint64(bkt.version(m))
+	})
+	if err != nil {
+		return dst, 0, err
+	}
+	return b, version, e
+}
+
+// SetIfVersion set the value of key if its version is still version,
+// under the lock of its chain, return ErrVersion otherwise
+// version 0 adds the key if not exist, or matches a key never set
+func (m *Map) SetIfVersion(key string, value []byte, version uint64) (err error) {
+	if m.meta.version == 0 {
+		return ErrNoVersion
+	}
+	if m.protect {
+		defer m.protected(&err)()
+	}
+	b, compressed, err := m.encode(value)
+	if err != nil {
+		return err
+	}
+	err = m.locked(key, version == 0, func(bkt *bucket) error {
+		cur := atomic.LoadUint64(bkt.version(m))
+		if cur != version && (version != 0 || uint32(cur) != 0) {
+			return ErrVersion
+		}
+		if err := m.store(bkt, b, compressed, len(value)); err != nil {
+			return err
+		}
+		m.setUpdated(bkt)
+		m.bumpVersion(bkt)
+		return nil
+	})
+	if err == ErrKeyNot {
+		err = ErrVersion
+	}
+	return
+}
+
+// bump the version of a written bucket
+func (m *Map) bumpVersion(b *bucket) {
+	if m.meta.version == 0 {
+		return
+	}
+	atomic.AddUint64(b.version(m), 1)
+}
+
+// seed the version of a new bucket, the high 32 bits from the counter
+// of the map, so a key deleted and added again does not take a version
+// it had before, the low ones counting the writes
+func (m *Map) resetVersion(b *bucket) {
+	if m.meta.version == 0 {
+		return
+	}
+	seq := atomic.AddUint32(&m.verSeq.next, 1)
+	atomic.StoreUint64(b.version(m), uint64(seq)<<32)
+}
+
+// the counter of the versions of new buckets, 16 bytes after the acl
+// record
+type verSeq struct {
+	next uint32
+	_    uint32
+	_    uint64
+}
+
+// the version counter record of a map of header h
+func verSeqOf(h *header) *verSeq {
+	off := unsafe.Sizeof(header{})
+	if h.features&featOrigin != 0 {
+		off += unsafe.Sizeof(origin{})
+	}
+	if h.features&featFIFO != 0 {
+		off += unsafe.Sizeof(fifo{})
+	}
+	if h.features&featACL != 0 {
+		off += unsafe.Sizeof(acl{})
+	}
+	return (*verSeq)(unsafe.Pointer(uintptr(unsafe.Pointer(h)) + off))
+}
+
+// version counter of a bucket
+func (b *bucket) version(m *Map) *uint64 {
+	return (*uint64)(unsafe.Pointer(uintptr(unsafe.Pointer(b)) + m.meta.version))
+}
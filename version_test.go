@@ -0,0 +1,90 @@
+package shm
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+func TestMap_SetIfVersion(t *testing.T) {
+	m := newTestMap(t, 64, 16, 8, Versions())
+	defer m.Close()
+	var err error
+	if err = m.SetIfVersion("n", []byte("a"), 1); err != ErrVersion {
+		t.Errorf("expect ErrVersion, got %v", err)
+	}
+	if err = m.SetIfVersion("n", make([]byte, 8), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.SetIfVersion("n", make([]byte, 8), 0); err != ErrVersion {
+		t.Errorf("expect ErrVersion, got %v", err)
+	}
+	const workers, adds = 4, 100
+	done := make(chan struct{})
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			var buf []byte
+			for i := 0; i < adds; {
+				v, ver, err := m.GetVersioned("n", buf[:0])
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				buf = v
+				n := binary.LittleEndian.Uint64(v)
+				binary.LittleEndian.PutUint64(v, n+1)
+				if err = m.SetIfVersion("n", v, ver); err == nil {
+					i++
+				} else if err != ErrVersion {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	for w := 0; w < workers; w++ {
+		<-done
+	}
+	v, ver, err := m.GetVersioned("n", nil)
+	if err != nil || binary.LittleEndian.Uint64(v) != workers*adds || uint32(ver) != workers*adds+1 {
+		t.Errorf("expect %d at version %d, got %v at %d, %v", workers*adds, workers*adds+1, v, ver, err)
+	}
+	if _, meta, _ := m.GetWithMeta("n"); meta.Version != ver {
+		t.Errorf("expect meta version %d, got %d", ver, meta.Version)
+	}
+	// a key deleted and set again has a version of its own
+	if !m.Delete("n") {
+		t.Fatal("expect deleted")
+	}
+	if err = m.Set("n", v); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.SetIfVersion("n", v, ver); err != ErrVersion {
+		t.Errorf("expect ErrVersion of the version before the delete, got %v", err)
+	}
+}
+
+func TestMap_GetVersionedReadOnly(t *testing.T) {
+	name := "testversionro.db"
+	defer os.Remove(name)
+	m := newTestFile(t, name, 64, 16, 8, Versions(), SingleWriter())
+	defer m.Close()
+	if err := m.Set("k", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	r := newTestFile(t, name, 64, 16, 8, Versions(), SingleWriter(), ReadOnly())
+	defer r.Close()
+	ss, _, err := r.slots("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := ss[0].ptr.serial()
+	v, ver, err := r.GetVersioned("k", nil)
+	if err != nil || string(v[:1]) != "1" || uint32(ver) != 1 {
+		t.Errorf("expect 1 at version 1, got %q at %x, %v", v, ver, err)
+	}
+	if ss[0].ptr.serial() != serial {
+		t.Error("expect the serial unchanged by a read")
+	}
+}
@@ -0,0 +1,83 @@
+package shm
+
+import (
+	"context"
+	"errors"
+	"github.com/fengyoulin/shm/internal/futex"
+	shmsync "github.com/fengyoulin/shm/sync"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoKeyWaits on WaitForKey of a map without KeyWaits
+var ErrNoKeyWaits = errors.New("map without key waits")
+
+// the waiters bit of a key wait word, the low bits count the adds
+const keyWaiters = 1 << 31
+
+// longest a waiter sleeps before checking the key and ctx again
+const keyWaitCheck = 100 * time.Millisecond
+
+// key waits after the key locks
+func (h *header) keyWaitOff() uint32 {
+	off := h.keyLockOff()
+	if h.features&featKeyLocks != 0 {
+		off += shmsync.MutexSize * uint32(h.slotCount())
+	}
+	return off
+}
+
+// the key wait word of the hash slot of key
+func (m *Map) keyWait(key string) (*uint32, error) {
+	if m.keyWaits == nil {
+		return nil, ErrNoKeyWaits
+	}
+	ss, _, err := m.slots(key)
+	if err != nil {
+		return nil, err
+	}
+	return &m.keyWaits[uint(ss[0].h)%uint(m.nslots)], nil
+}
+
+// bump the word of the slot of key added or set, wake its waiters
+func (m *Map) wakeKey(key string) {
+	w, err := m.keyWait(key)
+	if err != nil {
+		return
+	}
+	for {
+		old := atomic.LoadUint32(w)
+		if atomic.CompareAndSwapUint32(w, old, (old+1)&^keyWaiters) {
+			if old&keyWaiters != 0 {
+				futex.Wake(w, math.MaxInt32)
+			}
+			return
+		}
+	}
+}
+
+// WaitForKey wait until key is in the map, as Exists report, or ctx is
+// done, for a process to wait for the key another sets, woken by the
+// writes adding or setting a key of its hash slot
+// a key added by Get or Ref wakes it before the adder writes the value
+func (m *Map) WaitForKey(ctx context.Context, key string) error {
+	w, err := m.keyWait(key)
+	if err != nil {
+		return err
+	}
+	for {
+		old := atomic.LoadUint32(w)
+		// the bit set before the check, an add after it wakes the wait
+		if old&keyWaiters == 0 && !atomic.CompareAndSwapUint32(w, old, old|keyWaiters) {
+			continue
+		}
+		if m.Exists(key) {
+			return nil
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		futex.Wait(w, old|keyWaiters, keyWaitCheck)
+	}
+}
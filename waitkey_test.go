@@ -0,0 +1,52 @@
+package shm
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMap_WaitForKey(t *testing.T) {
+	name := "testwaitforkey.db"
+	defer os.Remove(name)
+	m := newTestFile(t, name, 64, 16, 16, KeyWaits())
+	defer m.Close()
+	var err error
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err = m.WaitForKey(ctx, "a"); err != context.DeadlineExceeded {
+		t.Errorf("expect a deadline, got %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- m.WaitForKey(context.Background(), "a")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err = m.Set("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Error(err)
+		}
+		// woken, not by the check every 100ms
+		if runtime.GOOS == "linux" && time.Since(start) > 50*time.Millisecond {
+			t.Errorf("expect woken, took %v", time.Since(start))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect the wait done")
+	}
+	if err = m.WaitForKey(context.Background(), "a"); err != nil {
+		t.Error(err)
+	}
+	n := newTestFile(t, "testnokeywaits.db", 64, 16, 16)
+	defer os.Remove("testnokeywaits.db")
+	defer n.Close()
+	if err = n.WaitForKey(ctx, "a"); err != ErrNoKeyWaits {
+		t.Errorf("expect ErrNoKeyWaits, got %v", err)
+	}
+}
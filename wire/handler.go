@@ -0,0 +1,138 @@
+package wire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/fengyoulin/shm"
+	"io"
+)
+
+// most entries returned by a scan
+const maxScan = 1024
+
+// Handler of requests, the payload of a response may be appended to
+// dst, returned for reuse
+type Handler interface {
+	Handle(req *Request, dst []byte) (resp Response, b []byte)
+}
+
+// ServeConn read requests from c and write the responses of h, until
+// the end of c or an error
+func ServeConn(c io.ReadWriter, h Handler) error {
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	var buf, payload []byte
+	for {
+		req, body, err := ReadRequest(r, buf)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		buf = body
+		var resp Response
+		resp, payload = h.Handle(&req, payload[:0])
+		if err = WriteResponse(w, &resp); err == nil && r.Buffered() == 0 {
+			err = w.Flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Maps serve the requests on maps by name
+type Maps map[string]*shm.Map
+
+// Handle implements Handler
+func (ms Maps) Handle(req *Request, dst []byte) (Response, []byte) {
+	m := ms[req.Map]
+	if m == nil {
+		return Response{Status: StatusNotFound, Payload: []byte("no map " + req.Map)}, dst
+	}
+	return Apply(m, req, dst)
+}
+
+// Apply req to m
+func Apply(m *shm.Map, req *Request, dst []byte) (resp Response, b []byte) {
+	var err error
+	b = dst
+	switch req.Op {
+	case OpGet:
+		b, err = m.Load(req.Key, dst)
+		resp.Payload = b
+	case OpSet:
+		err = m.Set(req.Key, req.Value)
+	case OpDelete:
+		if !m.Delete(req.Key) {
+			err = shm.ErrTryEnd
+		}
+	case OpLen:
+		b = append(dst, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(b, uint64(m.Len()))
+		resp.Payload = b
+	case OpScan:
+		if len(req.Value) != 8 {
+			err = ErrFrame
+			break
+		}
+		cursor := binary.BigEndian.Uint32(req.Value)
+		count := binary.BigEndian.Uint32(req.Value[4:])
+		if count > maxScan {
+			count = maxScan
+		}
+		b = append(dst, 0, 0, 0, 0)
+		var next int
+		next, err = m.Scan(int(cursor), int(count), func(key string, value []byte) bool {
+			b = appendField(b, []byte(key))
+			b = appendField(b, value)
+			return len(b) < MaxFrame/2
+		})
+		if err != nil {
+			b = dst
+			break
+		}
+		binary.BigEndian.PutUint32(b, uint32(next))
+		resp.Payload = b
+	default:
+		err = fmt.Errorf("unknown op %d", req.Op)
+	}
+	switch {
+	case err == shm.ErrKeyNot:
+		resp = Response{Status: StatusNotFound}
+	case err != nil:
+		resp = Response{Status: StatusError, Payload: []byte(err.Error())}
+	}
+	return
+}
+
+// ScanValue return the value of a scan request from cursor for at
+// most count entries
+func ScanValue(cursor, count uint32) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:], cursor)
+	binary.BigEndian.PutUint32(b[4:], count)
+	return b[:]
+}
+
+// ReadScan call fn with the entries in the payload of a scan
+// response, return the cursor to scan on from, 0 when done
+func ReadScan(payload []byte, fn func(key, value []byte)) (next uint32, err error) {
+	if len(payload) < 4 {
+		return 0, ErrFrame
+	}
+	next = binary.BigEndian.Uint32(payload)
+	for b := payload[4:]; len(b) > 0; {
+		var key, value []byte
+		if key, b, err = field(b); err != nil {
+			return
+		}
+		if value, b, err = field(b); err != nil {
+			return
+		}
+		fn(key, value)
+	}
+	return
+}
@@ -0,0 +1,172 @@
+// Package wire is the protocol of shm-server and shm-proxy over a unix
+// socket, for processes not linking the library, each message a frame
+// of a 32-bit big endian length and the body:
+//
+//	request:  op byte, then map name, key and value, each a 32-bit
+//	          big endian length and the bytes
+//	response: status byte, then the payload, a value or an error text
+//
+// a connection carries requests and their responses in order
+//
+// the value of a scan request is a 32-bit big endian cursor, 0 to
+// start, and the most entries to return, the payload of its response
+// is the cursor to scan on from, 0 when done, then a key and a value
+// of each entry as fields
+package wire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ops of a request
+const (
+	// OpGet the value of key
+	OpGet byte = 1 + iota
+	// OpSet key to value
+	OpSet
+	// OpDelete key
+	OpDelete
+	// OpLen the number of keys, a 64-bit big endian payload
+	OpLen
+	// OpScan entries from a cursor, see ScanValue and ReadScan
+	OpScan
+)
+
+// status of a response
+const (
+	// StatusOK with the payload of the op
+	StatusOK byte = iota
+	// StatusNotFound on a key or map not found
+	StatusNotFound
+	// StatusError with the error text as the payload
+	StatusError
+)
+
+// MaxFrame is the longest frame body
+const MaxFrame = 16 << 20
+
+// ErrFrame on a frame too long or malformed
+var ErrFrame = errors.New("malformed frame")
+
+// Request of an op on a map
+type Request struct {
+	Op    byte
+	Map   string
+	Key   string
+	Value []byte
+}
+
+// Response to a request
+type Response struct {
+	Status  byte
+	Payload []byte
+}
+
+// read a frame body into buf
+func readFrame(r *bufio.Reader, buf []byte) ([]byte, error) {
+	var l [4]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(l[:])
+	if n == 0 || n > MaxFrame {
+		return nil, ErrFrame
+	}
+	if cap(buf) < int(n) {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// field split a length prefixed field off b
+func field(b []byte) (f, rest []byte, err error) {
+	if len(b) < 4 {
+		return nil, nil, ErrFrame
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint64(n) > uint64(len(b)-4) {
+		return nil, nil, ErrFrame
+	}
+	return b[4 : 4+n], b[4+n:], nil
+}
+
+// appendField append a length prefixed field
+func appendField(b, f []byte) []byte {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(f)))
+	return append(append(b, l[:]...), f...)
+}
+
+// ReadRequest read a request, the value is only valid until the next
+// read with the same buf, io.EOF at the end of the connection
+func ReadRequest(r *bufio.Reader, buf []byte) (req Request, body []byte, err error) {
+	if body, err = readFrame(r, buf); err != nil {
+		return
+	}
+	req.Op = body[0]
+	var name, key []byte
+	rest := body[1:]
+	if name, rest, err = field(rest); err != nil {
+		return
+	}
+	if key, rest, err = field(rest); err != nil {
+		return
+	}
+	if req.Value, rest, err = field(rest); err != nil {
+		return
+	}
+	if len(rest) != 0 {
+		return req, body, ErrFrame
+	}
+	req.Map, req.Key = string(name), string(key)
+	return
+}
+
+// WriteRequest write a request
+func WriteRequest(w io.Writer, req *Request) error {
+	b := make([]byte, 5, 17+len(req.Map)+len(req.Key)+len(req.Value))
+	b[4] = req.Op
+	b = appendField(b, []byte(req.Map))
+	b = appendField(b, []byte(req.Key))
+	b = appendField(b, req.Value)
+	if len(b)-4 > MaxFrame {
+		return ErrFrame
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	_, err := w.Write(b)
+	return err
+}
+
+// ReadResponse read a response, the payload is only valid until the
+// next read with the same buf
+func ReadResponse(r *bufio.Reader, buf []byte) (resp Response, body []byte, err error) {
+	if body, err = readFrame(r, buf); err != nil {
+		return
+	}
+	return Response{Status: body[0], Payload: body[1:]}, body, nil
+}
+
+// WriteResponse write a response
+func WriteResponse(w io.Writer, resp *Response) error {
+	if 1+len(resp.Payload) > MaxFrame {
+		return ErrFrame
+	}
+	var h [5]byte
+	binary.BigEndian.PutUint32(h[:], uint32(1+len(resp.Payload)))
+	h[4] = resp.Status
+	if _, err := w.Write(h[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(resp.Payload)
+	return err
+}
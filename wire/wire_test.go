@@ -0,0 +1,78 @@
+package wire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/fengyoulin/shm"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServeConn(t *testing.T) {
+	m, err := shm.Create("", 64, 16, 4, 0, time.Second, shm.InMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	c, s := net.Pipe()
+	defer c.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeConn(s, Maps{"m": m})
+		s.Close()
+	}()
+	r := bufio.NewReader(c)
+	call := func(req Request) Response {
+		go func() {
+			if err := WriteRequest(c, &req); err != nil {
+				t.Error(err)
+			}
+		}()
+		resp, _, err := ReadResponse(r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	for i := 0; i < 10; i++ {
+		if resp := call(Request{Op: OpSet, Map: "m", Key: strconv.Itoa(i), Value: []byte{byte(i)}}); resp.Status != StatusOK {
+			t.Fatalf("set: %d %s", resp.Status, resp.Payload)
+		}
+	}
+	if resp := call(Request{Op: OpDelete, Map: "m", Key: "0"}); resp.Status != StatusOK {
+		t.Errorf("delete: %d %s", resp.Status, resp.Payload)
+	}
+	if resp := call(Request{Op: OpGet, Map: "m", Key: "0"}); resp.Status != StatusNotFound {
+		t.Errorf("get deleted: %d", resp.Status)
+	}
+	if resp := call(Request{Op: OpLen, Map: "m"}); binary.BigEndian.Uint64(resp.Payload) != 9 {
+		t.Errorf("len: %v", resp.Payload)
+	}
+	seen := make(map[string]byte)
+	for cursor := uint32(0); ; {
+		resp := call(Request{Op: OpScan, Map: "m", Value: ScanValue(cursor, 4)})
+		if resp.Status != StatusOK {
+			t.Fatalf("scan: %d %s", resp.Status, resp.Payload)
+		}
+		if cursor, err = ReadScan(resp.Payload, func(key, value []byte) {
+			seen[string(key)] = value[0]
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 9 || seen["5"] != 5 {
+		t.Errorf("scan: %v", seen)
+	}
+	if resp := call(Request{Op: 99, Map: "m"}); resp.Status != StatusError {
+		t.Errorf("unknown op: %d", resp.Status)
+	}
+	c.Close()
+	if err = <-done; err != nil {
+		t.Error(err)
+	}
+}
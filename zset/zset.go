@@ -0,0 +1,511 @@
+// Package zset provides sorted sets of members with float64 scores in
+// memory shared by processes, a skip list in an arena with the members
+// indexed by a map, like the sorted sets of redis
+package zset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/fengyoulin/shm"
+	"github.com/fengyoulin/shm/arena"
+	"github.com/fengyoulin/shm/database"
+	"github.com/fengyoulin/shm/mapping"
+	"github.com/fengyoulin/shm/sync"
+	"math/rand"
+	"time"
+	"unsafe"
+)
+
+// skip list levels, each one a quarter of the one below
+const (
+	maxLevel = 32
+	levelP   = 4
+)
+
+// HeaderSize is the bytes before the arena of a region
+const HeaderSize = 64
+
+var (
+	// ErrSize on a region too small
+	ErrSize = errors.New("region too small")
+	// ErrValueSize on a map of too small values
+	ErrValueSize = errors.New("map values too small")
+)
+
+// region header
+type header struct {
+	mu     uint32
+	level  uint32
+	length uint32
+	// offset of the head node, 0 before init
+	head uint32
+}
+
+// node of the skip list, followed by level links and the member
+type node struct {
+	score float64
+	level uint32
+	mlen  uint32
+}
+
+// link to the next node of a level, with the nodes it skips
+type link struct {
+	next uint32
+	span uint32
+}
+
+// ZSet of members sorted by score
+type ZSet struct {
+	m      *shm.Map
+	head   *header
+	mu     *sync.Mutex
+	values *arena.Arena
+	// mapping of Open, nil with New
+	mp *mapping.Mapping
+}
+
+// Open the sorted set in the files at path and path.zset, for at most
+// members members of at most memberLen bytes, in a region of size bytes
+// waiting for at most wait for their locks
+func Open(path string, members, memberLen, size int, wait time.Duration) (z *ZSet, err error) {
+	m, err := shm.Create(path, members, memberLen, 4, 0, wait)
+	if err != nil {
+		return
+	}
+	mp, unlock, err := database.Open(path+".zset", size, wait)
+	if err != nil {
+		_ = m.Close()
+		return
+	}
+	if err = unlock(); err == nil {
+		z, err = New(m, mp.Bytes())
+	}
+	if err != nil {
+		_ = mp.Close()
+		_ = m.Close()
+		return nil, err
+	}
+	z.mp = mp
+	return
+}
+
+// New use m for the members, with values of at least 4 bytes, and the
+// region b for the skip list, zeroed memory is an empty set
+func New(m *shm.Map, b []byte) (*ZSet, error) {
+	if len(b) < HeaderSize {
+		return nil, ErrSize
+	}
+	a, err := arena.At(b[HeaderSize:])
+	if err != nil {
+		return nil, err
+	}
+	mu, err := sync.MutexAt(b)
+	if err != nil {
+		return nil, err
+	}
+	return &ZSet{m: m, head: (*header)(unsafe.Pointer(&b[0])), mu: mu, values: a}, nil
+}
+
+// lock the set, the first to lock it allocates the head node, the
+// next to lock it after a holder died recovers the set
+func (z *ZSet) lock() (err error) {
+	if err = z.mu.Lock(); err == sync.ErrOwnerDead {
+		if err = z.recover(); err != nil {
+			_ = z.mu.Unlock()
+		}
+	}
+	if err != nil {
+		return
+	}
+	if z.head.head == 0 {
+		var off uint32
+		if off, err = z.alloc(maxLevel, ""); err != nil {
+			_ = z.mu.Unlock()
+			return
+		}
+		z.head.head = off
+		z.head.level = 1
+	}
+	return
+}
+
+// recover the set a holder died changing: a node is complete before
+// it is linked, and linked into or cut out of level 0 by one store, so
+// level 0 is whole, the upper levels, the spans and the length are
+// rebuilt from it, a member left twice by an update keeps the node the
+// map points to, and the map is pointed at the nodes, without those
+// it has no node of
+// a node cut out before it was freed is lost to the arena
+func (z *ZSet) recover() error {
+	head := z.head.head
+	if head == 0 {
+		return nil
+	}
+	var order []uint32
+	nodes := make(map[string][]uint32)
+	for x := z.links(head)[0].next; x != 0; x = z.links(x)[0].next {
+		member := string(z.member(x))
+		order = append(order, x)
+		nodes[member] = append(nodes[member], x)
+	}
+	// one node of each member, pointed to by the map
+	keep := make(map[uint32]bool, len(order))
+	var drop []uint32
+	for member, offs := range nodes {
+		k := offs[0]
+		if len(offs) > 1 {
+			mapped, err := z.lookup(member)
+			if err != nil {
+				return err
+			}
+			for _, off := range offs {
+				if off == mapped {
+					k = off
+				}
+			}
+		}
+		for _, off := range offs {
+			if off != k {
+				drop = append(drop, off)
+			}
+		}
+		v, err := z.m.Get(member, true)
+		if err == nil && len(v) < 4 {
+			err = ErrValueSize
+		}
+		if err != nil {
+			drop = append(drop, k)
+			continue
+		}
+		binary.LittleEndian.PutUint32(v, k)
+		keep[k] = true
+	}
+	var stale []string
+	z.m.Foreach(func(member string, value []byte) bool {
+		if len(value) < 4 || !keep[binary.LittleEndian.Uint32(value)] {
+			stale = append(stale, string([]byte(member)))
+		}
+		return true
+	})
+	for _, member := range stale {
+		z.m.Delete(member)
+	}
+	// relink the levels over the nodes kept
+	var last [maxLevel]uint32
+	var rank [maxLevel]uint32
+	for i := range last {
+		last[i] = head
+	}
+	level, r := 1, uint32(0)
+	for _, x := range order {
+		if !keep[x] {
+			continue
+		}
+		r++
+		links := z.links(x)
+		if len(links) > level {
+			level = len(links)
+		}
+		for i := range links {
+			prev := z.links(last[i])
+			prev[i].next = x
+			prev[i].span = r - rank[i]
+			last[i], rank[i] = x, r
+		}
+	}
+	for i := range last {
+		l := &z.links(last[i])[i]
+		l.next = 0
+		l.span = r - rank[i]
+	}
+	z.head.level = uint32(level)
+	z.head.length = r
+	var err error
+	for _, off := range drop {
+		if e := z.values.Free(off); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// unlock the set, keep the first error
+func (z *ZSet) unlock(err *error) {
+	if e := z.mu.Unlock(); *err == nil {
+		*err = e
+	}
+}
+
+// alloc a node of level links, zeroed
+func (z *ZSet) alloc(level int, member string) (off uint32, err error) {
+	n := int(unsafe.Sizeof(node{})) + level*int(unsafe.Sizeof(link{})) + len(member)
+	if off, err = z.values.Alloc(n); err != nil {
+		return
+	}
+	b := z.values.Bytes(off)
+	for i := range b {
+		b[i] = 0
+	}
+	nd := z.node(off)
+	nd.level = uint32(level)
+	nd.mlen = uint32(len(member))
+	copy(z.member(off), member)
+	return
+}
+
+func (z *ZSet) node(off uint32) *node {
+	return (*node)(unsafe.Pointer(&z.values.Bytes(off)[0]))
+}
+
+func (z *ZSet) links(off uint32) []link {
+	b := z.values.Bytes(off)
+	n := int(z.node(off).level)
+	return (*[maxLevel]link)(unsafe.Pointer(&b[unsafe.Sizeof(node{})]))[:n:n]
+}
+
+func (z *ZSet) member(off uint32) []byte {
+	b := z.values.Bytes(off)
+	nd := z.node(off)
+	start := int(unsafe.Sizeof(node{})) + int(nd.level)*int(unsafe.Sizeof(link{}))
+	return b[start : start+int(nd.mlen)]
+}
+
+// less order by score, then by member
+func (z *ZSet) less(off uint32, score float64, member string) bool {
+	nd := z.node(off)
+	if nd.score != score {
+		return nd.score < score
+	}
+	return bytes.Compare(z.member(off), []byte(member)) < 0
+}
+
+// random level of a new node
+func randomLevel() int {
+	level := 1
+	for level < maxLevel && rand.Intn(levelP) == 0 {
+		level++
+	}
+	return level
+}
+
+// offset of the node of member, 0 if none
+func (z *ZSet) lookup(member string) (off uint32, err error) {
+	v, err := z.m.Get(member, false)
+	if err == shm.ErrKeyNot {
+		return 0, nil
+	}
+	if err != nil {
+		return
+	}
+	if len(v) < 4 {
+		return 0, ErrValueSize
+	}
+	return binary.LittleEndian.Uint32(v), nil
+}
+
+// Add member with score, or update its score, true if added
+func (z *ZSet) Add(member string, score float64) (added bool, err error) {
+	if err = z.lock(); err != nil {
+		return
+	}
+	defer z.unlock(&err)
+	off, err := z.lookup(member)
+	if err != nil {
+		return
+	}
+	added = off == 0
+	if !added && z.node(off).score == score {
+		return
+	}
+	// the node of the new score first, the old one kept if it fails
+	old := off
+	if off, err = z.insert(member, score); err != nil {
+		return false, err
+	}
+	v, err := z.m.Get(member, true)
+	if err == nil && len(v) < 4 {
+		err = ErrValueSize
+	}
+	if err != nil {
+		z.remove(off)
+		return false, err
+	}
+	binary.LittleEndian.PutUint32(v, off)
+	if !added {
+		err = z.remove(old)
+	}
+	return
+}
+
+// insert a node, return its offset
+func (z *ZSet) insert(member string, score float64) (off uint32, err error) {
+	var update [maxLevel]uint32
+	var rank [maxLevel]uint32
+	x := z.head.head
+	for i := int(z.head.level) - 1; i >= 0; i-- {
+		if i < int(z.head.level)-1 {
+			rank[i] = rank[i+1]
+		}
+		for l := z.links(x)[i]; l.next != 0 && z.less(l.next, score, member); l = z.links(x)[i] {
+			rank[i] += l.span
+			x = l.next
+		}
+		update[i] = x
+	}
+	level := randomLevel()
+	if off, err = z.alloc(level, member); err != nil {
+		return
+	}
+	if level > int(z.head.level) {
+		head := z.links(z.head.head)
+		for i := int(z.head.level); i < level; i++ {
+			rank[i] = 0
+			update[i] = z.head.head
+			head[i].span = z.head.length
+		}
+		z.head.level = uint32(level)
+	}
+	z.node(off).score = score
+	links := z.links(off)
+	for i := 0; i < level; i++ {
+		prev := z.links(update[i])
+		links[i].next = prev[i].next
+		prev[i].next = off
+		links[i].span = prev[i].span - (rank[0] - rank[i])
+		prev[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < int(z.head.level); i++ {
+		z.links(update[i])[i].span++
+	}
+	z.head.length++
+	return
+}
+
+// remove the node at off and free it, an error if the arena is
+// damaged, the node unlinked still
+func (z *ZSet) remove(off uint32) error {
+	nd := z.node(off)
+	member := string(z.member(off))
+	var update [maxLevel]uint32
+	x := z.head.head
+	for i := int(z.head.level) - 1; i >= 0; i-- {
+		for l := z.links(x)[i]; l.next != 0 && z.less(l.next, nd.score, member); l = z.links(x)[i] {
+			x = l.next
+		}
+		update[i] = x
+	}
+	links := z.links(off)
+	for i := 0; i < int(z.head.level); i++ {
+		prev := z.links(update[i])
+		if prev[i].next == off {
+			prev[i].span += links[i].span - 1
+			prev[i].next = links[i].next
+		} else {
+			prev[i].span--
+		}
+	}
+	head := z.links(z.head.head)
+	for z.head.level > 1 && head[z.head.level-1].next == 0 {
+		z.head.level--
+	}
+	z.head.length--
+	return z.values.Free(off)
+}
+
+// Remove member, true if it was there
+func (z *ZSet) Remove(member string) (removed bool, err error) {
+	if err = z.lock(); err != nil {
+		return
+	}
+	defer z.unlock(&err)
+	off, err := z.lookup(member)
+	if err != nil || off == 0 {
+		return
+	}
+	err = z.remove(off)
+	z.m.Delete(member)
+	return true, err
+}
+
+// Score of member, false if not there
+func (z *ZSet) Score(member string) (score float64, ok bool, err error) {
+	if err = z.lock(); err != nil {
+		return
+	}
+	defer z.unlock(&err)
+	off, err := z.lookup(member)
+	if err != nil || off == 0 {
+		return
+	}
+	return z.node(off).score, true, nil
+}
+
+// Rank of member from 0 by ascending score, false if not there
+func (z *ZSet) Rank(member string) (rank int, ok bool, err error) {
+	if err = z.lock(); err != nil {
+		return
+	}
+	defer z.unlock(&err)
+	off, err := z.lookup(member)
+	if err != nil || off == 0 {
+		return
+	}
+	score := z.node(off).score
+	var r uint32
+	x := z.head.head
+	for i := int(z.head.level) - 1; i >= 0; i-- {
+		for l := z.links(x)[i]; l.next != 0 && (l.next == off || z.less(l.next, score, member)); l = z.links(x)[i] {
+			r += l.span
+			x = l.next
+		}
+		if x == off {
+			return int(r) - 1, true, nil
+		}
+	}
+	return
+}
+
+// RangeByScore call fn with the members of scores from min to max,
+// inclusive, by ascending score, until fn return false
+// the set is locked meanwhile, fn must not change it
+func (z *ZSet) RangeByScore(min, max float64, fn func(member string, score float64) bool) (err error) {
+	if err = z.lock(); err != nil {
+		return
+	}
+	defer z.unlock(&err)
+	x := z.head.head
+	for i := int(z.head.level) - 1; i >= 0; i-- {
+		for l := z.links(x)[i]; l.next != 0 && z.node(l.next).score < min; l = z.links(x)[i] {
+			x = l.next
+		}
+	}
+	for x = z.links(x)[0].next; x != 0; x = z.links(x)[0].next {
+		score := z.node(x).score
+		if score > max || !fn(string(z.member(x)), score) {
+			break
+		}
+	}
+	return
+}
+
+// Len return the number of members
+func (z *ZSet) Len() (n int, err error) {
+	if err = z.lock(); err != nil {
+		return
+	}
+	defer z.unlock(&err)
+	return int(z.head.length), nil
+}
+
+// Close the map and the region
+func (z *ZSet) Close() error {
+	err := z.m.Close()
+	if z.mp != nil {
+		if e := z.mp.Close(); err == nil {
+			err = e
+		}
+		z.mp = nil
+	}
+	return err
+}
@@ -0,0 +1,184 @@
+package zset
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestZSet(t *testing.T) {
+	name := "testzset.db"
+	defer os.Remove(name)
+	defer os.Remove(name + ".zset")
+	z, err := Open(name, 1024, 16, 1<<20, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	scores := make(map[string]float64)
+	for i := 0; i < 500; i++ {
+		member := fmt.Sprintf("m%d", rand.Intn(200))
+		score := float64(rand.Intn(100))
+		added, err := z.Add(member, score)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := scores[member]; ok == added {
+			t.Fatalf("add %s: added %v", member, added)
+		}
+		scores[member] = score
+		if i%7 == 0 {
+			removed, err := z.Remove(member)
+			if err != nil || !removed {
+				t.Fatalf("remove %s: %v, %v", member, removed, err)
+			}
+			delete(scores, member)
+		}
+	}
+	members := make([]string, 0, len(scores))
+	for member := range scores {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if scores[members[i]] != scores[members[j]] {
+			return scores[members[i]] < scores[members[j]]
+		}
+		return members[i] < members[j]
+	})
+	if n, err := z.Len(); err != nil || n != len(members) {
+		t.Errorf("expect len %d, got %d, %v", len(members), n, err)
+	}
+	for i, member := range members {
+		rank, ok, err := z.Rank(member)
+		if err != nil || !ok || rank != i {
+			t.Errorf("rank %s: expect %d, got %d, %v, %v", member, i, rank, ok, err)
+		}
+		score, ok, err := z.Score(member)
+		if err != nil || !ok || score != scores[member] {
+			t.Errorf("score %s: expect %v, got %v, %v, %v", member, scores[member], score, ok, err)
+		}
+	}
+	var got []string
+	if err = z.RangeByScore(20, 40, func(member string, score float64) bool {
+		got = append(got, member)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var expect []string
+	for _, member := range members {
+		if s := scores[member]; s >= 20 && s <= 40 {
+			expect = append(expect, member)
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(expect) {
+		t.Errorf("range: expect %v, got %v", expect, got)
+	}
+}
+
+func TestZSet_UpdateFull(t *testing.T) {
+	name := "testzsetfull.db"
+	defer os.Remove(name)
+	defer os.Remove(name + ".zset")
+	z, err := Open(name, 1024, 16, 4096, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	if _, err = z.Add("m", 1); err != nil {
+		t.Fatal(err)
+	}
+	// fill every size of node
+	for i := 0; i < 1000; i++ {
+		_, _ = z.Add(fmt.Sprintf("f%d", i), float64(i))
+	}
+	n, err := z.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := false
+	for i := 0; i < 20; i++ {
+		before, _, _ := z.Score("m")
+		if _, err = z.Add("m", float64(2+i)); err == nil {
+			continue
+		}
+		failed = true
+		if score, ok, err := z.Score("m"); err != nil || !ok || score != before {
+			t.Fatalf("expect score %v kept, got %v, %v, %v", before, score, ok, err)
+		}
+	}
+	if !failed {
+		t.Fatal("expect an update failing on a full arena")
+	}
+	if l, err := z.Len(); err != nil || l != n {
+		t.Errorf("expect len %d, got %d, %v", n, l, err)
+	}
+	if _, ok, err := z.Rank("m"); err != nil || !ok {
+		t.Errorf("expect m ranked, %v, %v", ok, err)
+	}
+}
+
+func TestZSet_Recover(t *testing.T) {
+	name := "testzsetrecover.db"
+	defer os.Remove(name)
+	defer os.Remove(name + ".zset")
+	z, err := Open(name, 1024, 16, 1<<20, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	for i := 0; i < 100; i++ {
+		if _, err = z.Add(fmt.Sprintf("m%02d", i), float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a holder dies between the inserts of an update and of an add and
+	// the map, and after a remove before the map
+	if err = z.lock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = z.insert("m10", 1000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = z.insert("new", 50.5); err != nil {
+		t.Fatal(err)
+	}
+	off, err := z.lookup("m20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = z.remove(off); err != nil {
+		t.Fatal(err)
+	}
+	z.head.mu = 1<<31 - 1
+	if score, ok, err := z.Score("m10"); err != nil || !ok || score != 10 {
+		t.Errorf("expect m10 at its old score, got %v, %v, %v", score, ok, err)
+	}
+	if _, ok, err := z.Score("m20"); err != nil || ok {
+		t.Errorf("expect m20 removed, got %v, %v", ok, err)
+	}
+	if rank, ok, err := z.Rank("new"); err != nil || !ok || rank != 50 {
+		t.Errorf("expect new ranked 50, got %d, %v, %v", rank, ok, err)
+	}
+	if n, err := z.Len(); err != nil || n != 100 {
+		t.Errorf("expect len 100, got %d, %v", n, err)
+	}
+	// m00 to m50 but m20, new, then m51 to m99
+	var members []string
+	for i := 0; i < 100; i++ {
+		if i == 51 {
+			members = append(members, "new")
+		}
+		if i != 20 {
+			members = append(members, fmt.Sprintf("m%02d", i))
+		}
+	}
+	for i, member := range members {
+		if rank, ok, err := z.Rank(member); err != nil || !ok || rank != i {
+			t.Errorf("rank %s: expect %d, got %d, %v, %v", member, i, rank, ok, err)
+		}
+	}
+}